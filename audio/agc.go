@@ -0,0 +1,115 @@
+// audio/agc.go - 采集端自动增益控制 (AGC)
+
+package audio
+
+import (
+	"math"
+)
+
+// AGC 参数
+const (
+	agcNoiseFloorDB = -50.0 // 低于该电平视为静音，冻结增益避免抽吸
+	agcMaxGainDB    = 20.0
+	agcMinGainDB    = -20.0
+	agcAttack       = 0.3  // 电平过高时快速降低增益
+	agcRelease      = 0.02 // 电平过低时缓慢提升增益
+	agcPeakLimit    = 0.98 // 放大后峰值上限（满幅比例），保证不会放大到削波
+)
+
+// AGC normalizes captured audio toward a target RMS level with smooth attack/release
+type AGC struct {
+	targetDB float64
+	gainDB   float64
+}
+
+// NewAGC creates an automatic gain control targeting the given RMS level in dBFS
+func NewAGC(targetDB float64) *AGC {
+	return &AGC{targetDB: targetDB}
+}
+
+// GainDB returns the current gain in dB
+func (a *AGC) GainDB() float64 {
+	return a.gainDB
+}
+
+// Process applies the gain in place. levelDB is the frame's RMS level as computed by calculateDecibels.
+func (a *AGC) Process(audioData []byte, levelDB float64, bitDepth int) {
+	// 静音时冻结增益
+	if levelDB > agcNoiseFloorDB {
+		desired := a.targetDB - levelDB
+		if desired > agcMaxGainDB {
+			desired = agcMaxGainDB
+		} else if desired < agcMinGainDB {
+			desired = agcMinGainDB
+		}
+
+		coeff := agcRelease
+		if desired < a.gainDB {
+			coeff = agcAttack
+		}
+		a.gainDB += (desired - a.gainDB) * coeff
+	}
+
+	if math.Abs(a.gainDB) < 0.01 {
+		return
+	}
+
+	gain := math.Pow(10, a.gainDB/20)
+
+	// 限制增益，使放大后的峰值不超过上限
+	if peak := framePeak(audioData, bitDepth); peak > 0 && peak*gain > agcPeakLimit {
+		gain = math.Max(agcPeakLimit/peak, math.Min(gain, 1.0))
+	}
+
+	applyGain(audioData, gain, bitDepth)
+}
+
+// framePeak 返回帧内最大采样幅度（满幅比例）
+func framePeak(audioData []byte, bitDepth int) float64 {
+	var peak float64
+	switch bitDepth {
+	case 16:
+		for i := 0; i+1 < len(audioData); i += 2 {
+			sample := int16(audioData[i]) | int16(audioData[i+1])<<8
+			peak = math.Max(peak, math.Abs(float64(sample)/32768.0))
+		}
+	case 32:
+		for i := 0; i+3 < len(audioData); i += 4 {
+			sample := int32(audioData[i]) | int32(audioData[i+1])<<8 | int32(audioData[i+2])<<16 | int32(audioData[i+3])<<24
+			peak = math.Max(peak, math.Abs(float64(sample)/2147483648.0))
+		}
+	case SampleDepthFloat32:
+		for i := 0; i+3 < len(audioData); i += 4 {
+			peak = math.Max(peak, math.Abs(float64(readFloat32(audioData[i:]))))
+		}
+	}
+	return peak
+}
+
+// applyGain 按线性增益缩放采样（小端），并做饱和处理
+func applyGain(audioData []byte, gain float64, bitDepth int) {
+	switch bitDepth {
+	case 16:
+		for i := 0; i+1 < len(audioData); i += 2 {
+			sample := int16(audioData[i]) | int16(audioData[i+1])<<8
+			scaled := math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(float64(sample)*gain)))
+			out := int16(scaled)
+			audioData[i] = byte(out)
+			audioData[i+1] = byte(out >> 8)
+		}
+	case 32:
+		for i := 0; i+3 < len(audioData); i += 4 {
+			sample := int32(audioData[i]) | int32(audioData[i+1])<<8 | int32(audioData[i+2])<<16 | int32(audioData[i+3])<<24
+			scaled := math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(float64(sample)*gain)))
+			out := int32(scaled)
+			audioData[i] = byte(out)
+			audioData[i+1] = byte(out >> 8)
+			audioData[i+2] = byte(out >> 16)
+			audioData[i+3] = byte(out >> 24)
+		}
+	case SampleDepthFloat32:
+		for i := 0; i+3 < len(audioData); i += 4 {
+			writeFloat32(audioData[i:], clampFloat32(float64(readFloat32(audioData[i:]))*gain))
+		}
+	}
+}
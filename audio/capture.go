@@ -3,8 +3,11 @@
 package audio
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"math"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -27,6 +30,17 @@ type Capturer struct {
 	// 添加输入缓冲区引用
 	inputBuffer interface{}
 	
+	// -input-channel-map：每个流声道取自的 0-based 设备声道（nil 表示按顺序），设备流打开 deviceChannels 个声道
+	channelMap     []int
+	deviceChannels int
+	
+	// 管道输入（设置后不使用 PortAudio）
+	pipePath   string
+	pipe       io.ReadCloser
+	pipeReader io.Reader // 从中读取采样：pipe 本身，或 WAV 文件的格式转换器
+	pipeSwap   bool      // -pcm-bigendian：原始 PCM 为大端，读出后转为小端
+	onEnd      func()
+	
 	// State management
 	running      int32 // atomic bool
 	initialized  int32 // atomic bool
@@ -37,19 +51,53 @@ type Capturer struct {
 	// 分贝计算相关
 	decibelMutex sync.RWMutex
 	currentDB    float64
+	peakLevel    float64 // 保持的峰值幅度 0.0 到 1.0（-waveform），每个缓冲区按 peakHoldDecay 衰减
+	
+	// 削波检测（计数仅在 captureLoop 中访问）
+	clipSamples     int64
+	clipTotal       int64
+	clipWindowStart time.Time
+	lastClipWarning time.Time
+	clipping        int32 // atomic bool
+	
+	// 每帧的处理耗时（atomic，纳秒），captureLoop 写入、GetStats 读取
+	latency int64
+	
+	// 信噪比估计（Observe 仅在 captureLoop 中调用，读取时由 decibelMutex 保护）
+	snr *SNREstimator
+	
+	// 固定输入增益（线性），在电平计算、自动增益与编码之前应用；可在运行时通过 SetGain 修改
+	gainMutex sync.Mutex
+	inputGain float64
+	
+	// 自动增益控制（为 nil 表示禁用）
+	agc *AGC
 	
-	// Control
-	stopChan chan struct{}
-	wg       sync.WaitGroup
+	// 高通/低通滤波，在电平计算与编码之前按顺序应用
+	filters []*Biquad
+	
+	// 当前帧第一个采样帧的累计序号，每采集一帧增加 FramesPerBuffer（仅在 captureLoop 及其回调中访问）
+	sampleIndex uint64
+	
+	// Control (cancel 取消本次 Start 派生的 context)
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 // NewCapturer creates a new audio capturer
 func NewCapturer(device *DeviceInfo, config *utils.Config, logger *utils.Logger) *Capturer {
+	var agc *AGC
+	if config.EnableAGC {
+		agc = NewAGC(config.AGCTargetDB)
+	}
 	return &Capturer{
+		inputGain: math.Pow(10, config.InputGainDB/20),
+		agc:      agc,
+		filters:  newCaptureFilters(config, logger),
+		snr:      NewSNREstimator(),
 		device:   device,
 		config:   config,
 		logger:   logger,
-		stopChan: make(chan struct{}),
 		currentDB: -60.0, // 默认静音级别
 		stats: &utils.AudioStats{
 			FramesProcessed: 0,
@@ -61,16 +109,72 @@ func NewCapturer(device *DeviceInfo, config *utils.Config, logger *utils.Logger)
 	}
 }
 
-// calculateDecibels 计算音频数据的分贝级别
-func (c *Capturer) calculateDecibels(audioData []byte) float64 {
+// newCaptureFilters 根据 -highpass/-lowpass 创建滤波器；截止频率超出当前采样率的可用范围时跳过
+func newCaptureFilters(config *utils.Config, logger *utils.Logger) []*Biquad {
+	var filters []*Biquad
+	if config.HighPassHz > 0 {
+		if filter := NewHighPass(config.SampleRate, config.Channels, config.HighPassHz); filter != nil {
+			filters = append(filters, filter)
+			logger.Infof("🎛️ High-pass filter at %.0f Hz", config.HighPassHz)
+		} else {
+			logger.Warnf("High-pass cutoff %.0f Hz is too high for %d Hz audio, filter disabled", config.HighPassHz, config.SampleRate)
+		}
+	}
+	if config.LowPassHz > 0 {
+		if filter := NewLowPass(config.SampleRate, config.Channels, config.LowPassHz); filter != nil {
+			filters = append(filters, filter)
+			logger.Infof("🎛️ Low-pass filter at %.0f Hz", config.LowPassHz)
+		} else {
+			logger.Infof("Low-pass cutoff %.0f Hz is above the usable band of %d Hz audio, filter not needed", config.LowPassHz, config.SampleRate)
+		}
+	}
+	return filters
+}
+
+// NewPipeCapturer creates a capturer that reads raw PCM from a pipe ("-" for stdin), little-endian
+// unless config.PipeBigEndian is set
+func NewPipeCapturer(pipePath string, config *utils.Config, logger *utils.Logger) *Capturer {
+	c := NewCapturer(nil, config, logger)
+	c.pipePath = pipePath
+	return c
+}
+
+// SetGain sets the fixed input trim in dB applied to captured samples (0 = unchanged); safe to call while capturing
+func (c *Capturer) SetGain(db float64) {
+	c.gainMutex.Lock()
+	c.inputGain = math.Pow(10, db/20)
+	c.gainMutex.Unlock()
+}
+
+// SampleIndex returns the cumulative index of the first sample frame of the buffer being
+// delivered. It is only meaningful inside the capture callback.
+func (c *Capturer) SampleIndex() uint64 {
+	return c.sampleIndex
+}
+
+// OnEnd registers a callback invoked when the capture source ends (pipe EOF)
+func (c *Capturer) OnEnd(callback func()) {
+	c.onEnd = callback
+}
+
+// 削波检测参数
+const (
+	clipLevel           = 0.99            // 达到满幅 99% 视为削波
+	clipWindow          = 1 * time.Second // 统计窗口
+	clipWarningInterval = 5 * time.Second // 警告限频
+)
+
+// calculateDecibels 计算音频数据的分贝级别与峰值幅度（同时统计接近满幅的削波采样）
+func (c *Capturer) calculateDecibels(audioData []byte) (float64, float64) {
 	if len(audioData) == 0 {
-		return -60.0 // 静音
+		return -60.0, 0 // 静音
 	}
 	
 	var sum float64 = 0
 	var sampleCount int = 0
+	var peak float64 = 0
 	
-	switch c.config.BitDepth {
+	switch SampleDepth(c.config) {
 	case 16:
 		for i := 0; i < len(audioData)-1; i += 2 {
 			// 转换为 int16
@@ -79,6 +183,10 @@ func (c *Capturer) calculateDecibels(audioData []byte) float64 {
 			normalizedSample := float64(sample) / 32768.0
 			sum += normalizedSample * normalizedSample
 			sampleCount++
+			peak = math.Max(peak, math.Abs(normalizedSample))
+			if math.Abs(normalizedSample) >= clipLevel {
+				c.clipSamples++
+			}
 		}
 	case 32:
 		for i := 0; i < len(audioData)-3; i += 4 {
@@ -91,21 +199,37 @@ func (c *Capturer) calculateDecibels(audioData []byte) float64 {
 			normalizedSample := float64(sample) / 2147483648.0
 			sum += normalizedSample * normalizedSample
 			sampleCount++
+			peak = math.Max(peak, math.Abs(normalizedSample))
+			if math.Abs(normalizedSample) >= clipLevel {
+				c.clipSamples++
+			}
+		}
+	case SampleDepthFloat32:
+		for i := 0; i < len(audioData)-3; i += 4 {
+			// float32 采样本身就是 -1.0 到 1.0
+			normalizedSample := float64(readFloat32(audioData[i:]))
+			sum += normalizedSample * normalizedSample
+			sampleCount++
+			peak = math.Max(peak, math.Abs(normalizedSample))
+			if math.Abs(normalizedSample) >= clipLevel {
+				c.clipSamples++
+			}
 		}
 	default:
-		return -60.0
+		return -60.0, peak
 	}
 	
 	if sampleCount == 0 {
-		return -60.0
+		return -60.0, peak
 	}
+	c.clipTotal += int64(sampleCount)
 	
 	// 计算 RMS (Root Mean Square)
 	rms := math.Sqrt(sum / float64(sampleCount))
 	
 	// 避免 log(0)
 	if rms < 1e-10 {
-		return -60.0
+		return -60.0, peak
 	}
 	
 	// 转换为分贝 (20 * log10(rms))
@@ -118,11 +242,38 @@ func (c *Capturer) calculateDecibels(audioData []byte) float64 {
 		db = 0.0
 	}
 	
-	return db
+	return db, peak
 }
 
-// updateDecibelLevel 更新当前分贝级别（带平滑处理）
-func (c *Capturer) updateDecibelLevel(newDB float64) {
+// updateClipping 每个统计窗口检查一次削波比例，超过阈值时设置标志并限频警告
+func (c *Capturer) updateClipping() {
+	now := time.Now()
+	if c.clipWindowStart.IsZero() {
+		c.clipWindowStart = now
+	}
+	if now.Sub(c.clipWindowStart) < clipWindow {
+		return
+	}
+
+	clipping := c.clipTotal > 0 && float64(c.clipSamples)/float64(c.clipTotal) > c.config.ClipFraction
+	if clipping {
+		atomic.StoreInt32(&c.clipping, 1)
+		if now.Sub(c.lastClipWarning) >= clipWarningInterval {
+			c.logger.Warnf("⚠️ Input clipping detected (%.2f%% of samples at full scale), reduce input gain",
+				float64(c.clipSamples)/float64(c.clipTotal)*100)
+			c.lastClipWarning = now
+		}
+	} else {
+		atomic.StoreInt32(&c.clipping, 0)
+	}
+
+	c.clipSamples = 0
+	c.clipTotal = 0
+	c.clipWindowStart = now
+}
+
+// updateDecibelLevel 更新当前分贝级别（带平滑处理）与保持的峰值
+func (c *Capturer) updateDecibelLevel(newDB, peak float64) {
 	c.decibelMutex.Lock()
 	defer c.decibelMutex.Unlock()
 	
@@ -130,6 +281,8 @@ func (c *Capturer) updateDecibelLevel(newDB float64) {
 	const smoothing = 0.3
 	c.currentDB = c.currentDB*(1-smoothing) + newDB*smoothing
 	c.stats.DecibelLevel = c.currentDB
+	c.peakLevel = math.Max(peak, c.peakLevel*peakHoldDecay)
+	c.snr.Observe(newDB)
 }
 
 // getCurrentDecibelLevel 获取当前分贝级别
@@ -139,16 +292,40 @@ func (c *Capturer) getCurrentDecibelLevel() float64 {
 	return c.currentDB
 }
 
+// getPeakLevel 获取保持的峰值幅度
+func (c *Capturer) getPeakLevel() float64 {
+	c.decibelMutex.RLock()
+	defer c.decibelMutex.RUnlock()
+	return c.peakLevel
+}
+
+// getSNR 获取估计的信噪比 (dB)，尚无有效音频时为 0
+func (c *Capturer) getSNR() float64 {
+	c.decibelMutex.RLock()
+	defer c.decibelMutex.RUnlock()
+	return c.snr.SNR()
+}
+
 // Initialize initializes the audio capturer
 func (c *Capturer) Initialize() error {
 	if atomic.LoadInt32(&c.initialized) == 1 {
 		return nil
 	}
 
+	if c.pipePath != "" {
+		return c.initializePipe()
+	}
+
 	c.logger.Infof("Initializing audio capturer for device: %s", c.device.Name)
 
+	channelMap, err := deviceChannelMap(c.config.InputChannelMap, c.config.Channels)
+	if err != nil {
+		return utils.WrapError(err, utils.ErrAudioCapture, "invalid input channel map")
+	}
+	deviceChannels := mapDeviceChannels(channelMap, c.config.Channels)
+
 	// Validate device for input
-	if err := ValidateDeviceForInput(c.device, c.config.SampleRate, c.config.Channels); err != nil {
+	if err := ValidateDeviceForInput(c.device, c.config.SampleRate, deviceChannels); err != nil {
 		return utils.WrapError(err, utils.ErrAudioCapture, "device validation failed")
 	}
 
@@ -159,11 +336,13 @@ func (c *Capturer) Initialize() error {
 	}
 
 	// Create input buffer based on bit depth
-	switch c.config.BitDepth {
+	switch SampleDepth(c.config) {
 	case 16:
-		c.inputBuffer = make([]int16, c.config.FramesPerBuffer*c.config.Channels)
+		c.inputBuffer = make([]int16, c.config.FramesPerBuffer*deviceChannels)
 	case 32:
-		c.inputBuffer = make([]int32, c.config.FramesPerBuffer*c.config.Channels)
+		c.inputBuffer = make([]int32, c.config.FramesPerBuffer*deviceChannels)
+	case SampleDepthFloat32:
+		c.inputBuffer = make([]float32, c.config.FramesPerBuffer*deviceChannels)
 	default:
 		return utils.NewAppError(utils.ErrAudioCapture, 
 			fmt.Sprintf("unsupported bit depth: %d", c.config.BitDepth))
@@ -173,7 +352,7 @@ func (c *Capturer) Initialize() error {
 	inputParams := portaudio.StreamParameters{
 		Input: portaudio.StreamDeviceParameters{
 			Device:   paDevice,
-			Channels: c.config.Channels,
+			Channels: deviceChannels,
 			Latency:  paDevice.DefaultLowInputLatency,
 		},
 		SampleRate:      float64(c.config.SampleRate),
@@ -186,6 +365,8 @@ func (c *Capturer) Initialize() error {
 		return utils.WrapError(err, utils.ErrAudioCapture, "failed to open audio stream")
 	}
 
+	c.channelMap = channelMap
+	c.deviceChannels = deviceChannels
 	c.stream = stream
 	atomic.StoreInt32(&c.initialized, 1)
 
@@ -195,8 +376,53 @@ func (c *Capturer) Initialize() error {
 	return nil
 }
 
-// Start begins audio capture
-func (c *Capturer) Start(callback AudioDataCallback) error {
+// initializePipe 初始化管道输入
+func (c *Capturer) initializePipe() error {
+	if c.config.BitDepth != 16 && c.config.BitDepth != 32 {
+		return utils.NewAppError(utils.ErrAudioCapture,
+			fmt.Sprintf("unsupported bit depth: %d", c.config.BitDepth))
+	}
+
+	pipe, err := OpenInputPipe(c.pipePath)
+	if err != nil {
+		return err
+	}
+
+	c.pipe = pipe
+	c.pipeReader = pipe
+	c.pipeSwap = false
+
+	// 普通文件以 RIFF 开头时按 WAV 解析，并转换为协商好的格式（管道与标准输入不探测，避免阻塞）
+	if info, err := os.Stat(c.pipePath); err == nil && info.Mode().IsRegular() {
+		reader, format, err := OpenWAVInput(pipe, c.config)
+		if err != nil {
+			pipe.Close()
+			c.pipe = nil
+			return err
+		}
+		c.pipeReader = reader
+		if format != nil {
+			if c.config.PipeBigEndian {
+				c.logger.Warn("⚠️ -pcm-bigendian ignored: WAV files are always little-endian")
+			}
+			atomic.StoreInt32(&c.initialized, 1)
+			c.logger.Infof("Audio capturer reading WAV file %s (%dHz, %d ch, %d-bit) as %dHz, %d ch, %d-bit, Buffer: %d frames",
+				c.pipePath, format.SampleRate, format.Channels, format.BitsPerSample,
+				c.config.SampleRate, c.config.Channels, c.config.BitDepth, c.config.FramesPerBuffer)
+			return nil
+		}
+	}
+	c.pipeSwap = c.config.PipeBigEndian
+	atomic.StoreInt32(&c.initialized, 1)
+
+	c.logger.Infof("Audio capturer reading raw PCM (%s) from pipe %s - Sample Rate: %dHz, Channels: %d, Bit Depth: %d, Buffer: %d frames",
+		pipeByteOrder(c.config), c.pipePath, c.config.SampleRate, c.config.Channels, c.config.BitDepth, c.config.FramesPerBuffer)
+
+	return nil
+}
+
+// Start begins audio capture; cancelling ctx stops the capture loop, Stop also releases the stream
+func (c *Capturer) Start(ctx context.Context, callback AudioDataCallback) error {
 	if atomic.LoadInt32(&c.initialized) == 0 {
 		return utils.NewAppError(utils.ErrAudioCapture, "capturer not initialized")
 	}
@@ -212,15 +438,19 @@ func (c *Capturer) Start(callback AudioDataCallback) error {
 	c.callback = callback
 
 	// Start the PortAudio stream
-	if err := c.stream.Start(); err != nil {
-		return utils.WrapError(err, utils.ErrAudioCapture, "failed to start audio stream")
+	if c.stream != nil {
+		if err := c.stream.Start(); err != nil {
+			return utils.WrapError(err, utils.ErrAudioCapture, "failed to start audio stream")
+		}
 	}
 
 	atomic.StoreInt32(&c.running, 1)
 
 	// Start capture loop
+	loopCtx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
 	c.wg.Add(1)
-	go c.captureLoop()
+	go c.captureLoop(loopCtx)
 
 	c.logger.Info("🎤 Audio capture started")
 	return nil
@@ -236,7 +466,7 @@ func (c *Capturer) Stop() {
 	atomic.StoreInt32(&c.running, 0)
 
 	// Signal stop
-	close(c.stopChan)
+	c.cancel()
 
 	// Stop the stream
 	if c.stream != nil {
@@ -263,16 +493,22 @@ func (c *Capturer) Terminate() {
 		c.stream.Close()
 		c.stream = nil
 	}
+	if c.pipe != nil {
+		c.pipe.Close()
+		c.pipe = nil
+		c.pipeReader = nil
+	}
 
 	atomic.StoreInt32(&c.initialized, 0)
 	c.logger.Info("🔚 Audio capturer terminated")
 }
 
 // captureLoop is the main capture loop
-func (c *Capturer) captureLoop() {
+func (c *Capturer) captureLoop(ctx context.Context) {
 	defer c.wg.Done()
 
 	c.logger.Debug("Audio capture loop started")
+	c.sampleIndex = 0
 
 	// Create buffer for audio data
 	frameSize := c.config.GetFrameSize()
@@ -284,36 +520,77 @@ func (c *Capturer) captureLoop() {
 	excitationTimeout := time.Duration(c.config.ExcitationTimeout) * time.Second
 	silentSince := time.Time{}
 	streaming := true
+	
+	// 管道输入没有声卡时钟，按帧时长节拍读取
+	pacer := newFramePacer(c.config.FramesPerBuffer, c.config.SampleRate)
 
-	for atomic.LoadInt32(&c.running) == 1 {
+	for ctx.Err() == nil {
 		startTime := time.Now()
 
-		// Read audio data from stream
-		err := c.stream.Read()
-		if err != nil {
-			c.logger.Error(fmt.Sprintf("Failed to read from audio stream: %v", err))
-			atomic.AddInt64(&c.stats.DroppedFrames, int64(c.config.FramesPerBuffer))
-			
-			// Check if this is a critical error
+		if c.pipe != nil {
+			// 管道输入：直接读取原始 PCM，并按帧时长控制节奏
+			if _, err := io.ReadFull(c.pipeReader, audioBuffer); err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					c.logger.Info("📄 Input pipe reached end of stream")
+				} else {
+					c.logger.Error(fmt.Sprintf("Failed to read from input pipe: %v", err))
+				}
+				if c.onEnd != nil {
+					go c.onEnd()
+				}
+				break
+			}
+			if c.pipeSwap {
+				swapSampleBytes(audioBuffer, c.config.BitDepth/8)
+			}
+			pacer.Wait()
+		} else {
+			// Read audio data from stream
+			// 阻塞读取总是读满整个输入缓冲区（绑定不返回实际帧数）；InputOverflowed 表示读取之前
+			// 有输入被丢弃，本次的数据仍然完整，计数后照常发送，不把整帧当作丢弃
+			err := c.stream.Read()
 			if err == portaudio.InputOverflowed {
-				c.logger.Warn("Input buffer overflow detected")
-			} else {
+				atomic.AddInt64(&c.stats.Overruns, 1)
+				c.logger.WarnRateLimited("capture-overflow", "Input buffer overflow detected")
+				err = nil
+			}
+			if err != nil {
+				c.logger.ErrorRateLimited("capture-read", fmt.Sprintf("Failed to read from audio stream: %v", err))
+				atomic.AddInt64(&c.stats.DroppedFrames, int64(c.config.FramesPerBuffer))
 				// For other errors, we might want to stop
 				break
 			}
-			continue
+
+			// Convert audio data to bytes
+			if err := c.convertAudioData(audioBuffer); err != nil {
+				c.logger.ErrorRateLimited("capture-convert", fmt.Sprintf("Failed to convert audio data: %v", err))
+				atomic.AddInt64(&c.stats.DroppedFrames, int64(c.config.FramesPerBuffer))
+				continue
+			}
 		}
 
-		// Convert audio data to bytes
-		if err := c.convertAudioData(audioBuffer); err != nil {
-			c.logger.Error(fmt.Sprintf("Failed to convert audio data: %v", err))
-			atomic.AddInt64(&c.stats.DroppedFrames, int64(c.config.FramesPerBuffer))
-			continue
+		// 固定输入增益（饱和处理，避免放大后溢出）
+		c.gainMutex.Lock()
+		inputGain := c.inputGain
+		c.gainMutex.Unlock()
+		if inputGain != 1.0 {
+			applyGain(audioBuffer, inputGain, SampleDepth(c.config))
+		}
+
+		// 滤除低频隆隆声/高频嘶声，电平计量与编码都基于滤波后的信号
+		for _, filter := range c.filters {
+			filter.Process(audioBuffer, SampleDepth(c.config))
 		}
 
 		// 计算分贝级别
-		decibelLevel := c.calculateDecibels(audioBuffer)
-		c.updateDecibelLevel(decibelLevel)
+		decibelLevel, peak := c.calculateDecibels(audioBuffer)
+		c.updateDecibelLevel(decibelLevel, peak)
+		c.updateClipping()
+
+		// 自动增益：使用本帧的原始电平，在回调（Opus 编码）之前调整音量
+		if c.agc != nil {
+			c.agc.Process(audioBuffer, decibelLevel, SampleDepth(c.config))
+		}
 
 		// Excitation logic - 只影响音频数据发送，不影响心跳包
 		if excitationEnabled {
@@ -345,10 +622,12 @@ func (c *Capturer) captureLoop() {
 		if streaming {
 			atomic.AddInt64(&c.stats.FramesProcessed, int64(c.config.FramesPerBuffer))
 		}
+		// 激励模式暂停时序号照样前进，接收端据此看到准确的间隙
+		c.sampleIndex += uint64(c.config.FramesPerBuffer)
 		
 		// Calculate processing latency
 		processingTime := time.Since(startTime)
-		c.stats.Latency = processingTime
+		atomic.StoreInt64(&c.latency, int64(processingTime))
 	}
 
 	c.logger.Debug("Audio capture loop ended")
@@ -360,7 +639,7 @@ func (c *Capturer) convertAudioData(output []byte) error {
 		return utils.NewAppError(utils.ErrAudioCapture, "input buffer is nil")
 	}
 
-	switch c.config.BitDepth {
+	switch SampleDepth(c.config) {
 	case 16:
 		// 修复：使用保存的输入缓冲区引用
 		input, ok := c.inputBuffer.([]int16)
@@ -368,7 +647,8 @@ func (c *Capturer) convertAudioData(output []byte) error {
 			return utils.NewAppError(utils.ErrAudioCapture, "invalid input buffer type for 16-bit")
 		}
 		
-		for i, sample := range input {
+		for i := 0; i < c.streamSamples(len(input)); i++ {
+			sample := input[deviceSampleIndex(c.channelMap, c.deviceChannels, c.config.Channels, i)]
 			if i*2+1 >= len(output) {
 				break
 			}
@@ -384,7 +664,8 @@ func (c *Capturer) convertAudioData(output []byte) error {
 			return utils.NewAppError(utils.ErrAudioCapture, "invalid input buffer type for 32-bit")
 		}
 		
-		for i, sample := range input {
+		for i := 0; i < c.streamSamples(len(input)); i++ {
+			sample := input[deviceSampleIndex(c.channelMap, c.deviceChannels, c.config.Channels, i)]
 			if i*4+3 >= len(output) {
 				break
 			}
@@ -395,6 +676,20 @@ func (c *Capturer) convertAudioData(output []byte) error {
 			output[i*4+3] = byte((sample >> 24) & 0xFF)
 		}
 
+	case SampleDepthFloat32:
+		input, ok := c.inputBuffer.([]float32)
+		if !ok {
+			return utils.NewAppError(utils.ErrAudioCapture, "invalid input buffer type for float32")
+		}
+
+		for i := 0; i < c.streamSamples(len(input)); i++ {
+			sample := input[deviceSampleIndex(c.channelMap, c.deviceChannels, c.config.Channels, i)]
+			if i*4+3 >= len(output) {
+				break
+			}
+			writeFloat32(output[i*4:], sample)
+		}
+
 	default:
 		return utils.NewAppError(utils.ErrAudioCapture, 
 			fmt.Sprintf("unsupported bit depth: %d", c.config.BitDepth))
@@ -403,6 +698,14 @@ func (c *Capturer) convertAudioData(output []byte) error {
 	return nil
 }
 
+// streamSamples 设备缓冲区（inputLen 个采样）中包含的流采样数
+func (c *Capturer) streamSamples(inputLen int) int {
+	if c.channelMap == nil {
+		return inputLen
+	}
+	return inputLen / c.deviceChannels * c.config.Channels
+}
+
 // IsRunning returns whether the capturer is currently running
 func (c *Capturer) IsRunning() bool {
 	return atomic.LoadInt32(&c.running) == 1
@@ -426,10 +729,25 @@ func (c *Capturer) GetStats() *utils.AudioStats {
 	return &utils.AudioStats{
 		FramesProcessed: atomic.LoadInt64(&c.stats.FramesProcessed),
 		DroppedFrames:   atomic.LoadInt64(&c.stats.DroppedFrames),
-		Latency:         c.stats.Latency,
+		Overruns:        atomic.LoadInt64(&c.stats.Overruns),
+		Latency:         time.Duration(atomic.LoadInt64(&c.latency)),
 		BufferUsage:     bufferUsage,
 		DecibelLevel:    c.getCurrentDecibelLevel(),
+		PeakLevel:       c.getPeakLevel(),
+		Clipping:        atomic.LoadInt32(&c.clipping) == 1,
+		SNR:             c.getSNR(),
+	}
+}
+
+// DeviceLatency returns the input latency of the opened stream as reported by PortAudio (0 for pipe input)
+func (c *Capturer) DeviceLatency() time.Duration {
+	if c.stream == nil {
+		return 0
+	}
+	if info := c.stream.Info(); info != nil {
+		return info.InputLatency
 	}
+	return 0
 }
 
 // calculateBufferUsage calculates current buffer usage
@@ -0,0 +1,69 @@
+// audio/channel_map.go - -output-channel-map / -input-channel-map：流声道与设备声道的对应关系
+
+package audio
+
+import (
+	"fmt"
+
+	"RemoteAudioCLI/utils"
+)
+
+// deviceChannelMap returns the 0-based device channel for each of the channels stream
+// channels from a 1-based map, or nil when the map is empty and channels are used in order.
+// A stream with fewer channels than the map uses its first entries.
+func deviceChannelMap(channelMap []int, channels int) ([]int, error) {
+	if len(channelMap) == 0 {
+		return nil, nil
+	}
+	if channels > len(channelMap) {
+		return nil, utils.NewAppError(utils.ErrInvalidConfig,
+			fmt.Sprintf("the stream has %d channels but the channel map lists only %d", channels, len(channelMap)))
+	}
+	mapped := make([]int, channels)
+	for i := range mapped {
+		mapped[i] = channelMap[i] - 1
+	}
+	return mapped, nil
+}
+
+// mapDeviceChannels 设备流需要打开的声道数：映射到的最高设备声道，未映射时与流相同
+func mapDeviceChannels(mapped []int, channels int) int {
+	if mapped == nil {
+		return channels
+	}
+	highest := 0
+	for _, ch := range mapped {
+		if ch+1 > highest {
+			highest = ch + 1
+		}
+	}
+	return highest
+}
+
+// OutputDeviceChannels returns how many channels the output device stream is opened with
+// for config (at least the highest -output-channel-map entry)
+func OutputDeviceChannels(config *utils.Config) int {
+	mapped, err := deviceChannelMap(config.OutputChannelMap, config.Channels)
+	if err != nil {
+		return config.Channels
+	}
+	return mapDeviceChannels(mapped, config.Channels)
+}
+
+// InputDeviceChannels returns how many channels the input device stream is opened with
+// for config (at least the highest -input-channel-map entry)
+func InputDeviceChannels(config *utils.Config) int {
+	mapped, err := deviceChannelMap(config.InputChannelMap, config.Channels)
+	if err != nil {
+		return config.Channels
+	}
+	return mapDeviceChannels(mapped, config.Channels)
+}
+
+// deviceSampleIndex 第 i 个交错流采样在设备缓冲区中的下标
+func deviceSampleIndex(mapped []int, deviceChannels, channels, i int) int {
+	if mapped == nil {
+		return i
+	}
+	return i/channels*deviceChannels + mapped[i%channels]
+}
@@ -0,0 +1,78 @@
+package audio
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"RemoteAudioCLI/utils"
+)
+
+func TestDeviceChannelMap(t *testing.T) {
+	mapped, err := deviceChannelMap([]int{3, 4}, 2)
+	if err != nil || !reflect.DeepEqual(mapped, []int{2, 3}) {
+		t.Fatalf("deviceChannelMap(3,4) = %v, %v; want [2 3]", mapped, err)
+	}
+	if channels := mapDeviceChannels(mapped, 2); channels != 4 {
+		t.Fatalf("device channels %d, want 4", channels)
+	}
+
+	// 单声道流只用第一个条目
+	if mapped, err = deviceChannelMap([]int{3, 4}, 1); err != nil || !reflect.DeepEqual(mapped, []int{2}) {
+		t.Fatalf("mono stream: %v, %v; want [2]", mapped, err)
+	}
+	if _, err = deviceChannelMap([]int{3}, 2); err == nil {
+		t.Fatalf("map shorter than the stream was accepted")
+	}
+	if mapped, err = deviceChannelMap(nil, 2); err != nil || mapped != nil || mapDeviceChannels(mapped, 2) != 2 {
+		t.Fatalf("empty map: %v, %v", mapped, err)
+	}
+}
+
+// TestPlayerOutputChannelMap 流声道写到映射的设备声道，其余设备声道为静音
+func TestPlayerOutputChannelMap(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.Channels = 2
+	config.BitDepth = 16
+	config.FramesPerBuffer = 2
+	player := NewPlayer(nil, config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	player.channelMap = []int{2, 3}
+	player.deviceChannels = 4
+	output := []int16{9, 9, 9, 9, 9, 9, 9, 9}
+	player.outputBuffer = output
+
+	data := make([]byte, 8)
+	for i, sample := range []int16{1, 2, 3, 4} {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(sample))
+	}
+	if err := player.convertAndWriteAudioData(data); err != nil {
+		t.Fatalf("convertAndWriteAudioData: %v", err)
+	}
+	if want := []int16{0, 0, 1, 2, 0, 0, 3, 4}; !reflect.DeepEqual(output, want) {
+		t.Fatalf("device buffer %v, want %v", output, want)
+	}
+}
+
+// TestCapturerInputChannelMap 只取映射的设备声道，按映射顺序组成流
+func TestCapturerInputChannelMap(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.Channels = 2
+	config.BitDepth = 16
+	config.FramesPerBuffer = 2
+	capturer := NewCapturer(nil, config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	capturer.channelMap = []int{3, 0}
+	capturer.deviceChannels = 4
+	capturer.inputBuffer = []int16{1, 2, 3, 4, 5, 6, 7, 8}
+
+	output := make([]byte, 8)
+	if err := capturer.convertAudioData(output); err != nil {
+		t.Fatalf("convertAudioData: %v", err)
+	}
+	var got []int16
+	for i := 0; i < len(output); i += 2 {
+		got = append(got, int16(binary.LittleEndian.Uint16(output[i:])))
+	}
+	if want := []int16{4, 1, 8, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("stream %v, want %v", got, want)
+	}
+}
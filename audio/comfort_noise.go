@@ -0,0 +1,120 @@
+// audio/comfort_noise.go - 缓冲区欠载时的舒适噪声
+
+package audio
+
+import (
+	"math"
+)
+
+// 舒适噪声参数
+const (
+	comfortNoiseMaxDB     = -50.0 // 舒适噪声电平上限
+	comfortNoiseLowpass   = 0.6   // 一阶低通系数，使噪声偏向低频，听感更柔和
+	comfortNoiseFloorRise = 0.05  // 噪声底估计每帧最多上升的 dB，下降则立即跟随
+)
+
+// ComfortNoise generates low-level shaped noise during playback gaps, matched to the measured noise floor
+type ComfortNoise struct {
+	floorDB float64   // 估计的噪声底 (dBFS)
+	level   float64   // 当前噪声幅度（满幅比例 RMS），用于渐入/渐出
+	seed    uint32    // xorshift 随机数状态
+	state   []float64 // 每个声道的低通滤波状态
+}
+
+// NewComfortNoise creates a comfort noise generator for the given channel count
+func NewComfortNoise(channels int) *ComfortNoise {
+	return &ComfortNoise{
+		floorDB: comfortNoiseMaxDB,
+		seed:    0x9E3779B9,
+		state:   make([]float64, channels),
+	}
+}
+
+// Observe updates the noise floor estimate from a frame of real audio (level as computed by calculateDecibels)
+func (cn *ComfortNoise) Observe(levelDB float64) {
+	if levelDB < cn.floorDB {
+		cn.floorDB = levelDB
+	} else {
+		cn.floorDB = math.Min(levelDB, cn.floorDB+comfortNoiseFloorRise)
+	}
+}
+
+// targetLevel 返回与噪声底匹配、且不超过上限的噪声幅度
+func (cn *ComfortNoise) targetLevel() float64 {
+	return math.Pow(10, math.Min(cn.floorDB, comfortNoiseMaxDB)/20)
+}
+
+// Fill writes comfort noise into a silent frame, fading in from the current level
+func (cn *ComfortNoise) Fill(frame []byte, bitDepth int) {
+	for i := range frame {
+		frame[i] = 0
+	}
+	cn.mix(frame, bitDepth, cn.targetLevel())
+}
+
+// FadeOut mixes the remaining comfort noise into a frame of real audio while fading it to zero,
+// so the transition from noise back to audio is smooth. It is a no-op once the noise has faded out.
+func (cn *ComfortNoise) FadeOut(frame []byte, bitDepth int) {
+	if cn.level == 0 {
+		return
+	}
+	cn.mix(frame, bitDepth, 0)
+}
+
+// mix 将噪声叠加到帧上，噪声幅度在本帧内从当前值线性过渡到 target
+func (cn *ComfortNoise) mix(frame []byte, bitDepth int, target float64) {
+	channels := len(cn.state)
+	bytesPerSample := bitDepth / 8
+	if bitDepth == SampleDepthFloat32 {
+		bytesPerSample = 4
+	}
+	if channels == 0 || (bitDepth != 16 && bitDepth != 32 && bitDepth != SampleDepthFloat32) {
+		return
+	}
+	samples := len(frame) / bytesPerSample
+	frames := samples / channels
+	if frames == 0 {
+		return
+	}
+
+	// 低通后的方差为 (1-a)/(1+a) 倍，均匀分布 [-1,1] 的 RMS 为 1/√3，据此归一化到单位 RMS
+	norm := math.Sqrt(3) / math.Sqrt((1-comfortNoiseLowpass)/(1+comfortNoiseLowpass))
+	step := (target - cn.level) / float64(frames)
+
+	for f := 0; f < frames; f++ {
+		level := cn.level + step*float64(f+1)
+		for ch := 0; ch < channels; ch++ {
+			cn.state[ch] = comfortNoiseLowpass*cn.state[ch] + (1-comfortNoiseLowpass)*cn.random()
+			noise := cn.state[ch] * norm * level
+
+			i := (f*channels + ch) * bytesPerSample
+			switch bitDepth {
+			case 16:
+				sample := int16(frame[i]) | int16(frame[i+1])<<8
+				mixed := math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(float64(sample)+noise*32768.0)))
+				out := int16(mixed)
+				frame[i] = byte(out)
+				frame[i+1] = byte(out >> 8)
+			case 32:
+				sample := int32(frame[i]) | int32(frame[i+1])<<8 | int32(frame[i+2])<<16 | int32(frame[i+3])<<24
+				mixed := math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(float64(sample)+noise*2147483648.0)))
+				out := int32(mixed)
+				frame[i] = byte(out)
+				frame[i+1] = byte(out >> 8)
+				frame[i+2] = byte(out >> 16)
+				frame[i+3] = byte(out >> 24)
+			case SampleDepthFloat32:
+				writeFloat32(frame[i:], clampFloat32(float64(readFloat32(frame[i:]))+noise))
+			}
+		}
+	}
+	cn.level = target
+}
+
+// random 返回 [-1, 1) 的均匀分布随机数 (xorshift32)
+func (cn *ComfortNoise) random() float64 {
+	cn.seed ^= cn.seed << 13
+	cn.seed ^= cn.seed >> 17
+	cn.seed ^= cn.seed << 5
+	return float64(cn.seed)/2147483648.0 - 1.0
+}
@@ -0,0 +1,79 @@
+// audio/conceal.go - -conceal repeat：欠载时重复最后一帧完好的音频并渐弱到静音
+
+package audio
+
+// FrameRepeater conceals playback gaps by repeating the last good frame, fading it out
+// linearly over a number of frames before falling back to silence. Single lost packets
+// are usually inaudible this way, where a hard drop to silence clicks.
+type FrameRepeater struct {
+	frames   int // 一次间隙中最多重复的帧数
+	channels int
+	last     []byte // 最后一帧完好音频的副本（缓冲区中的帧播放后会归还复用）
+	out      []byte // 渐弱后的重复帧
+	repeated int    // 当前间隙中已重复的帧数
+}
+
+// NewFrameRepeater creates a repeater that conceals up to frames frames of each gap
+func NewFrameRepeater(frames, channels int) *FrameRepeater {
+	if frames < 1 {
+		frames = 1
+	}
+	return &FrameRepeater{frames: frames, channels: channels}
+}
+
+// Observe keeps a copy of a frame of real audio and ends the current gap
+func (r *FrameRepeater) Observe(frame []byte) {
+	r.last = append(r.last[:0], frame...)
+	r.repeated = 0
+}
+
+// Fill returns the next frame for a gap: the last good frame faded towards silence, or nil
+// when no audio was seen yet or the gap has outlasted the repeat limit and silence is due
+func (r *FrameRepeater) Fill(bitDepth int) []byte {
+	if len(r.last) == 0 || r.repeated >= r.frames {
+		return nil
+	}
+	r.out = append(r.out[:0], r.last...)
+	from := 1 - float64(r.repeated)/float64(r.frames)
+	to := 1 - float64(r.repeated+1)/float64(r.frames)
+	r.ramp(r.out, bitDepth, from, to)
+	r.repeated++
+	return r.out
+}
+
+// ramp 将帧的增益在本帧内从 from 线性过渡到 to
+func (r *FrameRepeater) ramp(frame []byte, bitDepth int, from, to float64) {
+	bytesPerSample := bitDepth / 8
+	if bitDepth == SampleDepthFloat32 {
+		bytesPerSample = 4
+	}
+	if r.channels == 0 || (bitDepth != 16 && bitDepth != 32 && bitDepth != SampleDepthFloat32) {
+		return
+	}
+	frames := len(frame) / bytesPerSample / r.channels
+	if frames == 0 {
+		return
+	}
+
+	step := (to - from) / float64(frames)
+	for f := 0; f < frames; f++ {
+		gain := from + step*float64(f+1)
+		for ch := 0; ch < r.channels; ch++ {
+			i := (f*r.channels + ch) * bytesPerSample
+			switch bitDepth {
+			case 16:
+				out := int16(float64(int16(frame[i])|int16(frame[i+1])<<8) * gain)
+				frame[i] = byte(out)
+				frame[i+1] = byte(out >> 8)
+			case 32:
+				out := int32(float64(int32(frame[i])|int32(frame[i+1])<<8|int32(frame[i+2])<<16|int32(frame[i+3])<<24) * gain)
+				frame[i] = byte(out)
+				frame[i+1] = byte(out >> 8)
+				frame[i+2] = byte(out >> 16)
+				frame[i+3] = byte(out >> 24)
+			case SampleDepthFloat32:
+				writeFloat32(frame[i:], float32(float64(readFloat32(frame[i:]))*gain))
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package audio
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// TestFrameRepeaterFadesOut 间隙中重复上一帧并逐帧渐弱，超过上限后返回 nil（静音）；新的音频结束间隙
+func TestFrameRepeaterFadesOut(t *testing.T) {
+	const channels, frames = 2, 4
+	frame := make([]byte, frames*channels*2)
+	for i := 0; i < frames*channels; i++ {
+		binary.LittleEndian.PutUint16(frame[i*2:], uint16(10000))
+	}
+	sample := func(data []byte, i int) int16 { return int16(binary.LittleEndian.Uint16(data[i*2:])) }
+
+	repeater := NewFrameRepeater(2, channels)
+	if repeater.Fill(16) != nil {
+		t.Fatalf("concealed a gap before any audio was seen")
+	}
+	repeater.Observe(frame)
+	frame[0] = 0 // 缓冲区中的帧会被复用，重复的必须是副本
+
+	first := repeater.Fill(16)
+	if first == nil {
+		t.Fatalf("first gap frame not concealed")
+	}
+	// 第一帧从满幅渐弱到一半，声道之间相同
+	if got := sample(first, 0); got != 8750 {
+		t.Fatalf("first repeated sample %d, want 8750", got)
+	}
+	if got, last := sample(first, 1), sample(first, frames*channels-1); got != 8750 || last != 5000 {
+		t.Fatalf("first repeated frame ramps %d..%d, want 8750..5000", got, last)
+	}
+	second := repeater.Fill(16)
+	if got := sample(second, frames*channels-1); second == nil || got != 0 {
+		t.Fatalf("second repeated frame should end at silence, got %v", second)
+	}
+	if repeater.Fill(16) != nil {
+		t.Fatalf("gap concealed beyond the repeat limit")
+	}
+
+	repeater.Observe(frame)
+	if repeater.Fill(16) == nil {
+		t.Fatalf("new audio did not end the gap")
+	}
+}
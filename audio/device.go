@@ -6,6 +6,9 @@ package audio
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gordonklaus/portaudio"
 	"RemoteAudioCLI/utils"
@@ -13,21 +16,34 @@ import (
 
 // DeviceInfo represents information about an audio device
 type DeviceInfo struct {
-	Index              int
-	Name               string
-	MaxInputChannels   int
-	MaxOutputChannels  int
-	DefaultSampleRate  float64
-	HostAPI            string
-	IsDefaultInput     bool
-	IsDefaultOutput    bool
+	Index              int     `json:"index"`
+	Name               string  `json:"name"`
+	MaxInputChannels   int     `json:"maxInputChannels"`
+	MaxOutputChannels  int     `json:"maxOutputChannels"`
+	DefaultSampleRate  float64 `json:"defaultSampleRate"`
+	HostAPI            string  `json:"hostApi"`
+	IsDefaultInput     bool    `json:"isDefaultInput"`
+	IsDefaultOutput    bool    `json:"isDefaultOutput"`
+
+	// PortAudio 报告的默认延迟（JSON 中为纳秒）；流按 low 值打开
+	DefaultLowInputLatency   time.Duration `json:"defaultLowInputLatency"`
+	DefaultLowOutputLatency  time.Duration `json:"defaultLowOutputLatency"`
+	DefaultHighInputLatency  time.Duration `json:"defaultHighInputLatency"`
+	DefaultHighOutputLatency time.Duration `json:"defaultHighOutputLatency"`
 }
 
 // AudioSystem manages the PortAudio system
-var audioSystemInitialized = false
+// PortAudio 采用懒初始化：第一次真正需要设备时才初始化，管道/帮助等模式不会触碰声卡
+var (
+	audioSystemInitialized = false
+	audioSystemMutex       sync.Mutex
+)
 
-// Initialize initializes the PortAudio system
+// Initialize initializes the PortAudio system (safe to call multiple times)
 func Initialize() error {
+	audioSystemMutex.Lock()
+	defer audioSystemMutex.Unlock()
+
 	if audioSystemInitialized {
 		return nil
 	}
@@ -40,8 +56,11 @@ func Initialize() error {
 	return nil
 }
 
-// Terminate terminates the PortAudio system
+// Terminate terminates the PortAudio system (no-op if it was never initialized)
 func Terminate() error {
+	audioSystemMutex.Lock()
+	defer audioSystemMutex.Unlock()
+
 	if !audioSystemInitialized {
 		return nil
 	}
@@ -54,10 +73,137 @@ func Terminate() error {
 	return nil
 }
 
+// RefreshDevices re-initializes PortAudio so that devices added or removed since
+// initialization (and a changed default device) become visible. All open streams
+// are invalidated, so callers must close their streams first.
+func RefreshDevices() error {
+	audioSystemMutex.Lock()
+	defer audioSystemMutex.Unlock()
+
+	if audioSystemInitialized {
+		if err := portaudio.Terminate(); err != nil {
+			return utils.WrapError(err, utils.ErrAudioDevice, "failed to terminate PortAudio")
+		}
+		audioSystemInitialized = false
+	}
+
+	if err := portaudio.Initialize(); err != nil {
+		return utils.WrapError(err, utils.ErrAudioDevice, "failed to initialize PortAudio")
+	}
+
+	audioSystemInitialized = true
+	return nil
+}
+
+// hostAPIFilter 限定只使用某个 Host API 的设备（大小写不敏感的子串匹配，空表示不过滤）
+var hostAPIFilter string
+
+// SetHostAPIFilter restricts device listing and default device lookup to matching host APIs
+func SetHostAPIFilter(hostAPI string) {
+	hostAPIFilter = strings.TrimSpace(hostAPI)
+}
+
+// GetHostAPIFilter returns the current host API filter
+func GetHostAPIFilter() string {
+	return hostAPIFilter
+}
+
+// matchesHostAPIFilter 检查 Host API 名称是否匹配过滤条件
+func matchesHostAPIFilter(hostAPIName string) bool {
+	if hostAPIFilter == "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(hostAPIName), strings.ToLower(hostAPIFilter))
+}
+
+// HostAPIInfo describes an available PortAudio host API
+type HostAPIInfo struct {
+	Name        string `json:"name"`
+	DeviceCount int    `json:"deviceCount"`
+	IsDefault   bool   `json:"isDefault"`
+
+	// 该 Host API 的默认输入/输出设备在 -list-devices 中的序号，没有时为 -1
+	DefaultInputDevice  int `json:"defaultInputDevice"`
+	DefaultOutputDevice int `json:"defaultOutputDevice"`
+}
+
+// deviceIndexOf 返回设备序号，nil 时为 -1
+func deviceIndexOf(device *portaudio.DeviceInfo) int {
+	if device == nil {
+		return -1
+	}
+	return device.Index
+}
+
+// ListHostAPIs returns all host APIs available on this system
+func ListHostAPIs() ([]HostAPIInfo, error) {
+	if err := Initialize(); err != nil {
+		return nil, err
+	}
+
+	hostAPIs, err := portaudio.HostApis()
+	if err != nil {
+		return nil, utils.WrapError(err, utils.ErrAudioDevice, "failed to enumerate host APIs")
+	}
+
+	defaultHostAPI, err := portaudio.DefaultHostApi()
+	if err != nil {
+		defaultHostAPI = nil
+	}
+
+	var apiList []HostAPIInfo
+	for _, api := range hostAPIs {
+		apiList = append(apiList, HostAPIInfo{
+			Name:        api.Name,
+			DeviceCount: len(api.Devices),
+			IsDefault:   defaultHostAPI != nil && api == defaultHostAPI,
+			DefaultInputDevice:  deviceIndexOf(api.DefaultInputDevice),
+			DefaultOutputDevice: deviceIndexOf(api.DefaultOutputDevice),
+		})
+	}
+
+	return apiList, nil
+}
+
+// defaultPortAudioDevice 返回默认设备；设置了 Host API 过滤时使用匹配的第一个 Host API 的默认设备
+func defaultPortAudioDevice(input bool) (*portaudio.DeviceInfo, error) {
+	if hostAPIFilter == "" {
+		if input {
+			return portaudio.DefaultInputDevice()
+		}
+		return portaudio.DefaultOutputDevice()
+	}
+
+	hostAPIs, err := portaudio.HostApis()
+	if err != nil {
+		return nil, err
+	}
+	for _, api := range hostAPIs {
+		if !matchesHostAPIFilter(api.Name) {
+			continue
+		}
+		if input && api.DefaultInputDevice != nil {
+			return api.DefaultInputDevice, nil
+		}
+		if !input && api.DefaultOutputDevice != nil {
+			return api.DefaultOutputDevice, nil
+		}
+	}
+
+	return nil, utils.NewAppError(utils.ErrAudioDevice, fmt.Sprintf("no default device for host API matching %q", hostAPIFilter))
+}
+
+// IsInitialized reports whether PortAudio has been initialized
+func IsInitialized() bool {
+	audioSystemMutex.Lock()
+	defer audioSystemMutex.Unlock()
+	return audioSystemInitialized
+}
+
 // ListDevices returns a list of all available audio devices
 func ListDevices() ([]DeviceInfo, error) {
-	if !audioSystemInitialized {
-		return nil, utils.NewAppError(utils.ErrAudioDevice, "PortAudio not initialized")
+	if err := Initialize(); err != nil {
+		return nil, err
 	}
 
 	devices, err := portaudio.Devices()
@@ -65,13 +211,13 @@ func ListDevices() ([]DeviceInfo, error) {
 		return nil, utils.WrapError(err, utils.ErrAudioDevice, "failed to enumerate audio devices")
 	}
 
-	defaultInputDevice, err := portaudio.DefaultInputDevice()
+	defaultInputDevice, err := defaultPortAudioDevice(true)
 	if err != nil {
 		// Log warning but continue
 		defaultInputDevice = nil
 	}
 
-	defaultOutputDevice, err := portaudio.DefaultOutputDevice()
+	defaultOutputDevice, err := defaultPortAudioDevice(false)
 	if err != nil {
 		// Log warning but continue
 		defaultOutputDevice = nil
@@ -88,6 +234,11 @@ func ListDevices() ([]DeviceInfo, error) {
 			hostAPIName = "Unknown"
 		}
 
+		// 按 Host API 过滤（保留原始索引，便于 -input-device/-output-device 使用）
+		if !matchesHostAPIFilter(hostAPIName) {
+			continue
+		}
+
 		isDefaultInput := defaultInputDevice != nil && device == defaultInputDevice
 		isDefaultOutput := defaultOutputDevice != nil && device == defaultOutputDevice
 
@@ -100,6 +251,11 @@ func ListDevices() ([]DeviceInfo, error) {
 			HostAPI:            hostAPIName,
 			IsDefaultInput:     isDefaultInput,
 			IsDefaultOutput:    isDefaultOutput,
+
+			DefaultLowInputLatency:   device.DefaultLowInputLatency,
+			DefaultLowOutputLatency:  device.DefaultLowOutputLatency,
+			DefaultHighInputLatency:  device.DefaultHighInputLatency,
+			DefaultHighOutputLatency: device.DefaultHighOutputLatency,
 		}
 		deviceList = append(deviceList, deviceInfo)
 	}
@@ -109,16 +265,16 @@ func ListDevices() ([]DeviceInfo, error) {
 
 // GetDefaultInputDevice returns the default input device
 func GetDefaultInputDevice() (*DeviceInfo, error) {
-	if !audioSystemInitialized {
-		return nil, utils.NewAppError(utils.ErrAudioDevice, "PortAudio not initialized")
+	if err := Initialize(); err != nil {
+		return nil, err
 	}
 
-	device, err := portaudio.DefaultInputDevice()
+	device, err := defaultPortAudioDevice(true)
 	if err != nil {
 		return nil, utils.WrapError(err, utils.ErrAudioDevice, "failed to get default input device")
 	}
 
-	if device.MaxInputChannels == 0 {
+	if device == nil || device.MaxInputChannels == 0 {
 		return nil, utils.NewAppError(utils.ErrAudioDevice, "default input device has no input channels")
 	}
 
@@ -149,21 +305,26 @@ func GetDefaultInputDevice() (*DeviceInfo, error) {
 		HostAPI:            hostAPIName,
 		IsDefaultInput:     true,
 		IsDefaultOutput:    false,
+
+		DefaultLowInputLatency:   device.DefaultLowInputLatency,
+		DefaultLowOutputLatency:  device.DefaultLowOutputLatency,
+		DefaultHighInputLatency:  device.DefaultHighInputLatency,
+		DefaultHighOutputLatency: device.DefaultHighOutputLatency,
 	}, nil
 }
 
 // GetDefaultOutputDevice returns the default output device
 func GetDefaultOutputDevice() (*DeviceInfo, error) {
-	if !audioSystemInitialized {
-		return nil, utils.NewAppError(utils.ErrAudioDevice, "PortAudio not initialized")
+	if err := Initialize(); err != nil {
+		return nil, err
 	}
 
-	device, err := portaudio.DefaultOutputDevice()
+	device, err := defaultPortAudioDevice(false)
 	if err != nil {
 		return nil, utils.WrapError(err, utils.ErrAudioDevice, "failed to get default output device")
 	}
 
-	if device.MaxOutputChannels == 0 {
+	if device == nil || device.MaxOutputChannels == 0 {
 		return nil, utils.NewAppError(utils.ErrAudioDevice, "default output device has no output channels")
 	}
 
@@ -194,6 +355,11 @@ func GetDefaultOutputDevice() (*DeviceInfo, error) {
 		HostAPI:            hostAPIName,
 		IsDefaultInput:     false,
 		IsDefaultOutput:    true,
+
+		DefaultLowInputLatency:   device.DefaultLowInputLatency,
+		DefaultLowOutputLatency:  device.DefaultLowOutputLatency,
+		DefaultHighInputLatency:  device.DefaultHighInputLatency,
+		DefaultHighOutputLatency: device.DefaultHighOutputLatency,
 	}, nil
 }
 
@@ -204,17 +370,19 @@ func GetDeviceByIndex(index int) (*DeviceInfo, error) {
 		return nil, err
 	}
 
-	if index < 0 || index >= len(devices) {
-		return nil, utils.NewAppError(utils.ErrAudioDevice, fmt.Sprintf("invalid device index: %d", index))
+	for i := range devices {
+		if devices[i].Index == index {
+			return &devices[i], nil
+		}
 	}
 
-	return &devices[index], nil
+	return nil, utils.NewAppError(utils.ErrAudioDevice, fmt.Sprintf("invalid device index: %d", index))
 }
 
 // GetPortAudioDevice returns the actual PortAudio device for a DeviceInfo
 func GetPortAudioDevice(deviceInfo *DeviceInfo) (*portaudio.DeviceInfo, error) {
-	if !audioSystemInitialized {
-		return nil, utils.NewAppError(utils.ErrAudioDevice, "PortAudio not initialized")
+	if err := Initialize(); err != nil {
+		return nil, err
 	}
 
 	devices, err := portaudio.Devices()
@@ -268,3 +436,117 @@ func ValidateDeviceForOutput(deviceInfo *DeviceInfo, sampleRate int, channels in
 
 	return nil
 }
+
+// DeviceID returns a stable identifier for the device (host API and name); unlike Index
+// it does not change when other devices are plugged in or removed
+func DeviceID(device *DeviceInfo) string {
+	return device.HostAPI + "/" + device.Name
+}
+
+// FindDeviceByID returns the device whose DeviceID equals id
+func FindDeviceByID(id string) (*DeviceInfo, error) {
+	devices, err := ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		if DeviceID(&devices[i]) == id {
+			return &devices[i], nil
+		}
+	}
+	return nil, utils.NewAppError(utils.ErrAudioDevice, fmt.Sprintf("device %q not found", id))
+}
+
+// DefaultLatency returns the device's default low input (or output) latency reported by
+// PortAudio, which is the latency streams are opened with
+func DefaultLatency(deviceInfo *DeviceInfo, input bool) (time.Duration, error) {
+	if deviceInfo == nil {
+		return 0, utils.NewAppError(utils.ErrAudioDevice, "no device")
+	}
+	if input {
+		return deviceInfo.DefaultLowInputLatency, nil
+	}
+	return deviceInfo.DefaultLowOutputLatency, nil
+}
+
+// FormatLatencyRange formats a low/high default latency pair for device listings
+func FormatLatencyRange(low, high time.Duration) string {
+	return fmt.Sprintf("%.1f ms (low) / %.1f ms (high)",
+		float64(low)/float64(time.Millisecond), float64(high)/float64(time.Millisecond))
+}
+
+// CheckFormatSupported asks PortAudio whether the device can open an input (or output) stream
+// with the given sample rate, channel count and bit depth (see SampleDepth), without opening it
+func CheckFormatSupported(deviceInfo *DeviceInfo, input bool, sampleRate int, channels int, bitDepth int) error {
+	paDevice, err := GetPortAudioDevice(deviceInfo)
+	if err != nil {
+		return err
+	}
+
+	// 缓冲区类型决定采样格式，与 Capturer/Player 打开流时一致
+	var buffer interface{}
+	formatName := fmt.Sprintf("%d-bit", bitDepth)
+	switch bitDepth {
+	case 16:
+		buffer = make([]int16, channels)
+	case 32:
+		buffer = make([]int32, channels)
+	case SampleDepthFloat32:
+		buffer = make([]float32, channels)
+		formatName = "float32"
+	default:
+		return utils.NewAppError(utils.ErrAudioDevice, fmt.Sprintf("unsupported bit depth: %d", bitDepth))
+	}
+
+	deviceParams := portaudio.StreamDeviceParameters{Device: paDevice, Channels: channels}
+	params := portaudio.StreamParameters{SampleRate: float64(sampleRate)}
+	if input {
+		deviceParams.Latency = paDevice.DefaultLowInputLatency
+		params.Input = deviceParams
+	} else {
+		deviceParams.Latency = paDevice.DefaultLowOutputLatency
+		params.Output = deviceParams
+	}
+
+	if err := portaudio.IsFormatSupported(params, buffer); err != nil {
+		return utils.WrapError(err, utils.ErrAudioDevice,
+			fmt.Sprintf("%dHz, %d channels, %s not supported", sampleRate, channels, formatName))
+	}
+
+	return nil
+}
+
+// ProbeSampleRates are the sample rates tried by ProbeFormats
+var ProbeSampleRates = []int{8000, 16000, 24000, 44100, 48000, 96000}
+
+// ProbeChannelCounts returns the channel counts worth probing for a device with maxChannels channels
+func ProbeChannelCounts(maxChannels int) []int {
+	var counts []int
+	for _, channels := range []int{1, 2, 4, 6, 8} {
+		if channels <= maxChannels {
+			counts = append(counts, channels)
+		}
+	}
+	if maxChannels > 0 && counts[len(counts)-1] != maxChannels {
+		counts = append(counts, maxChannels)
+	}
+	return counts
+}
+
+// ProbeFormats asks PortAudio which combinations of sampleRates and channelCounts the device
+// supports for 16-bit input (or output) streams; supported[i][j] is for sampleRates[i] and channelCounts[j]
+func ProbeFormats(deviceInfo *DeviceInfo, input bool, sampleRates []int, channelCounts []int) ([][]bool, error) {
+	// 设备索引无效时直接返回错误，而不是得到一个全部不支持的矩阵
+	if _, err := GetPortAudioDevice(deviceInfo); err != nil {
+		return nil, err
+	}
+
+	supported := make([][]bool, len(sampleRates))
+	for i, sampleRate := range sampleRates {
+		supported[i] = make([]bool, len(channelCounts))
+		for j, channels := range channelCounts {
+			supported[i][j] = CheckFormatSupported(deviceInfo, input, sampleRate, channels, 16) == nil
+		}
+	}
+	return supported, nil
+}
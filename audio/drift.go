@@ -0,0 +1,109 @@
+// audio/drift.go - 采集端与播放端时钟漂移估计
+
+package audio
+
+import (
+	"sync"
+	"time"
+)
+
+// DriftAction 表示漂移估计器建议的补偿动作
+type DriftAction int
+
+const (
+	DriftNone        DriftAction = iota
+	DriftDropFrame               // 缓冲区持续偏满：丢弃一帧
+	DriftRepeatFrame             // 缓冲区持续偏空：重复一帧
+)
+
+// DriftEstimator 根据缓冲区占用率的长期趋势估计两端时钟漂移
+//
+// 发送端与接收端的采样时钟存在微小偏差时，缓冲区会缓慢地变满或变空。
+// 估计器对占用率做慢速指数平滑，只有平滑值持续偏离目标并且距离上次补偿
+// 超过 interval 时才建议丢弃/重复一帧，从而保证补偿足够稀疏、不易察觉。
+// Observe 在播放循环中调用，DriftPPM 可由统计读取方并发调用。
+type DriftEstimator struct {
+	mutex sync.Mutex
+
+	target    float64       // 目标缓冲区占用率 (0.0 - 1.0)
+	tolerance float64       // 允许的偏离范围
+	interval  time.Duration // 两次补偿之间的最短间隔
+	bufferLen int           // 缓冲区容量（帧）
+
+	smoothed       float64
+	started        bool
+	lastCorrection time.Time
+
+	// 漂移速率估计
+	windowStart      time.Time
+	windowStartFill  float64
+	windowCorrection int64 // 窗口内的净补偿帧数（丢弃为正，重复为负）
+	driftFrames      float64
+}
+
+const (
+	driftSmoothing = 0.01             // 占用率平滑系数，约 100 帧的时间常数
+	driftWindow    = 10 * time.Second // 漂移速率估计窗口
+)
+
+// NewDriftEstimator 创建漂移估计器
+func NewDriftEstimator(target, tolerance float64, interval time.Duration, bufferLen int) *DriftEstimator {
+	return &DriftEstimator{
+		target:    target,
+		tolerance: tolerance,
+		interval:  interval,
+		bufferLen: bufferLen,
+	}
+}
+
+// Observe 记录一次缓冲区占用率并返回建议的补偿动作
+func (d *DriftEstimator) Observe(usage float64) DriftAction {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	now := time.Now()
+	if !d.started {
+		d.started = true
+		d.smoothed = usage
+		d.lastCorrection = now
+		d.windowStart = now
+		d.windowStartFill = usage
+		return DriftNone
+	}
+
+	d.smoothed = d.smoothed*(1-driftSmoothing) + usage*driftSmoothing
+
+	// 每个窗口结束时更新漂移速率：缓冲区净增长帧数 + 已补偿帧数
+	if elapsed := now.Sub(d.windowStart); elapsed >= driftWindow {
+		grown := (d.smoothed - d.windowStartFill) * float64(d.bufferLen)
+		d.driftFrames = (grown + float64(d.windowCorrection)) / elapsed.Seconds()
+		d.windowStart = now
+		d.windowStartFill = d.smoothed
+		d.windowCorrection = 0
+	}
+
+	if d.interval <= 0 || now.Sub(d.lastCorrection) < d.interval {
+		return DriftNone
+	}
+
+	switch {
+	case d.smoothed > d.target+d.tolerance:
+		d.lastCorrection = now
+		d.windowCorrection++
+		return DriftDropFrame
+	case d.smoothed < d.target-d.tolerance:
+		d.lastCorrection = now
+		d.windowCorrection--
+		return DriftRepeatFrame
+	}
+	return DriftNone
+}
+
+// DriftPPM 将估计的漂移速率换算为 ppm（正值表示发送端时钟偏快）
+func (d *DriftEstimator) DriftPPM(framesPerBuffer, sampleRate int) float64 {
+	if sampleRate <= 0 {
+		return 0.0
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.driftFrames * float64(framesPerBuffer) / float64(sampleRate) * 1e6
+}
@@ -0,0 +1,101 @@
+// audio/filter.go - 采集端高通/低通滤波（二阶 Butterworth 双二阶节）
+
+package audio
+
+import (
+	"math"
+)
+
+// biquadQ Butterworth 响应的品质因数（1/√2），通带平坦、无谐振峰
+const biquadQ = 1 / math.Sqrt2
+
+// biquadMaxCutoff 截止频率相对采样率的上限；越接近奈奎斯特频率系数越病态
+const biquadMaxCutoff = 0.45
+
+// Biquad is a second-order IIR filter (RBJ cookbook coefficients, transposed direct form II)
+// with independent state per interleaved channel. It adds no buffering latency.
+type Biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+	// 每声道的两个延迟状态
+	z1, z2 []float64
+}
+
+// NewHighPass creates a high-pass filter that removes content below cutoffHz (e.g. 80 Hz rumble).
+// It returns nil if the cutoff is not below biquadMaxCutoff of the sample rate.
+func NewHighPass(sampleRate, channels int, cutoffHz float64) *Biquad {
+	w0, alpha, ok := biquadParams(sampleRate, cutoffHz)
+	if !ok {
+		return nil
+	}
+	cos := math.Cos(w0)
+	return newBiquad(channels, (1+cos)/2, -(1 + cos), (1+cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// NewLowPass creates a low-pass filter that removes content above cutoffHz (e.g. 15000 Hz hiss).
+// It returns nil if the cutoff is not below biquadMaxCutoff of the sample rate, where it would have no effect.
+func NewLowPass(sampleRate, channels int, cutoffHz float64) *Biquad {
+	w0, alpha, ok := biquadParams(sampleRate, cutoffHz)
+	if !ok {
+		return nil
+	}
+	cos := math.Cos(w0)
+	return newBiquad(channels, (1-cos)/2, 1-cos, (1-cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// biquadParams 计算归一化角频率与 alpha；截止频率超出稳定范围时返回 ok=false
+func biquadParams(sampleRate int, cutoffHz float64) (w0, alpha float64, ok bool) {
+	if sampleRate <= 0 || cutoffHz <= 0 || cutoffHz >= biquadMaxCutoff*float64(sampleRate) {
+		return 0, 0, false
+	}
+	w0 = 2 * math.Pi * cutoffHz / float64(sampleRate)
+	return w0, math.Sin(w0) / (2 * biquadQ), true
+}
+
+// newBiquad 按 a0 归一化系数
+func newBiquad(channels int, b0, b1, b2, a0, a1, a2 float64) *Biquad {
+	return &Biquad{
+		b0: b0 / a0, b1: b1 / a0, b2: b2 / a0,
+		a1: a1 / a0, a2: a2 / a0,
+		z1: make([]float64, channels),
+		z2: make([]float64, channels),
+	}
+}
+
+// processSample 滤波一个采样（-1.0 到 1.0），ch 为声道索引
+func (f *Biquad) processSample(x float64, ch int) float64 {
+	y := f.b0*x + f.z1[ch]
+	f.z1[ch] = f.b1*x - f.a1*y + f.z2[ch]
+	f.z2[ch] = f.b2*x - f.a2*y
+	return y
+}
+
+// Process filters interleaved little-endian samples in place; bitDepth is as returned by SampleDepth
+func (f *Biquad) Process(audioData []byte, bitDepth int) {
+	channels := len(f.z1)
+	switch bitDepth {
+	case 16:
+		for i := 0; i+1 < len(audioData); i += 2 {
+			sample := int16(audioData[i]) | int16(audioData[i+1])<<8
+			filtered := f.processSample(float64(sample)/32768.0, (i/2)%channels)
+			out := int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(filtered*32768.0))))
+			audioData[i] = byte(out)
+			audioData[i+1] = byte(out >> 8)
+		}
+	case 32:
+		for i := 0; i+3 < len(audioData); i += 4 {
+			sample := int32(audioData[i]) | int32(audioData[i+1])<<8 | int32(audioData[i+2])<<16 | int32(audioData[i+3])<<24
+			filtered := f.processSample(float64(sample)/2147483648.0, (i/4)%channels)
+			out := int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(filtered*2147483648.0))))
+			audioData[i] = byte(out)
+			audioData[i+1] = byte(out >> 8)
+			audioData[i+2] = byte(out >> 16)
+			audioData[i+3] = byte(out >> 24)
+		}
+	case SampleDepthFloat32:
+		for i := 0; i+3 < len(audioData); i += 4 {
+			filtered := f.processSample(float64(readFloat32(audioData[i:])), (i/4)%channels)
+			writeFloat32(audioData[i:], clampFloat32(filtered))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// audio/limiter.go - 播放端软限幅器：峰值接近满幅时平滑压缩，而不是硬削波
+
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+// limiterThreshold 开始压缩的峰值电平（约 -3 dBFS），其上的峰值被软拐点压向满幅
+var limiterThreshold = math.Pow(10, -3.0/20)
+
+// limiterActiveGain 增益低于此值（约 -0.1 dB）才算正在限幅，用于统计指示
+const limiterActiveGain = 0.989
+
+// limiterIndicatorHold 最后一次限幅后统计中继续显示指示的时长
+const limiterIndicatorHold = 500 * time.Millisecond
+
+// Limiter is a per-channel peak limiter with instant attack and exponential release.
+// Peaks above limiterThreshold are mapped through a tanh knee that approaches but never
+// reaches full scale, so the output does not need hard clamping.
+type Limiter struct {
+	release  float64   // 每个采样帧的包络衰减系数
+	envelope []float64 // 每声道的峰值包络
+	active   bool      // 上次 TakeActive 以来是否压缩过
+}
+
+// NewLimiter creates a limiter for interleaved audio; release is how long the gain takes
+// to recover (to 1/e) after a peak
+func NewLimiter(sampleRate, channels int, release time.Duration) *Limiter {
+	l := &Limiter{envelope: make([]float64, channels)}
+	if frames := release.Seconds() * float64(sampleRate); frames > 0 {
+		l.release = math.Exp(-1 / frames)
+	}
+	return l
+}
+
+// Process limits one sample (-1.0 to 1.0, float samples may exceed it) of channel ch
+func (l *Limiter) Process(x float64, ch int) float64 {
+	peak := math.Abs(x)
+	// 瞬时起音：峰值立即抬高包络；之后按 release 衰减
+	envelope := l.envelope[ch] * l.release
+	if peak > envelope {
+		envelope = peak
+	}
+	l.envelope[ch] = envelope
+	if envelope <= limiterThreshold {
+		return x
+	}
+	knee := 1 - limiterThreshold
+	limited := limiterThreshold + knee*math.Tanh((envelope-limiterThreshold)/knee)
+	gain := limited / envelope
+	if gain < limiterActiveGain {
+		l.active = true
+	}
+	return x * gain
+}
+
+// TakeActive reports whether the limiter reduced the gain noticeably since the last call
+func (l *Limiter) TakeActive() bool {
+	active := l.active
+	l.active = false
+	return active
+}
@@ -0,0 +1,48 @@
+package audio
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestLimiterBelowThreshold 阈值以下的采样不变，也不算限幅
+func TestLimiterBelowThreshold(t *testing.T) {
+	limiter := NewLimiter(48000, 2, 100*time.Millisecond)
+	for i := 0; i < 480; i++ {
+		x := 0.5 * math.Sin(float64(i)/10)
+		if got := limiter.Process(x, i%2); got != x {
+			t.Fatalf("sample %d: %v changed to %v", i, x, got)
+		}
+	}
+	if limiter.TakeActive() {
+		t.Fatalf("limiter reported active below the threshold")
+	}
+}
+
+// TestLimiterCompressesPeaks 超过满幅的峰值被压到满幅以下，声道之间相互独立，增益随后按 release 恢复
+func TestLimiterCompressesPeaks(t *testing.T) {
+	limiter := NewLimiter(48000, 2, 10*time.Millisecond)
+	for _, peak := range []float64{0.9, 1.0, 1.5, -4.0} {
+		if got := limiter.Process(peak, 0); math.Abs(got) >= 1 || math.Abs(got) < limiterThreshold || got*peak < 0 {
+			t.Fatalf("peak %v limited to %v", peak, got)
+		}
+	}
+	if !limiter.TakeActive() || limiter.TakeActive() {
+		t.Fatalf("TakeActive should report the peaks once")
+	}
+	if got := limiter.Process(0.5, 1); got != 0.5 {
+		t.Fatalf("channel 1 affected by channel 0 peaks: %v", got)
+	}
+
+	// 刚过峰值时小信号也被压低；50 ms（5 倍 release）后恢复原样
+	if got := limiter.Process(0.5, 0); got >= 0.5 {
+		t.Fatalf("gain recovered instantly: %v", got)
+	}
+	for i := 0; i < 2400; i++ {
+		limiter.Process(0.01, 0)
+	}
+	if got := limiter.Process(0.5, 0); got != 0.5 {
+		t.Fatalf("gain not recovered after release: %v", got)
+	}
+}
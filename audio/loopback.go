@@ -0,0 +1,57 @@
+// audio/loopback.go - WASAPI 环回采集（采集“正在播放的声音”）
+
+package audio
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"RemoteAudioCLI/utils"
+)
+
+// loopbackDeviceSuffix PortAudio WASAPI 为每个输出设备额外枚举的环回输入设备名称后缀
+const loopbackDeviceSuffix = "[Loopback]"
+
+// isWASAPI 检查 Host API 名称是否为 WASAPI
+func isWASAPI(hostAPIName string) bool {
+	return strings.Contains(strings.ToLower(hostAPIName), "wasapi")
+}
+
+// GetLoopbackDevice returns the WASAPI loopback input device for an output device.
+//
+// The Go PortAudio binding does not expose PaWasapiStreamInfo, so instead of
+// setting the loopback flag on the stream we use the "[Loopback]" input device
+// PortAudio's WASAPI host API enumerates for every render endpoint. The returned
+// device can be used with a normal Capturer.
+func GetLoopbackDevice(output *DeviceInfo) (*DeviceInfo, error) {
+	if runtime.GOOS != "windows" {
+		return nil, utils.NewAppError(utils.ErrAudioDevice, "loopback capture is only supported on Windows (WASAPI)")
+	}
+
+	if output == nil {
+		return nil, utils.NewAppError(utils.ErrAudioDevice, "no output device selected for loopback capture")
+	}
+
+	if !isWASAPI(output.HostAPI) {
+		return nil, utils.NewAppError(utils.ErrAudioDevice,
+			fmt.Sprintf("loopback capture requires a WASAPI output device, [%d] %s uses %s", output.Index, output.Name, output.HostAPI))
+	}
+
+	devices, err := ListDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range devices {
+		device := devices[i]
+		if device.MaxInputChannels > 0 && isWASAPI(device.HostAPI) &&
+			strings.Contains(device.Name, loopbackDeviceSuffix) &&
+			strings.HasPrefix(device.Name, strings.TrimSpace(output.Name)) {
+			return &device, nil
+		}
+	}
+
+	return nil, utils.NewAppError(utils.ErrAudioDevice,
+		fmt.Sprintf("no WASAPI loopback device found for %s (PortAudio must be built with WASAPI loopback support)", output.Name))
+}
@@ -23,6 +23,11 @@ type NotificationPlayer struct {
 	config   *utils.Config
 	logger   *utils.Logger
 	mutex    sync.Mutex
+
+	// -connect-sound / -disconnect-sound / -startup-sound：为 false 时对应的提示音不播放
+	connectSound    bool
+	disconnectSound bool
+	startupSound    bool
 }
 
 // NewNotificationPlayer 创建新的通知播放器
@@ -31,12 +36,20 @@ func NewNotificationPlayer(device *DeviceInfo, config *utils.Config, logger *uti
 		device: device,
 		config: config,
 		logger: logger,
+
+		connectSound:    config.ConnectSound,
+		disconnectSound: config.DisconnectSound,
+		startupSound:    config.StartupSound,
 	}
 }
 
-// PlayConnectionSound 播放连接提示音，返回播放完成通道
+// PlayConnectionSound 播放连接提示音，返回播放完成通道（-connect-sound=false 时立即完成）
 func (np *NotificationPlayer) PlayConnectionSound() chan struct{} {
 	done := make(chan struct{})
+	if !np.connectSound {
+		close(done)
+		return done
+	}
 	
 	go func() {
 		np.mutex.Lock()
@@ -66,6 +79,9 @@ func (np *NotificationPlayer) PlayConnectionSound() chan struct{} {
 
 // PlayDisconnectionSound 播放断开连接提示音
 func (np *NotificationPlayer) PlayDisconnectionSound() {
+	if !np.disconnectSound {
+		return
+	}
 	np.mutex.Lock()
 	defer np.mutex.Unlock()
 
@@ -85,8 +101,26 @@ func (np *NotificationPlayer) PlayDisconnectionSound() {
 	}
 }
 
+// PlayDeviceChangeBeep 输出切换到新设备后播放一声提示音
+func (np *NotificationPlayer) PlayDeviceChangeBeep() {
+	np.mutex.Lock()
+	defer np.mutex.Unlock()
+	np.logger.Info("🔔 Playing device change beep")
+	np.playSystemBeep()
+}
+
+// SetDevice 切换通知音使用的输出设备
+func (np *NotificationPlayer) SetDevice(device *DeviceInfo) {
+	np.mutex.Lock()
+	defer np.mutex.Unlock()
+	np.device = device
+}
+
 // PlayStartupBeep 启动后播放4声不同音调蜂鸣
 func (np *NotificationPlayer) PlayStartupBeep() {
+	if !np.startupSound {
+		return
+	}
 	np.mutex.Lock()
 	defer np.mutex.Unlock()
 	np.logger.Info("🔔 Playing startup 4-tone beep")
@@ -319,27 +353,36 @@ func (np *NotificationPlayer) playWithSystemPlayer(filePath string) error {
 	
 	switch runtime.GOOS {
 	case "windows":
-		// Windows: 使用 PowerShell 播放音频
-		script := fmt.Sprintf(`
+		// Windows: 使用 PowerShell 播放音频；WAV 用 System.Media.SoundPlayer，不需要加载
+		// presentationCore，启动快得多。单引号在 PowerShell 字符串中写作两个单引号
+		quoted := strings.ReplaceAll(filePath, "'", "''")
+		var script string
+		if strings.EqualFold(filepath.Ext(filePath), ".wav") {
+			script = fmt.Sprintf(`
 			try {
-				if ('%s' -match '\.wav$') {
-					$player = New-Object System.Media.SoundPlayer '%s'
-					$player.PlaySync()
-				} else {
-					Add-Type -AssemblyName presentationCore
-					$mediaPlayer = New-Object System.Windows.Media.MediaPlayer
-					$mediaPlayer.open('%s')
-					$mediaPlayer.Play()
-					Start-Sleep -Seconds 3
-					$mediaPlayer.Stop()
-					$mediaPlayer.Close()
-				}
+				(New-Object System.Media.SoundPlayer '%s').PlaySync()
 			} catch {
 				Write-Host "Failed to play audio file"
 			}
-		`, filePath, filePath, filePath)
+		`, quoted)
+		} else {
+			script = fmt.Sprintf(`
+			try {
+				Add-Type -AssemblyName presentationCore
+				$mediaPlayer = New-Object System.Windows.Media.MediaPlayer
+				$mediaPlayer.open('%s')
+				$mediaPlayer.Play()
+				Start-Sleep -Seconds 3
+				$mediaPlayer.Stop()
+				$mediaPlayer.Close()
+			} catch {
+				Write-Host "Failed to play audio file"
+			}
+		`, quoted)
+		}
 		
-		cmd = exec.Command("powershell", "-Command", script)
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-WindowStyle", "Hidden", "-Command", script)
+		hideWindow(cmd)
 		
 	case "darwin":
 		// macOS: 使用 afplay
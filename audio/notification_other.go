@@ -0,0 +1,8 @@
+//go:build !windows
+
+package audio
+
+import "os/exec"
+
+// hideWindow 其他平台的播放器不会打开窗口
+func hideWindow(cmd *exec.Cmd) {}
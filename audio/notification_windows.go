@@ -0,0 +1,16 @@
+// audio/notification_windows.go - 系统播放器回退：不显示 PowerShell 控制台窗口
+
+package audio
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// createNoWindow Windows 的 CREATE_NO_WINDOW 进程创建标志
+const createNoWindow = 0x08000000
+
+// hideWindow 让控制台子进程（powershell）在后台运行，不闪出窗口
+func hideWindow(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: createNoWindow}
+}
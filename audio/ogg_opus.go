@@ -0,0 +1,277 @@
+// audio/ogg_opus.go - Ogg 容器解复用，逐个读出 Ogg Opus 文件中的 Opus 包（不解码）
+
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"RemoteAudioCLI/utils"
+)
+
+// Ogg 页头标志
+const (
+	oggFlagContinued = 0x01 // 本页第一个包延续上一页
+	oggFlagFirst     = 0x02 // 逻辑流的第一页 (BOS)
+)
+
+// oggHeaderSize 页头固定部分的长度（不含分段表）
+const oggHeaderSize = 27
+
+// OpusSampleRate is the rate Opus packet durations are expressed in
+const OpusSampleRate = 48000
+
+// oggCRCTable Ogg 使用的 CRC-32（多项式 0x04C11DB7，不反射，初值 0）
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// oggCRC 计算页的校验和（校验和字段须已置 0）
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// OpusHead is the identification header of an Ogg Opus stream (RFC 7845)
+type OpusHead struct {
+	Channels        int
+	PreSkip         int // 解码后应丢弃的开头采样数（48kHz）
+	InputSampleRate int // 编码前的原始采样率，仅供参考
+	MappingFamily   int // 0 = 单流单/立体声
+}
+
+// OggOpusReader reads the Opus packets of the first logical stream in an Ogg file
+type OggOpusReader struct {
+	Head OpusHead
+
+	r        io.Reader
+	closer   io.Closer
+	serial   uint32
+	serialOK bool
+
+	// 当前页尚未读出的分段与数据
+	segments []byte
+	data     []byte
+	eos      bool
+}
+
+// OpenOggOpusFile opens an Ogg Opus file and reads its headers
+func OpenOggOpusFile(path string) (*OggOpusReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, utils.WrapError(err, utils.ErrAudioCapture, "failed to open input file")
+	}
+	reader, err := NewOggOpusReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader.closer = file
+	return reader, nil
+}
+
+// NewOggOpusReader reads the OpusHead and OpusTags headers from r. Only mono and
+// stereo streams (mapping family 0) are accepted, whose packets are plain Opus packets.
+func NewOggOpusReader(r io.Reader) (*OggOpusReader, error) {
+	or := &OggOpusReader{r: r}
+
+	head, err := or.nextPacket()
+	if err != nil {
+		return nil, utils.WrapError(err, utils.ErrAudioCapture, "failed to read Ogg Opus header")
+	}
+	if len(head) < 19 || string(head[:8]) != "OpusHead" {
+		return nil, utils.NewAppError(utils.ErrAudioCapture, "not an Ogg Opus file (missing OpusHead)")
+	}
+	if version := head[8]; version>>4 != 0 {
+		return nil, utils.ErrAudioCapturef("unsupported Ogg Opus version %d", version)
+	}
+	or.Head = OpusHead{
+		Channels:        int(head[9]),
+		PreSkip:         int(binary.LittleEndian.Uint16(head[10:12])),
+		InputSampleRate: int(binary.LittleEndian.Uint32(head[12:16])),
+		MappingFamily:   int(head[18]),
+	}
+	if or.Head.MappingFamily != 0 || or.Head.Channels < 1 || or.Head.Channels > 2 {
+		return nil, utils.ErrAudioCapturef("unsupported Ogg Opus channel mapping: family %d, %d channels (only mono/stereo)",
+			or.Head.MappingFamily, or.Head.Channels)
+	}
+
+	tags, err := or.nextPacket()
+	if err != nil {
+		return nil, utils.WrapError(err, utils.ErrAudioCapture, "failed to read Ogg Opus tags")
+	}
+	if len(tags) < 8 || string(tags[:8]) != "OpusTags" {
+		return nil, utils.NewAppError(utils.ErrAudioCapture, "Ogg Opus file is missing OpusTags")
+	}
+	return or, nil
+}
+
+// ReadPacket returns the next Opus audio packet, or io.EOF after the last one.
+// The returned slice is only valid until the next call.
+func (or *OggOpusReader) ReadPacket() ([]byte, error) {
+	for {
+		packet, err := or.nextPacket()
+		if err != nil {
+			return nil, err
+		}
+		// 零长度包在 Ogg Opus 中没有意义，跳过
+		if len(packet) > 0 {
+			return packet, nil
+		}
+	}
+}
+
+// Close closes the underlying file when the reader was opened with OpenOggOpusFile
+func (or *OggOpusReader) Close() error {
+	if or.closer == nil {
+		return nil
+	}
+	return or.closer.Close()
+}
+
+// nextPacket 拼接分段（可能跨页）得到下一个完整的包
+func (or *OggOpusReader) nextPacket() ([]byte, error) {
+	var packet []byte
+	started := false
+	for {
+		if len(or.segments) == 0 {
+			if or.eos {
+				if started {
+					return nil, io.ErrUnexpectedEOF
+				}
+				return nil, io.EOF
+			}
+			continued, err := or.readPage()
+			if err != nil {
+				if err == io.EOF && started {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			// 不接续上一页的页开始一个新包：丢弃未完成的残包
+			if !continued {
+				packet, started = packet[:0], false
+			}
+			continue
+		}
+
+		size := int(or.segments[0])
+		or.segments = or.segments[1:]
+		if size > len(or.data) {
+			return nil, utils.NewAppError(utils.ErrAudioCapture, "Ogg page data shorter than its segment table")
+		}
+		packet = append(packet, or.data[:size]...)
+		or.data = or.data[size:]
+		started = true
+		if size < 255 {
+			return packet, nil
+		}
+	}
+}
+
+// readPage 读取属于本逻辑流的下一页，返回该页第一个包是否延续上一页
+func (or *OggOpusReader) readPage() (bool, error) {
+	for {
+		var header [oggHeaderSize]byte
+		if _, err := io.ReadFull(or.r, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return false, utils.NewAppError(utils.ErrAudioCapture, "truncated Ogg page header")
+			}
+			return false, err
+		}
+		if string(header[:4]) != "OggS" || header[4] != 0 {
+			return false, utils.NewAppError(utils.ErrAudioCapture, "invalid Ogg page (lost sync)")
+		}
+		flags := header[5]
+		serial := binary.LittleEndian.Uint32(header[14:18])
+		checksum := binary.LittleEndian.Uint32(header[22:26])
+
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(or.r, segments); err != nil {
+			return false, utils.WrapError(err, utils.ErrAudioCapture, "truncated Ogg segment table")
+		}
+		size := 0
+		for _, s := range segments {
+			size += int(s)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(or.r, data); err != nil {
+			return false, utils.WrapError(err, utils.ErrAudioCapture, "truncated Ogg page")
+		}
+
+		binary.LittleEndian.PutUint32(header[22:26], 0)
+		page := append(append(header[:], segments...), data...)
+		if oggCRC(page) != checksum {
+			return false, utils.NewAppError(utils.ErrAudioCapture, "Ogg page checksum mismatch")
+		}
+
+		// 只跟随第一个逻辑流（多路复用的其他流跳过）
+		if !or.serialOK {
+			if flags&oggFlagFirst == 0 {
+				return false, utils.NewAppError(utils.ErrAudioCapture, "Ogg stream does not start with a BOS page")
+			}
+			or.serial, or.serialOK = serial, true
+		} else if serial != or.serial {
+			continue
+		}
+
+		or.segments = segments
+		or.data = data
+		or.eos = header[5]&0x04 != 0
+		return flags&oggFlagContinued != 0, nil
+	}
+}
+
+// OpusPacketFrames returns the duration of an Opus packet in sample frames at 48 kHz,
+// from its TOC byte and frame count code (RFC 6716 section 3.1)
+func OpusPacketFrames(packet []byte) (int, error) {
+	if len(packet) == 0 {
+		return 0, utils.NewAppError(utils.ErrAudioCapture, "empty Opus packet")
+	}
+	toc := packet[0]
+	config := int(toc >> 3)
+	var frameSize int
+	switch {
+	case config < 12: // SILK：10/20/40/60 ms
+		frameSize = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid：10/20 ms
+		frameSize = []int{480, 960}[config%2]
+	default: // CELT：2.5/5/10/20 ms
+		frameSize = []int{120, 240, 480, 960}[config%4]
+	}
+
+	var count int
+	switch toc & 0x03 {
+	case 0:
+		count = 1
+	case 1, 2:
+		count = 2
+	default:
+		if len(packet) < 2 {
+			return 0, utils.NewAppError(utils.ErrAudioCapture, "truncated Opus packet (missing frame count)")
+		}
+		count = int(packet[1] & 0x3F)
+	}
+	frames := frameSize * count
+	if frames == 0 || frames > 5760 { // 单包最长 120 ms
+		return 0, utils.ErrAudioCapturef("invalid Opus packet duration: %d frames", frames)
+	}
+	return frames, nil
+}
@@ -0,0 +1,192 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// opusHeadPacket 构造 OpusHead 识别头
+func opusHeadPacket(channels, family int) []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint16(head[10:], 312)
+	binary.LittleEndian.PutUint32(head[12:], 44100)
+	head[18] = byte(family)
+	return head
+}
+
+// opusTagsPacket 构造最小的 OpusTags 注释头
+func opusTagsPacket() []byte {
+	tags := append([]byte("OpusTags"), 4, 0, 0, 0)
+	tags = append(tags, "test"...)
+	return append(tags, 0, 0, 0, 0)
+}
+
+// oggStream 按 Ogg 分段规则把包写成页，每页最多 maxSegments 个分段，用来制造跨页的包
+func oggStream(serial uint32, packets [][]byte, maxSegments int) []byte {
+	type segment struct {
+		data  []byte
+		start bool // 包的第一个分段
+	}
+	var segments []segment
+	for _, packet := range packets {
+		start := true
+		for {
+			n := len(packet)
+			if n > 255 {
+				n = 255
+			}
+			segments = append(segments, segment{packet[:n], start})
+			start = false
+			packet = packet[n:]
+			if n < 255 {
+				break
+			}
+		}
+	}
+
+	var stream []byte
+	for seq := 0; len(segments) > 0; seq++ {
+		count := len(segments)
+		if count > maxSegments {
+			count = maxSegments
+		}
+		page := make([]byte, oggHeaderSize, oggHeaderSize+count+count*255)
+		copy(page, "OggS")
+		if !segments[0].start {
+			page[5] |= oggFlagContinued
+		}
+		if seq == 0 {
+			page[5] |= oggFlagFirst
+		}
+		if count == len(segments) {
+			page[5] |= 0x04
+		}
+		binary.LittleEndian.PutUint32(page[14:], serial)
+		binary.LittleEndian.PutUint32(page[18:], uint32(seq))
+		page[26] = byte(count)
+		for _, s := range segments[:count] {
+			page = append(page, byte(len(s.data)))
+		}
+		for _, s := range segments[:count] {
+			page = append(page, s.data...)
+		}
+		binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+		stream = append(stream, page...)
+		segments = segments[count:]
+	}
+	return stream
+}
+
+func TestOggOpusReaderPackets(t *testing.T) {
+	// 20 ms CELT 包（TOC 0xFC），其中一个长度正好是 255 的倍数、一个跨越多页
+	packets := [][]byte{
+		append([]byte{0xFC}, bytes.Repeat([]byte{1}, 99)...),
+		append([]byte{0xFC}, bytes.Repeat([]byte{2}, 509)...),
+		append([]byte{0xFC}, bytes.Repeat([]byte{3}, 1200)...),
+		{0xFC, 4},
+	}
+	stream := oggStream(0x1234, append([][]byte{opusHeadPacket(2, 0), opusTagsPacket()}, packets...), 3)
+
+	reader, err := NewOggOpusReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("NewOggOpusReader: %v", err)
+	}
+	want := OpusHead{Channels: 2, PreSkip: 312, InputSampleRate: 44100}
+	if reader.Head != want {
+		t.Fatalf("head = %+v, want %+v", reader.Head, want)
+	}
+	for i, packet := range packets {
+		got, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if !bytes.Equal(got, packet) {
+			t.Fatalf("packet %d: got %d bytes, want %d", i, len(got), len(packet))
+		}
+	}
+	if _, err := reader.ReadPacket(); err != io.EOF {
+		t.Fatalf("after last packet: got %v, want io.EOF", err)
+	}
+}
+
+// TestOggOpusReaderSkipsOtherStreams 多路复用文件中只读取第一个逻辑流
+func TestOggOpusReaderSkipsOtherStreams(t *testing.T) {
+	// 每个包一页：第一个流的 BOS 页在前，另一个流的页插在它后面
+	first := oggStream(1, [][]byte{opusHeadPacket(1, 0), opusTagsPacket(), {0xFC, 1}, {0xFC, 2}}, 1)
+	other := oggStream(2, [][]byte{[]byte("theora"), {9, 9, 9}}, 255)
+	headPage := oggHeaderSize + 1 + len(opusHeadPacket(1, 0))
+	stream := append(append(append([]byte{}, first[:headPage]...), other...), first[headPage:]...)
+
+	reader, err := NewOggOpusReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("NewOggOpusReader: %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		packet, err := reader.ReadPacket()
+		if err != nil || !bytes.Equal(packet, []byte{0xFC, byte(i)}) {
+			t.Fatalf("packet %d = %v (err %v)", i, packet, err)
+		}
+	}
+	if _, err := reader.ReadPacket(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF at the end of the first stream", err)
+	}
+}
+
+func TestOggOpusReaderRejectsInvalidFiles(t *testing.T) {
+	valid := oggStream(1, [][]byte{opusHeadPacket(2, 0), opusTagsPacket(), {0xFC, 1}}, 255)
+	corrupted := append([]byte(nil), valid...)
+	corrupted[len(corrupted)-1] ^= 0xFF
+
+	tests := []struct {
+		name   string
+		stream []byte
+	}{
+		{"not Ogg", []byte("RIFF\x00\x00\x00\x00WAVEfmt ")},
+		{"not Opus", oggStream(1, [][]byte{[]byte("\x01vorbis\x00\x00\x00\x00"), opusTagsPacket()}, 255)},
+		{"surround mapping", oggStream(1, [][]byte{opusHeadPacket(6, 1), opusTagsPacket()}, 255)},
+		{"missing tags", oggStream(1, [][]byte{opusHeadPacket(2, 0), {0xFC, 1}}, 255)},
+		{"checksum", corrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := NewOggOpusReader(bytes.NewReader(tt.stream))
+			if err == nil {
+				_, err = reader.ReadPacket()
+			}
+			if err == nil || err == io.EOF {
+				t.Fatalf("expected an error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOpusPacketFrames(t *testing.T) {
+	tests := []struct {
+		packet []byte
+		frames int
+	}{
+		{[]byte{0x08}, 960},        // SILK 20 ms
+		{[]byte{0x18}, 2880},       // SILK 60 ms
+		{[]byte{0x68}, 960},        // Hybrid 20 ms
+		{[]byte{0x80}, 120},        // CELT 2.5 ms
+		{[]byte{0xF8}, 960},        // CELT 20 ms
+		{[]byte{0xF9}, 1920},       // 两帧
+		{[]byte{0xFB, 0x03}, 2880}, // code 3：三帧
+	}
+	for _, tt := range tests {
+		frames, err := OpusPacketFrames(tt.packet)
+		if err != nil || frames != tt.frames {
+			t.Errorf("OpusPacketFrames(%#x) = %d, %v; want %d", tt.packet, frames, err, tt.frames)
+		}
+	}
+	for _, packet := range [][]byte{nil, {0xFB}, {0xFB, 0x00}, {0x1B, 0x03}} {
+		if _, err := OpusPacketFrames(packet); err == nil {
+			t.Errorf("OpusPacketFrames(%#x): expected an error", packet)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// audio/pipe.go - 原始 PCM 管道输入/输出（不经过 PortAudio）
+
+package audio
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+// StdioPipe 表示使用标准输入/输出作为管道
+const StdioPipe = "-"
+
+// nopCloser 包装标准输入输出，避免关闭进程的 stdin/stdout
+type nopReadCloser struct{ io.Reader }
+
+func (nopReadCloser) Close() error { return nil }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// OpenInputPipe 打开原始 PCM 输入管道，"-" 表示标准输入
+func OpenInputPipe(path string) (io.ReadCloser, error) {
+	if path == StdioPipe {
+		return nopReadCloser{os.Stdin}, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, utils.WrapError(err, utils.ErrAudioCapture, "failed to open input pipe")
+	}
+	return file, nil
+}
+
+// OpenOutputPipe 打开原始 PCM 输出管道，"-" 表示标准输出
+func OpenOutputPipe(path string) (io.WriteCloser, error) {
+	if path == StdioPipe {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, utils.WrapError(err, utils.ErrAudioPlayback, "failed to open output pipe")
+	}
+	return file, nil
+}
+
+// framePacer 按音频帧时长节拍运行，代替声卡提供的实时节奏
+type framePacer struct {
+	interval time.Duration
+	next     time.Time
+}
+
+// newFramePacer 根据每帧采样数和采样率创建节拍器
+func newFramePacer(framesPerBuffer, sampleRate int) *framePacer {
+	return &framePacer{
+		interval: time.Duration(framesPerBuffer) * time.Second / time.Duration(sampleRate),
+	}
+}
+
+// Wait 等待到下一帧的时间点；如果已经落后则重新对齐，不做追赶
+func (fp *framePacer) Wait() {
+	now := time.Now()
+	if fp.next.IsZero() || now.Sub(fp.next) > fp.interval {
+		fp.next = now
+	}
+	fp.next = fp.next.Add(fp.interval)
+	if wait := time.Until(fp.next); wait > 0 {
+		time.Sleep(wait)
+	}
+}
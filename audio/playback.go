@@ -3,7 +3,9 @@
 package audio
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"math"
 	"sync"
 	"sync/atomic"
@@ -16,23 +18,39 @@ import (
 // AudioBuffer represents a circular buffer for audio data
 type AudioBuffer struct {
 	data     [][]byte
+	times    []time.Time // 每帧的发送端采集时间（未知时为零值）
+	ends     []uint64    // 每帧最后一个采样帧之后的累计采样序号（-sample-index，未知时为 0）
 	readPos  int
 	writePos int
 	size     int
 	mutex    sync.RWMutex
 	full     bool
+	free     [][]byte // Recycle 归还的帧缓冲，WriteAt 优先复用
 }
 
 // NewAudioBuffer creates a new audio buffer
 func NewAudioBuffer(size int) *AudioBuffer {
 	return &AudioBuffer{
-		data: make([][]byte, size),
-		size: size,
+		data:  make([][]byte, size),
+		times: make([]time.Time, size),
+		ends:  make([]uint64, size),
+		size:  size,
 	}
 }
 
 // Write writes audio data to the buffer
 func (ab *AudioBuffer) Write(data []byte) bool {
+	return ab.WriteAt(data, time.Time{})
+}
+
+// WriteAt writes audio data together with its capture timestamp
+func (ab *AudioBuffer) WriteAt(data []byte, capturedAt time.Time) bool {
+	return ab.WriteIndexed(data, capturedAt, 0)
+}
+
+// WriteIndexed writes audio data with its capture timestamp and the cumulative sample
+// index just past its last sample frame (0 when unknown)
+func (ab *AudioBuffer) WriteIndexed(data []byte, capturedAt time.Time, endSample uint64) bool {
 	ab.mutex.Lock()
 	defer ab.mutex.Unlock()
 
@@ -42,9 +60,19 @@ func (ab *AudioBuffer) Write(data []byte) bool {
 		return false // Buffer is full
 	}
 
-	// Copy data
-	ab.data[ab.writePos] = make([]byte, len(data))
-	copy(ab.data[ab.writePos], data)
+	// Copy data（复用已归还的帧缓冲，避免每帧分配）
+	var frame []byte
+	if n := len(ab.free); n > 0 && cap(ab.free[n-1]) >= len(data) {
+		frame = ab.free[n-1][:len(data)]
+		ab.free[n-1] = nil
+		ab.free = ab.free[:n-1]
+	} else {
+		frame = make([]byte, len(data))
+	}
+	copy(frame, data)
+	ab.data[ab.writePos] = frame
+	ab.times[ab.writePos] = capturedAt
+	ab.ends[ab.writePos] = endSample
 
 	ab.writePos = nextWritePos
 	if ab.writePos == ab.readPos {
@@ -56,19 +84,47 @@ func (ab *AudioBuffer) Write(data []byte) bool {
 
 // Read reads audio data from the buffer
 func (ab *AudioBuffer) Read() ([]byte, bool) {
+	data, _, ok := ab.ReadAt()
+	return data, ok
+}
+
+// ReadAt reads audio data and its capture timestamp from the buffer
+func (ab *AudioBuffer) ReadAt() ([]byte, time.Time, bool) {
+	data, capturedAt, _, ok := ab.ReadIndexed()
+	return data, capturedAt, ok
+}
+
+// ReadIndexed reads audio data with the capture timestamp and end sample index given to WriteIndexed
+func (ab *AudioBuffer) ReadIndexed() ([]byte, time.Time, uint64, bool) {
 	ab.mutex.Lock()
 	defer ab.mutex.Unlock()
 
 	// Check if buffer is empty
 	if ab.readPos == ab.writePos && !ab.full {
-		return nil, false
+		return nil, time.Time{}, 0, false
 	}
 
 	data := ab.data[ab.readPos]
+	capturedAt := ab.times[ab.readPos]
+	endSample := ab.ends[ab.readPos]
 	ab.readPos = (ab.readPos + 1) % ab.size
 	ab.full = false
 
-	return data, true
+	return data, capturedAt, endSample, true
+}
+
+// Recycle returns a frame obtained from Read/ReadAt once the caller no longer uses it,
+// so that a later WriteAt can reuse its memory
+func (ab *AudioBuffer) Recycle(data []byte) {
+	if data == nil {
+		return
+	}
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	if len(ab.free) < ab.size {
+		ab.free = append(ab.free, data)
+	}
 }
 
 // Usage returns the current buffer usage as a percentage
@@ -76,18 +132,45 @@ func (ab *AudioBuffer) Usage() float64 {
 	ab.mutex.RLock()
 	defer ab.mutex.RUnlock()
 
+	return float64(ab.used()) / float64(ab.size)
+}
+
+// Len returns the number of buffered frames
+func (ab *AudioBuffer) Len() int {
+	ab.mutex.RLock()
+	defer ab.mutex.RUnlock()
+
+	return ab.used()
+}
+
+// used 返回已缓冲的帧数（调用方需持有锁）
+func (ab *AudioBuffer) used() int {
 	if ab.full {
-		return 1.0
+		return ab.size
 	}
-
-	var used int
 	if ab.writePos >= ab.readPos {
-		used = ab.writePos - ab.readPos
-	} else {
-		used = ab.size - ab.readPos + ab.writePos
+		return ab.writePos - ab.readPos
 	}
+	return ab.size - ab.readPos + ab.writePos
+}
 
-	return float64(used) / float64(ab.size)
+// Discard drops up to n of the oldest buffered frames and returns how many were dropped
+func (ab *AudioBuffer) Discard(n int) int {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	dropped := 0
+	for dropped < n && (ab.readPos != ab.writePos || ab.full) {
+		// 丢弃的帧从未被读出，可以直接复用
+		if len(ab.free) < ab.size {
+			ab.free = append(ab.free, ab.data[ab.readPos])
+		}
+		ab.data[ab.readPos] = nil
+		ab.readPos = (ab.readPos + 1) % ab.size
+		ab.full = false
+		dropped++
+	}
+	return dropped
 }
 
 // Clear clears the buffer
@@ -100,6 +183,19 @@ func (ab *AudioBuffer) Clear() {
 	ab.full = false
 }
 
+// 输出设备丢失检测与重新打开参数
+const (
+	maxConsecutiveWriteErrors = 3                      // 连续写入失败达到该次数视为设备丢失
+	reopenInitialBackoff      = 500 * time.Millisecond // 第一次重新打开前的等待，之后每次翻倍
+	reopenMaxBackoff          = 8 * time.Second
+)
+
+// 服务端静音抑制（-server-silence-suppress）参数
+const (
+	silenceSuppressDB = -50.0                 // 低于该电平的帧视为静音
+	silenceResumeFade = 20 * time.Millisecond // 恢复写入时的渐入时长，避免爆音
+)
+
 // Player handles audio output playback
 type Player struct {
 	device   *DeviceInfo
@@ -111,6 +207,15 @@ type Player struct {
 	// 添加输出缓冲区引用
 	outputBuffer interface{}
 	
+	// -output-channel-map：每个流声道对应的 0-based 设备声道（nil 表示按顺序），设备流打开 deviceChannels 个声道
+	channelMap     []int
+	deviceChannels int
+	
+	// 管道输出（设置后不使用 PortAudio）
+	pipePath string
+	pipe     io.WriteCloser
+	pipeSwap []byte // -pcm-bigendian：转为大端后再写入管道的缓冲区（nil 表示小端直写）
+	
 	// State management
 	running      int32 // atomic bool
 	initialized  int32 // atomic bool
@@ -121,29 +226,95 @@ type Player struct {
 	// 分贝计算相关
 	decibelMutex sync.RWMutex
 	currentDB    float64
+	peakLevel    float64 // 保持的峰值幅度 0.0 到 1.0（-waveform），每个缓冲区按 peakHoldDecay 衰减
+	
+	// 输出增益包络（渐入/渐出），在 convertAndWriteAudioData 中逐采样帧应用
+	gainMutex  sync.Mutex
+	gain       float64 // 当前增益 0.0 到 1.0
+	gainTarget float64
+	gainStep   float64 // 每个采样帧的增益变化量
+	volume     float64 // 运行时命令设置的音量 0.0 到 1.0（与包络相乘）
+	
+	// 立体声平衡：每声道的增益（nil 表示不调整；只作用于设备输出，分贝计量按平衡后的电平计算）
+	channelGain []float64
+	
+	// -stereo-width：立体声流的中/侧宽度（1 表示不处理），widthBuffer 为处理结果（仅在 convertAndWriteAudioData 中访问）
+	stereoWidth float64
+	widthBuffer []byte
+	
+	// 时间戳调度相关（仅在 playbackLoop 中访问）
+	scheduleAnchorLocal  time.Time // 第一帧带时间戳音频的本地播放时间
+	scheduleAnchorRemote time.Time // 第一帧带时间戳音频的发送端采集时间
+	
+	// 时钟漂移补偿（为 nil 表示禁用，仅在 playbackLoop 中访问）
+	drift *DriftEstimator
+	
+	// 欠载时的舒适噪声（为 nil 表示禁用，仅在 playbackLoop 中访问）
+	comfortNoise *ComfortNoise
+	
+	// -conceal repeat：欠载时重复最后一帧（为 nil 表示禁用，仅在 playbackLoop 中访问）
+	repeater *FrameRepeater
+	
+	// -limiter 软限幅（为 nil 表示禁用，仅在 convertAndWriteAudioData 中访问）
+	limiter       *Limiter
+	limitingUntil int64 // atomic，统计中显示限幅指示的截止时间（UnixNano）
 	
-	// 渐入效果相关
-	fadeInMutex    sync.RWMutex
-	fadeInProgress float64 // 0.0 到 1.0，表示渐入进度
-	fadeInDuration time.Duration
-	fadeInStartTime time.Time
-	isFadingIn      bool
+	// -max-latency-ms 对应的缓冲帧数上限（0 表示不限制）
+	maxLatencyFrames int
 	
-	// Control
-	stopChan chan struct{}
-	wg       sync.WaitGroup
+	// -prefill-frames：开始播放（及欠载后恢复）前缓冲区至少要有的帧数（0 表示不等待），
+	// buffering 为 1 时 playbackLoop 播放静音直到达到该帧数
+	prefillFrames int
+	buffering     int32 // atomic bool
+	
+	// 统计中的延迟（atomic，纳秒）：latency 为处理耗时或调度偏差，endToEndLatency 为采集到播放的延迟
+	latency         int64
+	endToEndLatency int64
+	
+	// 设备丢失后重新打开输出流（streamMutex 保护 stream/device 的替换）
+	streamMutex    sync.Mutex
+	onDeviceChange func(device *DeviceInfo)
+	
+	// Control (cancel 取消本次 Start 派生的 context)
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 // NewPlayer creates a new audio player
 func NewPlayer(device *DeviceInfo, config *utils.Config, logger *utils.Logger) *Player {
+	var drift *DriftEstimator
+	if config.DriftCorrectionInterval > 0 {
+		drift = NewDriftEstimator(config.DriftTargetBuffer, 0.25, config.DriftCorrectionInterval, config.BufferCount*2)
+	}
+	var comfortNoise *ComfortNoise
+	var repeater *FrameRepeater
+	switch config.Conceal {
+	case utils.ConcealComfort:
+		comfortNoise = NewComfortNoise(config.Channels)
+	case utils.ConcealRepeat:
+		repeater = NewFrameRepeater(config.ConcealFrames, config.Channels)
+	}
+	var limiter *Limiter
+	if config.Limiter {
+		limiter = NewLimiter(config.SampleRate, config.Channels, config.LimiterRelease)
+	}
 	return &Player{
+		drift:    drift,
+		comfortNoise: comfortNoise,
+		repeater:     repeater,
+		limiter:      limiter,
+		maxLatencyFrames: maxLatencyFrames(config, logger),
+		prefillFrames:    prefillFrames(config, logger),
+		channelGain:  balanceGains(config),
+		stereoWidth:  playbackStereoWidth(config),
 		device:   device,
 		config:   config,
 		logger:   logger,
 		buffer:   NewAudioBuffer(config.BufferCount * 2), // Extra buffers for safety
-		stopChan: make(chan struct{}),
 		currentDB: -60.0, // 默认静音级别
-		fadeInDuration: 5 * time.Second, // 5秒渐入时间
+		gain:       1.0,
+		gainTarget: 1.0,
+		volume:     1.0,
 		stats: &utils.AudioStats{
 			FramesProcessed: 0,
 			DroppedFrames:   0,
@@ -154,24 +325,104 @@ func NewPlayer(device *DeviceInfo, config *utils.Config, logger *utils.Logger) *
 	}
 }
 
-// calculateDecibels 计算音频数据的分贝级别
-func (p *Player) calculateDecibels(audioData []byte) float64 {
+// NewPipePlayer creates a player that writes raw PCM to a pipe ("-" for stdout), little-endian
+// unless config.PipeBigEndian is set
+func NewPipePlayer(pipePath string, config *utils.Config, logger *utils.Logger) *Player {
+	p := NewPlayer(nil, config, logger)
+	p.pipePath = pipePath
+	p.comfortNoise = nil // 管道输出保持原始数据
+	p.repeater = nil
+	p.limiter = nil
+	p.channelGain = nil
+	return p
+}
+
+// maxLatencyFrames 将 config.MaxLatency 换算为缓冲帧数（0 表示不限制，至少为 1 帧）
+func maxLatencyFrames(config *utils.Config, logger *utils.Logger) int {
+	if config.MaxLatency <= 0 {
+		return 0
+	}
+	frameDuration := time.Duration(float64(config.FramesPerBuffer) / float64(config.SampleRate) * float64(time.Second))
+	frames := int(config.MaxLatency / frameDuration)
+	if frames < 1 {
+		frames = 1
+	}
+	if capacity := config.BufferCount * 2; frames >= capacity && logger != nil {
+		logger.Warnf("Max latency %v is not below the playback buffer (%d frames, %v); it has no effect",
+			config.MaxLatency, capacity, time.Duration(capacity)*frameDuration)
+	}
+	return frames
+}
+
+// prefillFrames 返回 config.PrefillFrames，限制在播放缓冲区容量与 -max-latency-ms 的帧数以内
+// （否则永远达不到，或达到前就被丢弃）
+func prefillFrames(config *utils.Config, logger *utils.Logger) int {
+	frames := config.PrefillFrames
+	if frames <= 0 {
+		return 0
+	}
+	limit := config.BufferCount * 2
+	if latencyFrames := maxLatencyFrames(config, nil); latencyFrames > 0 && latencyFrames < limit {
+		limit = latencyFrames
+	}
+	if frames > limit {
+		if logger != nil {
+			logger.Warnf("Prefill of %d frames does not fit the playback buffer or -max-latency-ms; using %d", frames, limit)
+		}
+		frames = limit
+	}
+	return frames
+}
+
+// balanceGains 将 -1.0（全左）到 +1.0（全右）的平衡值换算为左右声道增益；
+// 只有立体声流才调整，单声道和多声道返回 nil
+func balanceGains(config *utils.Config) []float64 {
+	if config.Channels != 2 || config.Balance == 0 {
+		return nil
+	}
+	return []float64{math.Min(1, 1-config.Balance), math.Min(1, 1+config.Balance)}
+}
+
+// balanceGain 返回第 sampleIndex 个交错采样所在声道的平衡增益
+func (p *Player) balanceGain(sampleIndex int) float64 {
+	if p.channelGain == nil {
+		return 1.0
+	}
+	return p.channelGain[sampleIndex%len(p.channelGain)]
+}
+
+// sampleScale 返回第 i 个交错采样的缩放系数：渐入/渐出增益、音量与立体声平衡相乘
+func (p *Player) sampleScale(i int, applyGain bool) float64 {
+	scale := p.balanceGain(i) * p.volume
+	if applyGain {
+		scale *= p.gain
+	}
+	return scale
+}
+
+// peakHoldDecay 保持的峰值每个缓冲区的衰减系数，使统计循环在两次采样之间不会漏掉短促的峰值
+const peakHoldDecay = 0.9
+
+// calculateDecibels 计算音频数据的分贝级别与峰值幅度
+func (p *Player) calculateDecibels(audioData []byte) (float64, float64) {
 	if len(audioData) == 0 {
-		return -60.0 // 静音
+		return -60.0, 0 // 静音
 	}
 	
 	var sum float64 = 0
 	var sampleCount int = 0
+	var peak float64 = 0
 	
-	switch p.config.BitDepth {
+	switch SampleDepth(p.config) {
 	case 16:
 		for i := 0; i < len(audioData)-1; i += 2 {
 			// 转换为 int16
 			sample := int16(audioData[i]) | (int16(audioData[i+1]) << 8)
 			// 转换为 -1.0 到 1.0 的浮点数
-			normalizedSample := float64(sample) / 32768.0
+			normalizedSample := float64(sample) / 32768.0 * p.balanceGain(i/2)
 			sum += normalizedSample * normalizedSample
 			sampleCount++
+			peak = math.Max(peak, math.Abs(normalizedSample))
 		}
 	case 32:
 		for i := 0; i < len(audioData)-3; i += 4 {
@@ -181,16 +432,24 @@ func (p *Player) calculateDecibels(audioData []byte) float64 {
 				(int32(audioData[i+2]) << 16) |
 				(int32(audioData[i+3]) << 24)
 			// 转换为 -1.0 到 1.0 的浮点数
-			normalizedSample := float64(sample) / 2147483648.0
+			normalizedSample := float64(sample) / 2147483648.0 * p.balanceGain(i/4)
+			sum += normalizedSample * normalizedSample
+			sampleCount++
+			peak = math.Max(peak, math.Abs(normalizedSample))
+		}
+	case SampleDepthFloat32:
+		for i := 0; i < len(audioData)-3; i += 4 {
+			normalizedSample := float64(readFloat32(audioData[i:])) * p.balanceGain(i/4)
 			sum += normalizedSample * normalizedSample
 			sampleCount++
+			peak = math.Max(peak, math.Abs(normalizedSample))
 		}
 	default:
-		return -60.0
+		return -60.0, peak
 	}
 	
 	if sampleCount == 0 {
-		return -60.0
+		return -60.0, peak
 	}
 	
 	// 计算 RMS (Root Mean Square)
@@ -198,7 +457,7 @@ func (p *Player) calculateDecibels(audioData []byte) float64 {
 	
 	// 避免 log(0)
 	if rms < 1e-10 {
-		return -60.0
+		return -60.0, peak
 	}
 	
 	// 转换为分贝 (20 * log10(rms))
@@ -211,11 +470,11 @@ func (p *Player) calculateDecibels(audioData []byte) float64 {
 		db = 0.0
 	}
 	
-	return db
+	return db, peak
 }
 
-// updateDecibelLevel 更新当前分贝级别（带平滑处理）
-func (p *Player) updateDecibelLevel(newDB float64) {
+// updateDecibelLevel 更新当前分贝级别（带平滑处理）与保持的峰值
+func (p *Player) updateDecibelLevel(newDB, peak float64) {
 	p.decibelMutex.Lock()
 	defer p.decibelMutex.Unlock()
 	
@@ -223,6 +482,7 @@ func (p *Player) updateDecibelLevel(newDB float64) {
 	const smoothing = 0.3
 	p.currentDB = p.currentDB*(1-smoothing) + newDB*smoothing
 	p.stats.DecibelLevel = p.currentDB
+	p.peakLevel = math.Max(peak, p.peakLevel*peakHoldDecay)
 }
 
 // getCurrentDecibelLevel 获取当前分贝级别
@@ -232,33 +492,68 @@ func (p *Player) getCurrentDecibelLevel() float64 {
 	return p.currentDB
 }
 
+// getPeakLevel 获取保持的峰值幅度
+func (p *Player) getPeakLevel() float64 {
+	p.decibelMutex.RLock()
+	defer p.decibelMutex.RUnlock()
+	return p.peakLevel
+}
+
 // Initialize initializes the audio player
 func (p *Player) Initialize() error {
 	if atomic.LoadInt32(&p.initialized) == 1 {
 		return nil
 	}
 
+	if p.pipePath != "" {
+		return p.initializePipe()
+	}
+
 	p.logger.Infof("Initializing audio player for device: %s", p.device.Name)
 
+	stream, err := p.openStream(p.device)
+	if err != nil {
+		return err
+	}
+
+	p.stream = stream
+	atomic.StoreInt32(&p.initialized, 1)
+
+	p.logger.Infof("Audio player initialized - Sample Rate: %dHz, Channels: %d, Bit Depth: %d, Buffer: %d frames",
+		p.config.SampleRate, p.config.Channels, p.config.BitDepth, p.config.FramesPerBuffer)
+
+	return nil
+}
+
+// openStream 在指定设备上打开（但不启动）输出流，并分配与之绑定的输出缓冲区
+func (p *Player) openStream(device *DeviceInfo) (*portaudio.Stream, error) {
+	channelMap, err := deviceChannelMap(p.config.OutputChannelMap, p.config.Channels)
+	if err != nil {
+		return nil, utils.WrapError(err, utils.ErrAudioPlayback, "invalid output channel map")
+	}
+	deviceChannels := mapDeviceChannels(channelMap, p.config.Channels)
+
 	// Validate device for output
-	if err := ValidateDeviceForOutput(p.device, p.config.SampleRate, p.config.Channels); err != nil {
-		return utils.WrapError(err, utils.ErrAudioPlayback, "device validation failed")
+	if err := ValidateDeviceForOutput(device, p.config.SampleRate, deviceChannels); err != nil {
+		return nil, utils.WrapError(err, utils.ErrAudioPlayback, "device validation failed")
 	}
 
 	// Get PortAudio device
-	paDevice, err := GetPortAudioDevice(p.device)
+	paDevice, err := GetPortAudioDevice(device)
 	if err != nil {
-		return utils.WrapError(err, utils.ErrAudioPlayback, "failed to get PortAudio device")
+		return nil, utils.WrapError(err, utils.ErrAudioPlayback, "failed to get PortAudio device")
 	}
 
 	// Create output buffer based on bit depth
-	switch p.config.BitDepth {
+	switch SampleDepth(p.config) {
 	case 16:
-		p.outputBuffer = make([]int16, p.config.FramesPerBuffer*p.config.Channels)
+		p.outputBuffer = make([]int16, p.config.FramesPerBuffer*deviceChannels)
 	case 32:
-		p.outputBuffer = make([]int32, p.config.FramesPerBuffer*p.config.Channels)
+		p.outputBuffer = make([]int32, p.config.FramesPerBuffer*deviceChannels)
+	case SampleDepthFloat32:
+		p.outputBuffer = make([]float32, p.config.FramesPerBuffer*deviceChannels)
 	default:
-		return utils.NewAppError(utils.ErrAudioPlayback, 
+		return nil, utils.NewAppError(utils.ErrAudioPlayback, 
 			fmt.Sprintf("unsupported bit depth: %d", p.config.BitDepth))
 	}
 
@@ -266,7 +561,7 @@ func (p *Player) Initialize() error {
 	outputParams := portaudio.StreamParameters{
 		Output: portaudio.StreamDeviceParameters{
 			Device:   paDevice,
-			Channels: p.config.Channels,
+			Channels: deviceChannels,
 			Latency:  paDevice.DefaultLowOutputLatency,
 		},
 		SampleRate:      float64(p.config.SampleRate),
@@ -276,20 +571,105 @@ func (p *Player) Initialize() error {
 	// Create the stream
 	stream, err := portaudio.OpenStream(outputParams, p.outputBuffer)
 	if err != nil {
-		return utils.WrapError(err, utils.ErrAudioPlayback, "failed to open audio stream")
+		return nil, utils.WrapError(err, utils.ErrAudioPlayback, "failed to open audio stream")
 	}
+	p.channelMap = channelMap
+	p.deviceChannels = deviceChannels
 
-	p.stream = stream
+	return stream, nil
+}
+
+// reopenStream 在输出设备丢失后关闭旧流，并按退避间隔尝试在当前默认输出设备上重新打开，
+// 最多尝试 DeviceReopenAttempts 次；成功返回 true
+func (p *Player) reopenStream(ctx context.Context) bool {
+	p.logger.Warn("🎧 Output device appears to be gone, trying to reopen audio output...")
+
+	p.streamMutex.Lock()
+	if p.stream != nil {
+		p.stream.Close()
+		p.stream = nil
+	}
+	p.streamMutex.Unlock()
+
+	backoff := reopenInitialBackoff
+	for attempt := 1; attempt <= p.config.DeviceReopenAttempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > reopenMaxBackoff {
+			backoff = reopenMaxBackoff
+		}
+
+		// PortAudio 只在初始化时枚举设备，重新初始化后才能看到新的默认设备
+		err := RefreshDevices()
+		var device *DeviceInfo
+		if err == nil {
+			device, err = GetDefaultOutputDevice()
+		}
+		var stream *portaudio.Stream
+		if err == nil {
+			stream, err = p.openStream(device)
+		}
+		if err == nil {
+			if err = stream.Start(); err != nil {
+				stream.Close()
+			}
+		}
+		if err != nil {
+			p.logger.Warnf("Reopen attempt %d/%d failed: %v", attempt, p.config.DeviceReopenAttempts, err)
+			continue
+		}
+
+		p.streamMutex.Lock()
+		p.stream = stream
+		p.device = device
+		p.streamMutex.Unlock()
+
+		p.logger.Infof("🎧 Audio output resumed on %s", device.Name)
+		if p.onDeviceChange != nil {
+			go p.onDeviceChange(device)
+		}
+		return true
+	}
+
+	p.logger.Error(fmt.Sprintf("❌ Could not reopen audio output after %d attempts, giving up", p.config.DeviceReopenAttempts))
+	return false
+}
+
+// OnDeviceChange registers a callback invoked after playback moved to a new output device
+func (p *Player) OnDeviceChange(callback func(device *DeviceInfo)) {
+	p.onDeviceChange = callback
+}
+
+// initializePipe 初始化管道输出
+func (p *Player) initializePipe() error {
+	if p.config.BitDepth != 16 && p.config.BitDepth != 32 {
+		return utils.NewAppError(utils.ErrAudioPlayback,
+			fmt.Sprintf("unsupported bit depth: %d", p.config.BitDepth))
+	}
+
+	pipe, err := OpenOutputPipe(p.pipePath)
+	if err != nil {
+		return err
+	}
+
+	p.pipe = pipe
+	p.pipeSwap = nil
+	if p.config.PipeBigEndian {
+		p.pipeSwap = make([]byte, p.config.FramesPerBuffer*p.config.GetFrameSize())
+	}
 	atomic.StoreInt32(&p.initialized, 1)
 
-	p.logger.Infof("Audio player initialized - Sample Rate: %dHz, Channels: %d, Bit Depth: %d, Buffer: %d frames",
-		p.config.SampleRate, p.config.Channels, p.config.BitDepth, p.config.FramesPerBuffer)
+	p.logger.Infof("Audio player writing raw PCM (%s) to pipe %s - Sample Rate: %dHz, Channels: %d, Bit Depth: %d, Buffer: %d frames",
+		pipeByteOrder(p.config), p.pipePath, p.config.SampleRate, p.config.Channels, p.config.BitDepth, p.config.FramesPerBuffer)
 
 	return nil
 }
 
-// Start begins audio playback
-func (p *Player) Start() error {
+// Start begins audio playback; cancelling ctx stops the playback loop, Stop also releases the stream
+func (p *Player) Start(ctx context.Context) error {
 	if atomic.LoadInt32(&p.initialized) == 0 {
 		return utils.NewAppError(utils.ErrAudioPlayback, "player not initialized")
 	}
@@ -299,69 +679,136 @@ func (p *Player) Start() error {
 	}
 
 	// Start the PortAudio stream
-	if err := p.stream.Start(); err != nil {
-		return utils.WrapError(err, utils.ErrAudioPlayback, "failed to start audio stream")
+	if p.stream != nil {
+		if err := p.stream.Start(); err != nil {
+			return utils.WrapError(err, utils.ErrAudioPlayback, "failed to start audio stream")
+		}
 	}
 
 	// 等待一小段时间让音频设备稳定
 	time.Sleep(100 * time.Millisecond)
 
 	atomic.StoreInt32(&p.running, 1)
+	if p.prefillFrames > 0 {
+		atomic.StoreInt32(&p.buffering, 1)
+	}
 
 	// Start playback loop
+	loopCtx, cancel := context.WithCancel(ctx)
+	p.cancel = cancel
 	p.wg.Add(1)
-	go p.playbackLoop()
+	go p.playbackLoop(loopCtx)
 
 	p.logger.Info("🔊 Audio playback started")
 	return nil
 }
 
-// StartWithFadeIn 延迟启动音频播放并应用渐入效果
-func (p *Player) StartWithFadeIn(delay time.Duration) error {
+// StartWithFadeIn 启动音频播放，输出增益在 fade 时长内从 0 线性升到 1，避免开始时的爆音
+func (p *Player) StartWithFadeIn(ctx context.Context, fade time.Duration) error {
 	if p == nil {
 		return utils.NewAppError(utils.ErrAudioPlayback, "audio player is nil")
 	}
-	if atomic.LoadInt32(&p.initialized) == 0 {
-		return utils.NewAppError(utils.ErrAudioPlayback, "player not initialized")
+
+	p.setGainRamp(0.0, 0)
+	p.setGainRamp(1.0, fade)
+	if err := p.Start(ctx); err != nil {
+		return err
 	}
 
-	if atomic.LoadInt32(&p.running) == 1 {
-		return utils.NewAppError(utils.ErrAudioPlayback, "player already running")
+	p.logger.Info(fmt.Sprintf("🎵 Starting audio playback with %v fade-in", fade))
+	return nil
+}
+
+// StopWithFadeOut 先在 fade 时长内把输出增益降到 0，再停止播放，避免停止时的爆音
+func (p *Player) StopWithFadeOut(fade time.Duration) {
+	if atomic.LoadInt32(&p.running) == 1 && p.pipe == nil && fade > 0 {
+		p.setGainRamp(0.0, fade)
+		// 多等一个缓冲周期，确保渐出已写入声卡
+		bufferTime := time.Duration(p.config.FramesPerBuffer) * time.Second / time.Duration(p.config.SampleRate)
+		time.Sleep(fade + bufferTime)
 	}
+	p.Stop()
+}
 
-	// 启动 PortAudio 流
-	if err := p.stream.Start(); err != nil {
-		return utils.WrapError(err, utils.ErrAudioPlayback, "failed to start audio stream")
+// Drain keeps playing the queued audio until the buffer is empty or timeout has passed,
+// fading out over the last FadeDuration of it, and reports whether everything was played.
+// It does not stop the player; call Stop afterwards.
+func (p *Player) Drain(timeout time.Duration) bool {
+	if atomic.LoadInt32(&p.running) == 0 {
+		return p.buffer.Len() == 0
+	}
+	// 不再有新的音频，剩余的帧不必凑够 -prefill-frames
+	atomic.StoreInt32(&p.buffering, 0)
+	frameTime := p.config.GetFrameDuration()
+	deadline := time.Now().Add(timeout)
+	fading := false
+	for {
+		remaining := time.Duration(p.buffer.Len()) * frameTime
+		left := time.Until(deadline)
+		// 渐出在缓冲的音频播完（或超时）时正好到 0
+		if !fading && (remaining <= p.config.FadeDuration || left <= p.config.FadeDuration) {
+			fade := remaining
+			if left < fade {
+				fade = left
+			}
+			p.setGainRamp(0.0, fade)
+			fading = true
+		}
+		if remaining == 0 || left <= 0 || atomic.LoadInt32(&p.running) == 0 {
+			// 多等一个缓冲周期，确保最后一帧已写入声卡
+			time.Sleep(frameTime)
+			return remaining == 0
+		}
+		time.Sleep(frameTime / 2)
 	}
+}
 
-	// 等待一小段时间让音频设备稳定
-	time.Sleep(100 * time.Millisecond)
+// setGainRamp 设置增益包络：在 duration 内从当前增益线性变化到 target（duration <= 0 时立即生效）
+func (p *Player) setGainRamp(target float64, duration time.Duration) {
+	p.gainMutex.Lock()
+	defer p.gainMutex.Unlock()
 
-	// 延迟启动播放循环和渐入效果
-	go func() {
-		time.Sleep(delay)
-		
-		// 开始渐入效果
-		p.fadeInMutex.Lock()
-		p.isFadingIn = true
-		p.fadeInProgress = 0.0
-		p.fadeInStartTime = time.Now()
-		p.fadeInMutex.Unlock()
-		
-		// 启动播放循环
-		atomic.StoreInt32(&p.running, 1)
-		p.wg.Add(1)
-		go p.playbackLoop()
-		
-		p.logger.Info("🎵 Starting audio playback with fade-in effect")
-	}()
+	p.gainTarget = target
+	frames := duration.Seconds() * float64(p.config.SampleRate)
+	if frames < 1 {
+		p.gain = target
+		p.gainStep = 0
+		return
+	}
+	p.gainStep = math.Abs(target-p.gain) / frames
+}
 
-	p.logger.Info("🔊 Audio player initialized (fade-in scheduled)")
-	return nil
+// SetVolume sets the output volume from 0.0 (silent) to 1.0 (unchanged); safe to call while
+// playing. Pipe output is written unmodified and ignores it.
+func (p *Player) SetVolume(volume float64) {
+	p.gainMutex.Lock()
+	p.volume = math.Max(0, math.Min(1, volume))
+	p.gainMutex.Unlock()
+}
+
+// stepGain 将增益向目标推进一个采样帧
+func stepGain(gain, target, step float64) float64 {
+	if gain < target {
+		gain += step
+		if gain > target {
+			gain = target
+		}
+	} else if gain > target {
+		gain -= step
+		if gain < target {
+			gain = target
+		}
+	}
+	return gain
 }
 
 // Stop stops audio playback
 func (p *Player) Stop() {
+	// 取消 context，通知播放循环退出
+	if p.cancel != nil {
+		p.cancel()
+	}
+
 	if atomic.LoadInt32(&p.running) == 0 {
 		return
 	}
@@ -369,13 +816,12 @@ func (p *Player) Stop() {
 	p.logger.Info("⏹️ Stopping audio playback...")
 	atomic.StoreInt32(&p.running, 0)
 
-	// Signal stop
-	close(p.stopChan)
-
 	// Stop the stream
+	p.streamMutex.Lock()
 	if p.stream != nil {
 		p.stream.Stop()
 	}
+	p.streamMutex.Unlock()
 
 	// Wait for playback loop to finish
 	p.wg.Wait()
@@ -400,6 +846,10 @@ func (p *Player) Terminate() {
 		p.stream.Close()
 		p.stream = nil
 	}
+	if p.pipe != nil {
+		p.pipe.Close()
+		p.pipe = nil
+	}
 
 	atomic.StoreInt32(&p.initialized, 0)
 	p.logger.Info("🔚 Audio player terminated")
@@ -407,13 +857,26 @@ func (p *Player) Terminate() {
 
 // QueueAudio queues audio data for playback
 func (p *Player) QueueAudio(audioData []byte) error {
+	return p.QueueAudioAt(audioData, time.Time{})
+}
+
+// QueueAudioAt queues audio data together with the sender's capture timestamp
+func (p *Player) QueueAudioAt(audioData []byte, capturedAt time.Time) error {
+	return p.QueueAudioIndexed(audioData, capturedAt, 0)
+}
+
+// QueueAudioIndexed queues audio data with the sender's capture timestamp and the
+// cumulative sample index just past its last sample frame (0 when unknown); the
+// index of the frame being played is reported as AudioStats.PlayoutSample
+func (p *Player) QueueAudioIndexed(audioData []byte, capturedAt time.Time, endSample uint64) error {
 	if atomic.LoadInt32(&p.initialized) == 0 {
 		return utils.NewAppError(utils.ErrAudioPlayback, "player not initialized")
 	}
 
 	// Try to write to buffer
-	if !p.buffer.Write(audioData) {
+	if !p.buffer.WriteIndexed(audioData, capturedAt, endSample) {
 		atomic.AddInt64(&p.stats.DroppedFrames, int64(p.config.FramesPerBuffer))
+		atomic.AddInt64(&p.stats.Overruns, 1)
 		return utils.NewAppError(utils.ErrBuffer, "audio buffer is full")
 	}
 
@@ -421,7 +884,7 @@ func (p *Player) QueueAudio(audioData []byte) error {
 }
 
 // playbackLoop is the main playback loop
-func (p *Player) playbackLoop() {
+func (p *Player) playbackLoop(ctx context.Context) {
 	defer p.wg.Done()
 
 	p.logger.Debug("Audio playback loop started")
@@ -429,162 +892,288 @@ func (p *Player) playbackLoop() {
 	// Create silence buffer for when no data is available
 	frameSize := p.config.GetFrameSize()
 	silenceBuffer := make([]byte, p.config.FramesPerBuffer*frameSize)
+	
+	// 漂移补偿需要重复播放的帧
+	var repeatFrame []byte
+	// 上一轮从缓冲区取出的帧，播放完（且不再重复）后归还缓冲区复用
+	var lastFrame []byte
+	
+	// 管道输出没有声卡时钟，按帧时长节拍写入
+	pacer := newFramePacer(p.config.FramesPerBuffer, p.config.SampleRate)
+	
+	// 连续的（非下溢）流写入错误次数
+	writeErrors := 0
+	
+	// 静音抑制：连续静音达到 suppressFrames 帧后停止输出流（0 表示禁用，管道输出不适用）
+	suppressFrames := 0
+	if p.pipe == nil && p.config.ServerSilenceSuppress > 0 {
+		suppressFrames = int(p.config.ServerSilenceSuppress / pacer.interval)
+		if suppressFrames < 1 {
+			suppressFrames = 1
+		}
+	}
+	silentFrames := 0
+	suspended := false
 
-	for atomic.LoadInt32(&p.running) == 1 {
+	for ctx.Err() == nil {
 		startTime := time.Now()
 
+		if repeatFrame == nil {
+			p.buffer.Recycle(lastFrame)
+			lastFrame = nil
+		}
+		
+		// 缓冲的音频超过延迟上限时丢弃最旧的帧，回到实时
+		if p.maxLatencyFrames > 0 {
+			p.catchUp()
+		}
+
+		// -prefill-frames：缓冲中时先不取数据（播放静音），直到缓冲区攒够帧数
+		buffering := atomic.LoadInt32(&p.buffering) == 1
+		if buffering && p.buffer.Len() >= p.prefillFrames {
+			atomic.StoreInt32(&p.buffering, 0)
+			buffering = false
+			p.logger.Debugf("Prefilled %d frames, playing", p.prefillFrames)
+		}
+
 		// Try to get audio data from buffer
-		audioData, hasData := p.buffer.Read()
+		var audioData []byte
+		var capturedAt time.Time
+		var endSample uint64
+		var hasData bool
+		if repeatFrame != nil {
+			audioData, hasData = repeatFrame, true
+			repeatFrame = nil
+		} else if !buffering {
+			audioData, capturedAt, endSample, hasData = p.buffer.ReadIndexed()
+			lastFrame = audioData
+		}
+		
+		// 时钟漂移补偿：偶尔丢弃或重复一帧，使缓冲区保持在目标占用率附近
+		if p.drift != nil && hasData && !capturedAt.IsZero() {
+			switch p.drift.Observe(p.buffer.Usage()) {
+			case DriftDropFrame:
+				if next, nextCapturedAt, nextEndSample, ok := p.buffer.ReadIndexed(); ok {
+					p.buffer.Recycle(audioData)
+					audioData, capturedAt, endSample = next, nextCapturedAt, nextEndSample
+					lastFrame = next
+					p.logger.Debugf("Clock drift compensation: dropped one frame (%.0f ppm)", p.drift.DriftPPM(p.config.FramesPerBuffer, p.config.SampleRate))
+				}
+			case DriftRepeatFrame:
+				repeatFrame = audioData
+				p.logger.Debugf("Clock drift compensation: repeated one frame (%.0f ppm)", p.drift.DriftPPM(p.config.FramesPerBuffer, p.config.SampleRate))
+			}
+		}
 		
 		var dataToPlay []byte
 		var isActualAudio bool = false
+		frameSilent := true
 		if hasData && len(audioData) == p.config.FramesPerBuffer*frameSize {
 			dataToPlay = audioData
 			isActualAudio = true
 			
-			// 应用渐入效果
-			dataToPlay = p.applyFadeInEffect(dataToPlay)
-			
 			// 计算播放音频的分贝级别
-			decibelLevel := p.calculateDecibels(audioData)
-			p.updateDecibelLevel(decibelLevel)
+			decibelLevel, peak := p.calculateDecibels(audioData)
+			p.updateDecibelLevel(decibelLevel, peak)
+			frameSilent = decibelLevel < silenceSuppressDB
+			
+			// 跟踪噪声底，并把上一段舒适噪声渐出到真实音频中
+			if p.comfortNoise != nil {
+				p.comfortNoise.Observe(decibelLevel)
+				p.comfortNoise.FadeOut(dataToPlay, SampleDepth(p.config))
+			}
+			if p.repeater != nil {
+				p.repeater.Observe(dataToPlay)
+			}
+			
+			// 根据发送端时间戳计算端到端延迟与调度偏差
+			if !capturedAt.IsZero() {
+				p.updateScheduleStats(capturedAt)
+			}
 		} else {
-			// No data available or incorrect size, play silence
+			// No data available or incorrect size, play silence (或舒适噪声 / 渐弱的上一帧)
 			dataToPlay = silenceBuffer
-			p.updateDecibelLevel(-60.0) // 静音
-			if !hasData {
+			if p.comfortNoise != nil {
+				p.comfortNoise.Fill(dataToPlay, SampleDepth(p.config))
+			} else if p.repeater != nil {
+				if frame := p.repeater.Fill(SampleDepth(p.config)); frame != nil {
+					dataToPlay = frame
+				}
+			}
+			p.updateDecibelLevel(-60.0, 0) // 静音
+			if !hasData && !buffering {
 				atomic.AddInt64(&p.stats.DroppedFrames, int64(p.config.FramesPerBuffer))
+				atomic.AddInt64(&p.stats.Underruns, 1)
+				// 缓冲区已播空：重新缓冲，避免从一两帧恢复后马上再次欠载
+				if p.prefillFrames > 0 {
+					atomic.StoreInt32(&p.buffering, 1)
+				}
 			}
 		}
 
-		// Convert audio data and write to stream
-		if err := p.convertAndWriteAudioData(dataToPlay); err != nil {
-			p.logger.Error(fmt.Sprintf("Failed to write audio data: %v", err))
-			atomic.AddInt64(&p.stats.DroppedFrames, int64(p.config.FramesPerBuffer))
-			continue
+		// 持续静音时停止输出流让设备空闲，下一帧非静音音频到来时重新启动并渐入
+		if suppressFrames > 0 {
+			if frameSilent {
+				silentFrames++
+			} else {
+				silentFrames = 0
+			}
+			if suspended && !frameSilent {
+				suspended = !p.resumeSuspendedStream()
+			} else if !suspended && silentFrames >= suppressFrames {
+				suspended = p.suspendStream()
+			}
 		}
 
-		// Write audio data to stream with retry mechanism
-		maxRetries := 3
-		var writeErr error
-		for retry := 0; retry < maxRetries; retry++ {
-			writeErr = p.stream.Write()
-			if writeErr == nil {
-				break // 成功写入
+		if p.pipe != nil {
+			// 管道输出：直接写入原始 PCM（-pcm-bigendian 时先复制并翻转字节序，缓冲区数据保持小端）
+			if p.pipeSwap != nil {
+				dataToPlay = append(p.pipeSwap[:0], dataToPlay...)
+				p.pipeSwap = dataToPlay
+				swapSampleBytes(dataToPlay, p.config.BitDepth/8)
 			}
-			
+			if _, err := p.pipe.Write(dataToPlay); err != nil {
+				atomic.AddInt64(&p.stats.WriteErrors, 1)
+				p.logger.Error(fmt.Sprintf("Failed to write to output pipe: %v", err))
+				break
+			}
+			pacer.Wait()
+		} else if suspended {
+			// 输出流已停止：照常消费缓冲区但不写入设备，按帧时长节拍
+			pacer.Wait()
+		} else {
+			// Convert audio data and write to stream
+			if err := p.convertAndWriteAudioData(dataToPlay); err != nil {
+				p.logger.ErrorRateLimited("playback-convert", fmt.Sprintf("Failed to write audio data: %v", err))
+				atomic.AddInt64(&p.stats.DroppedFrames, int64(p.config.FramesPerBuffer))
+				atomic.AddInt64(&p.stats.WriteErrors, 1)
+				continue
+			}
+
+			// Write audio data to stream
+			// 阻塞写入总是写完整个输出缓冲区（绑定不返回实际帧数）；OutputUnderflowed 表示写入之前
+			// 设备已经欠载，本帧仍然写入了，重试会把同一帧再播放一遍
+			writeErr := p.stream.Write()
 			if writeErr == portaudio.OutputUnderflowed {
-				// 输出下溢，等待一下再重试
-				if retry < maxRetries-1 {
-					p.logger.Debug("Output buffer underflow, retrying...")
-					time.Sleep(10 * time.Millisecond)
-					continue
-				}
+				// 设备侧欠载（写入来不及），与无数据可播同属欠载
+				atomic.AddInt64(&p.stats.Underruns, 1)
+				p.logger.WarnRateLimited("playback-underflow", "Output buffer underflow detected")
+				writeErr = nil
 			}
-			break // 其他错误或重试次数用完
-		}
 		
-		if writeErr != nil {
-			p.logger.Error(fmt.Sprintf("Failed to write to audio stream: %v", writeErr))
-			atomic.AddInt64(&p.stats.DroppedFrames, int64(p.config.FramesPerBuffer))
-			
-			// Check if this is a critical error
-			if writeErr == portaudio.OutputUnderflowed {
-				p.logger.Warn("Output buffer underflow detected")
-			} else {
-				// For other errors, we might want to stop
+			if writeErr != nil {
+				p.logger.ErrorRateLimited("playback-write", fmt.Sprintf("Failed to write to audio stream: %v", writeErr))
+				atomic.AddInt64(&p.stats.DroppedFrames, int64(p.config.FramesPerBuffer))
+				
+				// 连续写入失败多半是设备已断开（如蓝牙耳机），尝试在默认设备上重新打开
+				atomic.AddInt64(&p.stats.WriteErrors, 1)
+				writeErrors++
+				if writeErrors < maxConsecutiveWriteErrors {
+					pacer.Wait()
+					continue
+				}
+				writeErrors = 0
+				if p.config.DeviceReopenAttempts > 0 && p.reopenStream(ctx) {
+					continue
+				}
 				break
 			}
-			continue
+			writeErrors = 0
 		}
 
 		// Update statistics - 只有在播放实际音频数据时才更新帧数统计
 		if isActualAudio {
 			atomic.AddInt64(&p.stats.FramesProcessed, int64(p.config.FramesPerBuffer))
+			if endSample != 0 {
+				atomic.StoreUint64(&p.stats.PlayoutSample, endSample)
+			}
 		}
 		
-		// Calculate processing latency
-		processingTime := time.Since(startTime)
-		p.stats.Latency = processingTime
+		// Calculate processing latency (带时间戳时由 updateScheduleStats 提供调度偏差)
+		if capturedAt.IsZero() {
+			processingTime := time.Since(startTime)
+			atomic.StoreInt64(&p.latency, int64(processingTime))
+		}
 		p.stats.BufferUsage = p.buffer.Usage()
 	}
 
 	p.logger.Debug("Audio playback loop ended")
 }
 
-// applyFadeInEffect 应用渐入效果到音频数据
-func (p *Player) applyFadeInEffect(audioData []byte) []byte {
-	p.fadeInMutex.RLock()
-	isFadingIn := p.isFadingIn
-	fadeInProgress := p.fadeInProgress
-	p.fadeInMutex.RUnlock()
-	
-	// 计算当前渐入进度
-	p.fadeInMutex.Lock()
-	elapsed := time.Since(p.fadeInStartTime)
-	if elapsed >= p.fadeInDuration {
-		// 渐入完成，设置为非渐入状态并返回原始音频数据
-		p.isFadingIn = false
-		p.fadeInProgress = 1.0
-		p.fadeInMutex.Unlock()
-		return audioData
+// suspendStream 在持续静音后停止输出流，让设备进入空闲；成功返回 true
+func (p *Player) suspendStream() bool {
+	p.streamMutex.Lock()
+	defer p.streamMutex.Unlock()
+	if p.stream == nil {
+		return false
 	}
-	
-	if !isFadingIn {
-		// 不在渐入状态且渐入未完成，返回静音数据
-		p.fadeInMutex.Unlock()
-		result := make([]byte, len(audioData))
-		// 填充静音数据
-		for i := range result {
-			result[i] = 0
-		}
-		return result
+	if err := p.stream.Stop(); err != nil {
+		p.logger.WarnRateLimited("playback-suspend", fmt.Sprintf("Failed to pause idle audio stream: %v", err))
+		return false
 	}
-	
-	// 计算渐入进度 (0.0 到 1.0)
-	p.fadeInProgress = float64(elapsed) / float64(p.fadeInDuration)
-	// 使用平滑的渐入曲线 (ease-in)
-	fadeInProgress = p.fadeInProgress * p.fadeInProgress
-	p.fadeInMutex.Unlock()
-	
-	// 应用渐入效果
-	result := make([]byte, len(audioData))
-	copy(result, audioData)
-	
-	switch p.config.BitDepth {
-	case 16:
-		// 16位音频，每2个字节一个样本
-		for i := 0; i < len(result)-1; i += 2 {
-			sample := int16(result[i]) | (int16(result[i+1]) << 8)
-			// 应用渐入系数
-			fadedSample := int16(float64(sample) * fadeInProgress)
-			result[i] = byte(fadedSample & 0xFF)
-			result[i+1] = byte((fadedSample >> 8) & 0xFF)
-		}
-	case 24:
-		// 24位音频，每3个字节一个样本
-		for i := 0; i < len(result)-2; i += 3 {
-			sample := int32(result[i]) | (int32(result[i+1]) << 8) | (int32(result[i+2]) << 16)
-			// 应用渐入系数
-			fadedSample := int32(float64(sample) * fadeInProgress)
-			result[i] = byte(fadedSample & 0xFF)
-			result[i+1] = byte((fadedSample >> 8) & 0xFF)
-			result[i+2] = byte((fadedSample >> 16) & 0xFF)
-		}
-	case 32:
-		// 32位音频，每4个字节一个样本
-		for i := 0; i < len(result)-3; i += 4 {
-			sample := int32(result[i]) | (int32(result[i+1]) << 8) | (int32(result[i+2]) << 16) | (int32(result[i+3]) << 24)
-			// 应用渐入系数
-			fadedSample := int32(float64(sample) * fadeInProgress)
-			result[i] = byte(fadedSample & 0xFF)
-			result[i+1] = byte((fadedSample >> 8) & 0xFF)
-			result[i+2] = byte((fadedSample >> 16) & 0xFF)
-			result[i+3] = byte((fadedSample >> 24) & 0xFF)
-		}
+	p.logger.Infof("💤 Output silent for %v, letting the audio device idle", p.config.ServerSilenceSuppress)
+	return true
+}
+
+// resumeSuspendedStream 重新启动被静音抑制停止的输出流，增益从 0 渐入；成功返回 true
+func (p *Player) resumeSuspendedStream() bool {
+	// 保留当前的包络目标（会话结束渐出进行中时不把音量拉回来）
+	p.gainMutex.Lock()
+	target := p.gainTarget
+	p.gainMutex.Unlock()
+	p.setGainRamp(0.0, 0)
+	p.setGainRamp(target, silenceResumeFade)
+
+	p.streamMutex.Lock()
+	defer p.streamMutex.Unlock()
+	if p.stream == nil {
+		return false
 	}
-	
-	return result
+	if err := p.stream.Start(); err != nil {
+		atomic.AddInt64(&p.stats.WriteErrors, 1)
+		p.logger.ErrorRateLimited("playback-resume", fmt.Sprintf("Failed to restart audio stream after silence: %v", err))
+		return false
+	}
+	p.logger.Info("🔈 Audio resumed, output device active again")
+	return true
+}
+
+// catchUp discards the oldest buffered frames once the queued audio exceeds the
+// -max-latency-ms ceiling. It drops down to half the ceiling so that a buffer
+// pinned at the limit glitches once instead of on every frame.
+func (p *Player) catchUp() {
+	buffered := p.buffer.Len()
+	if buffered <= p.maxLatencyFrames {
+		return
+	}
+	keep := p.maxLatencyFrames / 2
+	if keep < 1 {
+		keep = 1
+	}
+	dropped := p.buffer.Discard(buffered - keep)
+	if dropped == 0 {
+		return
+	}
+	atomic.AddInt64(&p.stats.DroppedFrames, int64(dropped*p.config.FramesPerBuffer))
+	droppedMs := float64(dropped*p.config.FramesPerBuffer) / float64(p.config.SampleRate) * 1000
+	p.logger.WarnRateLimited("playback-catchup", fmt.Sprintf("⏩ Buffered audio above %v: dropped %d frames (%.0f ms) to catch up",
+		p.config.MaxLatency, dropped, droppedMs))
+}
+
+// updateScheduleStats 根据帧的采集时间计算实际交付时间与计划播放时间的偏差
+//
+// 计划播放时间 = 本地锚点 + (采集时间 - 远端锚点)，锚点取第一帧带时间戳的音频。
+// 偏差为正表示帧比计划晚到，为负表示提前，写入 AudioStats.Latency。
+func (p *Player) updateScheduleStats(capturedAt time.Time) {
+	now := time.Now()
+	if p.scheduleAnchorLocal.IsZero() {
+		p.scheduleAnchorLocal = now
+		p.scheduleAnchorRemote = capturedAt
+	}
+
+	scheduled := p.scheduleAnchorLocal.Add(capturedAt.Sub(p.scheduleAnchorRemote))
+	atomic.StoreInt64(&p.latency, int64(now.Sub(scheduled)))
+	atomic.StoreInt64(&p.endToEndLatency, int64(now.Sub(capturedAt)))
 }
 
 // convertAndWriteAudioData converts bytes to the appropriate format and writes to stream buffer
@@ -592,8 +1181,15 @@ func (p *Player) convertAndWriteAudioData(audioData []byte) error {
 	if p.outputBuffer == nil {
 		return utils.NewAppError(utils.ErrAudioPlayback, "output buffer is nil")
 	}
+	audioData = p.widenStereo(audioData)
 
-	switch p.config.BitDepth {
+	// 增益包络：只有渐入/渐出进行中或增益不为 1 时才逐采样帧缩放
+	p.gainMutex.Lock()
+	defer p.gainMutex.Unlock()
+	applyGain := p.gain != 1.0 || p.gainTarget != 1.0
+	channels := p.config.Channels
+
+	switch SampleDepth(p.config) {
 	case 16:
 		// 修复：使用保存的输出缓冲区引用
 		output, ok := p.outputBuffer.([]int16)
@@ -602,21 +1198,30 @@ func (p *Player) convertAndWriteAudioData(audioData []byte) error {
 		}
 
 		sampleCount := len(audioData) / 2
-		if sampleCount > len(output) {
-			sampleCount = len(output)
+		if limit := p.streamSamples(len(output)); sampleCount > limit {
+			sampleCount = limit
+		}
+
+		// Fill remaining with silence if needed（映射声道时整体清零，未映射的设备声道保持静音）
+		for i := p.silenceFrom(sampleCount); i < len(output); i++ {
+			output[i] = 0
 		}
 
 		for i := 0; i < sampleCount; i++ {
 			if i*2+1 < len(audioData) {
 				// Little-endian conversion
 				sample := int16(audioData[i*2]) | (int16(audioData[i*2+1]) << 8)
-				output[i] = sample
+				if scale := p.sampleScale(i, applyGain); scale != 1.0 {
+					sample = int16(float64(sample) * scale)
+				}
+				if p.limiter != nil {
+					sample = int16(math.Round(p.limiter.Process(float64(sample)/32768.0, i%channels) * 32768.0))
+				}
+				output[deviceSampleIndex(p.channelMap, p.deviceChannels, channels, i)] = sample
+			}
+			if applyGain && (i+1)%channels == 0 {
+				p.gain = stepGain(p.gain, p.gainTarget, p.gainStep)
 			}
-		}
-
-		// Fill remaining with silence if needed
-		for i := sampleCount; i < len(output); i++ {
-			output[i] = 0
 		}
 
 	case 32:
@@ -627,8 +1232,13 @@ func (p *Player) convertAndWriteAudioData(audioData []byte) error {
 		}
 
 		sampleCount := len(audioData) / 4
-		if sampleCount > len(output) {
-			sampleCount = len(output)
+		if limit := p.streamSamples(len(output)); sampleCount > limit {
+			sampleCount = limit
+		}
+
+		// Fill remaining with silence if needed（映射声道时整体清零，未映射的设备声道保持静音）
+		for i := p.silenceFrom(sampleCount); i < len(output); i++ {
+			output[i] = 0
 		}
 
 		for i := 0; i < sampleCount; i++ {
@@ -638,23 +1248,90 @@ func (p *Player) convertAndWriteAudioData(audioData []byte) error {
 					(int32(audioData[i*4+1]) << 8) |
 					(int32(audioData[i*4+2]) << 16) |
 					(int32(audioData[i*4+3]) << 24)
-				output[i] = sample
+				if scale := p.sampleScale(i, applyGain); scale != 1.0 {
+					sample = int32(float64(sample) * scale)
+				}
+				if p.limiter != nil {
+					sample = int32(math.Round(p.limiter.Process(float64(sample)/2147483648.0, i%channels) * 2147483648.0))
+				}
+				output[deviceSampleIndex(p.channelMap, p.deviceChannels, channels, i)] = sample
+			}
+			if applyGain && (i+1)%channels == 0 {
+				p.gain = stepGain(p.gain, p.gainTarget, p.gainStep)
 			}
 		}
 
-		// Fill remaining with silence if needed
-		for i := sampleCount; i < len(output); i++ {
+	case SampleDepthFloat32:
+		output, ok := p.outputBuffer.([]float32)
+		if !ok {
+			return utils.NewAppError(utils.ErrAudioPlayback, "invalid output buffer type for float32")
+		}
+
+		sampleCount := len(audioData) / 4
+		if limit := p.streamSamples(len(output)); sampleCount > limit {
+			sampleCount = limit
+		}
+
+		// Fill remaining with silence if needed（映射声道时整体清零，未映射的设备声道保持静音）
+		for i := p.silenceFrom(sampleCount); i < len(output); i++ {
 			output[i] = 0
 		}
 
+		for i := 0; i < sampleCount; i++ {
+			sample := readFloat32(audioData[i*4:])
+			if scale := p.sampleScale(i, applyGain); scale != 1.0 {
+				sample = float32(float64(sample) * scale)
+			}
+			if p.limiter != nil {
+				sample = clampFloat32(p.limiter.Process(float64(sample), i%channels))
+			}
+			output[deviceSampleIndex(p.channelMap, p.deviceChannels, channels, i)] = sample
+			if applyGain && (i+1)%channels == 0 {
+				p.gain = stepGain(p.gain, p.gainTarget, p.gainStep)
+			}
+		}
+
 	default:
 		return utils.NewAppError(utils.ErrAudioPlayback, 
 			fmt.Sprintf("unsupported bit depth: %d", p.config.BitDepth))
 	}
 
+	// 限幅后保持指示一段时间，避免统计行闪烁
+	if p.limiter != nil && p.limiter.TakeActive() {
+		atomic.StoreInt64(&p.limitingUntil, time.Now().Add(limiterIndicatorHold).UnixNano())
+	}
 	return nil
 }
 
+// streamSamples 设备缓冲区（outputLen 个采样）能容纳的流采样数
+func (p *Player) streamSamples(outputLen int) int {
+	if p.channelMap == nil {
+		return outputLen
+	}
+	return outputLen / p.deviceChannels * p.config.Channels
+}
+
+// silenceFrom 写入 sampleCount 个流采样前需要清零的设备缓冲区起始下标
+func (p *Player) silenceFrom(sampleCount int) int {
+	if p.channelMap != nil {
+		return 0
+	}
+	return sampleCount
+}
+
+// DeviceLatency returns the output latency of the opened stream as reported by PortAudio (0 for pipe output)
+func (p *Player) DeviceLatency() time.Duration {
+	p.streamMutex.Lock()
+	defer p.streamMutex.Unlock()
+	if p.stream == nil {
+		return 0
+	}
+	if info := p.stream.Info(); info != nil {
+		return info.OutputLatency
+	}
+	return 0
+}
+
 // IsRunning returns whether the player is currently running
 func (p *Player) IsRunning() bool {
 	return atomic.LoadInt32(&p.running) == 1
@@ -675,12 +1352,26 @@ func (p *Player) GetStats() *utils.AudioStats {
 		bufferUsage = 0.0
 	}
 	
+	var driftPPM float64
+	if p.drift != nil {
+		driftPPM = p.drift.DriftPPM(p.config.FramesPerBuffer, p.config.SampleRate)
+	}
+	
 	return &utils.AudioStats{
 		FramesProcessed: atomic.LoadInt64(&p.stats.FramesProcessed),
 		DroppedFrames:   atomic.LoadInt64(&p.stats.DroppedFrames),
-		Latency:         p.stats.Latency,
+		Underruns:       atomic.LoadInt64(&p.stats.Underruns),
+		Overruns:        atomic.LoadInt64(&p.stats.Overruns),
+		WriteErrors:     atomic.LoadInt64(&p.stats.WriteErrors),
+		PlayoutSample:   atomic.LoadUint64(&p.stats.PlayoutSample),
+		Latency:         time.Duration(atomic.LoadInt64(&p.latency)),
+		EndToEndLatency: time.Duration(atomic.LoadInt64(&p.endToEndLatency)),
+		ClockDriftPPM:   driftPPM,
 		BufferUsage:     bufferUsage,
 		DecibelLevel:    p.getCurrentDecibelLevel(),
+		PeakLevel:       p.getPeakLevel(),
+		Limiting:        time.Now().UnixNano() < atomic.LoadInt64(&p.limitingUntil),
+		Buffering:       atomic.LoadInt32(&p.buffering) == 1,
 	}
 }
 
@@ -0,0 +1,90 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+// TestPlayerDrain 排空时缓冲中的所有帧都应在停止前写出（管道输出不做渐出，原样比较）
+func TestPlayerDrain(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.SampleRate = 48000
+	config.FramesPerBuffer = 480
+	path := filepath.Join(t.TempDir(), "out.pcm")
+	player := NewPipePlayer(path, config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	if err := player.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	defer player.Terminate()
+
+	var queued []byte
+	for i := 0; i < config.BufferCount*2; i++ {
+		frame := bytes.Repeat([]byte{byte(i + 1)}, config.FramesPerBuffer*config.GetFrameSize())
+		if err := player.QueueAudio(frame); err != nil {
+			t.Fatalf("QueueAudio: %v", err)
+		}
+		queued = append(queued, frame...)
+	}
+	if err := player.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if !player.Drain(2 * time.Second) {
+		t.Fatalf("Drain reported buffered audio left")
+	}
+	player.Stop()
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.HasPrefix(written, queued) {
+		t.Fatalf("output has %d bytes and does not start with the %d queued bytes", len(written), len(queued))
+	}
+}
+
+// TestPlayerPrefill 缓冲中播放静音且不计欠载，攒够 -prefill-frames 帧后开始播放
+func TestPlayerPrefill(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.SampleRate = 48000
+	config.FramesPerBuffer = 480
+	config.PrefillFrames = 3
+	path := filepath.Join(t.TempDir(), "out.pcm")
+	player := NewPipePlayer(path, config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	if err := player.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	defer player.Terminate()
+
+	frame := bytes.Repeat([]byte{1}, config.FramesPerBuffer*config.GetFrameSize())
+	if err := player.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := player.QueueAudio(frame); err != nil {
+			t.Fatalf("QueueAudio: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	stats := player.GetStats()
+	if !stats.Buffering || stats.FramesProcessed != 0 || stats.Underruns != 0 {
+		t.Fatalf("below the prefill: buffering %v, %d frames played, %d underruns", stats.Buffering, stats.FramesProcessed, stats.Underruns)
+	}
+
+	if err := player.QueueAudio(frame); err != nil {
+		t.Fatalf("QueueAudio: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for player.GetStats().FramesProcessed < 3*int64(config.FramesPerBuffer) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := player.GetStats(); stats.FramesProcessed != 3*int64(config.FramesPerBuffer) {
+		t.Fatalf("%d frames played after the prefill, want %d", stats.FramesProcessed, 3*config.FramesPerBuffer)
+	}
+	player.Stop()
+}
@@ -0,0 +1,129 @@
+// audio/resample.go - 输出设备采样率与流不一致时的线性插值重采样
+
+package audio
+
+import (
+	"math"
+)
+
+// Resampler converts interleaved PCM from the stream's sample rate to a device's
+// sample rate with linear interpolation, and re-chunks the result into frames of
+// a fixed size so each chunk can be queued to a Player as one buffer.
+type Resampler struct {
+	step      float64 // 每个输出采样帧在输入中前进的距离（inRate / outRate）
+	channels  int
+	bitDepth  int // 见 SampleDepth
+	outFrames int
+
+	// position 为下一个输出采样帧在输入中的位置；-1 表示上一块输入的最后一帧
+	position float64
+	previous []float64 // 上一块输入的最后一帧（跨块插值）
+	pending  []float64 // 尚未凑满 outFrames 的输出采样
+}
+
+// NewResampler creates a resampler from inRate to outRate; Process returns chunks of outFrames frames
+func NewResampler(inRate, outRate, channels, bitDepth, outFrames int) *Resampler {
+	return &Resampler{
+		step:      float64(inRate) / float64(outRate),
+		channels:  channels,
+		bitDepth:  bitDepth,
+		outFrames: outFrames,
+	}
+}
+
+// Process resamples one block of interleaved little-endian PCM and returns the
+// complete output chunks that are now available (possibly none)
+func (r *Resampler) Process(audioData []byte) [][]byte {
+	input := decodeSamples(audioData, r.bitDepth)
+	frames := len(input) / r.channels
+	if frames == 0 {
+		return nil
+	}
+	if r.previous == nil {
+		// 第一块：用首帧充当"上一帧"
+		r.previous = append([]float64(nil), input[:r.channels]...)
+	}
+
+	// 取第 index 帧第 ch 声道的采样，index 为 -1 时取上一块的最后一帧
+	sampleAt := func(index, ch int) float64 {
+		if index < 0 {
+			return r.previous[ch]
+		}
+		return input[index*r.channels+ch]
+	}
+
+	for r.position < float64(frames-1) {
+		index := int(math.Floor(r.position))
+		frac := r.position - float64(index)
+		for ch := 0; ch < r.channels; ch++ {
+			r.pending = append(r.pending, sampleAt(index, ch)*(1-frac)+sampleAt(index+1, ch)*frac)
+		}
+		r.position += r.step
+	}
+	r.position -= float64(frames)
+	copy(r.previous, input[(frames-1)*r.channels:])
+
+	var chunks [][]byte
+	chunkSamples := r.outFrames * r.channels
+	for len(r.pending) >= chunkSamples {
+		chunks = append(chunks, encodeSamples(r.pending[:chunkSamples], r.bitDepth))
+		r.pending = append(r.pending[:0], r.pending[chunkSamples:]...)
+	}
+	return chunks
+}
+
+// decodeSamples 将小端 PCM 转为 -1.0 到 1.0 的浮点采样
+func decodeSamples(audioData []byte, bitDepth int) []float64 {
+	var samples []float64
+	switch bitDepth {
+	case 16:
+		samples = make([]float64, 0, len(audioData)/2)
+		for i := 0; i+1 < len(audioData); i += 2 {
+			sample := int16(audioData[i]) | int16(audioData[i+1])<<8
+			samples = append(samples, float64(sample)/32768.0)
+		}
+	case 32:
+		samples = make([]float64, 0, len(audioData)/4)
+		for i := 0; i+3 < len(audioData); i += 4 {
+			sample := int32(audioData[i]) | int32(audioData[i+1])<<8 | int32(audioData[i+2])<<16 | int32(audioData[i+3])<<24
+			samples = append(samples, float64(sample)/2147483648.0)
+		}
+	case SampleDepthFloat32:
+		samples = make([]float64, 0, len(audioData)/4)
+		for i := 0; i+3 < len(audioData); i += 4 {
+			samples = append(samples, float64(readFloat32(audioData[i:])))
+		}
+	}
+	return samples
+}
+
+// encodeSamples 将浮点采样编码为小端 PCM（饱和处理）
+func encodeSamples(samples []float64, bitDepth int) []byte {
+	switch bitDepth {
+	case 16:
+		data := make([]byte, len(samples)*2)
+		for i, sample := range samples {
+			out := int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(sample*32768.0))))
+			data[i*2] = byte(out)
+			data[i*2+1] = byte(out >> 8)
+		}
+		return data
+	case 32:
+		data := make([]byte, len(samples)*4)
+		for i, sample := range samples {
+			out := int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(sample*2147483648.0))))
+			data[i*4] = byte(out)
+			data[i*4+1] = byte(out >> 8)
+			data[i*4+2] = byte(out >> 16)
+			data[i*4+3] = byte(out >> 24)
+		}
+		return data
+	case SampleDepthFloat32:
+		data := make([]byte, len(samples)*4)
+		for i, sample := range samples {
+			writeFloat32(data[i*4:], clampFloat32(sample))
+		}
+		return data
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// audio/sample_format.go - float32 采样格式的分派与读写
+
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+
+	"RemoteAudioCLI/utils"
+)
+
+// SampleDepthFloat32 is the bitDepth value that selects 32-bit float samples in the
+// per-sample helpers and CheckFormatSupported (integer formats use their bit width)
+const SampleDepthFloat32 = -32
+
+// pipeByteOrder 描述原始 PCM 管道的字节序，用于日志
+func pipeByteOrder(config *utils.Config) string {
+	if config.PipeBigEndian {
+		return "big-endian"
+	}
+	return "little-endian"
+}
+
+// SampleDepth returns the bitDepth value for config: config.BitDepth for integer
+// samples, SampleDepthFloat32 for float32
+func SampleDepth(config *utils.Config) int {
+	if config.SampleFormat == utils.SampleFormatFloat32 {
+		return SampleDepthFloat32
+	}
+	return config.BitDepth
+}
+
+// swapSampleBytes 原地翻转每个采样的字节序（大端 <-> 小端），bytesPerSample 为 1 时不变
+func swapSampleBytes(data []byte, bytesPerSample int) {
+	if bytesPerSample < 2 {
+		return
+	}
+	for i := 0; i+bytesPerSample <= len(data); i += bytesPerSample {
+		sample := data[i : i+bytesPerSample]
+		for a, b := 0, bytesPerSample-1; a < b; a, b = a+1, b-1 {
+			sample[a], sample[b] = sample[b], sample[a]
+		}
+	}
+}
+
+// readFloat32 读取小端 float32 采样
+func readFloat32(data []byte) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(data))
+}
+
+// writeFloat32 写入小端 float32 采样
+func writeFloat32(data []byte, sample float32) {
+	binary.LittleEndian.PutUint32(data, math.Float32bits(sample))
+}
+
+// clampFloat32 将浮点采样限制在 [-1, 1]
+func clampFloat32(sample float64) float32 {
+	return float32(math.Max(-1, math.Min(1, sample)))
+}
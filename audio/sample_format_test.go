@@ -0,0 +1,73 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+func TestSwapSampleBytes(t *testing.T) {
+	data := []byte{0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC, 0xDE, 0xF0}
+	swapSampleBytes(data, 2)
+	if want := []byte{0x34, 0x12, 0x78, 0x56, 0xBC, 0x9A, 0xF0, 0xDE}; !bytes.Equal(data, want) {
+		t.Fatalf("16-bit swap = %x, want %x", data, want)
+	}
+	swapSampleBytes(data, 2)
+	swapSampleBytes(data, 4)
+	if want := []byte{0x78, 0x56, 0x34, 0x12, 0xF0, 0xDE, 0xBC, 0x9A}; !bytes.Equal(data, want) {
+		t.Fatalf("32-bit swap = %x, want %x", data, want)
+	}
+}
+
+// TestPipeCapturerBigEndian -pcm-bigendian：管道中的大端采样交给回调时已转为小端
+func TestPipeCapturerBigEndian(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.SampleRate = 48000
+	config.Channels = 2
+	config.BitDepth = 16
+	config.FramesPerBuffer = 4
+	config.PipeBigEndian = true
+
+	samples := []int16{1000, -1000, 0x1234, -0x1234, 1, -1, 32767, -32768}
+	input := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		binary.BigEndian.PutUint16(input[i*2:], uint16(sample))
+	}
+	path := filepath.Join(t.TempDir(), "in.s16be")
+	if err := os.WriteFile(path, input, 0644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	capturer := NewPipeCapturer(path, config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	if err := capturer.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	defer capturer.Terminate()
+	received := make(chan []byte, 4)
+	if err := capturer.Start(context.Background(), func(data []byte) {
+		received <- append([]byte(nil), data...)
+	}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	var got []byte
+	for len(got) < len(input) {
+		select {
+		case data := <-received:
+			got = append(got, data...)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d of %d bytes", len(got), len(input))
+		}
+	}
+	for i, sample := range samples {
+		if value := int16(binary.LittleEndian.Uint16(got[i*2:])); value != sample {
+			t.Fatalf("sample %d = %d, want %d", i, value, sample)
+		}
+	}
+}
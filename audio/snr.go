@@ -0,0 +1,59 @@
+// audio/snr.go - 采集端信噪比估计：有效语音的 RMS 电平与噪声底之比
+
+package audio
+
+import (
+	"math"
+)
+
+// 信噪比估计参数
+const (
+	snrFloorRise       = 0.02 // 噪声底估计每帧最多上升的 dB，下降则立即跟随
+	snrSpeechMargin    = 10.0 // 高出噪声底该 dB 数的帧视为有效语音
+	snrSpeechSmoothing = 0.05 // 语音电平的指数平滑系数
+)
+
+// SNREstimator estimates the signal-to-noise ratio of captured audio from per-frame RMS
+// levels: a minimum-tracking noise floor, and the smoothed level of the frames that stand
+// clearly above it (active speech or music). Both are in dBFS, so their difference is the
+// RMS ratio in dB.
+type SNREstimator struct {
+	floorDB    float64
+	speechDB   float64
+	floorSeen  bool
+	speechSeen bool
+}
+
+// NewSNREstimator creates an estimator that has seen no audio yet
+func NewSNREstimator() *SNREstimator {
+	return &SNREstimator{}
+}
+
+// Observe updates the estimate with a frame's level as computed by calculateDecibels
+func (e *SNREstimator) Observe(levelDB float64) {
+	switch {
+	case !e.floorSeen || levelDB < e.floorDB:
+		e.floorDB = levelDB
+		e.floorSeen = true
+	default:
+		e.floorDB = math.Min(levelDB, e.floorDB+snrFloorRise)
+	}
+
+	if levelDB < e.floorDB+snrSpeechMargin {
+		return
+	}
+	if !e.speechSeen {
+		e.speechDB = levelDB
+		e.speechSeen = true
+		return
+	}
+	e.speechDB += (levelDB - e.speechDB) * snrSpeechSmoothing
+}
+
+// SNR returns the estimated ratio in dB, or 0 while no active audio has been seen
+func (e *SNREstimator) SNR() float64 {
+	if !e.speechSeen {
+		return 0
+	}
+	return math.Max(e.speechDB-e.floorDB, 0)
+}
@@ -0,0 +1,29 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+// TestSNREstimator 语音电平与噪声底之差即为信噪比；只有噪声时没有估计值
+func TestSNREstimator(t *testing.T) {
+	e := NewSNREstimator()
+	for i := 0; i < 50; i++ {
+		e.Observe(-55)
+	}
+	if snr := e.SNR(); snr != 0 {
+		t.Fatalf("SNR %.1f dB from noise alone, want 0 (unknown)", snr)
+	}
+
+	// 语音与停顿交替：停顿把噪声底拉回，语音电平收敛到 -20 dB
+	for i := 0; i < 400; i++ {
+		if i%4 == 3 {
+			e.Observe(-55)
+		} else {
+			e.Observe(-20)
+		}
+	}
+	if snr := e.SNR(); math.Abs(snr-35) > 0.5 {
+		t.Fatalf("SNR %.1f dB, want about 35", snr)
+	}
+}
@@ -0,0 +1,65 @@
+// audio/stereo_width.go - -stereo-width：用中/侧 (mid/side) 处理收窄或展宽立体声声像
+
+package audio
+
+import (
+	"math"
+
+	"RemoteAudioCLI/utils"
+)
+
+// playbackStereoWidth 返回播放时使用的立体声宽度；只有立体声流才处理，其余返回 1（不变）
+func playbackStereoWidth(config *utils.Config) float64 {
+	if config.Channels != 2 {
+		return 1
+	}
+	return config.StereoWidth
+}
+
+// widenStereo 将立体声帧分解为中 (L+R)/2 与侧 (L-R)/2，侧信号乘以宽度后重新合成，
+// 超出满幅的采样被限制在满幅以内。结果写入播放器自己的缓冲区，audioData 本身不变
+func (p *Player) widenStereo(audioData []byte) []byte {
+	if p.stereoWidth == 1 {
+		return audioData
+	}
+	p.widthBuffer = append(p.widthBuffer[:0], audioData...)
+	data := p.widthBuffer
+
+	switch SampleDepth(p.config) {
+	case 16:
+		for i := 0; i+3 < len(data); i += 4 {
+			l, r := p.midSide(float64(int16(data[i])|int16(data[i+1])<<8), float64(int16(data[i+2])|int16(data[i+3])<<8))
+			left := int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(l))))
+			right := int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(r))))
+			data[i], data[i+1] = byte(left), byte(left>>8)
+			data[i+2], data[i+3] = byte(right), byte(right>>8)
+		}
+	case 32:
+		read := func(b []byte) float64 {
+			return float64(int32(b[0]) | int32(b[1])<<8 | int32(b[2])<<16 | int32(b[3])<<24)
+		}
+		write := func(b []byte, sample float64) {
+			out := int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(sample))))
+			b[0], b[1], b[2], b[3] = byte(out), byte(out>>8), byte(out>>16), byte(out>>24)
+		}
+		for i := 0; i+7 < len(data); i += 8 {
+			l, r := p.midSide(read(data[i:]), read(data[i+4:]))
+			write(data[i:], l)
+			write(data[i+4:], r)
+		}
+	case SampleDepthFloat32:
+		for i := 0; i+7 < len(data); i += 8 {
+			l, r := p.midSide(float64(readFloat32(data[i:])), float64(readFloat32(data[i+4:])))
+			writeFloat32(data[i:], clampFloat32(l))
+			writeFloat32(data[i+4:], clampFloat32(r))
+		}
+	}
+	return data
+}
+
+// midSide 对一对左右采样应用立体声宽度
+func (p *Player) midSide(left, right float64) (float64, float64) {
+	mid := (left + right) / 2
+	side := (left - right) / 2 * p.stereoWidth
+	return mid + side, mid - side
+}
@@ -0,0 +1,49 @@
+package audio
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"RemoteAudioCLI/utils"
+)
+
+// TestWidenStereo 宽度 0 合成单声道，1 不变，2 加倍侧信号并限制在满幅以内；非立体声流不处理
+func TestWidenStereo(t *testing.T) {
+	frame := func(samples ...int16) []byte {
+		data := make([]byte, len(samples)*2)
+		for i, sample := range samples {
+			binary.LittleEndian.PutUint16(data[i*2:], uint16(sample))
+		}
+		return data
+	}
+	for _, test := range []struct {
+		width float64
+		in    []int16
+		want  []int16
+	}{
+		{0, []int16{1000, 3000}, []int16{2000, 2000}},
+		{1, []int16{1000, 3000}, []int16{1000, 3000}},
+		{2, []int16{1000, 3000}, []int16{0, 4000}},
+		{2, []int16{32000, -32000}, []int16{32767, -32768}},
+	} {
+		config := utils.NewDefaultConfig()
+		config.BitDepth = 16
+		config.StereoWidth = test.width
+		player := NewPlayer(nil, config, utils.NewLoggerWithLevel(utils.LogLevelError))
+		in := frame(test.in...)
+		got := player.widenStereo(in)
+		if want := frame(test.want...); string(got) != string(want) {
+			t.Errorf("width %v: %v -> %v, want %v", test.width, test.in, got, want)
+		}
+		if test.width != 1 && string(in) != string(frame(test.in...)) {
+			t.Errorf("width %v modified the queued frame", test.width)
+		}
+	}
+
+	config := utils.NewDefaultConfig()
+	config.Channels = 1
+	config.StereoWidth = 0
+	if width := playbackStereoWidth(config); width != 1 {
+		t.Fatalf("mono stream width %v, want 1", width)
+	}
+}
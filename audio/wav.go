@@ -0,0 +1,317 @@
+// audio/wav.go - WAV (RIFF) 文件解析，以及把文件采样转换为会话音频格式的读取器
+
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"RemoteAudioCLI/utils"
+)
+
+// WAV 格式标签（fmt 块的 wFormatTag）
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+// wavUnknownSize 流式写出的 WAV 在 data 块大小未知时使用的占位值
+const wavUnknownSize = 0xFFFFFFFF
+
+// wavConvertFrames 转换时每次从文件读取的采样帧数
+const wavConvertFrames = 4096
+
+// WAVFormat describes the samples of a WAV file as declared by its fmt chunk
+type WAVFormat struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int  // 8、16、24 或 32
+	Float         bool // IEEE float 采样（仅 32 位）
+}
+
+// frameSize 返回每个采样帧的字节数
+func (f WAVFormat) frameSize() int {
+	return f.Channels * f.BitsPerSample / 8
+}
+
+// sampleDepth 返回与 SampleDepth 相同含义的采样深度，非 16/32 位整数时返回 0
+func (f WAVFormat) sampleDepth() int {
+	switch {
+	case f.Float:
+		return SampleDepthFloat32
+	case f.BitsPerSample == 16 || f.BitsPerSample == 32:
+		return f.BitsPerSample
+	}
+	return 0
+}
+
+// WAVReader reads the sample data of a WAV file. NewWAVReader consumes the RIFF
+// header and every chunk before "data"; Read then returns the raw little-endian
+// samples of the data chunk and io.EOF at its end.
+type WAVReader struct {
+	Format    WAVFormat
+	r         io.Reader
+	remaining int64 // data 块剩余字节数，-1 表示大小未知（读到文件结束）
+}
+
+// NewWAVReader parses the RIFF/WAVE header of r, skipping metadata chunks (and
+// their pad bytes) until the data chunk
+func NewWAVReader(r io.Reader) (*WAVReader, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, utils.WrapError(err, utils.ErrAudioCapture, "failed to read WAV header")
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, utils.NewAppError(utils.ErrAudioCapture, "not a RIFF/WAVE file")
+	}
+
+	var format *WAVFormat
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, utils.WrapError(err, utils.ErrAudioCapture, "WAV file has no data chunk")
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, utils.WrapError(err, utils.ErrAudioCapture, "failed to read WAV fmt chunk")
+			}
+			parsed, err := parseWAVFormat(body)
+			if err != nil {
+				return nil, err
+			}
+			format = parsed
+			if err := skipWAVPad(r, size); err != nil {
+				return nil, err
+			}
+		case "data":
+			if format == nil {
+				return nil, utils.NewAppError(utils.ErrAudioCapture, "WAV data chunk before fmt chunk")
+			}
+			if size == wavUnknownSize {
+				size = -1
+			}
+			return &WAVReader{Format: *format, r: r, remaining: size}, nil
+		default:
+			// LIST/INFO、fact、bext 等元数据块：连同填充字节一起跳过
+			if _, err := io.CopyN(io.Discard, r, size+size&1); err != nil {
+				return nil, utils.WrapError(err, utils.ErrAudioCapture, "failed to skip WAV "+id+" chunk")
+			}
+		}
+	}
+}
+
+// parseWAVFormat 解析 fmt 块，只接受 8/16/24/32 位整数 PCM 与 32 位浮点
+func parseWAVFormat(body []byte) (*WAVFormat, error) {
+	if len(body) < 16 {
+		return nil, utils.ErrAudioCapturef("WAV fmt chunk too short: %d bytes", len(body))
+	}
+	tag := binary.LittleEndian.Uint16(body[0:2])
+	if tag == wavFormatExtensible {
+		// WAVE_FORMAT_EXTENSIBLE：真实格式在 SubFormat GUID 的前两个字节
+		if len(body) < 26 {
+			return nil, utils.ErrAudioCapturef("WAV extensible fmt chunk too short: %d bytes", len(body))
+		}
+		tag = binary.LittleEndian.Uint16(body[24:26])
+	}
+	format := &WAVFormat{
+		Channels:      int(binary.LittleEndian.Uint16(body[2:4])),
+		SampleRate:    int(binary.LittleEndian.Uint32(body[4:8])),
+		BitsPerSample: int(binary.LittleEndian.Uint16(body[14:16])),
+		Float:         tag == wavFormatFloat,
+	}
+	if format.Channels <= 0 || format.SampleRate <= 0 {
+		return nil, utils.ErrAudioCapturef("invalid WAV format: %d Hz, %d channels", format.SampleRate, format.Channels)
+	}
+	switch {
+	case tag == wavFormatPCM && (format.BitsPerSample == 8 || format.BitsPerSample == 16 || format.BitsPerSample == 24 || format.BitsPerSample == 32):
+	case tag == wavFormatFloat && format.BitsPerSample == 32:
+	default:
+		return nil, utils.ErrAudioCapturef("unsupported WAV sample format: tag 0x%04X, %d bits", tag, format.BitsPerSample)
+	}
+	return format, nil
+}
+
+// skipWAVPad 奇数大小的块后面跟一个填充字节
+func skipWAVPad(r io.Reader, size int64) error {
+	if size&1 == 0 {
+		return nil
+	}
+	var pad [1]byte
+	if _, err := io.ReadFull(r, pad[:]); err != nil {
+		return utils.WrapError(err, utils.ErrAudioCapture, "failed to read WAV chunk padding")
+	}
+	return nil
+}
+
+// Read reads raw samples from the data chunk
+func (w *WAVReader) Read(p []byte) (int, error) {
+	if w.remaining == 0 {
+		return 0, io.EOF
+	}
+	if w.remaining > 0 && int64(len(p)) > w.remaining {
+		p = p[:w.remaining]
+	}
+	n, err := w.r.Read(p)
+	if w.remaining > 0 {
+		w.remaining -= int64(n)
+	}
+	return n, err
+}
+
+// OpenWAVInput returns r unchanged (but buffered) when it does not start with a RIFF
+// header. Otherwise it parses the WAV header and returns a reader that delivers the
+// file's samples in the stream format of config, together with the file's format.
+func OpenWAVInput(r io.Reader, config *utils.Config) (io.Reader, *WAVFormat, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil || !bytes.Equal(magic, []byte("RIFF")) {
+		// 不是 WAV（或不足 4 字节）：按原始 PCM 处理
+		return buffered, nil, nil
+	}
+	wav, err := NewWAVReader(buffered)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewWAVConverter(wav, config), &wav.Format, nil
+}
+
+// wavConverter 把 WAV 采样转换为会话格式：采样深度转换、声道映射，采样率不同时线性插值重采样
+type wavConverter struct {
+	wav       *WAVReader
+	channels  int
+	bitDepth  int        // 见 SampleDepth
+	resampler *Resampler // nil 表示采样率相同
+	raw       []byte
+	pending   []byte
+	done      bool
+}
+
+// NewWAVConverter returns a reader that yields the samples of wav as interleaved
+// little-endian PCM in the sample rate, channel count and sample format of config.
+// A file already in that format is read without conversion. Channels are mapped by
+// averaging to mono, duplicating mono, or otherwise keeping the first channels and
+// filling extra output channels with silence.
+func NewWAVConverter(wav *WAVReader, config *utils.Config) io.Reader {
+	format := wav.Format
+	bitDepth := SampleDepth(config)
+	if format.SampleRate == config.SampleRate && format.Channels == config.Channels && format.sampleDepth() == bitDepth {
+		return wav
+	}
+	converter := &wavConverter{
+		wav:      wav,
+		channels: config.Channels,
+		bitDepth: bitDepth,
+		raw:      make([]byte, wavConvertFrames*format.frameSize()),
+	}
+	if format.SampleRate != config.SampleRate {
+		converter.resampler = NewResampler(format.SampleRate, config.SampleRate, config.Channels, bitDepth, config.FramesPerBuffer)
+	}
+	return converter
+}
+
+// Read returns converted samples; the resampler's last partial chunk at the end of the file is dropped
+func (wc *wavConverter) Read(p []byte) (int, error) {
+	for len(wc.pending) == 0 {
+		if wc.done {
+			return 0, io.EOF
+		}
+		if err := wc.convertBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, wc.pending)
+	wc.pending = wc.pending[n:]
+	return n, nil
+}
+
+// convertBlock 读取并转换一块采样，追加到 pending
+func (wc *wavConverter) convertBlock() error {
+	n, err := io.ReadFull(wc.wav, wc.raw)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		wc.done = true
+	} else if err != nil {
+		return utils.WrapError(err, utils.ErrAudioCapture, "failed to read WAV samples")
+	}
+
+	format := wc.wav.Format
+	block := wc.raw[:n-n%format.frameSize()]
+	if len(block) == 0 {
+		return nil
+	}
+	samples := mapChannels(decodeWAVSamples(block, format), format.Channels, wc.channels)
+	encoded := encodeSamples(samples, wc.bitDepth)
+	if wc.resampler == nil {
+		wc.pending = encoded
+		return nil
+	}
+	for _, chunk := range wc.resampler.Process(encoded) {
+		wc.pending = append(wc.pending, chunk...)
+	}
+	return nil
+}
+
+// decodeWAVSamples 将 WAV 采样转为 -1.0 到 1.0 的浮点采样（8 位为无符号）
+func decodeWAVSamples(data []byte, format WAVFormat) []float64 {
+	switch {
+	case format.BitsPerSample == 8:
+		samples := make([]float64, len(data))
+		for i, b := range data {
+			samples[i] = (float64(b) - 128) / 128.0
+		}
+		return samples
+	case format.BitsPerSample == 24:
+		samples := make([]float64, 0, len(data)/3)
+		for i := 0; i+2 < len(data); i += 3 {
+			// 放到 int32 的高 24 位再右移，完成符号扩展
+			sample := (int32(data[i])<<8 | int32(data[i+1])<<16 | int32(data[i+2])<<24) >> 8
+			samples = append(samples, float64(sample)/8388608.0)
+		}
+		return samples
+	}
+	return decodeSamples(data, format.sampleDepth())
+}
+
+// MapPCMChannels converts interleaved little-endian PCM of the given sample depth (see
+// SampleDepth) from one channel count to another, like the WAV converter does
+func MapPCMChannels(data []byte, bitDepth, from, to int) []byte {
+	if from == to {
+		return data
+	}
+	return encodeSamples(mapChannels(decodeSamples(data, bitDepth), from, to), bitDepth)
+}
+
+// mapChannels 将交错采样从 from 声道映射到 to 声道
+func mapChannels(samples []float64, from, to int) []float64 {
+	if from == to {
+		return samples
+	}
+	frames := len(samples) / from
+	mapped := make([]float64, frames*to)
+	for f := 0; f < frames; f++ {
+		frame := samples[f*from : (f+1)*from]
+		out := mapped[f*to : (f+1)*to]
+		switch {
+		case to == 1:
+			sum := 0.0
+			for _, sample := range frame {
+				sum += sample
+			}
+			out[0] = sum / float64(from)
+		case from == 1:
+			for ch := range out {
+				out[ch] = frame[0]
+			}
+		default:
+			copy(out, frame)
+		}
+	}
+	return mapped
+}
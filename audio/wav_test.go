@@ -0,0 +1,212 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"RemoteAudioCLI/utils"
+)
+
+// wavChunk 构造一个 RIFF 块，奇数大小时补一个填充字节
+func wavChunk(id string, body []byte) []byte {
+	chunk := make([]byte, 8, 8+len(body)+1)
+	copy(chunk, id)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(body)))
+	chunk = append(chunk, body...)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// wavFmt 构造 16 字节的 fmt 块
+func wavFmt(tag uint16, channels, sampleRate, bits int) []byte {
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint16(body[0:], tag)
+	binary.LittleEndian.PutUint16(body[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(body[4:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(body[8:], uint32(sampleRate*channels*bits/8))
+	binary.LittleEndian.PutUint16(body[12:], uint16(channels*bits/8))
+	binary.LittleEndian.PutUint16(body[14:], uint16(bits))
+	return wavChunk("fmt ", body)
+}
+
+// wavFile 把块拼成完整的 RIFF/WAVE 文件
+func wavFile(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	file := make([]byte, 8, 8+len(body))
+	copy(file, "RIFF")
+	binary.LittleEndian.PutUint32(file[4:], uint32(len(body)))
+	return append(file, body...)
+}
+
+func TestWAVReader16Bit(t *testing.T) {
+	samples := []byte{0x01, 0x00, 0xFF, 0x7F, 0x00, 0x80, 0x34, 0x12}
+	file := wavFile(wavFmt(wavFormatPCM, 2, 44100, 16), wavChunk("data", samples))
+
+	wav, err := NewWAVReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("NewWAVReader: %v", err)
+	}
+	want := WAVFormat{SampleRate: 44100, Channels: 2, BitsPerSample: 16}
+	if wav.Format != want {
+		t.Fatalf("format = %+v, want %+v", wav.Format, want)
+	}
+	data, err := io.ReadAll(wav)
+	if err != nil || !bytes.Equal(data, samples) {
+		t.Fatalf("data = %v (err %v), want %v", data, err, samples)
+	}
+}
+
+// TestWAVReaderSkipsMetadataChunks fmt 前后的元数据块（含奇数大小与填充字节）都要跳过，data 之后的块不算采样
+func TestWAVReaderSkipsMetadataChunks(t *testing.T) {
+	samples := []byte{0x00, 0x00, 0x80, 0xFF, 0xFF, 0x7F, 0x01, 0x00, 0x00}
+	file := wavFile(
+		wavChunk("JUNK", make([]byte, 28)),
+		wavFmt(wavFormatPCM, 1, 48000, 24),
+		wavChunk("LIST", []byte("INFOISFT\x03\x00\x00\x00ab\x00")), // 15 字节，后跟填充字节
+		wavChunk("fact", []byte{3, 0, 0, 0}),
+		wavChunk("data", samples),
+		wavChunk("id3 ", []byte{1, 2, 3}),
+	)
+
+	wav, err := NewWAVReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("NewWAVReader: %v", err)
+	}
+	if wav.Format.BitsPerSample != 24 || wav.Format.Channels != 1 {
+		t.Fatalf("format = %+v, want 24-bit mono", wav.Format)
+	}
+	data, err := io.ReadAll(wav)
+	if err != nil || !bytes.Equal(data, samples) {
+		t.Fatalf("data = %v (err %v), want %v", data, err, samples)
+	}
+}
+
+// TestWAVConverter24Bit 24 位单声道转换为 16 位立体声：满幅与符号扩展正确，两个声道相同
+func TestWAVConverter24Bit(t *testing.T) {
+	// 0、-1.0、最大正值、1/256 满幅
+	samples := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0xFF, 0xFF, 0x7F, 0x00, 0x80, 0x00}
+	file := wavFile(wavFmt(wavFormatPCM, 1, 48000, 24), wavChunk("data", samples))
+
+	config := utils.NewDefaultConfig()
+	config.SampleRate = 48000
+	config.Channels = 2
+	config.BitDepth = 16
+	reader, format, err := OpenWAVInput(bytes.NewReader(file), config)
+	if err != nil || format == nil {
+		t.Fatalf("OpenWAVInput: format=%v err=%v", format, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	want := []int16{0, 0, -32768, -32768, 32767, 32767, 128, 128}
+	if len(data) != len(want)*2 {
+		t.Fatalf("converted %d bytes, want %d", len(data), len(want)*2)
+	}
+	for i, sample := range want {
+		if got := int16(binary.LittleEndian.Uint16(data[i*2:])); got != sample {
+			t.Fatalf("sample %d = %d, want %d", i, got, sample)
+		}
+	}
+}
+
+// TestWAVConverterResamples 采样率不同时重采样到会话采样率，输出按 FramesPerBuffer 分块
+func TestWAVConverterResamples(t *testing.T) {
+	const inputFrames = 2400
+	samples := make([]byte, inputFrames*2)
+	file := wavFile(wavFmt(wavFormatPCM, 1, 24000, 16), wavChunk("data", samples))
+
+	config := utils.NewDefaultConfig()
+	config.SampleRate = 48000
+	config.Channels = 2
+	config.BitDepth = 16
+	config.FramesPerBuffer = 480
+	reader, _, err := OpenWAVInput(bytes.NewReader(file), config)
+	if err != nil {
+		t.Fatalf("OpenWAVInput: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	frames := len(data) / config.GetFrameSize()
+	if frames%config.FramesPerBuffer != 0 || frames < 2*inputFrames-config.FramesPerBuffer || frames > 2*inputFrames {
+		t.Fatalf("resampled to %d frames, want whole buffers close to %d", frames, 2*inputFrames)
+	}
+}
+
+// TestWAVReaderUnchangedFormat 与会话格式相同的文件直接读取，不经过转换
+func TestWAVReaderUnchangedFormat(t *testing.T) {
+	samples := []byte{1, 2, 3, 4}
+	file := wavFile(wavFmt(wavFormatPCM, 2, 48000, 16), wavChunk("data", samples))
+
+	config := utils.NewDefaultConfig()
+	config.SampleRate = 48000
+	config.Channels = 2
+	config.BitDepth = 16
+	reader, _, err := OpenWAVInput(bytes.NewReader(file), config)
+	if err != nil {
+		t.Fatalf("OpenWAVInput: %v", err)
+	}
+	if _, ok := reader.(*WAVReader); !ok {
+		t.Fatalf("reader is %T, want *WAVReader", reader)
+	}
+}
+
+func TestOpenWAVInputRawPCM(t *testing.T) {
+	raw := []byte{0x10, 0x20, 0x30, 0x40, 0x50, 0x60}
+	reader, format, err := OpenWAVInput(bytes.NewReader(raw), utils.NewDefaultConfig())
+	if err != nil || format != nil {
+		t.Fatalf("raw PCM detected as WAV: format=%v err=%v", format, err)
+	}
+	data, _ := io.ReadAll(reader)
+	if !bytes.Equal(data, raw) {
+		t.Fatalf("raw PCM changed: %v", data)
+	}
+}
+
+func TestWAVReaderRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"not RIFF", append([]byte("RIFX\x00\x00\x00\x00WAVE"), wavFmt(wavFormatPCM, 1, 8000, 16)...)},
+		{"data before fmt", wavFile(wavChunk("data", []byte{0, 0}), wavFmt(wavFormatPCM, 1, 8000, 16))},
+		{"ADPCM", wavFile(wavFmt(0x0002, 1, 8000, 4), wavChunk("data", []byte{0, 0}))},
+		{"float64", wavFile(wavFmt(wavFormatFloat, 1, 8000, 64), wavChunk("data", make([]byte, 8)))},
+		{"no data chunk", wavFile(wavFmt(wavFormatPCM, 1, 8000, 16))},
+		{"truncated metadata", wavFile(wavFmt(wavFormatPCM, 1, 8000, 16), []byte("LIST\x40\x00\x00\x00abc"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewWAVReader(bytes.NewReader(tt.file)); err == nil {
+				t.Fatalf("expected an error")
+			}
+		})
+	}
+}
+
+// TestMapPCMChannelsDownmix 立体声取平均混为单声道（-send-mono）
+func TestMapPCMChannelsDownmix(t *testing.T) {
+	stereo := make([]byte, 8)
+	for i, sample := range []int16{1000, 3000, -2000, 2000} {
+		binary.LittleEndian.PutUint16(stereo[i*2:], uint16(sample))
+	}
+	mono := MapPCMChannels(stereo, 16, 2, 1)
+	want := []int16{2000, 0}
+	if len(mono) != len(want)*2 {
+		t.Fatalf("downmixed to %d bytes, want %d", len(mono), len(want)*2)
+	}
+	for i, sample := range want {
+		if got := int16(binary.LittleEndian.Uint16(mono[i*2:])); got != sample {
+			t.Fatalf("sample %d = %d, want %d", i, got, sample)
+		}
+	}
+}
@@ -4,7 +4,7 @@ go 1.18
 
 require github.com/gordonklaus/portaudio v0.0.0-20250206071425-98a94950218b
 
-require github.com/hraban/opus v0.0.0-20230925203106-0188a62cb302 // indirect
+require github.com/hraban/opus v0.0.0-20230925203106-0188a62cb302
 
 // Windows 7 兼容性配置
 // 使用较旧但稳定的 PortAudio & Go 版本以确保兼容性
@@ -4,10 +4,15 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -21,6 +26,7 @@ import (
 	"RemoteAudioCLI/audio"
 	"RemoteAudioCLI/network"
 	"RemoteAudioCLI/utils"
+	"RemoteAudioCLI/version"
 )
 
 func main() {
@@ -30,17 +36,97 @@ func main() {
 	var (
 		mode         = flag.String("mode", "", "Operating mode: 'server' or 'client'")
 		host         = flag.String("host", "", "Server host address")
+		portFile     = flag.String("port-file", "", "Server: write the bound port to this file (useful with -port 0)")
+		iface        = flag.String("interface", "", "Server: bind to the address of this network interface, e.g. eth0 (cannot be combined with -host)")
 		port         = flag.Int("port", 0, "Server port")
 		inputDevice  = flag.String("input-device", "", "Input audio device name or index")
-		outputDevice = flag.String("output-device", "", "Output audio device name or index")
+		outputDevice = flag.String("output-device", "", "Output audio device name or index; comma-separated to play on several devices at once (server)")
+		outputChannelMap = flag.String("output-channel-map", "", "Server: 1-based device channels to play the stream channels on, e.g. 3,4")
+		inputChannelMap  = flag.String("input-channel-map", "", "Client: 1-based device channels to capture the stream channels from, e.g. 3,4")
+		selectDevice = flag.Bool("select-device", false, "Pick the output (server) or input (client) device from a list even when other flags are given")
 		listDevices  = flag.Bool("list-devices", false, "List all available audio devices")
+		jsonOutput   = flag.Bool("json", false, "With -list-devices: print devices as JSON")
+		hostAPI      = flag.String("host-api", "", "Only use devices of host APIs matching this name, e.g. WASAPI")
+		listHostAPIs = flag.Bool("list-host-apis", false, "List all available audio host APIs")
+		probeDevice  = flag.Int("probe-device", -1, "Print the sample rates and channel counts the device with this index supports")
+		agc = flag.Bool("agc", false, "Client: enable automatic gain control")
+		agcTargetDB = flag.Float64("agc-target-db", -20.0, "Client: AGC target RMS level in dB")
+		clipFraction = flag.Float64("clip-fraction", 0.001, "Client: fraction of full-scale samples per second that triggers a clipping warning")
+		startMuted = flag.Bool("start-muted", false, "Client: start muted, type 'm' and Enter to toggle mute")
+		ptt        = flag.Bool("ptt", false, "Client: push-to-talk, only send audio after typing the -ptt-key and Enter, until typed again")
+		pttKey     = flag.String("ptt-key", "t", "Client: runtime command that toggles transmitting in -ptt mode")
+		loopbackCapture = flag.Bool("loopback-capture", false, "Client: capture system audio from the output device via WASAPI loopback (Windows)")
+		deviceBuffer    = flag.Int("device-buffer", 0, "Client: capture device buffer size in sample frames, repacketized into the stream's packet size (0 = same as the packet size)")
+		sendMono        = flag.Bool("send-mono", false, "Client: downmix captured audio to mono before encoding (the device stays in its channel count)")
+		resume       = flag.Bool("resume", false, "Start with the settings saved by the last successful start (other settings flags are ignored)")
+		check        = flag.Bool("check", false, "Validate the configuration (devices, formats, network, codec), print a report and exit")
+		showVersion  = flag.Bool("version", false, "Show version, build and protocol information")
 		help         = flag.Bool("help", false, "Show help information")
-		quality      = flag.String("quality", "normal", "Stream quality: verylow, low, normal, high, lossless")
+		logFormat    = flag.String("log-format", "text", "Log output format: text or json (one JSON object per line)")
+		logLevel     = flag.String("log-level", "info", "Minimum log level: debug, info, warn or error")
+		psk          = flag.String("psk", "", "Pre-shared passphrase that encrypts audio payloads (both ends must use the same one; also read from REMOTEAUDIO_PSK)")
+		replayWindow = flag.Int("replay-window", 64, "Server: with -psk, reject audio packets whose sequence number was already received among this many recent packets (1-65536)")
+		statusAddr   = flag.String("status-addr", "", "Serve an auto-refreshing HTML status page on this address, e.g. :8081")
+		statsInterval = flag.Duration("stats-interval", 0, "Refresh interval of the live stats line (default 500ms), or between stats events with -log-format json (default 10s)")
+		noColor      = flag.Bool("no-color", false, "Disable colored log and statistics output")
+		waveform     = flag.Bool("waveform", false, "Show a scrolling ASCII waveform of the peak level instead of the stats line level meter")
+		quality      = flag.String("quality", "normal", "Stream quality: verylow, low, normal, high, lossless or a preset from the presets file")
+		presetsFile  = flag.String("presets-file", "", "Load user quality presets from this JSON file (default: ~/.config/remoteaudio/presets.json if it exists)")
 		compress     = flag.String("compress", "", "Compression mode: 'yes' (Opus) or 'no' (PCM)")
+		codec        = flag.String("codec", "", "Audio codec: 'pcm', 'opus' or 'flac' (overrides -compress)")
+		channels     = flag.Int("channels", 0, "Override the channel count of the quality preset (1-8, e.g. 6 for 5.1)")
+		sampleFormat = flag.String("sample-format", "int", "Client: sample format on the wire: int or float32 (32-bit float PCM, requires -codec pcm)")
+		inputGain    = flag.Float64("input-gain", 0, "Client: fixed input trim in dB applied before metering and encoding (-40 to 40)")
+		highPass     = flag.Float64("highpass", 0, "Client: high-pass filter cutoff in Hz to remove rumble, e.g. 80 (0 disables)")
+		lowPass      = flag.Float64("lowpass", 0, "Client: low-pass filter cutoff in Hz to remove hiss, e.g. 15000 (0 disables)")
+		maxBitrate   = flag.Int("max-bitrate", 0, "Client: cap the stream bandwidth in kbit/s, headers included (0 = unlimited)")
+		simulateLoss    = flag.Float64("simulate-loss", 0, "Client (testing): drop this fraction of outgoing audio packets, e.g. 0.05")
+		simulateJitter  = flag.Duration("simulate-jitter", 0, "Client (testing): delay each outgoing audio packet by a random time up to this, e.g. 30ms")
+		simulateReorder = flag.Float64("simulate-reorder", 0, "Client (testing): send this fraction of outgoing audio packets after the next one, e.g. 0.02")
 		excitation   = flag.Bool("excitation", false, "Enable excitation mode (pause streaming when silent)")
 		excitationThreshold = flag.Float64("excitation-threshold", -45.0, "Excitation threshold in dB")
 		excitationTimeout   = flag.Int("excitation-timeout", 10, "Excitation timeout in seconds")
-		allowClient = flag.String("allow-client", "", "Comma-separated list of allowed client IPs (whitelist, default: allow all)")
+		volume       = flag.Int("volume", 100, "Server: playback volume in percent (0-100)")
+		balance      = flag.Float64("balance", 0, "Server: stereo balance from -1.0 (full left) to +1.0 (full right)")
+		stereoWidth  = flag.Float64("stereo-width", 1.0, "Server: stereo width, 0 (mono) to 4; 1 leaves the image unchanged, above 1 widens it")
+		connectSound    = flag.Bool("connect-sound", true, "Server: play the connection sound once a client has stayed connected for -connect-sound-delay")
+		connectSoundDelay = flag.Duration("connect-sound-delay", 500*time.Millisecond, "Server: how long a client must stay connected before the connection sound plays and playback fades in")
+		disconnectSound = flag.Bool("disconnect-sound", true, "Server: play the disconnection sound when a client session ends")
+		startupSound    = flag.Bool("startup-sound", true, "Server: play the 4-tone beep when the server starts")
+		comfortNoise = flag.Bool("comfort-noise", false, "Server: play low-level comfort noise during audio gaps instead of silence (same as -conceal comfort)")
+		conceal       = flag.String("conceal", "", "Server: how audio gaps are filled: silence (default), repeat or comfort")
+		concealFrames = flag.Int("conceal-frames", 3, "Server: with -conceal repeat, how many frames the last good frame is repeated while fading out")
+		limiter        = flag.Bool("limiter", false, "Server: softly compress output peaks approaching full scale instead of hard clipping")
+		limiterRelease = flag.Duration("limiter-release", 100*time.Millisecond, "Server: how fast the -limiter gain recovers after a peak")
+		deviceReopenAttempts = flag.Int("device-reopen-attempts", 5, "Server: attempts to reopen playback on the default output device after the current one disappears (0 disables)")
+		fadeDuration = flag.Duration("fade", 500*time.Millisecond, "Server: playback fade-in/fade-out duration (0 disables)")
+		drainTimeout = flag.Duration("drain-timeout", 0, "Server: on shutdown, play the buffered audio (fading out at its end) for up to this long before closing the output (0 disables)")
+		maxLatencyMs = flag.Int("max-latency-ms", 0, "Server: drop the oldest buffered audio when playback lags more than this many ms (0 = unlimited)")
+		prefillFrames = flag.Int("prefill-frames", 0, "Server: buffer this many frames before playback starts and after an underrun (0 = start at once)")
+		serverSilenceSuppress = flag.Duration("server-silence-suppress", 0, "Server: let the output device idle after this much continuous silence (0 disables)")
+		maxSession = flag.Duration("max-session", 0, "Server: disconnect a client after its session has lasted this long (0 disables)")
+		idleTimeout = flag.Duration("idle-timeout", 0, "Server: disconnect a client that sends no audio, only heartbeats, for this long (0 disables)")
+		preopenOutput = flag.Bool("preopen-output", false, "Server: open the output stream at startup so playback is ready when audio arrives")
+		minSampleRate = flag.Int("min-sample-rate", 0, "Server: reject clients that cannot stream at or above this sample rate (0 = no limit)")
+		maxSampleRate = flag.Int("max-sample-rate", 0, "Server: coerce clients down to this sample rate (0 = no limit)")
+		allowCodecs = flag.String("allow-codecs", "", "Server: comma-separated list of accepted codecs, e.g. pcm,opus (default: all)")
+		maxAudioPayload = flag.Int("max-audio-payload", 32768, "Server: maximum accepted audio packet payload in bytes")
+		allowClient = flag.String("allow-client", "", "Comma-separated list of allowed client IPs, CIDR ranges or host names (whitelist, default: allow all)")
+		allowClientFile = flag.String("allow-client-file", "", "Server: file with one allowed client IP, CIDR range or host name per line (# starts a comment)")
+		denyClient = flag.String("deny-client", "", "Server: comma-separated list of client IPs, CIDR ranges or host names to reject, even if allowed")
+		denyClientFile = flag.String("deny-client-file", "", "Server: file with one denied client IP, CIDR range or host name per line (# starts a comment)")
+		driftCorrection = flag.Duration("drift-correction", 10*time.Second, "Minimum interval between clock drift corrections on the server (0 disables)")
+		connectRetries = flag.Int("connect-retries", 3, "Client: extra connection attempts, 1s apart, while the server refuses or does not answer the handshake")
+		sampleIndex = flag.Bool("sample-index", false, "Client: tag audio packets with a cumulative sample index for sample-accurate sync")
+		controlChannel = flag.Bool("control-channel", false, "Client: send heartbeats over a second connection so they are not delayed by audio")
+		heartbeatInterval = flag.Duration("heartbeat-interval", 5*time.Second, "Interval between heartbeat packets")
+		heartbeatTimeout  = flag.Duration("heartbeat-timeout", 10*time.Second, "Warn when no packet has been received for this long")
+		keepaliveTimeout  = flag.Duration("keepalive-timeout", 30*time.Second, "Close the connection when no packet has been received for this long")
+		duration = flag.Duration("duration", 0, "Stop automatically after the given duration, e.g. 30s or 5m (default: run until interrupted)")
+		inputPipe  = flag.String("input-pipe", "", "Client: read raw PCM from a named pipe instead of an input device ('-' for stdin)")
+		outputPipe = flag.String("output-pipe", "", "Server: write raw PCM to a named pipe instead of an output device ('-' for stdout)")
+		inputFile  = flag.String("input-file", "", "Client: send the Opus packets of an Ogg Opus file without re-encoding")
+		pcmBigEndian = flag.Bool("pcm-bigendian", false, "Raw PCM on -input-pipe/-output-pipe is big-endian (the network stream stays little-endian)")
 	)
 
 	flag.Parse()
@@ -51,20 +137,73 @@ func main() {
 		return
 	}
 
+	// Show version information
+	if *showVersion {
+		printVersion()
+		return
+	}
+
 	// Initialize logger
 	logger := utils.NewLogger()
+	logger.SetNoColor(*noColor)
+	logger.SetWaveform(*waveform)
+	parsedLogFormat, formatErr := utils.ParseLogFormat(*logFormat)
+	if formatErr != nil {
+		logger.Error(formatErr.Error())
+		os.Exit(utils.ExitCodeConfig)
+	}
+	logger.SetFormat(parsedLogFormat)
+	parsedLogLevel, levelErr := utils.ParseLogLevel(*logLevel)
+	if levelErr != nil {
+		logger.Error(levelErr.Error())
+		os.Exit(utils.ExitCodeConfig)
+	}
+	logger.SetLevel(parsedLogLevel)
+	logger.SetStatsInterval(*statsInterval)
+	// 音频写入标准输出时，日志改为输出到标准错误
+	// JSON 设备列表同理，保证标准输出只有 JSON
+	if *outputPipe == audio.StdioPipe || ((*listDevices || *listHostAPIs) && *jsonOutput) {
+		logger.SetOutput(os.Stderr)
+	}
 	logger.Info("🎵 Remote Audio CLI - Starting Application")
+	logger.Info(fmt.Sprintf("📦 Version %s, protocol v%d", version.String(), network.ProtocolVersion))
 
-	// Initialize audio system EARLY - before any device operations
-	if err := audio.Initialize(); err != nil {
-		logger.Error(fmt.Sprintf("Failed to initialize audio system: %v", err))
-		gracefulExitWithCode(logger, 1)
-	}
+	// PortAudio 在第一次需要设备时才初始化（见 audio.Initialize），
+	// 管道模式不会初始化；Terminate 只在已初始化时才真正释放
 	defer audio.Terminate()
 
-	// List audio devices if requested (now after initialization)
+	// 用户质量预设：默认文件不存在时只有内置预设，显式指定的文件必须可用
+	presets, presetsErr := utils.LoadQualityPresets(*presetsFile)
+	if presetsErr != nil {
+		logger.Error(presetsErr.Error())
+		gracefulExitWithCode(logger, utils.ExitCodeConfig)
+	}
+	userPresets = presets
+	if len(userPresets) > 0 {
+		logger.Debug(fmt.Sprintf("Loaded %d quality presets: %s", len(userPresets), strings.Join(sortedPresetNames(), ", ")))
+	}
+
+	// 按 Host API 过滤设备（影响设备列表、默认设备和交互式选择）
+	if *hostAPI != "" {
+		audio.SetHostAPIFilter(*hostAPI)
+		logger.Info(fmt.Sprintf("🎚️ Host API filter: %s", *hostAPI))
+	}
+
+	// List host APIs if requested
+	if *listHostAPIs {
+		listAudioHostAPIs(logger, *jsonOutput)
+		return
+	}
+
+	// List audio devices if requested
 	if *listDevices {
-		listAudioDevices(logger)
+		listAudioDevices(logger, *jsonOutput)
+		return
+	}
+
+	// 探测设备支持的采样率/声道组合
+	if *probeDevice >= 0 {
+		probeAudioDevice(*probeDevice, logger)
 		return
 	}
 
@@ -72,9 +211,16 @@ func main() {
 	config := utils.NewDefaultConfig()
 	
 	// Check if command line arguments are provided
-	hasArgs := (*mode != "" || *host != "" || *port != 0 || *inputDevice != "" || *outputDevice != "")
+	hasArgs := (*mode != "" || *host != "" || *iface != "" || *port != 0 || *inputDevice != "" || *outputDevice != "" || *inputPipe != "" || *outputPipe != "" || *inputFile != "" || *loopbackCapture)
 
-	if hasArgs {
+	if *resume {
+		last, err := utils.LoadLastConfig()
+		if err != nil {
+			logger.Error(err.Error())
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config = applyLastConfig(last, logger)
+	} else if hasArgs {
 		// Use command line arguments
 		if *mode != "" {
 			config.Mode = *mode
@@ -82,11 +228,37 @@ func main() {
 		if *host != "" {
 			config.Host = *host
 		}
-		if *port != 0 {
-			config.Port = *port
+		if *iface != "" {
+			if *host != "" {
+				logger.Error("Invalid arguments: -host and -interface cannot be used together")
+				gracefulExitWithCode(logger, utils.ExitCodeConfig)
+			}
+			config.Interface = *iface
+		}
+		config.PortFile = *portFile
+		// 显式指定 -port 0 时由系统分配端口（仅服务端）
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "port" {
+				config.Port = *port
+			}
+		})
+		if config.Port < 0 || config.Port > 65535 {
+			logger.Error("Invalid port: must be between 0 and 65535")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
 		}
 		config.InputDevice = *inputDevice
 		config.OutputDevice = *outputDevice
+		for _, channelMapFlag := range []struct {
+			value  string
+			target *[]int
+		}{{*outputChannelMap, &config.OutputChannelMap}, {*inputChannelMap, &config.InputChannelMap}} {
+			channelMap, err := utils.ParseChannelMap(channelMapFlag.value)
+			if err != nil {
+				logger.Error(err.Error())
+				gracefulExitWithCode(logger, utils.ExitCodeConfig)
+			}
+			*channelMapFlag.target = channelMap
+		}
 
 		// If no mode specified even with other args, prompt for mode
 		if config.Mode == "" {
@@ -95,40 +267,345 @@ func main() {
 
 		config.StreamQuality = parseQualityArg(*quality)
 		applyQualityParams(config)
+		if *channels != 0 {
+			if *channels < 1 || *channels > 8 {
+				logger.Error("Invalid channel count: must be between 1 and 8")
+				gracefulExitWithCode(logger, utils.ExitCodeConfig)
+			}
+			config.Channels = *channels
+		}
 		config.Compression = parseCompressionArg(*compress)
+		if *codec != "" {
+			parsedCodec, ok := parseCodecArg(*codec)
+			if !ok {
+				logger.Error(fmt.Sprintf("Invalid codec: %s (must be pcm, opus or flac)", *codec))
+				gracefulExitWithCode(logger, utils.ExitCodeConfig)
+			}
+			config.Compression = parsedCodec
+		}
+		// 用户预设可指定编解码器与码率，命令行显式给出的 -codec / -compress / -max-bitrate 优先
+		preset, isUserPreset := userPresets[config.StreamQuality]
+		if isUserPreset && preset.Codec != "" && !flagWasSet("codec") && !flagWasSet("compress") {
+			config.Compression, _ = parseCodecArg(preset.Codec)
+		}
+		parsedSampleFormat, sampleFormatErr := utils.ParseSampleFormat(*sampleFormat)
+		if sampleFormatErr != nil {
+			logger.Error(sampleFormatErr.Error())
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		if parsedSampleFormat == utils.SampleFormatFloat32 {
+			if config.Compression != utils.CodecPCM {
+				logger.Error("Invalid sample format: float32 is only supported with -codec pcm")
+				gracefulExitWithCode(logger, utils.ExitCodeConfig)
+			}
+			// float32 采样固定占 32 位，取代预设的整数位深
+			config.BitDepth = 32
+		}
+		config.SampleFormat = parsedSampleFormat
+		if *maxBitrate < 0 {
+			logger.Error("Invalid max bitrate: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.MaxBitrate = *maxBitrate * 1000
+		if *simulateLoss < 0 || *simulateLoss > 1 || *simulateReorder < 0 || *simulateReorder > 1 {
+			logger.Error("Invalid -simulate-loss or -simulate-reorder: must be between 0 and 1")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		if *simulateJitter < 0 {
+			logger.Error("Invalid simulate jitter: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.SimulateLoss = *simulateLoss
+		config.SimulateJitter = *simulateJitter
+		config.SimulateReorder = *simulateReorder
+		if isUserPreset && preset.Bitrate > 0 && !flagWasSet("max-bitrate") {
+			config.MaxBitrate = preset.Bitrate * 1000
+		}
+		if config.Mode == "client" && config.MaxBitrate > 0 {
+			applyBitrateCap(config, *channels, logger)
+		}
 		config.EnableExcitation = *excitation
 		config.ExcitationThreshold = *excitationThreshold
 		config.ExcitationTimeout = *excitationTimeout
-		if *allowClient != "" {
-			ips := strings.Split(*allowClient, ",")
-			for i := range ips {
-				ips[i] = strings.TrimSpace(ips[i])
+		config.AllowClients = parseClientListFlag("allow-client", *allowClient, logger)
+		config.AllowClientsFile = *allowClientFile
+		config.DenyClients = parseClientListFlag("deny-client", *denyClient, logger)
+		config.DenyClientsFile = *denyClientFile
+		config.DriftCorrectionInterval = *driftCorrection
+		if *maxAudioPayload <= 0 || *maxAudioPayload > network.MaxPayloadSize {
+			logger.Error(fmt.Sprintf("Invalid max audio payload: must be between 1 and %d bytes", network.MaxPayloadSize))
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.MaxAudioPayloadSize = *maxAudioPayload
+		// 服务端握手策略：显式指定 -quality 时，预设即为客户端可请求的上限
+		if config.Mode == "server" {
+			flag.Visit(func(f *flag.Flag) {
+				if f.Name == "quality" || f.Name == "channels" {
+					config.MaxSampleRate = config.SampleRate
+					config.MaxChannels = config.Channels
+					config.MaxBitDepth = config.BitDepth
+				}
+			})
+		}
+		if *minSampleRate < 0 || *maxSampleRate < 0 || (*maxSampleRate > 0 && *minSampleRate > *maxSampleRate) {
+			logger.Error("Invalid sample rate limits: -min-sample-rate must not exceed -max-sample-rate")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		if *maxSampleRate > 0 {
+			config.MaxSampleRate = *maxSampleRate
+		}
+		config.MinSampleRate = *minSampleRate
+		if *allowCodecs != "" {
+			for _, name := range strings.Split(*allowCodecs, ",") {
+				parsedCodec, ok := parseCodecArg(strings.TrimSpace(name))
+				if !ok {
+					logger.Error(fmt.Sprintf("Invalid codec in -allow-codecs: %s (must be pcm, opus or flac)", name))
+					gracefulExitWithCode(logger, utils.ExitCodeConfig)
+				}
+				config.AllowedCodecs = append(config.AllowedCodecs, parsedCodec)
+			}
+		}
+		if *fadeDuration < 0 {
+			logger.Error("Invalid fade duration: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.FadeDuration = *fadeDuration
+		if *drainTimeout < 0 {
+			logger.Error("Invalid drain timeout: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.DrainTimeout = *drainTimeout
+		if *maxLatencyMs < 0 {
+			logger.Error("Invalid max latency: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.MaxLatency = time.Duration(*maxLatencyMs) * time.Millisecond
+		if *prefillFrames < 0 {
+			logger.Error("Invalid prefill frames: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.PrefillFrames = *prefillFrames
+		if *serverSilenceSuppress < 0 {
+			logger.Error("Invalid server silence suppress duration: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.ServerSilenceSuppress = *serverSilenceSuppress
+		if *maxSession < 0 || *idleTimeout < 0 {
+			logger.Error("Invalid -max-session or -idle-timeout: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.MaxSession = *maxSession
+		config.IdleTimeout = *idleTimeout
+		config.PreopenOutput = *preopenOutput
+		parsedConceal, concealErr := utils.ParseConcealMode(*conceal)
+		if concealErr != nil {
+			logger.Error(concealErr.Error())
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		if *comfortNoise {
+			if *conceal != "" && parsedConceal != utils.ConcealComfort {
+				logger.Error(fmt.Sprintf("-comfort-noise cannot be combined with -conceal %s", parsedConceal))
+				gracefulExitWithCode(logger, utils.ExitCodeConfig)
 			}
-			config.AllowClients = ips
+			parsedConceal = utils.ConcealComfort
+		}
+		if *concealFrames < 1 {
+			logger.Error("Invalid conceal frames: must be at least 1")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.Conceal = parsedConceal
+		config.ConcealFrames = *concealFrames
+		config.ConnectSound = *connectSound
+		config.DisconnectSound = *disconnectSound
+		config.StartupSound = *startupSound
+		if *connectSoundDelay < 0 {
+			logger.Error("Invalid connect sound delay: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.ConnectSoundDelay = *connectSoundDelay
+		if *limiterRelease <= 0 {
+			logger.Error("Invalid limiter release: must be positive")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.Limiter = *limiter
+		config.LimiterRelease = *limiterRelease
+		if *volume < 0 || *volume > 100 {
+			logger.Error("Invalid volume: must be between 0 and 100")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.Volume = *volume
+		if *replayWindow < 1 || *replayWindow > 65536 {
+			logger.Error("Invalid replay window: must be between 1 and 65536 packets")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.ReplayWindow = *replayWindow
+		config.StatusAddr = *statusAddr
+		config.LogLevel = *logLevel
+		if *balance < -1 || *balance > 1 {
+			logger.Error("Invalid balance: must be between -1.0 and 1.0")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.Balance = *balance
+		if *stereoWidth < 0 || *stereoWidth > 4 {
+			logger.Error("Invalid stereo width: must be between 0 and 4")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.StereoWidth = *stereoWidth
+		if *deviceReopenAttempts < 0 {
+			logger.Error("Invalid device reopen attempts: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.DeviceReopenAttempts = *deviceReopenAttempts
+		if *duration < 0 {
+			logger.Error("Invalid duration: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.Duration = *duration
+		if *connectRetries < 0 {
+			logger.Error("Invalid connect retries: must not be negative")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.ConnectRetries = *connectRetries
+		config.ControlChannel = *controlChannel
+		config.SampleIndex = *sampleIndex
+		config.HeartbeatInterval = *heartbeatInterval
+		config.HeartbeatTimeout = *heartbeatTimeout
+		config.KeepaliveTimeout = *keepaliveTimeout
+		if err := config.ValidateKeepalive(); err != nil {
+			logger.Error(fmt.Sprintf("Invalid keepalive settings: %v", err))
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.InputPipe = *inputPipe
+		config.OutputPipe = *outputPipe
+		if *pcmBigEndian && *inputPipe == "" && *outputPipe == "" {
+			logger.Error("Invalid input: -pcm-bigendian only applies to -input-pipe or -output-pipe")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.PipeBigEndian = *pcmBigEndian
+		if *inputFile != "" && (*inputPipe != "" || *loopbackCapture) {
+			logger.Error("Invalid input: -input-file cannot be combined with -input-pipe or -loopback-capture")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.InputFile = *inputFile
+		config.LoopbackCapture = *loopbackCapture
+		if *sendMono && (*inputFile != "" || *inputPipe != "") {
+			logger.Error("Invalid input: -send-mono only applies to device capture, not -input-file or -input-pipe")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.SendMono = *sendMono
+		if *deviceBuffer < 0 || *deviceBuffer > 8192 {
+			logger.Error("Invalid device buffer: must be between 0 and 8192 sample frames")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		if *deviceBuffer > 0 && (*inputFile != "" || *inputPipe != "") {
+			logger.Error("Invalid input: -device-buffer only applies to device capture, not -input-file or -input-pipe")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
 		}
+		config.DeviceBuffer = *deviceBuffer
+		config.StartMuted = *startMuted
+		config.PTTKey = strings.ToLower(strings.TrimSpace(*pttKey))
+		if err := network.ValidatePTTKey(config.PTTKey); *ptt && err != nil {
+			logger.Error(fmt.Sprintf("Invalid -ptt-key: %v", err))
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		if *ptt && *inputPipe == audio.StdioPipe {
+			logger.Error("Invalid input: -ptt reads its key from stdin and cannot be combined with -input-pipe -")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.PTT = *ptt
+		if *clipFraction <= 0 || *clipFraction >= 1 {
+			logger.Error("Invalid clip fraction: must be between 0 and 1")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.ClipFraction = *clipFraction
+		if *highPass < 0 || *lowPass < 0 || (*highPass > 0 && *lowPass > 0 && *highPass >= *lowPass) {
+			logger.Error("Invalid filter cutoffs: must not be negative, and -highpass must be below -lowpass")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		if *inputGain < -40 || *inputGain > 40 {
+			logger.Error("Invalid input gain: must be between -40 and 40 dB")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.InputGainDB = *inputGain
+		config.HighPassHz = *highPass
+		config.LowPassHz = *lowPass
+		config.EnableAGC = *agc
+		if *agcTargetDB >= 0 || *agcTargetDB < -60 {
+			logger.Error("Invalid AGC target: must be between -60 and 0 dB")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		config.AGCTargetDB = *agcTargetDB
+		if *selectDevice && (*inputDevice != "" || *outputDevice != "") {
+			logger.Error("Invalid arguments: -select-device cannot be used together with -input-device or -output-device")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+		if *selectDevice && ((config.Mode == "server" && config.OutputPipe != "") ||
+			(config.Mode == "client" && (config.InputPipe != "" || config.InputFile != "" || config.LoopbackCapture))) {
+			logger.Error("Invalid arguments: -select-device needs an audio device, not a pipe, -input-file or -loopback-capture")
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+	} else if last, err := utils.LoadLastConfig(); err == nil && promptUseLastConfig(last) {
+		config = applyLastConfig(last, logger)
 	} else {
 		// Interactive mode - prompt for all settings
 		logger.Info("🔧 Interactive Setup Mode")
 		config = interactiveSetup(logger)
 	}
+	// 口令不保存到设置文件，任何启动方式都从 -psk 或环境变量读取（环境变量不会出现在进程列表中）
+	config.PSK = *psk
+	if config.PSK == "" {
+		config.PSK = os.Getenv("REMOTEAUDIO_PSK")
+	}
 
 	// Validate mode
 	if config.Mode != "server" && config.Mode != "client" {
 		logger.Error("Invalid mode. Must be 'server' or 'client'")
-		gracefulExitWithCode(logger, 1)
+		gracefulExitWithCode(logger, utils.ExitCodeConfig)
+	}
+	if config.Mode == "client" && (config.Port == 0 || config.Interface != "") {
+		logger.Error("Invalid arguments: -port 0 and -interface are only supported in server mode")
+		gracefulExitWithCode(logger, utils.ExitCodeConfig)
 	}
 
 	logger.Info(fmt.Sprintf("Operating in %s mode", strings.ToUpper(config.Mode)))
 
+	// -select-device：参数模式下也从列表中选择设备（交互式设置本来就会询问）；未选出时使用默认设备
+	if *selectDevice && hasArgs && !*resume {
+		if config.Mode == "server" {
+			if device := promptOutputDevice(logger); device != nil {
+				config.SelectedOutputDevice = device
+			}
+		} else if device := promptInputDevice(logger); device != nil {
+			config.SelectedInputDevice = device
+		}
+	}
+
+	// 只做检查，不推流；退出码供部署脚本判断
+	if *check {
+		exitCode := 0
+		if !runConfigCheck(config, logger) {
+			exitCode = 1
+		}
+		audio.Terminate()
+		os.Exit(exitCode)
+	}
+
 	// Setup signal handling for graceful shutdown
-	setupSignalHandling(logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	setupSignalHandling(logger, cancel)
 
 	// Start server or client based on mode
+	var err error
 	switch config.Mode {
 	case "server":
-		startServer(config, logger)
+		err = startServer(ctx, config, logger)
 	case "client":
-		startClient(config, logger)
+		err = startClient(ctx, config, logger)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		gracefulExitWithCode(logger, utils.ExitCodeForError(err))
 	}
 	
 	// 如果程序执行到这里，说明服务端或客户端已经正常退出
@@ -150,6 +627,9 @@ var soundFiles embed.FS
 // 全局变量用于管理退出状态
 var (
 	isShuttingDown int32 // atomic bool
+
+	// 预设文件中的用户质量预设（名称为小写），与内置预设同名时取代内置预设
+	userPresets map[string]utils.QualityPreset
 )
 
 
@@ -282,8 +762,13 @@ func interactiveSetup(logger *utils.Logger) *utils.Config {
 		}
 		applyQualityParams(config)
 		
-		// Step 6: Select compression mode
-		config.Compression = promptCompressionMode(logger)
+		// Step 6: Select compression mode（用户预设指定了编解码器与码率时直接使用）
+		if preset, ok := userPresets[config.StreamQuality]; ok && preset.Codec != "" {
+			config.Compression, _ = parseCodecArg(preset.Codec)
+			config.MaxBitrate = preset.Bitrate * 1000
+		} else {
+			config.Compression = promptCompressionMode(logger)
+		}
 		
 		// Step 7: Enable excitation streaming?
 		config.EnableExcitation = promptEnableExcitation(logger)
@@ -380,6 +865,7 @@ func promptOutputDevice(logger *utils.Logger) *audio.DeviceInfo {
 
 	if len(outputDevices) == 0 {
 		fmt.Println("  ❌ No output devices found")
+		fmt.Println("  💡 Use -output-pipe to run the server without an audio device")
 		return nil
 	}
 
@@ -454,6 +940,7 @@ func promptInputDevice(logger *utils.Logger) *audio.DeviceInfo {
 
 	if len(inputDevices) == 0 {
 		fmt.Println("  ❌ No input devices found")
+		fmt.Println("  💡 Use -input-pipe to run the client without an audio device")
 		return nil
 	}
 
@@ -549,8 +1036,8 @@ func promptServerPort(logger *utils.Logger, reader *bufio.Reader) int {
 	}
 }
 
-// setupSignalHandling 设置信号处理，用于优雅关闭
-func setupSignalHandling(logger *utils.Logger) {
+// setupSignalHandling 设置信号处理，收到信号时取消服务端/客户端的 context
+func setupSignalHandling(logger *utils.Logger, cancel context.CancelFunc) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
@@ -559,7 +1046,7 @@ func setupSignalHandling(logger *utils.Logger) {
 		logger.Info("\n🛑 Received shutdown signal, gracefully stopping...")
 		
 		// 立即触发网络模块关闭，执行程序终止操作
-		network.NotifyShutdown()
+		cancel()
 		
 		// 等待网络模块完全停止
 		logger.Info("⏳ Waiting for services to stop...")
@@ -570,6 +1057,109 @@ func setupSignalHandling(logger *utils.Logger) {
 	}()
 }
 
+// parseClientListFlag 拆分逗号分隔的 -allow-client / -deny-client 列表，条目无效时退出
+func parseClientListFlag(name, value string, logger *utils.Logger) []string {
+	if value == "" {
+		return nil
+	}
+	entries := strings.Split(value, ",")
+	for i := range entries {
+		entries[i] = strings.TrimSpace(entries[i])
+		if err := utils.ValidateClientEntry(entries[i]); err != nil {
+			logger.Error(fmt.Sprintf("Invalid -%s entry: %v", name, err))
+			gracefulExitWithCode(logger, utils.ExitCodeConfig)
+		}
+	}
+	return entries
+}
+
+// reloadOnSIGHUP 收到 SIGHUP 时重新读取 -resume 的配置文件，在不中断服务的情况下
+// 应用允许的客户端、音量与日志级别；其余改动只记录为需要重启
+func reloadOnSIGHUP(ctx context.Context, server *network.Server, config *utils.Config, logger *utils.Logger) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+		}
+		last, err := utils.LoadLastConfig()
+		if err != nil {
+			logger.Warn(fmt.Sprintf("SIGHUP: could not reload settings: %v", err))
+			continue
+		}
+		reloaded := last.Config
+		level, err := utils.ParseLogLevel(reloaded.LogLevel)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("SIGHUP: %v, keeping log level %s", err, config.LogLevel))
+			level, _ = utils.ParseLogLevel(config.LogLevel)
+			reloaded.LogLevel = config.LogLevel
+		}
+		allowClients, err := reloaded.AllowedClients()
+		if err != nil {
+			logger.Warn(fmt.Sprintf("SIGHUP: %v, keeping the current allowed client list", err))
+			allowClients = config.AllowClients
+		}
+		denyClients, err := reloaded.DeniedClients()
+		if err != nil {
+			logger.Warn(fmt.Sprintf("SIGHUP: %v, keeping the current denied client list", err))
+			denyClients = config.DenyClients
+		}
+		if reloaded.Volume < 0 || reloaded.Volume > 100 {
+			logger.Warn(fmt.Sprintf("SIGHUP: invalid volume %d, keeping %d%%", reloaded.Volume, config.Volume))
+			reloaded.Volume = config.Volume
+		}
+
+		// 监听地址与流格式在运行中无法更改
+		restart := []struct {
+			name    string
+			changed bool
+		}{
+			{"host", reloaded.Host != config.Host},
+			{"port", reloaded.Port != config.Port},
+			{"interface", reloaded.Interface != config.Interface},
+			{"sample rate", reloaded.SampleRate != config.SampleRate},
+			{"channels", reloaded.Channels != config.Channels},
+			{"bit depth", reloaded.BitDepth != config.BitDepth},
+			{"frames per buffer", reloaded.FramesPerBuffer != config.FramesPerBuffer},
+			{"buffer count", reloaded.BufferCount != config.BufferCount},
+			{"compression", reloaded.Compression != config.Compression},
+		}
+		for _, setting := range restart {
+			if setting.changed {
+				logger.Warn(fmt.Sprintf("SIGHUP: %s changed, requires restart", setting.name))
+			}
+		}
+
+		server.Reload(allowClients, denyClients, reloaded.Volume)
+		logger.SetLevel(level)
+		config.Volume = reloaded.Volume
+		config.LogLevel = reloaded.LogLevel
+		allowed := "all"
+		if len(allowClients) > 0 {
+			allowed = strings.Join(allowClients, ",")
+		}
+		denied := "none"
+		if len(denyClients) > 0 {
+			denied = strings.Join(denyClients, ",")
+		}
+		logger.Info(fmt.Sprintf("🔄 Settings reloaded: allowed clients %s, denied clients %s, volume %d%%, log level %s",
+			allowed, denied, reloaded.Volume, level))
+	}
+}
+
+// printVersion 打印版本与构建信息；协议版本不同的两端无法完成握手
+func printVersion() {
+	fmt.Printf("RemoteAudioCLI %s\n", version.Version)
+	fmt.Printf("  Git commit:       %s\n", version.GitCommit)
+	fmt.Printf("  Build date:       %s\n", version.BuildDate)
+	fmt.Printf("  Go version:       %s\n", version.GoVersion())
+	fmt.Printf("  Protocol version: %d\n", network.ProtocolVersion)
+}
+
 func showHelp() {
 	fmt.Println("🎵 Remote Audio CLI - Real-time Audio Streaming")
 	fmt.Println("")
@@ -582,19 +1172,91 @@ func showHelp() {
 	fmt.Println("  -host string")
 	fmt.Println("        Server host address (default: localhost)")
 	fmt.Println("  -port int")
-	fmt.Println("        Server port (default: 8080)")
+	fmt.Println("        Server port (default: 8080; 0 lets the server pick a free port and log it)")
+	fmt.Println("  -port-file string")
+	fmt.Println("        Write the port the server actually bound to this file, e.g. for -port 0 (server mode)")
+	fmt.Println("  -interface string")
+	fmt.Println("        Bind the server to the address of this network interface, e.g. eth0 (cannot be combined with -host)")
 	fmt.Println("  -input-device string")
 	fmt.Println("        Input audio device name or index (client mode)")
 	fmt.Println("  -output-device string")
 	fmt.Println("        Output audio device name or index (server mode)")
+	fmt.Println("        Comma-separated to play on several devices at once, e.g. \"Speakers,USB\"; each device is resampled")
+	fmt.Println("        to its default rate if needed, and a failing device does not stop the others")
+	fmt.Println("  -output-channel-map string")
+	fmt.Println("        Play the stream channels on these 1-based device channels, e.g. \"3,4\" for outputs 3/4 of an")
+	fmt.Println("        interface (server mode; other device channels get silence; applies to every -output-device, not to pipes)")
+	fmt.Println("  -input-channel-map string")
+	fmt.Println("        Capture the stream channels from these 1-based device channels, e.g. \"3,4\" (client mode; not for pipes)")
+	fmt.Println("  -select-device")
+	fmt.Println("        Choose the output (server) or input (client) device from the device list at startup, as in the")
+	fmt.Println("        interactive setup, while taking every other setting from the flags (cannot be combined with -output-device/-input-device)")
 	fmt.Println("  -list-devices")
 	fmt.Println("        List all available audio devices")
+	fmt.Println("  -host-api string")
+	fmt.Println("        Only list/use devices whose host API contains this name, e.g. WASAPI (lowest latency on Windows)")
+	fmt.Println("  -list-host-apis")
+	fmt.Println("        List all available audio host APIs with their device count, default input/output device index")
+	fmt.Println("        and which one is the system default")
+	fmt.Println("  -probe-device int")
+	fmt.Println("        Print which sample rates and channel counts (16-bit) the device with this index supports for input and output")
+	fmt.Println("  -json")
+	fmt.Println("        With -list-devices/-list-host-apis: print the list as JSON (logs go to stderr)")
+	fmt.Println("  -resume")
+	fmt.Println("        Start with the settings saved by the last successful start (~/.config/remoteaudio/last.json);")
+	fmt.Println("        devices are matched by host API and name, other settings flags are ignored")
+	fmt.Println("  -check")
+	fmt.Println("        Validate devices, sample-rate support, listen address (server) or server reachability (client)")
+	fmt.Println("        and the codec, print a report and exit with code 0 on success or 1 on failure")
+	fmt.Println("  -version")
+	fmt.Println("        Show app version, git commit, build date, Go version and protocol version")
 	fmt.Println("  -help")
 	fmt.Println("        Show this help information")
+	fmt.Println("  -log-format string")
+	fmt.Println("        Log output format: text (colored, live stats line) or json (one object per line with level, time, msg; stats as separate events)")
+	fmt.Println("  -log-level string")
+	fmt.Println("        Minimum log level: debug, info, warn or error (default: info)")
+	fmt.Println("  -psk string")
+	fmt.Println("        Pre-shared passphrase: audio payloads are encrypted and authenticated with AES-256-GCM using a")
+	fmt.Println("        per-session key derived from it; both ends must use the same passphrase, and a server with -psk")
+	fmt.Println("        rejects clients without it. Also read from the REMOTEAUDIO_PSK environment variable, never saved for -resume")
+	fmt.Println("  -replay-window int")
+	fmt.Println("        Server, with -psk: how many recent audio packet sequence numbers are remembered; an authentic packet")
+	fmt.Println("        that repeats one of them or is older than the window is dropped as a replay (1-65536, default: 64)")
+	fmt.Println("  -status-addr string")
+	fmt.Println("        Serve a small HTML status page (connection, format, live stats, connected client) that refreshes itself, e.g. :8081;")
+	fmt.Println("        /config on the same address returns the negotiated session config as JSON")
+	fmt.Println("  -stats-interval duration")
+	fmt.Println("        How often the live stats line is refreshed, e.g. 1s over slow SSH links (default: 500ms); with -log-format json, the interval between stats events (default: 10s)")
+	fmt.Println("  -no-color")
+	fmt.Println("        Disable colored log output and level meter colors")
+	fmt.Println("  -waveform")
+	fmt.Println("        Show a scrolling ASCII waveform of the peak level per stats refresh instead of the level meter")
+	fmt.Println("        (only when the output is a terminal)")
 	fmt.Println("  -quality string")
-	fmt.Println("        Stream quality: verylow, low, normal, high, lossless (default: normal)")
+	fmt.Println("        Stream quality: verylow, low, normal, high, lossless or the name of a user preset (default: normal)")
+	fmt.Println("        In server mode, an explicit -quality caps what clients may request")
+	fmt.Println("  -presets-file string")
+	fmt.Println("        JSON file of user quality presets: {\"podcast\": {\"sample_rate\": 48000, \"channels\": 1, \"bit_depth\": 16,")
+	fmt.Println("        \"frames_per_buffer\": 960, \"codec\": \"opus\", \"bitrate\": 64}}; codec and bitrate (kbit/s, like -max-bitrate)")
+	fmt.Println("        are optional, and a preset named like a built-in one replaces it (default: ~/.config/remoteaudio/presets.json)")
+	fmt.Println("  -channels int")
+	fmt.Println("        Override the preset channel count, 1-8 (e.g. 4, 6 or 8 for multichannel interfaces)")
+	fmt.Println("  -sample-format string")
+	fmt.Println("        Sample format: int (default) or float32; float32 opens the devices with 32-bit float buffers")
+	fmt.Println("        and sends float PCM, avoiding integer conversion (client mode, requires -codec pcm)")
+	fmt.Println("  -max-bitrate int")
+	fmt.Println("        Cap the bandwidth used by audio packets in kbit/s (client mode, default: unlimited)")
+	fmt.Println("        Opus is encoded at the cap, FLAC bursts are smoothed; PCM above the cap steps down the")
+	fmt.Println("        quality preset unless -quality was given explicitly, in which case the client refuses to start")
+	fmt.Println("  -simulate-loss float, -simulate-jitter duration, -simulate-reorder float")
+	fmt.Println("        For testing: drop this fraction of outgoing audio packets, delay each by a random time up to")
+	fmt.Println("        the jitter, and send this fraction one packet late, e.g. -simulate-loss 0.05 -simulate-jitter 30ms")
+	fmt.Println("        -simulate-reorder 0.02 (client mode; heartbeats are not affected; default: off)")
 	fmt.Println("  -compress string")
 	fmt.Println("        Compression mode: 'yes' (Opus) or 'no' (PCM) (default: yes)")
+	fmt.Println("  -codec string")
+	fmt.Println("        Audio codec: 'pcm', 'opus' or 'flac' (lossless compressed); overrides -compress")
 	fmt.Println("  -excitation")
 	fmt.Println("        Enable excitation mode (pause streaming when silent)")
 	fmt.Println("  -excitation-threshold float")
@@ -602,7 +1264,127 @@ func showHelp() {
 	fmt.Println("  -excitation-timeout int")
 	fmt.Println("        Excitation timeout in seconds (default: 10)")
 	fmt.Println("  -allow-client string")
-	fmt.Println("        Comma-separated list of allowed client IPs (whitelist, default: allow all)")
+	fmt.Println("        Comma-separated list of allowed client IPs, CIDR ranges (192.168.1.0/24) or host names (whitelist, default: allow all)")
+	fmt.Println("  -allow-client-file string")
+	fmt.Println("        File with one allowed client IP, CIDR range or host name per line, # starts a comment;")
+	fmt.Println("        combined with -allow-client and read again on SIGHUP (server mode)")
+	fmt.Println("  -deny-client string")
+	fmt.Println("        Comma-separated list of client IPs, CIDR ranges or host names to reject; deny rules win over allow rules")
+	fmt.Println("        and also apply without an allow list (server mode)")
+	fmt.Println("  -deny-client-file string")
+	fmt.Println("        File of deny entries in the -allow-client-file format, combined with -deny-client and read again on SIGHUP (server mode)")
+	fmt.Println("        On SIGHUP the server re-reads the -resume settings file and applies the allowed clients,")
+	fmt.Println("        -volume and -log-level without restarting; other changes are logged as requiring a restart")
+	fmt.Println("  -volume int")
+	fmt.Println("        Playback volume in percent, 0-100; the v <0-100> runtime command changes it (server mode, default: 100)")
+	fmt.Println("  -device-reopen-attempts int")
+	fmt.Println("        When the output device disappears mid-playback, try this many times (with backoff) to continue on the default output device, 0 disables (server mode, default: 5)")
+	fmt.Println("  -balance float")
+	fmt.Println("        Stereo balance from -1.0 (full left) to +1.0 (full right); the opposite channel is attenuated (server mode, stereo streams only, default: 0)")
+	fmt.Println("  -stereo-width float")
+	fmt.Println("        Scale the side (L-R) signal: 0 collapses to mono, 1 is unchanged, up to 4 widens the image; samples")
+	fmt.Println("        pushed past full scale are clipped to it (server mode, stereo streams only, not applied to -output-pipe, default: 1)")
+	fmt.Println("  -connect-sound, -disconnect-sound, -startup-sound")
+	fmt.Println("        Play the connection sound, the disconnection sound or the startup beep; use e.g. -connect-sound=false")
+	fmt.Println("        to silence one and keep the others (server mode, default: true)")
+	fmt.Println("  -connect-sound-delay duration")
+	fmt.Println("        Wait this long after a client connects before playing the connection sound; playback fades in")
+	fmt.Println("        once the delay and the sound are over, even with -connect-sound=false (server mode, default: 500ms)")
+	fmt.Println("  -conceal string")
+	fmt.Println("        How playback gaps are filled (server mode, not applied to -output-pipe, default: silence):")
+	fmt.Println("        silence, repeat (repeat the last good frame while fading it out, then silence) or comfort")
+	fmt.Println("        (low-level noise matched to the stream's noise floor, max -50dB)")
+	fmt.Println("  -conceal-frames int")
+	fmt.Println("        With -conceal repeat, fade the repeated frame out over this many frames (default: 3)")
+	fmt.Println("  -comfort-noise")
+	fmt.Println("        Same as -conceal comfort (server mode)")
+	fmt.Println("  -limiter")
+	fmt.Println("        Softly compress output peaks above -3dBFS per channel instead of letting them clip; the stats line shows LIMIT while active (server mode, not applied to -output-pipe)")
+	fmt.Println("  -limiter-release duration")
+	fmt.Println("        How fast the -limiter gain recovers after a peak; attack is instant (server mode, default: 100ms)")
+	fmt.Println("  -fade duration")
+	fmt.Println("        Fade playback in/out over this duration when a client connects or disconnects, 0 disables (server mode, default: 500ms)")
+	fmt.Println("  -drain-timeout duration")
+	fmt.Println("        When the server shuts down, keep playing the audio still buffered for up to this long, fading out")
+	fmt.Println("        over its last -fade, instead of cutting it off (server mode, default: 0 = fade out immediately)")
+	fmt.Println("  -max-latency-ms int")
+	fmt.Println("        Drop the oldest buffered audio to catch up when playback lags by more than this many ms (server mode, default: 0 = unlimited)")
+	fmt.Println("  -prefill-frames int")
+	fmt.Println("        Play silence until this many frames are buffered, at the start and again after an underrun, so playback")
+	fmt.Println("        does not resume from a near-empty buffer (server mode, at most 2 × buffer count, default: 0 = start at once)")
+	fmt.Println("  -server-silence-suppress duration")
+	fmt.Println("        Stop writing to the output device after this much continuous silence (below -50dB) so it can idle, resuming with a short fade-in on the next non-silent frame (server mode, default: 0 = disabled)")
+	fmt.Println("  -max-session duration")
+	fmt.Println("        Disconnect a client after its session has lasted this long, e.g. 1h on a shared machine (server mode, default: 0 = disabled)")
+	fmt.Println("  -idle-timeout duration")
+	fmt.Println("        Disconnect a client that sends no audio, only heartbeats (e.g. while muted), for this long (server mode, default: 0 = disabled)")
+	fmt.Println("  -preopen-output")
+	fmt.Println("        Open the output stream at startup with the -quality format and keep one open between sessions; it is reopened only if a client negotiates a different format (server mode)")
+	fmt.Println("  -min-sample-rate int")
+	fmt.Println("        Reject clients that cannot stream at or above this sample rate (server mode, default: no limit)")
+	fmt.Println("  -max-sample-rate int")
+	fmt.Println("        Coerce clients asking for a higher sample rate down to this rate (server mode, default: no limit)")
+	fmt.Println("  -allow-codecs string")
+	fmt.Println("        Comma-separated codecs the server accepts, e.g. pcm,opus; other codecs fall back to the first one (server mode, default: all)")
+	fmt.Println("  -max-audio-payload int")
+	fmt.Println("        Maximum accepted audio packet payload in bytes; larger packets close the connection (server mode, default: 32768)")
+	fmt.Println("  -drift-correction duration")
+	fmt.Println("        Minimum interval between clock drift corrections on the server, 0 disables (default: 10s)")
+	fmt.Println("  -connect-retries int")
+	fmt.Println("        Extra connection attempts, 1s apart, while the server refuses the connection or does not answer the handshake; protocol mismatches fail at once (client mode, default: 3)")
+	fmt.Println("  -sample-index")
+	fmt.Println("        Tag each audio packet with the cumulative index of its first sample (8 extra bytes); the server counts gaps and reports the playout sample position in its stats (client mode)")
+	fmt.Println("  -control-channel")
+	fmt.Println("        Open a second connection for heartbeats so they are not queued behind audio; falls back to one connection if the server does not support it (client mode)")
+	fmt.Println("  -heartbeat-interval duration")
+	fmt.Println("        Interval between heartbeat packets (default: 5s)")
+	fmt.Println("  -heartbeat-timeout duration")
+	fmt.Println("        Warn about an unstable connection after this long without packets, must exceed -heartbeat-interval (default: 10s)")
+	fmt.Println("  -keepalive-timeout duration")
+	fmt.Println("        Close the connection after this long without packets, must exceed -heartbeat-timeout (default: 30s)")
+	fmt.Println("  -duration duration")
+	fmt.Println("        Stop automatically after the given duration, e.g. 30s or 5m (default: run until interrupted)")
+	fmt.Println("  -input-gain float")
+	fmt.Println("        Fixed input trim in dB (-40 to 40) applied before metering, AGC and encoding; samples saturate instead of wrapping (client mode, default: 0)")
+	fmt.Println("  -highpass float")
+	fmt.Println("        High-pass filter cutoff in Hz applied before encoding, e.g. 80 to remove rumble (client mode, default: off)")
+	fmt.Println("  -lowpass float")
+	fmt.Println("        Low-pass filter cutoff in Hz applied before encoding, e.g. 15000 to remove hiss (client mode, default: off)")
+	fmt.Println("  -agc")
+	fmt.Println("        Enable automatic gain control on captured audio (client mode)")
+	fmt.Println("  -agc-target-db float")
+	fmt.Println("        AGC target RMS level in dB (default: -20.0)")
+	fmt.Println("  -clip-fraction float")
+	fmt.Println("        Fraction of full-scale samples per second that counts as input clipping (client mode, default: 0.001)")
+	fmt.Println("  -start-muted")
+	fmt.Println("        Start the client muted; type 'm' and Enter while streaming to toggle mute (client mode)")
+	fmt.Println("  -ptt")
+	fmt.Println("        Push-to-talk: start without transmitting; type the -ptt-key and Enter to start sending audio, and again to stop (client mode, needs a terminal)")
+	fmt.Println("  -ptt-key string")
+	fmt.Println("        Runtime command that toggles transmitting with -ptt; must not be q, s, m, v or h (client mode, default: t)")
+	fmt.Println("  -loopback-capture")
+	fmt.Println("        Capture what the -output-device (or default WASAPI output) plays instead of a microphone (client mode, Windows WASAPI only)")
+	fmt.Println("  -send-mono")
+	fmt.Println("        Downmix the captured channels to mono before encoding, halving the bandwidth of a stereo stream; the device still captures in -channels and the handshake requests 1 channel (client mode)")
+	fmt.Println("  -device-buffer int")
+	fmt.Println("        Capture device buffer size in sample frames, e.g. 1024; captured audio is cut into packets of the stream's")
+	fmt.Println("        frames per buffer (e.g. 960-frame Opus packets) before sending (client mode, default: 0 = same as the packet size)")
+	fmt.Println("  -input-pipe string")
+	fmt.Println("        Read raw little-endian PCM from a named pipe instead of an input device, '-' for stdin; a regular .wav file is parsed and converted to the stream format (client mode)")
+	fmt.Println("  -input-file string")
+	fmt.Println("        Send the packets of a mono/stereo Ogg Opus file (.opus) as they are, without capture or re-encoding; the handshake requests Opus at 48kHz with the file's channels and packet duration, and the client stops at the end of the file (client mode)")
+	fmt.Println("  -output-pipe string")
+	fmt.Println("        Write raw little-endian PCM to a named pipe instead of an output device, '-' for stdout (server mode)")
+	fmt.Println("  -pcm-bigendian")
+	fmt.Println("        Raw PCM on -input-pipe/-output-pipe is big-endian (e.g. s16be); samples are converted at the pipe, the network stream stays little-endian. WAV files ignore it")
+	fmt.Println("")
+	fmt.Println("EXIT CODES:")
+	fmt.Println("  0  Normal exit")
+	fmt.Println("  1  Other errors; -check exits with 1 when a check fails")
+	fmt.Println("  2  Audio device, capture or playback error")
+	fmt.Println("  3  Network, connection or timeout error")
+	fmt.Println("  4  Invalid configuration or flags")
+	fmt.Println("  5  Protocol or handshake error")
 	fmt.Println("")
 	fmt.Println("INTERACTIVE MODE:")
 	fmt.Println("  Run without arguments for interactive setup:")
@@ -624,11 +1406,32 @@ func showHelp() {
 	fmt.Println("  # Connect with PCM uncompressed audio")
 	fmt.Println("  RemoteAudioCLI -mode=client -host=\"192.168.1.100\" -port=8080 -quality=lossless -compress=no")
 	fmt.Println("")
+	fmt.Println("  # Lossless compressed audio with FLAC")
+	fmt.Println("  RemoteAudioCLI -mode=client -host=\"192.168.1.100\" -port=8080 -quality=lossless -codec=flac")
+	fmt.Println("")
+	fmt.Println("  # Stream for 30 seconds, then shut down")
+	fmt.Println("  RemoteAudioCLI -mode=client -host=\"192.168.1.100\" -port=8080 -duration=30s")
+	fmt.Println("")
+	fmt.Println("  # Stream system audio (what you hear) on Windows")
+	fmt.Println("  RemoteAudioCLI -mode=client -host=\"192.168.1.100\" -loopback-capture")
+	fmt.Println("")
+	fmt.Println("  # Stream a file decoded by ffmpeg through stdin (format must match the quality preset)")
+	fmt.Println("  ffmpeg -i music.flac -f s16le -ar 48000 -ac 2 - | RemoteAudioCLI -mode=client -host=\"192.168.1.100\" -quality=high -input-pipe=-")
+	fmt.Println("")
+	fmt.Println("  # Write received audio to stdout")
+	fmt.Println("  RemoteAudioCLI -mode=server -port=8080 -output-pipe=- > received.pcm")
+	fmt.Println("")
 	fmt.Println("  # List available audio devices")
 	fmt.Println("  RemoteAudioCLI -list-devices")
+	fmt.Println("")
+	fmt.Println("  # Only show WASAPI devices")
+	fmt.Println("  RemoteAudioCLI -list-devices -host-api=WASAPI")
+	fmt.Println("")
+	fmt.Println("  # List devices as JSON for scripts")
+	fmt.Println("  RemoteAudioCLI -list-devices -json")
 }
 
-func listAudioDevices(logger *utils.Logger) {
+func listAudioDevices(logger *utils.Logger, asJSON bool) {
 	logger.Info("📋 Listing Available Audio Devices")
 
 	devices, err := audio.ListDevices()
@@ -637,18 +1440,32 @@ func listAudioDevices(logger *utils.Logger) {
 		return
 	}
 
+	// 机器可读输出，供脚本选择设备
+	if asJSON {
+		if devices == nil {
+			devices = []audio.DeviceInfo{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(devices); err != nil {
+			logger.Error(fmt.Sprintf("Failed to encode device list: %v", err))
+		}
+		return
+	}
+
 	fmt.Println("")
 	fmt.Println("🎤 INPUT DEVICES:")
 	inputCount := 0
-	for i, device := range devices {
+	for _, device := range devices {
 		if device.MaxInputChannels > 0 {
 			defaultMark := ""
 			if device.IsDefaultInput {
 				defaultMark = " (DEFAULT)"
 			}
-			fmt.Printf("  [%d] %s%s\n", i, device.Name, defaultMark)
+			fmt.Printf("  [%d] %s%s\n", device.Index, device.Name, defaultMark)
 			fmt.Printf("      Channels: %d, Sample Rate: %.0f Hz, Host API: %s\n",
 				device.MaxInputChannels, device.DefaultSampleRate, device.HostAPI)
+			fmt.Printf("      Latency: %s\n", audio.FormatLatencyRange(device.DefaultLowInputLatency, device.DefaultHighInputLatency))
 			inputCount++
 		}
 	}
@@ -659,15 +1476,16 @@ func listAudioDevices(logger *utils.Logger) {
 	fmt.Println("")
 	fmt.Println("🔊 OUTPUT DEVICES:")
 	outputCount := 0
-	for i, device := range devices {
+	for _, device := range devices {
 		if device.MaxOutputChannels > 0 {
 			defaultMark := ""
 			if device.IsDefaultOutput {
 				defaultMark = " (DEFAULT)"
 			}
-			fmt.Printf("  [%d] %s%s\n", i, device.Name, defaultMark)
+			fmt.Printf("  [%d] %s%s\n", device.Index, device.Name, defaultMark)
 			fmt.Printf("      Channels: %d, Sample Rate: %.0f Hz, Host API: %s\n",
 				device.MaxOutputChannels, device.DefaultSampleRate, device.HostAPI)
+			fmt.Printf("      Latency: %s\n", audio.FormatLatencyRange(device.DefaultLowOutputLatency, device.DefaultHighOutputLatency))
 			outputCount++
 		}
 	}
@@ -677,70 +1495,359 @@ func listAudioDevices(logger *utils.Logger) {
 	fmt.Println("")
 }
 
-func startServer(config *utils.Config, logger *utils.Logger) {
-	logger.Info(fmt.Sprintf("🖧 Starting server on %s:%d", config.Host, config.Port))
+// probeAudioDevice 打印设备在输入/输出方向上支持的采样率与声道数矩阵（16 位）
+func probeAudioDevice(index int, logger *utils.Logger) {
+	logger.Info(fmt.Sprintf("🔍 Probing audio device %d", index))
+
+	device, err := audio.GetDeviceByIndex(index)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to probe audio device: %v", err))
+		return
+	}
+
+	fmt.Println("")
+	fmt.Printf("  [%d] %s\n", device.Index, device.Name)
+	fmt.Printf("      Host API: %s, Default Sample Rate: %.0f Hz\n", device.HostAPI, device.DefaultSampleRate)
+
+	roles := []struct {
+		title       string
+		input       bool
+		maxChannels int
+	}{
+		{"🎤 INPUT:", true, device.MaxInputChannels},
+		{"🔊 OUTPUT:", false, device.MaxOutputChannels},
+	}
+	for _, role := range roles {
+		fmt.Println("")
+		fmt.Println(role.title)
+		if role.maxChannels == 0 {
+			fmt.Println("  No channels in this direction")
+			continue
+		}
+
+		channelCounts := audio.ProbeChannelCounts(role.maxChannels)
+		supported, err := audio.ProbeFormats(device, role.input, audio.ProbeSampleRates, channelCounts)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to probe audio device: %v", err))
+			return
+		}
+
+		fmt.Printf("  %10s", "")
+		for _, channels := range channelCounts {
+			fmt.Printf(" %5s", fmt.Sprintf("%dch", channels))
+		}
+		fmt.Println("")
+		for i, sampleRate := range audio.ProbeSampleRates {
+			fmt.Printf("  %7d Hz", sampleRate)
+			for j := range channelCounts {
+				mark := "-"
+				if supported[i][j] {
+					mark = "✓"
+				}
+				fmt.Printf(" %5s", mark)
+			}
+			fmt.Println("")
+		}
+	}
+	fmt.Println("")
+}
+
+// hostAPIDeviceIndex 格式化 Host API 默认设备的序号（-1 表示没有）
+func hostAPIDeviceIndex(index int) string {
+	if index < 0 {
+		return "none"
+	}
+	return fmt.Sprintf("[%d]", index)
+}
+
+// listAudioHostAPIs 列出可用的音频 Host API
+func listAudioHostAPIs(logger *utils.Logger, asJSON bool) {
+	logger.Info("📋 Listing Available Audio Host APIs")
+
+	hostAPIs, err := audio.ListHostAPIs()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to list host APIs: %v", err))
+		return
+	}
+
+	if asJSON {
+		if hostAPIs == nil {
+			hostAPIs = []audio.HostAPIInfo{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(hostAPIs); err != nil {
+			logger.Error(fmt.Sprintf("Failed to encode host API list: %v", err))
+		}
+		return
+	}
+
+	fmt.Println("")
+	fmt.Println("🎚️ HOST APIS:")
+	for _, api := range hostAPIs {
+		defaultMark := ""
+		if api.IsDefault {
+			defaultMark = " (DEFAULT)"
+		}
+		fmt.Printf("  %s%s - %d devices\n", api.Name, defaultMark, api.DeviceCount)
+		fmt.Printf("      Default input: %s, default output: %s\n",
+			hostAPIDeviceIndex(api.DefaultInputDevice), hostAPIDeviceIndex(api.DefaultOutputDevice))
+	}
+	if len(hostAPIs) == 0 {
+		fmt.Println("  No host APIs found")
+	}
+	fmt.Println("")
+	fmt.Println("💡 Use -host-api <name> to only show/use devices of one host API (WASAPI usually has the lowest latency on Windows)")
+	fmt.Println("")
+}
+
+func startServer(ctx context.Context, config *utils.Config, logger *utils.Logger) error {
+	if config.Interface != "" {
+		logger.Info(fmt.Sprintf("🖧 Starting server on interface %s, port %d", config.Interface, config.Port))
+	} else {
+		logger.Info(fmt.Sprintf("🖧 Starting server on %s:%d", config.Host, config.Port))
+	}
+
+	// 白名单/黑名单文件的条目只合并到运行中的配置，保存的设置仍引用文件，SIGHUP 时重新读取
+	allowClients, err := config.AllowedClients()
+	if err != nil {
+		return err
+	}
+	denyClients, err := config.DeniedClients()
+	if err != nil {
+		return err
+	}
 
 	var outputDevice *audio.DeviceInfo
-	var err error
+	var extraOutputDevices []*audio.DeviceInfo
 
 	// 检查是否有交互式选择的设备
-	if config.SelectedOutputDevice != nil {
+	if config.OutputPipe != "" {
+		logger.Info(fmt.Sprintf("Writing raw PCM to output pipe: %s", config.OutputPipe))
+	} else if config.SelectedOutputDevice != nil {
 		if device, ok := config.SelectedOutputDevice.(*audio.DeviceInfo); ok {
 			outputDevice = device
 			logger.Info(fmt.Sprintf("Using selected output device: %s", outputDevice.Name))
 		} else {
-			logger.Error("Invalid selected output device type")
-			gracefulExitWithCode(logger, 1)
+			return fmt.Errorf("invalid selected output device type")
 		}
 	} else {
-		outputDevice, err = getOutputDevice(config.OutputDevice, logger)
+		specs := splitDeviceSpecs(config.OutputDevice)
+		outputDevice, err = getOutputDevice(specs[0], logger)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to get output device: %v", err))
-			gracefulExitWithCode(logger, 1)
+			return fmt.Errorf("failed to get output device: %w", err)
+		}
+		for _, spec := range specs[1:] {
+			device, err := getOutputDevice(spec, logger)
+			if err != nil {
+				return fmt.Errorf("failed to get output device %q: %w", spec, err)
+			}
+			extraOutputDevices = append(extraOutputDevices, device)
 		}
 	}
 
+	outputDevices := extraOutputDevices
+	if outputDevice != nil {
+		outputDevices = append([]*audio.DeviceInfo{outputDevice}, extraOutputDevices...)
+	}
+	applyDevicePreferences(config, outputDevice, logger)
+	saveLastConfig(config, nil, outputDevices, logger)
+	config.AllowClients = allowClients
+	config.DenyClients = denyClients
+
 	// Create and start server
 	server := network.NewServer(config, logger)
-	if err := server.Start(outputDevice); err != nil {
-		logger.Error(fmt.Sprintf("Server failed: %v", err))
-		gracefulExitWithCode(logger, 1)
+	go reloadOnSIGHUP(ctx, server, config, logger)
+	if config.StatusAddr != "" {
+		if err := network.ServeStatusPage(ctx, config.StatusAddr, server, logger); err != nil {
+			return err
+		}
+	}
+	for _, device := range extraOutputDevices {
+		server.AddOutputDevice(device)
 	}
+	if err := server.Serve(ctx, outputDevice); err != nil {
+		return fmt.Errorf("server failed: %w", err)
+	}
+	return nil
+}
+
+// runConfigCheck 校验配置（设备、格式、网络、编码器）并打印报告，全部通过时返回 true
+func runConfigCheck(config *utils.Config, logger *utils.Logger) bool {
+	logger.Info("🩺 Checking configuration (no audio will be streamed)")
+
+	passed := true
+	report := func(name string, err error, detail string) {
+		if err != nil {
+			passed = false
+			fmt.Printf("  ❌ %-10s %v\n", name, err)
+		} else {
+			fmt.Printf("  ✅ %-10s %s\n", name, detail)
+		}
+	}
+
+	fmt.Println("")
+	fmt.Printf("🩺 CONFIGURATION CHECK (%s mode)\n", config.Mode)
+	// 服务端的编解码器由客户端在握手时决定
+	fmt.Printf("  Format: %dHz, %d channels, %d-bit, %d frames/buffer\n",
+		config.SampleRate, config.Channels, config.BitDepth, config.FramesPerBuffer)
+
+	address := config.GetNetworkAddress()
+	// 设备的默认低延迟，用于估算理论单向延迟（管道为 0）
+	var deviceLatency time.Duration
+	if config.Mode == "server" {
+		if config.OutputPipe != "" {
+			report("Output", nil, fmt.Sprintf("raw PCM to pipe %s (not opened)", config.OutputPipe))
+		} else {
+			for _, spec := range splitDeviceSpecs(config.OutputDevice) {
+				device, err := getOutputDevice(spec, logger)
+				if err == nil {
+					err = audio.ValidateDeviceForOutput(device, config.SampleRate, audio.OutputDeviceChannels(config))
+				}
+				if err == nil {
+					err = audio.CheckFormatSupported(device, false, config.SampleRate, audio.OutputDeviceChannels(config), audio.SampleDepth(config))
+				}
+				detail := ""
+				if device != nil {
+					detail = fmt.Sprintf("[%d] %s", device.Index, device.Name)
+				}
+				report("Output", err, detail)
+				if err == nil {
+					if latency, latencyErr := audio.DefaultLatency(device, false); latencyErr == nil && latency > deviceLatency {
+						deviceLatency = latency
+					}
+				}
+			}
+		}
+
+		// 尝试绑定后立即关闭
+		listenAddress, err := network.ListenAddress(config)
+		if err == nil {
+			var listener net.Listener
+			listener, err = net.Listen("tcp", listenAddress)
+			if err == nil {
+				listener.Close()
+			}
+		}
+		report("Listen", err, fmt.Sprintf("%s is bindable", listenAddress))
+		if config.AllowClientsFile != "" || config.DenyClientsFile != "" {
+			allowClients, err := config.AllowedClients()
+			var denyClients []string
+			if err == nil {
+				denyClients, err = config.DeniedClients()
+			}
+			report("Clients", err, fmt.Sprintf("%d allowed, %d denied entries", len(allowClients), len(denyClients)))
+		}
+	} else {
+		if config.InputFile != "" {
+			reader, err := audio.OpenOggOpusFile(config.InputFile)
+			detail := ""
+			if err == nil {
+				detail = fmt.Sprintf("Ogg Opus file %s (%d ch)", config.InputFile, reader.Head.Channels)
+				reader.Close()
+			}
+			report("Input", err, detail)
+		} else if config.InputPipe != "" {
+			report("Input", nil, fmt.Sprintf("raw PCM from pipe %s (not opened)", config.InputPipe))
+		} else {
+			var device *audio.DeviceInfo
+			var err error
+			if config.LoopbackCapture {
+				device, err = getLoopbackDevice(config.OutputDevice, logger)
+			} else {
+				device, err = getInputDevice(config.InputDevice, logger)
+			}
+			if err == nil {
+				err = audio.ValidateDeviceForInput(device, config.SampleRate, audio.InputDeviceChannels(config))
+			}
+			if err == nil {
+				err = audio.CheckFormatSupported(device, true, config.SampleRate, audio.InputDeviceChannels(config), audio.SampleDepth(config))
+			}
+			detail := ""
+			if device != nil {
+				detail = fmt.Sprintf("[%d] %s", device.Index, device.Name)
+			}
+			report("Input", err, detail)
+			if err == nil {
+				deviceLatency, _ = audio.DefaultLatency(device, true)
+			}
+		}
+
+		report("Encoder", network.CheckEncoder(config), fmt.Sprintf("%s encoder created", config.Compression))
+
+		// 尝试连接后立即关闭（服务端会记录一次未完成握手的连接）
+		conn, err := net.DialTimeout("tcp", address, config.ConnTimeout)
+		if err == nil {
+			conn.Close()
+		}
+		report("Connect", err, fmt.Sprintf("%s is reachable", address))
+	}
+	report("Latency", nil, config.LatencySummary(deviceLatency))
+
+	fmt.Println("")
+	if passed {
+		logger.Info("✅ Configuration check passed")
+	} else {
+		logger.Error("❌ Configuration check failed")
+	}
+	return passed
 }
 
 // 在 startClient 里捕获 capturer 初始化失败时自动回退 bit depth
-func startClient(config *utils.Config, logger *utils.Logger) {
+func startClient(ctx context.Context, config *utils.Config, logger *utils.Logger) error {
 	logger.Info(fmt.Sprintf("🖥️ Starting client, connecting to %s:%d", config.Host, config.Port))
 
 	var inputDevice *audio.DeviceInfo
 	var err error
 
 	// 检查是否有交互式选择的设备
-	if config.SelectedInputDevice != nil {
+	if config.InputFile != "" {
+		logger.Info(fmt.Sprintf("Sending pre-encoded audio from input file: %s", config.InputFile))
+	} else if config.InputPipe != "" {
+		logger.Info(fmt.Sprintf("Reading audio from input pipe: %s", config.InputPipe))
+	} else if config.LoopbackCapture {
+		inputDevice, err = getLoopbackDevice(config.OutputDevice, logger)
+		if err != nil {
+			return fmt.Errorf("failed to set up loopback capture: %w", err)
+		}
+	} else if config.SelectedInputDevice != nil {
 		// 类型断言，将 interface{} 转换为 *audio.DeviceInfo
 		if device, ok := config.SelectedInputDevice.(*audio.DeviceInfo); ok {
 			inputDevice = device
 			logger.Info(fmt.Sprintf("Using selected input device: %s", inputDevice.Name))
 		} else {
-			logger.Error("Invalid selected input device type")
-			gracefulExitWithCode(logger, 1)
+			return fmt.Errorf("invalid selected input device type")
 		}
 	} else {
 		// 使用命令行指定的设备或默认设备
 		inputDevice, err = getInputDevice(config.InputDevice, logger)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to get input device: %v", err))
-			gracefulExitWithCode(logger, 1)
+			return fmt.Errorf("failed to get input device: %w", err)
 		}
 	}
 
+	if config.LoopbackCapture {
+		// 环回采集的设备来自 -output-device
+		saveLastConfig(config, nil, []*audio.DeviceInfo{inputDevice}, logger)
+	} else {
+		applyDevicePreferences(config, inputDevice, logger)
+		saveLastConfig(config, inputDevice, nil, logger)
+	}
+
 	client := network.NewClient(config, logger)
+	if config.StatusAddr != "" {
+		if err := network.ServeStatusPage(ctx, config.StatusAddr, client, logger); err != nil {
+			return err
+		}
+	}
 	// 捕获 bit depth 24 不支持时自动回退
 	retry := false
+	// Opus 编码器无法创建或持续失败时改用 PCM 重新握手
+	codecRetry := false
 	for {
-		err = client.Start(inputDevice)
+		err = client.Run(ctx, inputDevice)
 		if err == nil {
-			break
+			return nil
 		}
 		if strings.Contains(err.Error(), "unsupported bit depth: 24") && config.BitDepth == 24 && !retry {
 			logger.Warn("24-bit audio not supported by device, falling back to 16-bit.")
@@ -748,11 +1855,47 @@ func startClient(config *utils.Config, logger *utils.Logger) {
 			retry = true
 			continue
 		}
-		logger.Error(fmt.Sprintf("Client failed: %v", err))
-		gracefulExitWithCode(logger, 1)
+		if errors.Is(err, network.ErrOpusEncoder) && config.Compression == utils.CodecOpus && !codecRetry {
+			logger.Warn(fmt.Sprintf("⚠️ Opus encoder unavailable (%v), falling back to uncompressed PCM.", err))
+			config.Compression = utils.CodecPCM
+			codecRetry = true
+			continue
+		}
+		return fmt.Errorf("client failed: %w", err)
 	}
 }
 
+// getLoopbackDevice 获取输出设备对应的 WASAPI 环回采集设备
+func getLoopbackDevice(outputSpec string, logger *utils.Logger) (*audio.DeviceInfo, error) {
+	// 环回只支持 WASAPI，未指定 Host API 时默认只在 WASAPI 设备中选择
+	if audio.GetHostAPIFilter() == "" {
+		audio.SetHostAPIFilter("WASAPI")
+	}
+
+	outputDevice, err := getOutputDevice(outputSpec, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	loopbackDevice, err := audio.GetLoopbackDevice(outputDevice)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info(fmt.Sprintf("🔁 Loopback capture of [%d] %s via [%d] %s", outputDevice.Index, outputDevice.Name, loopbackDevice.Index, loopbackDevice.Name))
+	return loopbackDevice, nil
+}
+
+// findDeviceByIndex 按 PortAudio 原始索引查找设备
+func findDeviceByIndex(devices []audio.DeviceInfo, index int) *audio.DeviceInfo {
+	for i := range devices {
+		if devices[i].Index == index {
+			return &devices[i]
+		}
+	}
+	return nil
+}
+
 // getInputDevice 获取输入设备 - 改进错误处理和设备索引验证
 func getInputDevice(deviceSpec string, logger *utils.Logger) (*audio.DeviceInfo, error) {
 	devices, err := audio.ListDevices()
@@ -760,11 +1903,19 @@ func getInputDevice(deviceSpec string, logger *utils.Logger) (*audio.DeviceInfo,
 		return nil, err
 	}
 
+	// 没有任何输入设备（如无声卡的服务器）时给出可操作的提示
+	firstDevice := firstDeviceWithChannels(devices, true)
+	if firstDevice == nil {
+		return nil, noDevicesError(true)
+	}
+
 	// If no device specified, use default input device
 	if deviceSpec == "" {
 		defaultDevice, err := audio.GetDefaultInputDevice()
 		if err != nil {
-			return nil, err
+			// 默认设备不可用或没有输入声道时回退到第一个可用设备
+			logger.Warn(fmt.Sprintf("Default input device unavailable (%v), using [%d] %s", err, firstDevice.Index, firstDevice.Name))
+			return firstDevice, nil
 		}
 		logger.Info(fmt.Sprintf("Using default input device: %s", defaultDevice.Name))
 		return defaultDevice, nil
@@ -772,24 +1923,25 @@ func getInputDevice(deviceSpec string, logger *utils.Logger) (*audio.DeviceInfo,
 
 	// Try to parse as device index
 	if index, err := strconv.Atoi(deviceSpec); err == nil {
-		// Validate index range
-		if index < 0 || index >= len(devices) {
-			return nil, fmt.Errorf("device index %d out of range (0-%d)", index, len(devices)-1)
+		// 设备按原始索引查找（启用 -host-api 过滤后列表不再连续）
+		device := findDeviceByIndex(devices, index)
+		if device == nil {
+			return nil, fmt.Errorf("device index %d not found", index)
 		}
 		
 		// Check if device has input channels
-		if devices[index].MaxInputChannels <= 0 {
-			return nil, fmt.Errorf("device [%d] %s has no input channels", index, devices[index].Name)
+		if device.MaxInputChannels <= 0 {
+			return nil, fmt.Errorf("device [%d] %s has no input channels", index, device.Name)
 		}
 		
-		logger.Info(fmt.Sprintf("Using input device [%d]: %s", index, devices[index].Name))
-		return &devices[index], nil
+		logger.Info(fmt.Sprintf("Using input device [%d]: %s", index, device.Name))
+		return device, nil
 	}
 
 	// Try to find by name
-	for i, device := range devices {
+	for _, device := range devices {
 		if device.MaxInputChannels > 0 && strings.Contains(strings.ToLower(device.Name), strings.ToLower(deviceSpec)) {
-			logger.Info(fmt.Sprintf("Using input device [%d]: %s", i, device.Name))
+			logger.Info(fmt.Sprintf("Using input device [%d]: %s", device.Index, device.Name))
 			return &device, nil
 		}
 	}
@@ -797,6 +1949,144 @@ func getInputDevice(deviceSpec string, logger *utils.Logger) (*audio.DeviceInfo,
 	return nil, fmt.Errorf("input device not found: %s", deviceSpec)
 }
 
+// saveLastConfig 保存本次启动的配置供 -resume 使用；设备按稳定 ID 保存，失败只记录警告
+func saveLastConfig(config *utils.Config, inputDevice *audio.DeviceInfo, outputDevices []*audio.DeviceInfo, logger *utils.Logger) {
+	last := &utils.LastConfig{Config: config}
+	if inputDevice != nil {
+		last.InputDeviceID = audio.DeviceID(inputDevice)
+	}
+	for _, device := range outputDevices {
+		if device != nil {
+			last.OutputDeviceIDs = append(last.OutputDeviceIDs, audio.DeviceID(device))
+		}
+	}
+	// 其他设备的音量/增益保留，并记下本次设备使用的值
+	if previous, err := utils.LoadLastConfig(); err == nil {
+		last.OutputVolumes, last.InputGains = previous.OutputVolumes, previous.InputGains
+	}
+	if config.Mode == "server" && len(last.OutputDeviceIDs) > 0 {
+		if last.OutputVolumes == nil {
+			last.OutputVolumes = make(map[string]int)
+		}
+		last.OutputVolumes[last.OutputDeviceIDs[0]] = config.Volume
+	}
+	if config.Mode == "client" && last.InputDeviceID != "" {
+		if last.InputGains == nil {
+			last.InputGains = make(map[string]float64)
+		}
+		last.InputGains[last.InputDeviceID] = config.InputGainDB
+	}
+	if err := utils.SaveLastConfig(last); err != nil {
+		logger.Warn(fmt.Sprintf("Could not save settings for -resume: %v", err))
+		return
+	}
+	logger.Debug("💾 Settings saved for -resume")
+}
+
+// applyDevicePreferences 应用为所选设备保存的播放音量（服务端）或输入增益（客户端），
+// 本次显式指定了 -volume / -input-gain 时以参数为准
+func applyDevicePreferences(config *utils.Config, device *audio.DeviceInfo, logger *utils.Logger) {
+	if device == nil {
+		return
+	}
+	last, err := utils.LoadLastConfig()
+	if err != nil {
+		return
+	}
+	id := audio.DeviceID(device)
+	if config.Mode == "server" {
+		if volume, ok := last.OutputVolumes[id]; ok && !flagWasSet("volume") && volume >= 0 && volume <= 100 {
+			config.Volume = volume
+			logger.Info(fmt.Sprintf("🎚️ Using the saved volume %d%% for %s", volume, device.Name))
+		}
+		return
+	}
+	if gain, ok := last.InputGains[id]; ok && !flagWasSet("input-gain") && gain >= -40 && gain <= 40 {
+		config.InputGainDB = gain
+		logger.Info(fmt.Sprintf("🎚️ Using the saved input gain %+.1f dB for %s", gain, device.Name))
+	}
+}
+
+// flagWasSet 报告命令行是否显式指定了该参数
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// applyLastConfig 将保存的设备 ID 解析为当前的设备索引；设备已不存在时回退到默认设备
+func applyLastConfig(last *utils.LastConfig, logger *utils.Logger) *utils.Config {
+	config := last.Config
+	logger.Info(fmt.Sprintf("♻️ Resuming last settings: %s mode, %s:%d, quality %s", config.Mode, config.Host, config.Port, config.StreamQuality))
+
+	resolve := func(id string) string {
+		device, err := audio.FindDeviceByID(id)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Saved device %s is not available, using the default device", id))
+			return ""
+		}
+		return strconv.Itoa(device.Index)
+	}
+	if last.InputDeviceID != "" {
+		config.InputDevice = resolve(last.InputDeviceID)
+	}
+	if len(last.OutputDeviceIDs) > 0 {
+		specs := make([]string, 0, len(last.OutputDeviceIDs))
+		for _, id := range last.OutputDeviceIDs {
+			if spec := resolve(id); spec != "" {
+				specs = append(specs, spec)
+			}
+		}
+		config.OutputDevice = strings.Join(specs, ",")
+	}
+	if level, err := utils.ParseLogLevel(config.LogLevel); err == nil {
+		logger.SetLevel(level)
+	}
+	return config
+}
+
+// promptUseLastConfig 交互模式下询问是否沿用上次的设置
+func promptUseLastConfig(last *utils.LastConfig) bool {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("")
+	fmt.Printf("♻️ Last settings: %s mode, %s:%d, quality %s\n", last.Config.Mode, last.Config.Host, last.Config.Port, last.Config.StreamQuality)
+	fmt.Print("Use last settings? (y/N): ")
+	input, _ := reader.ReadString('\n')
+	input = strings.ToLower(strings.TrimSpace(input))
+	return input == "y" || input == "yes"
+}
+
+// firstDeviceWithChannels 返回第一个有输入（或输出）声道的设备，没有时返回 nil
+func firstDeviceWithChannels(devices []audio.DeviceInfo, input bool) *audio.DeviceInfo {
+	for i := range devices {
+		if (input && devices[i].MaxInputChannels > 0) || (!input && devices[i].MaxOutputChannels > 0) {
+			return &devices[i]
+		}
+	}
+	return nil
+}
+
+// noDevicesError 没有可用音频设备时的错误，提示改用管道模式
+func noDevicesError(input bool) error {
+	if input {
+		return utils.NewAppError(utils.ErrAudioDevice, "no input devices found; use -input-pipe to stream raw PCM without an audio device")
+	}
+	return utils.NewAppError(utils.ErrAudioDevice, "no output devices found; use -output-pipe to write raw PCM without an audio device")
+}
+
+// splitDeviceSpecs 拆分逗号分隔的设备列表；空字符串表示默认设备
+func splitDeviceSpecs(spec string) []string {
+	specs := strings.Split(spec, ",")
+	for i := range specs {
+		specs[i] = strings.TrimSpace(specs[i])
+	}
+	return specs
+}
+
 // getOutputDevice 获取输出设备 - 改进错误处理和设备索引验证
 func getOutputDevice(deviceSpec string, logger *utils.Logger) (*audio.DeviceInfo, error) {
 	devices, err := audio.ListDevices()
@@ -804,11 +2094,19 @@ func getOutputDevice(deviceSpec string, logger *utils.Logger) (*audio.DeviceInfo
 		return nil, err
 	}
 
+	// 没有任何输出设备（如无声卡的服务器）时给出可操作的提示
+	firstDevice := firstDeviceWithChannels(devices, false)
+	if firstDevice == nil {
+		return nil, noDevicesError(false)
+	}
+
 	// If no device specified, use default output device
 	if deviceSpec == "" {
 		defaultDevice, err := audio.GetDefaultOutputDevice()
 		if err != nil {
-			return nil, err
+			// 默认设备不可用或没有输出声道时回退到第一个可用设备
+			logger.Warn(fmt.Sprintf("Default output device unavailable (%v), using [%d] %s", err, firstDevice.Index, firstDevice.Name))
+			return firstDevice, nil
 		}
 		logger.Info(fmt.Sprintf("Using default output device: %s", defaultDevice.Name))
 		return defaultDevice, nil
@@ -816,24 +2114,25 @@ func getOutputDevice(deviceSpec string, logger *utils.Logger) (*audio.DeviceInfo
 
 	// Try to parse as device index
 	if index, err := strconv.Atoi(deviceSpec); err == nil {
-		// Validate index range
-		if index < 0 || index >= len(devices) {
-			return nil, fmt.Errorf("device index %d out of range (0-%d)", index, len(devices)-1)
+		// 设备按原始索引查找（启用 -host-api 过滤后列表不再连续）
+		device := findDeviceByIndex(devices, index)
+		if device == nil {
+			return nil, fmt.Errorf("device index %d not found", index)
 		}
 		
 		// Check if device has output channels
-		if devices[index].MaxOutputChannels <= 0 {
-			return nil, fmt.Errorf("device [%d] %s has no output channels", index, devices[index].Name)
+		if device.MaxOutputChannels <= 0 {
+			return nil, fmt.Errorf("device [%d] %s has no output channels", index, device.Name)
 		}
 		
-		logger.Info(fmt.Sprintf("Using output device [%d]: %s", index, devices[index].Name))
-		return &devices[index], nil
+		logger.Info(fmt.Sprintf("Using output device [%d]: %s", index, device.Name))
+		return device, nil
 	}
 
 	// Try to find by name
-	for i, device := range devices {
+	for _, device := range devices {
 		if device.MaxOutputChannels > 0 && strings.Contains(strings.ToLower(device.Name), strings.ToLower(deviceSpec)) {
-			logger.Info(fmt.Sprintf("Using output device [%d]: %s", i, device.Name))
+			logger.Info(fmt.Sprintf("Using output device [%d]: %s", device.Index, device.Name))
 			return &device, nil
 		}
 	}
@@ -850,9 +2149,14 @@ func promptStreamQuality(logger *utils.Logger) string {
 	fmt.Println("  4. High (higher quality, 48000Hz, 16bit)")
 	fmt.Println("  5. Lossless (best quality, 48000Hz, 24bit)")
 	fmt.Println("  6. Custom (user defined)")
+	// 预设文件中的预设编号从 7 开始（取代内置预设的不另列出）
+	names := sortedPresetNames()
+	for i, name := range names {
+		fmt.Printf("  %d. %s (%s)\n", i+7, name, describePreset(userPresets[name]))
+	}
 	reader := bufio.NewReader(os.Stdin)
 	for {
-		fmt.Print("Enter your choice (1-6, default 3): ")
+		fmt.Printf("Enter your choice (1-%d, default 3): ", 6+len(names))
 		input, err := reader.ReadString('\n')
 		if err != nil {
 			logger.Error(fmt.Sprintf("Error reading input: %v", err))
@@ -866,21 +2170,19 @@ func promptStreamQuality(logger *utils.Logger) string {
 	}
 }
 
-func getCompressionModeName(compression bool) string {
-	if compression {
-		return "Opus"
-	}
-	return "PCM"
+func getCompressionModeName(compression utils.CodecType) string {
+	return compression.String()
 }
 
-func promptCompressionMode(logger *utils.Logger) bool {
+func promptCompressionMode(logger *utils.Logger) utils.CodecType {
 	fmt.Println("")
 	fmt.Println("🎵 Select Compression Mode:")
 	fmt.Println("  1. PCM (uncompressed, higher bandwidth)")
 	fmt.Println("  2. Opus (compressed, lower bandwidth)")
+	fmt.Println("  3. FLAC (lossless compressed)")
 	reader := bufio.NewReader(os.Stdin)
 	for {
-		fmt.Print("Enter your choice (1 or 2, default 2): ")
+		fmt.Print("Enter your choice (1-3, default 2): ")
 		input, err := reader.ReadString('\n')
 		if err != nil {
 			logger.Error(fmt.Sprintf("Error reading input: %v", err))
@@ -889,11 +2191,13 @@ func promptCompressionMode(logger *utils.Logger) bool {
 		input = strings.TrimSpace(input)
 		switch input {
 		case "1", "pcm":
-			return false
+			return utils.CodecPCM
 		case "2", "opus", "":
-			return true
+			return utils.CodecOpus
+		case "3", "flac":
+			return utils.CodecFLAC
 		default:
-			fmt.Println("❌ Invalid choice. Please enter 1 or 2.")
+			fmt.Println("❌ Invalid choice. Please enter 1, 2 or 3.")
 		}
 	}
 }
@@ -924,14 +2228,30 @@ func promptEnableExcitation(logger *utils.Logger) bool {
 }
 
 // compression 参数解析
-func parseCompressionArg(c string) bool {
+func parseCompressionArg(c string) utils.CodecType {
 	switch strings.ToLower(c) {
 	case "yes", "opus", "true", "1":
-		return true
+		return utils.CodecOpus
 	case "no", "pcm", "false", "0":
-		return false
+		return utils.CodecPCM
+	case "flac":
+		return utils.CodecFLAC
 	default:
-		return true // 默认使用Opus压缩
+		return utils.CodecOpus // 默认使用Opus压缩
+	}
+}
+
+// codec 参数解析
+func parseCodecArg(c string) (utils.CodecType, bool) {
+	switch strings.ToLower(c) {
+	case "pcm":
+		return utils.CodecPCM, true
+	case "opus":
+		return utils.CodecOpus, true
+	case "flac":
+		return utils.CodecFLAC, true
+	default:
+		return utils.CodecPCM, false
 	}
 }
 
@@ -948,12 +2268,94 @@ func parseQualityArg(q string) string {
 		return "high"
 	case "5", "lossless", "max":
 		return "lossless"
-	default:
-		return "normal"
 	}
+	name := strings.ToLower(strings.TrimSpace(q))
+	if _, ok := userPresets[name]; ok {
+		return name
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if names := sortedPresetNames(); n >= 7 && n-7 < len(names) {
+			return names[n-7]
+		}
+	}
+	return "normal"
+}
+
+// sortedPresetNames 返回预设文件中不与内置预设同名的预设，按名称排序
+func sortedPresetNames() []string {
+	var names []string
+	for name := range userPresets {
+		builtin := false
+		for _, preset := range qualityPresets {
+			builtin = builtin || preset == name
+		}
+		if !builtin {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describePreset 预设参数的简短描述
+func describePreset(preset utils.QualityPreset) string {
+	description := fmt.Sprintf("%dHz, %dch, %dbit", preset.SampleRate, preset.Channels, preset.BitDepth)
+	if preset.Codec != "" {
+		description += ", " + strings.ToLower(preset.Codec)
+	}
+	if preset.Bitrate > 0 {
+		description += fmt.Sprintf(", %d kbit/s", preset.Bitrate)
+	}
+	return description
+}
+
+// qualityPresets 从高到低的质量预设，-max-bitrate 降级时按此顺序选择
+var qualityPresets = []string{"lossless", "high", "normal", "low", "verylow"}
+
+// applyBitrateCap 在 PCM 超出 -max-bitrate 时降低质量预设；显式指定 -quality 时不降级而是报错退出
+func applyBitrateCap(config *utils.Config, channelOverride int, logger *utils.Logger) {
+	if network.CheckBitrate(config) == nil {
+		return
+	}
+	explicitQuality := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "quality" {
+			explicitQuality = true
+		}
+	})
+	if config.Compression == utils.CodecPCM && !explicitQuality {
+		requested := config.StreamQuality
+		start := 0
+		for i, preset := range qualityPresets {
+			if preset == requested {
+				start = i + 1
+			}
+		}
+		for _, preset := range qualityPresets[start:] {
+			config.StreamQuality = preset
+			applyQualityParams(config)
+			if channelOverride != 0 {
+				config.Channels = channelOverride
+			}
+			if network.CheckBitrate(config) == nil {
+				logger.Warn(fmt.Sprintf("🚦 Quality lowered from %s to %s to fit -max-bitrate %d kbit/s", requested, preset, config.MaxBitrate/1000))
+				return
+			}
+		}
+	}
+	logger.Error(fmt.Sprintf("Invalid max bitrate: %v", network.CheckBitrate(config)))
+	gracefulExitWithCode(logger, utils.ExitCodeConfig)
 }
 
 func applyQualityParams(config *utils.Config) {
+	// 预设文件中的预设优先，可以取代同名的内置预设
+	if preset, ok := userPresets[config.StreamQuality]; ok {
+		config.SampleRate = preset.SampleRate
+		config.Channels = preset.Channels
+		config.BitDepth = preset.BitDepth
+		config.FramesPerBuffer = preset.FramesPerBuffer
+		return
+	}
 	// 根据 StreamQuality 设置音频参数
 	switch config.StreamQuality {
 	case "verylow":
@@ -0,0 +1,51 @@
+// network/audio_io.go - 网络代码与音频后端之间的接口；默认实现为 audio.Capturer / audio.Player（PortAudio 或管道）
+
+package network
+
+import (
+	"context"
+	"time"
+
+	"RemoteAudioCLI/audio"
+	"RemoteAudioCLI/utils"
+)
+
+// AudioSource produces the PCM the client sends. Start delivers FramesPerBuffer frames of
+// interleaved little-endian PCM per callback, in the format of the config the source was
+// created with. *audio.Capturer (sound card, loopback or -input-pipe) is the default.
+type AudioSource interface {
+	Initialize() error
+	Start(ctx context.Context, callback audio.AudioDataCallback) error
+	Stop()
+	Terminate()
+	GetStats() *utils.AudioStats
+	// SampleIndex returns the cumulative index of the first sample frame of the buffer
+	// being delivered; it is only called inside the callback
+	SampleIndex() uint64
+	// DeviceLatency returns the input latency of the underlying device (0 if none)
+	DeviceLatency() time.Duration
+}
+
+// AudioSink plays the PCM the server receives. *audio.Player (sound card or -output-pipe)
+// is the default.
+type AudioSink interface {
+	Initialize() error
+	StartWithFadeIn(ctx context.Context, fade time.Duration) error
+	// QueueAudioIndexed queues one block of PCM with the sender's capture timestamp and the
+	// cumulative sample index just past its last sample frame (0 when unknown)
+	QueueAudioIndexed(audioData []byte, capturedAt time.Time, endSample uint64) error
+	// SetVolume sets the playback gain from 0.0 to 1.0
+	SetVolume(volume float64)
+	// Drain plays the queued audio for up to timeout and reports whether all of it was played
+	Drain(timeout time.Duration) bool
+	StopWithFadeOut(fade time.Duration)
+	Terminate()
+	GetStats() *utils.AudioStats
+	// DeviceLatency returns the output latency of the underlying device (0 if none)
+	DeviceLatency() time.Duration
+}
+
+// gainSetter is implemented by sources whose input gain can be changed at runtime
+type gainSetter interface {
+	SetGain(db float64)
+}
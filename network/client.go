@@ -3,26 +3,49 @@
 package network
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"RemoteAudioCLI/audio"
 	"RemoteAudioCLI/utils"
-	"github.com/hraban/opus"
 )
 
+// ErrOpusEncoder is returned (wrapped) by Run when the Opus encoder cannot be created
+// or keeps failing; the caller may run the client again with uncompressed PCM
+var ErrOpusEncoder = errors.New("opus encoder failed")
+
+// opusEncodeFailureLimit 连续编码失败达到该次数时放弃 Opus，由调用方改用 PCM 重新握手
+const opusEncodeFailureLimit = 50
+
 // Client represents a network client for audio streaming
 type Client struct {
 	config   *utils.Config
 	logger   *utils.Logger
 	conn     net.Conn
-	capturer *audio.Capturer
+	capturer AudioSource
+	
+	// SetAudioSource：非 nil 时代替声卡/管道采集，握手后按协商的格式创建
+	newAudioSource func(config *utils.Config) AudioSource
+	
+	// -control-channel：服务端同意时心跳走这条连接（nil 时与音频共用 conn）
+	controlConn  net.Conn
+	sessionToken [SessionTokenSize]byte
+	
+	// -psk：本会话的音频负载加密，未启用时为 nil
+	cipher *packetCipher
 	
 	// Connection state
-	connected    int32 // atomic bool
+	connected    int32 // atomic bool，为 0 时不再上报读写错误（服务端告别后立即清零）
+	active       int32 // atomic bool，会话启动后到 Stop 清理完成前为 1
 	sequence     uint32
 	lastHeartbeat time.Time
 	
@@ -31,25 +54,71 @@ type Client struct {
 	lastHeartbeatSent time.Time
 	lastHeartbeatReceived time.Time
 	
-	// Statistics
+	// Statistics（roundTripTime 由 heartbeatLoop 写入、GetStats 读取：atomic，纳秒）
 	stats *utils.NetworkStats
+	roundTripTime int64
 	
 	// Control channels
-	stopChan   chan struct{}
 	errorChan  chan error
 	wg         sync.WaitGroup
+	stopMutex  sync.Mutex
 	
-	opusEncoder *opus.Encoder
+	opusEncoder *opusMultiEncoder
 	useOpus     bool
+	flacEncoder *flacEncoder
+	// 连续的 Opus 编码失败次数（仅在 onAudioData 中访问）
+	opusEncodeErrors int
+	
+	// -max-bitrate 发送限速（nil = 不限）
+	throttle *tokenBucket
+	
+	// -simulate-*：本会话音频包经过的模拟网络（nil = 直接写入 conn）
+	simulator *netSimulator
+	
+	// 音频热路径的复用缓冲区（仅在采集回调 onAudioData 中访问），握手后按音频格式分配
+	pcm16          []int16
+	indexedPayload []byte // 采样序号 + 音频数据
+	audioWriter    packetWriter
+	
+	// 服务端同意 -sample-index 时为 true，音频包带上采集端的累计采样序号
+	sampleIndexed bool
+	
+	// 本会话协商的配置（服务端的握手回复，Flags 只保留实际使用的功能），供状态页 /config 使用
+	negotiated HandshakeConfig
+	
+	// -input-file：直接发送 Ogg Opus 文件中的包，不采集也不重新编码（nil 表示使用采集）
+	opusFile        *audio.OggOpusReader
+	opusFileFrames  int    // 文件中每个包的时长（48kHz 采样帧），决定协商的 FramesPerBuffer
+	firstFilePacket []byte // 握手前为确定包时长而读出的第一个音频包
+	
+	// -send-mono：设备按 captureChannels 采集，onAudioData 中混为单声道后再编码
+	captureChannels int
+	
+	// -device-buffer：设备缓冲与包长不同时，把采集的数据重新切分为 FramesPerBuffer 帧的包（nil 表示一一对应）
+	repacketizer *repacketizer
+	
+	// 静音控制（m 命令切换）与运行时命令读取
+	muted         int32 // atomic bool
+	transmitting  int32 // atomic bool，-ptt 时按键切换
+	commandReader *utils.CommandReader
+	
+	// 本次运行的生命周期（由 Run 的 ctx、Stop、-duration 或管道结束触发关闭），每次 Run 重新创建
+	shutdown *ConnectionManager
+	// 导致客户端停止的错误，由 Run 返回
+	runErr error
 }
 
-// NewClient creates a new network client
+// NewClient creates a new network client; a nil logger uses utils.NewLogger()
 func NewClient(config *utils.Config, logger *utils.Logger) *Client {
+	if logger == nil {
+		logger = utils.NewLogger()
+	}
 	return &Client{
 		config:    config,
 		logger:    logger,
-		stopChan:  make(chan struct{}),
 		errorChan: make(chan error, 10),
+		// 握手会把 Channels 改成协商结果，这里记下设备的采集声道数
+		captureChannels: config.Channels,
 		stats: &utils.NetworkStats{
 			BytesSent:     0,
 			BytesReceived: 0,
@@ -58,38 +127,131 @@ func NewClient(config *utils.Config, logger *utils.Logger) *Client {
 	}
 }
 
-// Start initiates the client connection and audio streaming
+// SetAudioSource replaces the sound card or -input-pipe capture with a source created by
+// newSource after each handshake, with the negotiated stream format. It must be called before
+// Run and is ignored when config.InputFile is set.
+func (c *Client) SetAudioSource(newSource func(config *utils.Config) AudioSource) {
+	c.newAudioSource = newSource
+}
+
+// Start initiates the client connection and audio streaming until Stop is called
 func (c *Client) Start(inputDevice *audio.DeviceInfo) error {
+	return c.Run(context.Background(), inputDevice)
+}
+
+// Run connects to the server and streams audio until ctx is cancelled, Stop is
+// called, the configured duration elapses or a connection error occurs. A nil
+// inputDevice uses the default input device (ignored when config.InputPipe is set or a
+// source was set with SetAudioSource).
+// It returns nil after a requested shutdown; a stopped Client can be run again.
+func (c *Client) Run(ctx context.Context, inputDevice *audio.DeviceInfo) error {
+	if err := c.config.ValidateKeepalive(); err != nil {
+		return err
+	}
+	if inputDevice == nil && c.capturesFromDevice() {
+		device, err := audio.GetDefaultInputDevice()
+		if err != nil {
+			return err
+		}
+		inputDevice = device
+	}
+	
+	c.shutdown = NewConnectionManager(ctx)
+	c.runErr = nil
+	defer c.shutdown.NotifyShutdown()
+	// 返回前确保清理已完成，以便再次 Run
+	defer c.Stop()
+	
+	if c.config.InputFile != "" {
+		if err := c.openInputFile(); err != nil {
+			return err
+		}
+		defer c.opusFile.Close()
+	}
+	
 	c.logger.Info("🔗 Connecting to server...")
 	
 	// 注册关闭回调
-	RegisterShutdownCallback(func() {
+	c.shutdown.RegisterShutdownCallback(func() {
 		c.Stop()
 	})
 	
-	// Connect to server
-	if err := c.connect(); err != nil {
-		return utils.WrapError(err, utils.ErrConnection, "failed to connect to server")
+	// Connect to server and perform handshake (retried while the server is not ready yet)
+	c.controlConn = nil
+	if err := c.connectWithRetries(); err != nil {
+		return err
 	}
 	
-	c.logger.Info("✅ Connected to server successfully")
+	c.logger.Info("🤝 Handshake completed")
+	if c.sessionToken != ([SessionTokenSize]byte{}) {
+		c.openControlConnection()
+	} else if c.config.ControlChannel {
+		c.logger.Warn("⚠️ Server does not support a control connection, heartbeats share the audio connection")
+	}
 	
-	// Perform handshake
-	if err := c.handshake(); err != nil {
-		c.conn.Close()
-		return utils.WrapError(err, utils.ErrProtocol, "handshake failed")
+	// 握手后格式已确定（服务端可能改了编解码器），再检查是否超出带宽上限
+	if err := CheckBitrate(c.config); err != nil {
+		c.abortSession()
+		return err
+	}
+	c.throttle = nil
+	if c.config.MaxBitrate > 0 {
+		c.throttle = newTokenBucket(c.config.MaxBitrate, time.Now())
+		c.logger.Infof("🚦 Send rate capped at %d kbit/s", c.config.MaxBitrate/1000)
 	}
 	
-	c.logger.Info("🤝 Handshake completed")
+	// 编码器按协商后的格式在采集之前创建；失败时（main 据此回退到 PCM）只需结束这次会话。
+	// 预编码文件的包原样发送，不需要编码器
+	c.useOpus = c.config.Compression == utils.CodecOpus && c.opusFile == nil
+	c.opusEncodeErrors = 0
+	c.opusEncoder, c.flacEncoder = nil, nil
+	if c.opusFile == nil {
+		var err error
+		c.opusEncoder, c.flacEncoder, err = sessionEncoders(c.config)
+		if err != nil {
+			c.abortSession()
+			return err
+		}
+	}
 	
 	// Initialize audio capturer
-	c.capturer = audio.NewCapturer(inputDevice, c.config, c.logger)
-	if err := c.capturer.Initialize(); err != nil {
-		c.conn.Close()
-		return utils.WrapError(err, utils.ErrAudioCapture, "failed to initialize audio capturer")
+	if c.opusFile != nil {
+		if err := c.checkInputFileFormat(); err != nil {
+			c.abortSession()
+			return err
+		}
+		c.capturer = nil
+	} else if c.newAudioSource != nil {
+		c.capturer = c.newAudioSource(c.config)
+	} else if c.config.InputPipe != "" {
+		capturer := audio.NewPipeCapturer(c.config.InputPipe, c.config, c.logger)
+		// 管道输入结束即视为正常结束推流
+		capturer.OnEnd(func() {
+			c.logger.Info("🔚 Input pipe closed, stopping client")
+			c.shutdown.NotifyShutdown()
+		})
+		c.capturer = capturer
+	} else {
+		c.repacketizer = nil
+		if c.config.DeviceBuffer > 0 && c.config.DeviceBuffer != c.config.FramesPerBuffer {
+			c.repacketizer = newRepacketizer(c.config.FramesPerBuffer, c.config.GetFrameSize())
+			c.logger.Infof("📦 Capturing %d-frame device buffers, sending %d-frame packets", c.config.DeviceBuffer, c.config.FramesPerBuffer)
+		}
+		c.capturer = audio.NewCapturer(inputDevice, c.captureConfig(), c.logger)
+		if c.sendsMono() {
+			c.logger.Infof("🎚️ Downmixing %d-channel capture to mono before encoding", c.captureChannels)
+		}
+	}
+	if c.capturer != nil {
+		if err := c.capturer.Initialize(); err != nil {
+			c.abortSession()
+			return utils.WrapError(err, utils.ErrAudioCapture, "failed to initialize audio capturer")
+		}
+		c.logger.Info("🎤 Audio capturer initialized")
+		c.logger.Infof("⏱️ Latency: %s", c.config.LatencySummary(c.capturer.DeviceLatency()))
+	} else {
+		c.logger.Infof("⏱️ Latency: %s", c.config.LatencySummary(0))
 	}
-	
-	c.logger.Info("🎤 Audio capturer initialized")
 	
 	// 初始化心跳包时间
 	c.heartbeatMutex.Lock()
@@ -97,58 +259,89 @@ func (c *Client) Start(inputDevice *audio.DeviceInfo) error {
 	c.lastHeartbeatReceived = time.Now()
 	c.heartbeatMutex.Unlock()
 	
+	// 从这里起失败时由 Stop 完成清理：停止采集、关闭连接并等待后台协程
+	atomic.StoreInt32(&c.active, 1)
+	c.shutdown.IncrementConnections()
+	
 	// Start background routines
 	c.wg.Add(4) // 增加到4个goroutine
-	go c.audioStreamingLoop()
-	go c.heartbeatLoop()
-	go c.packetProcessingLoop() // 新增：处理服务端数据包
-	go c.errorHandlingLoop()
-	
-	// Monitor shutdown signals
-	go c.monitorShutdown()
+	runCtx := c.shutdown.Context()
+	go c.audioStreamingLoop(runCtx)
+	go c.heartbeatLoop(runCtx)
+	go c.packetProcessingLoop(runCtx, c.controlConnection()) // 新增：处理服务端数据包
+	go c.errorHandlingLoop(runCtx)
 	
-	c.useOpus = c.config.Compression
-	if c.useOpus {
-		validOpusRates := map[int]bool{8000: true, 12000: true, 16000: true, 24000: true, 48000: true}
-		if !validOpusRates[c.config.SampleRate] {
-			return utils.NewAppError(utils.ErrAudioCapture, fmt.Sprintf("Opus only supports sample rates: 8000, 12000, 16000, 24000, 48000 Hz, got %d", c.config.SampleRate))
-		}
-		var err error
-		c.opusEncoder, err = opus.NewEncoder(c.config.SampleRate, c.config.Channels, opus.AppAudio)
-		if err != nil {
-			return utils.WrapError(err, utils.ErrAudioCapture, "failed to initialize Opus encoder")
+	c.allocateAudioBuffers()
+	c.simulator = newNetSimulator(c.config, c.conn)
+	if c.simulator != nil {
+		c.logger.Warnf("🧪 Simulating a bad network on outgoing audio: %s", c.simulator)
+		if simulator := c.simulator; simulator.queue != nil {
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				simulator.run(runCtx)
+			}()
 		}
 	}
 	
 	// Start audio capture
-	if err := c.capturer.Start(c.onAudioData); err != nil {
-		c.Stop()
-		return utils.WrapError(err, utils.ErrAudioCapture, "failed to start audio capture")
+	if c.capturer != nil {
+		if err := c.capturer.Start(runCtx, c.onAudioData); err != nil {
+			c.Stop()
+			return utils.WrapError(err, utils.ErrAudioCapture, "failed to start audio capture")
+		}
 	}
 	
 	c.logger.Info("🚀 Client started successfully - streaming audio...")
 	c.logger.Info("💡 Press Ctrl+C to stop the client")
+	
+	if c.config.StartMuted {
+		atomic.StoreInt32(&c.muted, 1)
+		c.logger.Info("🔇 Client started muted")
+	}
+	if c.config.PTT {
+		atomic.StoreInt32(&c.transmitting, 0)
+		c.logger.Infof("⏸️ Push-to-talk: not transmitting until %s is typed", c.config.PTTKey)
+	}
+	c.startRuntimeCommands()
 	c.logger.Info("📊 Real-time statistics will appear below:")
 	atomic.StoreInt32(&c.connected, 1)
-	IncrementConnections()
+	
+	// 连接标记为就绪后再开始发送文件，避免丢掉开头的包
+	if c.opusFile != nil {
+		c.wg.Add(1)
+		go c.fileStreamingLoop(runCtx)
+	}
+	
+	// 到达 -duration 指定的运行时间后自动关闭
+	if c.config.Duration > 0 {
+		c.logger.Infof("⏲️ Client will stop automatically after %v", c.config.Duration)
+		go c.shutdown.scheduleShutdown(c.config.Duration, c.logger)
+	}
 	
 	// Wait for shutdown
 	c.wg.Wait()
 	
-	return nil
+	return c.runErr
 }
 
 // Stop gracefully shuts down the client
 func (c *Client) Stop() {
-	// 使用原子操作确保只执行一次
-	oldValue := atomic.SwapInt32(&c.connected, 0)
-	if oldValue == 0 {
-		// 已经在停止过程中或已经停止
+	// 持锁执行，并发调用者会等待清理完成；使用原子操作确保只执行一次
+	c.stopMutex.Lock()
+	defer c.stopMutex.Unlock()
+	
+	atomic.StoreInt32(&c.connected, 0)
+	if atomic.SwapInt32(&c.active, 0) == 0 {
+		// 已经停止（服务端告别只清零 connected，清理仍由这里完成）
 		return
 	}
 	
 	c.logger.Info("🛑 Stopping client...")
 	
+	// 停止处理终端命令
+	c.commandReader.Stop()
+	
 	// Stop audio capture
 	if c.capturer != nil {
 		c.capturer.Stop()
@@ -159,15 +352,13 @@ func (c *Client) Stop() {
 	if c.conn != nil {
 		c.conn.Close()
 	}
-	
-	// Signal stop to all goroutines (使用安全的关闭方式)
-	select {
-	case <-c.stopChan:
-		// 通道已经关闭，不需要再次关闭
-	default:
-		close(c.stopChan)
+	if c.controlConn != nil {
+		c.controlConn.Close()
 	}
 	
+	// Signal stop to all goroutines
+	c.shutdown.NotifyShutdown()
+	
 	// Wait for goroutines to finish with timeout
 	done := make(chan struct{})
 	go func() {
@@ -183,25 +374,76 @@ func (c *Client) Stop() {
 	}
 	
 	// 减少连接计数
-	DecrementConnections()
+	c.shutdown.DecrementConnections()
 	
 	c.logger.Info("✅ Client stopped")
 }
 
-// monitorShutdown 监控关闭信号
-func (c *Client) monitorShutdown() {
-	select {
-	case <-GetShutdownChannel():
-		c.logger.Info("🛑 Shutdown signal received")
-		// 只有在还连接时才调用Stop
-		if atomic.LoadInt32(&c.connected) == 1 {
-			c.Stop()
+// abortSession 在握手之后、后台协程启动之前失败时结束会话：先半关闭音频连接，等服务端
+// 结束会话并关闭连接（最多 ConnTimeout），使随后立即重连（如回退到 PCM 或 16 位）时
+// 不会被当成第二个客户端拒绝
+func (c *Client) abortSession() {
+	if c.controlConn != nil {
+		c.controlConn.Close()
+	}
+	if conn, ok := c.conn.(interface{ CloseWrite() error }); ok && conn.CloseWrite() == nil {
+		c.conn.SetReadDeadline(time.Now().Add(c.config.ConnTimeout))
+		io.Copy(io.Discard, c.conn)
+	}
+	c.conn.Close()
+}
+
+// connectRetryDelay 服务端尚未就绪时两次连接尝试之间的等待
+const connectRetryDelay = time.Second
+
+// connectWithRetries connects and performs the handshake. Attempts that fail because the
+// server refuses the connection or does not answer in time (e.g. it is still starting up)
+// are retried up to config.ConnectRetries times; other failures are returned immediately
+func (c *Client) connectWithRetries() error {
+	for attempt := 0; ; attempt++ {
+		err := c.connect()
+		if err == nil {
+			c.logger.Info("✅ Connected to server successfully")
+			if err = c.handshake(); err == nil {
+				// 每次握手开始一个新会话，音频包序列号从 1 重新计数（服务端同时重置丢包统计）
+				atomic.StoreUint32(&c.sequence, 0)
+				return nil
+			}
+			c.conn.Close()
+			err = utils.WrapError(err, handshakeErrorType(err), "handshake failed")
+		} else {
+			err = utils.WrapError(err, utils.ErrConnection, "failed to connect to server")
+		}
+
+		var handshakeErr *HandshakeError
+		if attempt >= c.config.ConnectRetries || !errors.As(err, &handshakeErr) || !handshakeErr.Retryable() {
+			return err
+		}
+		c.logger.Warnf("⏳ Server not ready (%s), retrying in %v (%d/%d)",
+			handshakeErr.Kind, connectRetryDelay, attempt+1, c.config.ConnectRetries)
+		select {
+		case <-c.shutdown.ShutdownChannel():
+			return err
+		case <-time.After(connectRetryDelay):
 		}
-	case <-c.stopChan:
-		return
 	}
 }
 
+// handshakeErrorType classifies a handshake failure for the exit code: no answer in time is a
+// timeout, a refused connection a connection error, anything else a protocol error
+func handshakeErrorType(err error) utils.ErrorType {
+	var handshakeErr *HandshakeError
+	if errors.As(err, &handshakeErr) {
+		switch handshakeErr.Kind {
+		case HandshakeTimeout:
+			return utils.ErrTimeout
+		case HandshakeRefused:
+			return utils.ErrConnection
+		}
+	}
+	return utils.ErrProtocol
+}
+
 // connect establishes a TCP connection to the server
 func (c *Client) connect() error {
 	address := c.config.GetNetworkAddress()
@@ -210,7 +452,7 @@ func (c *Client) connect() error {
 	
 	conn, err := net.DialTimeout("tcp", address, c.config.ConnTimeout)
 	if err != nil {
-		return fmt.Errorf("failed to connect to %s: %w", address, err)
+		return &HandshakeError{Kind: classifyDialError(err), Err: fmt.Errorf("failed to connect to %s: %w", address, err)}
 	}
 	
 	c.conn = conn
@@ -222,10 +464,7 @@ func (c *Client) connect() error {
 func (c *Client) handshake() error {
 	c.logger.Info("🤝 Starting handshake...")
 	
-	var compression uint8 = 0
-	if c.config.Compression {
-		compression = 1
-	}
+	compression := uint8(c.config.Compression)
 	handshakeConfig := &HandshakeConfig{
 		SampleRate:      uint32(c.config.SampleRate),
 		Channels:        uint8(c.config.Channels),
@@ -233,6 +472,29 @@ func (c *Client) handshake() error {
 		FramesPerBuffer: uint16(c.config.FramesPerBuffer),
 		BufferCount:     uint8(c.config.BufferCount),
 		Compression:     compression,
+		SampleFormat:    uint8(c.config.SampleFormat),
+	}
+	if c.sendsMono() {
+		handshakeConfig.Channels = 1
+	}
+	if c.config.ControlChannel {
+		handshakeConfig.Flags |= HandshakeFlagControlChannel
+	}
+	if c.config.SampleIndex {
+		handshakeConfig.Flags |= HandshakeFlagSampleIndex
+	}
+	// 每次握手用新的盐派生会话密钥（还要等服务端回复的随机值）
+	c.cipher = nil
+	var psk *pskKeys
+	if c.config.PSK != "" {
+		salt, err := newPSKSalt()
+		if err != nil {
+			return fmt.Errorf("failed to derive the -psk session key: %w", err)
+		}
+		psk = newPSKKeys(c.config.PSK, salt)
+		handshakeConfig.Flags |= HandshakeFlagEncrypted
+		handshakeConfig.PSKSalt = salt
+		handshakeConfig.PSKCheck = psk.clientCheck
 	}
 	
 	// Validate configuration
@@ -248,16 +510,28 @@ func (c *Client) handshake() error {
 	
 	c.logger.Debug("📤 Handshake packet sent")
 	
-	// Set read timeout for handshake response
-	c.conn.SetReadDeadline(time.Now().Add(c.config.ReadTimeout))
-	defer c.conn.SetReadDeadline(time.Time{})
-	
-	// Read handshake response
-	responsePacket, err := ReadPacket(c.conn)
+	// Read handshake response; the connection timeout applies to header and payload separately
+	responsePacket, err := ReadPacketWithTimeout(c.conn, c.config.ConnTimeout, 0)
 	if err != nil {
+		var versionErr *ProtocolVersionError
+		if errors.As(err, &versionErr) {
+			return &HandshakeError{Kind: HandshakeProtocolMismatch, Err: errors.New(protocolMismatchMessage(versionErr.Remote, ProtocolVersion))}
+		}
+		if errors.Is(err, io.EOF) {
+			// 旧版本服务端遇到不认识的协议版本时直接断开，不会回复错误包
+			return fmt.Errorf("server closed the connection during handshake (it may speak an older protocol version than v%d): %w", ProtocolVersion, err)
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return &HandshakeError{Kind: HandshakeTimeout, Err: fmt.Errorf("no handshake response within %v: %w", c.config.ConnTimeout, err)}
+		}
 		return fmt.Errorf("failed to read handshake response: %w", err)
 	}
 	
+	if responsePacket.Header.Type == PacketTypeError {
+		return fmt.Errorf("server rejected handshake: %s", string(responsePacket.Payload))
+	}
+	
 	if responsePacket.Header.Type != PacketTypeHandshake {
 		return fmt.Errorf("unexpected packet type in handshake response: %s", responsePacket.Header.Type)
 	}
@@ -268,16 +542,120 @@ func (c *Client) handshake() error {
 		return fmt.Errorf("failed to parse server config: %w", err)
 	}
 	
+	if err := serverConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid server config: %w", err)
+	}
+	
+	// 服务端须用同一口令算出校验值，否则它无法解密，也可能是冒充的服务端
+	if psk != nil {
+		if serverConfig.Flags&HandshakeFlagEncrypted == 0 {
+			return errors.New("server did not confirm the pre-shared key (-psk): it has a different or no -psk")
+		}
+		cipher, err := psk.sessionCipher(serverConfig.PSKNonce)
+		if err != nil {
+			return fmt.Errorf("failed to derive the -psk session key: %w", err)
+		}
+		if !checkEqual(serverConfig.PSKCheck, cipher.serverCheck) {
+			return errors.New("server did not confirm the pre-shared key (-psk): it has a different or no -psk")
+		}
+		c.cipher = cipher
+		c.logger.Info("🔐 Audio payloads are encrypted with the pre-shared key (AES-256-GCM)")
+	}
+	
+	// 服务端可能按其策略调整了参数
+	if changes := handshakeChanges(*handshakeConfig, serverConfig); len(changes) > 0 {
+		c.logger.Warnf("⚖️ Server adjusted stream config: %s", strings.Join(changes, ", "))
+	}
+	
 	// Update client configuration with server's preferred settings
 	c.updateConfigFromServer(&serverConfig)
+	c.sessionToken = [SessionTokenSize]byte{}
+	if c.config.ControlChannel && serverConfig.Flags&HandshakeFlagControlChannel != 0 {
+		c.sessionToken = serverConfig.SessionToken
+	}
+	c.sampleIndexed = c.config.SampleIndex && serverConfig.Flags&HandshakeFlagSampleIndex != 0
+	if c.config.SampleIndex && !c.sampleIndexed {
+		c.logger.Warn("⚠️ Server does not support sample indexes, sending audio without them")
+	}
+	c.negotiated = serverConfig
+	if !c.sampleIndexed {
+		c.negotiated.Flags &^= HandshakeFlagSampleIndex
+	}
 	
-	c.logger.Infof("✅ Handshake successful - Sample Rate: %dHz, Channels: %d, Bit Depth: %d, compress: Opus %s",
+	c.logger.Infof("✅ Handshake successful - Sample Rate: %dHz, Channels: %d, Bit Depth: %d, codec: %s",
 		serverConfig.SampleRate, serverConfig.Channels, serverConfig.BitDepth,
-		map[bool]string{true: "ON", false: "OFF"}[c.config.Compression])
+		utils.CodecType(serverConfig.Compression))
 	
 	return nil
 }
 
+// openControlConnection dials a second connection and attaches it to the session with the
+// token from the handshake. On failure the client keeps using the audio connection for heartbeats.
+func (c *Client) openControlConnection() {
+	conn, err := net.DialTimeout("tcp", c.config.GetNetworkAddress(), c.config.ConnTimeout)
+	if err != nil {
+		c.logger.Warnf("⚠️ Failed to open control connection, heartbeats share the audio connection: %v", err)
+		return
+	}
+	
+	conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+	if err := WritePacket(conn, NewAttachPacket(c.sessionToken)); err == nil {
+		// 服务端原样回复 Attach 包作为确认
+		var ack *Packet
+		if ack, err = ReadPacketWithTimeout(conn, c.config.ConnTimeout, 0); err == nil && ack.Header.Type != PacketTypeAttach {
+			err = fmt.Errorf("unexpected packet type: %s", ack.Header.Type)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		c.logger.Warnf("⚠️ Server did not accept the control connection, heartbeats share the audio connection: %v", err)
+		return
+	}
+	
+	c.controlConn = conn
+	c.logger.Info("🎛️ Control connection established, heartbeats use a separate connection")
+}
+
+// controlConnection returns the connection that carries heartbeats
+func (c *Client) controlConnection() net.Conn {
+	if c.controlConn != nil {
+		return c.controlConn
+	}
+	return c.conn
+}
+
+// sessionEncoders 创建会话使用的编码器（测试中替换以模拟编码器不可用）
+var sessionEncoders = newEncoders
+
+// newEncoders creates the encoder for the configured codec (both nil for PCM)
+func newEncoders(config *utils.Config) (*opusMultiEncoder, *flacEncoder, error) {
+	switch config.Compression {
+	case utils.CodecOpus:
+		if !isOpusSampleRate(uint32(config.SampleRate)) {
+			return nil, nil, utils.WrapError(ErrOpusEncoder, utils.ErrAudioCapture, fmt.Sprintf("Opus only supports sample rates: 8000, 12000, 16000, 24000, 48000 Hz, got %d", config.SampleRate))
+		}
+		opusEncoder, err := newOpusMultiEncoder(config.SampleRate, config.Channels, opusTargetBitrate(config))
+		if err != nil {
+			return nil, nil, utils.WrapError(fmt.Errorf("%w: %v", ErrOpusEncoder, err), utils.ErrAudioCapture, "failed to initialize Opus encoder")
+		}
+		return opusEncoder, nil, nil
+	case utils.CodecFLAC:
+		flacEncoder, err := newFLACEncoder(config.SampleRate, config.Channels, config.BitDepth)
+		if err != nil {
+			return nil, nil, utils.WrapError(err, utils.ErrAudioCapture, "failed to initialize FLAC encoder")
+		}
+		return nil, flacEncoder, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// CheckEncoder verifies that the encoder for the configured codec can be created (used by -check)
+func CheckEncoder(config *utils.Config) error {
+	_, _, err := newEncoders(config)
+	return err
+}
+
 // updateConfigFromServer updates client config based on server response
 func (c *Client) updateConfigFromServer(serverConfig *HandshakeConfig) {
 	// Use server's preferred settings
@@ -286,37 +664,201 @@ func (c *Client) updateConfigFromServer(serverConfig *HandshakeConfig) {
 	c.config.BitDepth = int(serverConfig.BitDepth)
 	c.config.FramesPerBuffer = int(serverConfig.FramesPerBuffer)
 	c.config.BufferCount = int(serverConfig.BufferCount)
+	c.config.Compression = utils.CodecType(serverConfig.Compression)
+	c.config.SampleFormat = utils.SampleFormat(serverConfig.SampleFormat)
+}
+
+// startRuntimeCommands 启动运行时命令：q 退出、s 统计、v 输入音量、m 切换静音、-ptt 按键切换发送（标准输入被管道占用或不是终端时跳过）
+func (c *Client) startRuntimeCommands() {
+	if c.config.InputPipe == audio.StdioPipe {
+		return
+	}
+
+	commands := runtimeCommands{
+		quit: func() {
+			c.logger.Info("👋 Quit requested")
+			c.shutdown.NotifyShutdown()
+		},
+		stats: func() {
+			c.logger.LogNetworkStats(c.GetStats())
+			if c.capturer != nil {
+				c.logger.LogAudioStats(c.capturer.GetStats())
+			}
+		},
+		volume: func(percent int) {
+			if c.capturer == nil {
+				c.logger.Warn("Volume cannot be changed while sending a pre-encoded file")
+				return
+			}
+			source, ok := c.capturer.(gainSetter)
+			if !ok {
+				c.logger.Warn("Volume cannot be changed for this audio source")
+				return
+			}
+			// 音量即输入增益：100% 为原始电平，取代 -input-gain 的设置
+			source.SetGain(20 * math.Log10(float64(percent)/100))
+			c.logger.Infof("🎚️ Input volume set to %d%%", percent)
+		},
+		mute: c.ToggleMute,
+	}
+	if c.config.PTT {
+		commands.ptt = c.ToggleTransmit
+		commands.pttKey = c.config.PTTKey
+	}
+	c.commandReader = startCommandPrompt(c.logger, commands)
+	if c.commandReader == nil && c.config.PTT {
+		c.logger.Warn("⚠️ Push-to-talk needs an interactive terminal on stdin, the client will not transmit")
+	}
+}
+
+// ToggleTransmit starts or stops transmitting in -ptt mode
+func (c *Client) ToggleTransmit() {
+	if atomic.CompareAndSwapInt32(&c.transmitting, 0, 1) {
+		c.logger.Info("🎙️ Transmitting")
+	} else {
+		atomic.StoreInt32(&c.transmitting, 0)
+		c.logger.Info("⏸️ Stopped transmitting")
+	}
+}
+
+// IsTransmitting returns false while -ptt mode is waiting for the push-to-talk key
+func (c *Client) IsTransmitting() bool {
+	return !c.config.PTT || atomic.LoadInt32(&c.transmitting) == 1
+}
+
+// ToggleMute switches the mute state; while muted no audio packets are sent (heartbeats keep the connection alive)
+func (c *Client) ToggleMute() {
+	if atomic.CompareAndSwapInt32(&c.muted, 0, 1) {
+		c.logger.Info("🔇 Muted")
+	} else {
+		atomic.StoreInt32(&c.muted, 0)
+		c.logger.Info("🔊 Unmuted")
+	}
+}
+
+// IsMuted returns true if audio sending is muted
+func (c *Client) IsMuted() bool {
+	return atomic.LoadInt32(&c.muted) == 1
 }
 
 // onAudioData is called when audio data is captured
 func (c *Client) onAudioData(audioData []byte) {
-	if atomic.LoadInt32(&c.connected) == 0 || IsShutdownRequested() {
+	if atomic.LoadInt32(&c.connected) == 0 || c.shutdown.IsShutdownRequested() {
+		return
+	}
+	// 静音或按键通话未按下时与激励模式一样不发送音频包
+	if c.IsMuted() || !c.IsTransmitting() {
 		return
 	}
+	if c.sendsMono() {
+		audioData = audio.MapPCMChannels(audioData, audio.SampleDepth(c.config), c.captureChannels, 1)
+	}
+	if c.repacketizer == nil {
+		c.sendAudioFrame(audioData, c.capturer.SampleIndex())
+		return
+	}
+	c.repacketizer.Push(audioData, c.capturer.SampleIndex())
+	for {
+		packet, sampleIndex, ok := c.repacketizer.Next()
+		if !ok {
+			return
+		}
+		c.sendAudioFrame(packet, sampleIndex)
+	}
+}
+
+// sendAudioFrame encodes one FramesPerBuffer frame of PCM and sends it; sampleIndex is
+// the cumulative index of its first sample frame
+func (c *Client) sendAudioFrame(audioData []byte, sampleIndex uint64) {
 	var payload []byte
 	if c.useOpus && c.opusEncoder != nil {
 		// PCM []byte 转 []int16
 		sampleCount := len(audioData) / 2
-		pcm16 := make([]int16, sampleCount)
+		if cap(c.pcm16) < sampleCount {
+			c.pcm16 = make([]int16, sampleCount)
+		}
+		pcm16 := c.pcm16[:sampleCount]
 		for i := 0; i < sampleCount; i++ {
 			pcm16[i] = int16(audioData[2*i]) | int16(audioData[2*i+1])<<8
 		}
-		maxDataBytes := 4000
-		opusBuf := make([]byte, maxDataBytes)
-		lenOut, err := c.opusEncoder.Encode(pcm16, opusBuf)
+		encoded, err := c.opusEncoder.Encode(pcm16)
 		if err != nil {
-			c.logger.Error(fmt.Sprintf("Opus encode error: %v", err))
+			c.logger.ErrorRateLimited("opus-encode", fmt.Sprintf("Opus encode error: %v", err))
+			// 持续失败时结束本次运行，调用方改用 PCM 重新握手
+			if c.opusEncodeErrors++; c.opusEncodeErrors == opusEncodeFailureLimit {
+				c.errorChan <- utils.WrapError(fmt.Errorf("%w: %d consecutive encode errors, last: %v", ErrOpusEncoder, c.opusEncodeErrors, err),
+					utils.ErrAudioCapture, "Opus encoding keeps failing")
+			}
 			return
 		}
-		payload = opusBuf[:lenOut]
+		c.opusEncodeErrors = 0
+		payload = encoded
+	} else if c.flacEncoder != nil {
+		// FLAC 无损压缩
+		encoded, err := c.flacEncoder.Encode(audioData)
+		if err != nil {
+			c.logger.ErrorRateLimited("flac-encode", fmt.Sprintf("FLAC encode error: %v", err))
+			return
+		}
+		payload = encoded
 	} else {
 		// PCM 直传
 		payload = audioData
 	}
+	c.sendAudioPayload(payload, sampleIndex)
+}
+
+// captureConfig 返回打开采集设备用的配置：-send-mono 时按设备的声道数，-device-buffer 时按设备缓冲大小；
+// 流的格式仍以 c.config 为准
+func (c *Client) captureConfig() *utils.Config {
+	if !c.sendsMono() && c.repacketizer == nil {
+		return c.config
+	}
+	config := *c.config
+	if c.sendsMono() {
+		config.Channels = c.captureChannels
+	}
+	if c.repacketizer != nil {
+		config.FramesPerBuffer = c.config.DeviceBuffer
+	}
+	return &config
+}
+
+// capturesFromDevice reports whether audio comes from a sound card rather than a pipe, a
+// file or a SetAudioSource source
+func (c *Client) capturesFromDevice() bool {
+	return c.config.InputPipe == "" && c.config.InputFile == "" && c.newAudioSource == nil
+}
+
+// sendsMono reports whether -send-mono downmixes a multi-channel capture device to a mono stream
+func (c *Client) sendsMono() bool {
+	return c.config.SendMono && c.capturesFromDevice() && c.captureChannels > 1
+}
+
+// sendAudioPayload sends one encoded audio frame; sampleIndex is the cumulative index of
+// its first sample frame and is only sent when the server accepted -sample-index
+func (c *Client) sendAudioPayload(payload []byte, sampleIndex uint64) {
+	var flags uint8
+	if c.sampleIndexed {
+		indexed := append(c.indexedPayload[:SampleIndexSize], payload...)
+		binary.BigEndian.PutUint64(indexed, sampleIndex)
+		c.indexedPayload = indexed
+		payload = indexed
+		flags = PacketFlagSampleIndex
+	}
+	if c.throttle != nil && !c.waitForBandwidth(len(payload)+HeaderSize) {
+		return
+	}
 	sequence := atomic.AddUint32(&c.sequence, 1)
-	audioPacket := NewAudioPacket(payload, sequence)
+	audioPacket := newPacket(PacketTypeAudio, payload)
+	audioPacket.Header.Sequence = sequence
+	audioPacket.Header.Flags = flags
 	c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
-	if err := WritePacket(c.conn, audioPacket); err != nil {
+	var writer io.Writer = c.conn
+	if c.simulator != nil {
+		writer = c.simulator
+	}
+	if err := c.audioWriter.WritePacket(writer, &audioPacket); err != nil {
 		if atomic.LoadInt32(&c.connected) == 1 {
 			c.errorChan <- utils.WrapError(err, utils.ErrNetwork, "failed to send audio packet")
 		}
@@ -325,48 +867,81 @@ func (c *Client) onAudioData(audioData []byte) {
 	atomic.AddInt64(&c.stats.BytesSent, int64(len(payload)+HeaderSize))
 }
 
+// allocateAudioBuffers sizes the per-session scratch buffers from the negotiated
+// format so that onAudioData does not allocate in steady state
+func (c *Client) allocateAudioBuffers() {
+	c.pcm16 = nil
+	if c.useOpus {
+		c.pcm16 = make([]int16, c.config.FramesPerBuffer*c.config.Channels)
+	}
+	frameBytes := c.config.FramesPerBuffer * c.config.GetFrameSize()
+	c.indexedPayload = make([]byte, SampleIndexSize, SampleIndexSize+frameBytes)
+	c.audioWriter = packetWriter{buf: make([]byte, HeaderSize+SampleIndexSize+frameBytes+packetCipherOverhead), cipher: c.cipher}
+}
+
+// waitForBandwidth applies the -max-bitrate token bucket to a packet of n bytes.
+// Short bursts are delayed; a packet that would wait longer than one buffer is dropped
+// so the capture loop does not fall behind. It returns false if the packet must not be sent
+func (c *Client) waitForBandwidth(n int) bool {
+	bufferDuration := time.Duration(float64(c.config.FramesPerBuffer) / float64(c.config.SampleRate) * float64(time.Second))
+	wait, ok := c.throttle.reserve(n, time.Now(), bufferDuration)
+	if !ok {
+		c.logger.WarnRateLimited("throttle-drop", fmt.Sprintf("🚦 Dropping audio packet: send rate above the %d kbit/s cap", c.config.MaxBitrate/1000))
+		return false
+	}
+	if wait > 0 {
+		c.logger.WarnRateLimited("throttle", fmt.Sprintf("🚦 Throttling: delaying audio packets to stay under %d kbit/s", c.config.MaxBitrate/1000))
+		time.Sleep(wait)
+	}
+	return true
+}
+
 // audioStreamingLoop handles the main audio streaming logic
-func (c *Client) audioStreamingLoop() {
+func (c *Client) audioStreamingLoop(ctx context.Context) {
 	defer c.wg.Done()
 	
-	// 每100ms刷新一次统计信息
+	// 每100ms采样一次统计信息，显示由 logger 按 -stats-interval 限频
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 	
 	for {
 		select {
-		case <-c.stopChan:
-			return
-		case <-GetShutdownChannel():
+		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			// 实时显示统计信息
 			if atomic.LoadInt32(&c.connected) == 1 {
-				networkStats := c.GetStats()
-				
-				var audioStats *utils.AudioStats
-				if c.capturer != nil {
-					audioStats = c.capturer.GetStats()
-				} else {
-					// 创建默认的音频统计
-					audioStats = &utils.AudioStats{
-						FramesProcessed: 0,
-						DroppedFrames:   0,
-						Latency:         0,
-						BufferUsage:     0,
-						DecibelLevel:    -60.0,
-					}
-				}
-				
 				// 使用新的实时统计显示方法
-				c.logger.LogRealTimeStats(networkStats, audioStats)
+				c.logger.LogRealTimeStats(c.GetStats(), c.currentAudioStats())
 			}
 		}
 	}
 }
 
+// currentAudioStats 返回实时统计行与状态页共用的采集统计
+func (c *Client) currentAudioStats() *utils.AudioStats {
+	var audioStats *utils.AudioStats
+	if c.capturer != nil {
+		audioStats = c.capturer.GetStats()
+	} else {
+		// 创建默认的音频统计
+		audioStats = &utils.AudioStats{
+			FramesProcessed: 0,
+			DroppedFrames:   0,
+			Latency:         0,
+			BufferUsage:     0,
+			DecibelLevel:    -60.0,
+		}
+	}
+	
+	audioStats.Muted = c.IsMuted()
+	audioStats.PTT = c.config.PTT
+	audioStats.Transmitting = c.IsTransmitting()
+	return audioStats
+}
+
 // heartbeatLoop sends periodic heartbeat packets
-func (c *Client) heartbeatLoop() {
+func (c *Client) heartbeatLoop(ctx context.Context) {
 	defer c.wg.Done()
 	
 	// 使用配置中的心跳包间隔
@@ -375,9 +950,7 @@ func (c *Client) heartbeatLoop() {
 	
 	for {
 		select {
-		case <-c.stopChan:
-			return
-		case <-GetShutdownChannel():
+		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			if atomic.LoadInt32(&c.connected) == 1 {
@@ -389,15 +962,16 @@ func (c *Client) heartbeatLoop() {
 				c.lastHeartbeatSent = time.Now()
 				c.heartbeatMutex.Unlock()
 				
-				c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
-				if err := WritePacket(c.conn, heartbeatPacket); err != nil {
+				conn := c.controlConnection()
+				conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+				if err := WritePacket(conn, heartbeatPacket); err != nil {
 					if atomic.LoadInt32(&c.connected) == 1 {
 						c.errorChan <- utils.WrapError(err, utils.ErrNetwork, "failed to send heartbeat")
 					}
 				} else {
 					c.lastHeartbeat = time.Now()
 					// 计算 RTT (Round Trip Time)
-					c.stats.RoundTripTime = time.Since(heartbeatStart)
+					atomic.StoreInt64(&c.roundTripTime, int64(time.Since(heartbeatStart)))
 					c.logger.Debug("💓 Heartbeat sent")
 				}
 			}
@@ -406,22 +980,21 @@ func (c *Client) heartbeatLoop() {
 }
 
 // errorHandlingLoop handles errors from other goroutines
-func (c *Client) errorHandlingLoop() {
+func (c *Client) errorHandlingLoop(ctx context.Context) {
 	defer c.wg.Done()
 	
 	for {
 		select {
-		case <-c.stopChan:
-			return
-		case <-GetShutdownChannel():
+		case <-ctx.Done():
 			return
 		case err := <-c.errorChan:
-			c.logger.Error(fmt.Sprintf("Client error: %v", err))
+			c.logger.ErrorRateLimited("client-error", fmt.Sprintf("Client error: %v", err))
 			atomic.AddInt64(&c.stats.ErrorCount, 1)
 			
 			// For critical errors, stop the client
-			if utils.IsErrorType(err, utils.ErrConnection) || utils.IsErrorType(err, utils.ErrNetwork) {
+			if utils.IsErrorType(err, utils.ErrConnection) || utils.IsErrorType(err, utils.ErrNetwork) || errors.Is(err, ErrOpusEncoder) {
 				c.logger.Error("Critical error detected, stopping client...")
+				c.runErr = err
 				go c.Stop()
 				return
 			}
@@ -429,31 +1002,26 @@ func (c *Client) errorHandlingLoop() {
 	}
 }
 
-// packetProcessingLoop processes incoming packets from the server
-func (c *Client) packetProcessingLoop() {
+// packetProcessingLoop processes incoming packets from the server on conn
+func (c *Client) packetProcessingLoop(ctx context.Context, conn net.Conn) {
 	defer c.wg.Done()
 	
 	c.logger.Debug("Starting packet processing loop")
 	
 	for {
 		select {
-		case <-c.stopChan:
-			c.logger.Debug("Packet processing loop stopped by signal")
-			return
-		case <-GetShutdownChannel():
+		case <-ctx.Done():
 			c.logger.Debug("Packet processing loop stopped by shutdown signal")
 			return
 		default:
 			// Continue processing
 		}
 		
-		// Set read timeout
-		c.conn.SetReadDeadline(time.Now().Add(c.config.ReadTimeout))
-		
-		packet, err := ReadPacket(c.conn)
+		// 读超时分别作用于包头和负载
+		packet, err := ReadPacketWithTimeout(conn, c.config.PacketReadTimeout(), 0)
 		if err != nil {
 			if atomic.LoadInt32(&c.connected) == 1 {
-				c.logger.Error(fmt.Sprintf("Failed to read packet: %v", err))
+				c.logger.ErrorRateLimited("read-packet", fmt.Sprintf("Failed to read packet: %v", err))
 				c.errorChan <- utils.WrapError(err, utils.ErrNetwork, "failed to read packet")
 			}
 			return
@@ -475,6 +1043,17 @@ func (c *Client) packetProcessingLoop() {
 			errorMessage := string(packet.Payload)
 			c.logger.Error(fmt.Sprintf("Server error: %s", errorMessage))
 			
+		case PacketTypeControl:
+			if len(packet.Payload) > 0 && packet.Payload[0] == ControlGoodbye {
+				// 服务端主动结束会话（-max-session / -idle-timeout）：正常停止，不当作连接错误
+				c.logger.Infof("👋 Server ended the session: %s", packet.Payload[1:])
+				// 先清零 connected，服务端随后关闭连接引起的读写错误不再上报；清理由关闭回调中的 Stop 完成
+				atomic.StoreInt32(&c.connected, 0)
+				c.shutdown.NotifyShutdown()
+				return
+			}
+			c.logger.Warnf("Unknown control packet received: %#x", packet.Payload)
+			
 		default:
 			c.logger.Warnf("Unknown packet type received: %s", packet.Header.Type)
 		}
@@ -491,7 +1070,7 @@ func (c *Client) GetStats() *utils.NetworkStats {
 	return &utils.NetworkStats{
 		BytesSent:      atomic.LoadInt64(&c.stats.BytesSent),
 		BytesReceived:  atomic.LoadInt64(&c.stats.BytesReceived),
-		RoundTripTime:  c.stats.RoundTripTime,
+		RoundTripTime:  time.Duration(atomic.LoadInt64(&c.roundTripTime)),
 		ErrorCount:     atomic.LoadInt64(&c.stats.ErrorCount),
 	}
 }
\ No newline at end of file
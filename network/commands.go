@@ -0,0 +1,88 @@
+// network/commands.go - 会话运行时的终端命令（q/s/v/m）
+
+package network
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"RemoteAudioCLI/utils"
+)
+
+// runtimeCommands 运行时命令的处理函数，由客户端/服务端分别提供
+type runtimeCommands struct {
+	quit   func()
+	stats  func()
+	volume func(percent int)
+	mute   func()
+	// 按键通话：输入 pttKey 切换发送（nil 表示未启用 -ptt）
+	ptt    func()
+	pttKey string
+}
+
+// builtinCommands 内置命令名，-ptt-key 不能与之冲突
+var builtinCommands = []string{"q", "quit", "s", "stats", "m", "mute", "v", "volume", "h", "help", "?"}
+
+// ValidatePTTKey checks that a -ptt-key is a single word that does not shadow a built-in command
+func ValidatePTTKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " \t") || strings.ToLower(key) != key {
+		return fmt.Errorf("push-to-talk key %q must be a single lower-case word", key)
+	}
+	for _, command := range builtinCommands {
+		if key == command {
+			return fmt.Errorf("push-to-talk key %q is already a runtime command", key)
+		}
+	}
+	return nil
+}
+
+// runtimeCommandsHelp 启动命令读取后打印的提示
+const runtimeCommandsHelp = "💡 Commands (press Enter): q = quit, s = stats summary, v <0-100> = volume, m = toggle mute, h = help"
+
+// startCommandPrompt 在标准输入为终端时启动命令读取；不是终端时返回 nil
+func startCommandPrompt(logger *utils.Logger, commands runtimeCommands) *utils.CommandReader {
+	reader, err := utils.StartCommandReader(func(command string, args []string) {
+		commands.dispatch(logger, command, args)
+	})
+	if err != nil {
+		logger.Debugf("Runtime commands unavailable: %v", err)
+		return nil
+	}
+	logger.Info(runtimeCommandsHelp)
+	if commands.ptt != nil {
+		logger.Infof("🎙️ Push-to-talk: type %s and Enter to start/stop transmitting", commands.pttKey)
+	}
+	return reader
+}
+
+// dispatch 执行一条命令；无法识别或参数错误时打印提示
+func (rc runtimeCommands) dispatch(logger *utils.Logger, command string, args []string) {
+	if rc.ptt != nil && command == rc.pttKey {
+		rc.ptt()
+		return
+	}
+	switch command {
+	case "q", "quit":
+		rc.quit()
+	case "s", "stats":
+		rc.stats()
+	case "m", "mute":
+		rc.mute()
+	case "v", "volume":
+		if len(args) != 1 {
+			logger.Warn("Usage: v <0-100>")
+			return
+		}
+		percent, err := strconv.Atoi(args[0])
+		if err != nil || percent < 0 || percent > 100 {
+			logger.Warnf("Invalid volume %q: must be 0-100", args[0])
+			return
+		}
+		rc.volume(percent)
+	case "h", "help", "?":
+		logger.Info(runtimeCommandsHelp)
+	default:
+		logger.Warnf("Unknown command %q (h for help)", command)
+	}
+}
@@ -0,0 +1,39 @@
+package network
+
+import (
+	"testing"
+
+	"RemoteAudioCLI/utils"
+)
+
+func TestValidatePTTKey(t *testing.T) {
+	for _, key := range []string{"t", "ptt", "x1"} {
+		if err := ValidatePTTKey(key); err != nil {
+			t.Errorf("ValidatePTTKey(%q) = %v, want nil", key, err)
+		}
+	}
+	for _, key := range []string{"", "m", "quit", "?", "T", "a b"} {
+		if err := ValidatePTTKey(key); err == nil {
+			t.Errorf("ValidatePTTKey(%q): expected an error", key)
+		}
+	}
+}
+
+// TestDispatchPTT 按键通话键优先于内置命令分派，未启用 -ptt 时按未知命令处理
+func TestDispatchPTT(t *testing.T) {
+	logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+	toggles, mutes := 0, 0
+	commands := runtimeCommands{mute: func() { mutes++ }, ptt: func() { toggles++ }, pttKey: "t"}
+	commands.dispatch(logger, "t", nil)
+	commands.dispatch(logger, "m", nil)
+	commands.dispatch(logger, "t", nil)
+	if toggles != 2 || mutes != 1 {
+		t.Fatalf("ptt toggled %d times, mute %d times; want 2 and 1", toggles, mutes)
+	}
+
+	commands.ptt = nil
+	commands.dispatch(logger, "t", nil)
+	if toggles != 2 {
+		t.Fatalf("ptt key dispatched without -ptt")
+	}
+}
@@ -3,66 +3,98 @@
 package network
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"RemoteAudioCLI/utils"
 )
 
-// ConnectionManager 管理连接状态和优雅关闭
+// ConnectionManager 管理一次 Serve/Run 的生命周期：关闭信号基于 context，
+// 父 context 取消或调用 NotifyShutdown 都会触发关闭；每次运行创建新的实例
 type ConnectionManager struct {
-	shutdownRequested int32
+	ctx               context.Context
+	cancel            context.CancelFunc
 	activeConnections int32
-	shutdownChan      chan struct{}
 	mutex             sync.RWMutex
 	onShutdown        []func()
 }
 
-var globalConnectionManager = &ConnectionManager{
-	shutdownChan: make(chan struct{}),
-	onShutdown:   make([]func(), 0),
-}
+// NewConnectionManager creates a connection manager that shuts down when parent is cancelled
+func NewConnectionManager(parent context.Context) *ConnectionManager {
+	ctx, cancel := context.WithCancel(parent)
+	cm := &ConnectionManager{
+		ctx:        ctx,
+		cancel:     cancel,
+		onShutdown: make([]func(), 0),
+	}
 
-// NotifyShutdown 通知所有连接开始关闭
-func NotifyShutdown() {
-	if atomic.CompareAndSwapInt32(&globalConnectionManager.shutdownRequested, 0, 1) {
-		close(globalConnectionManager.shutdownChan)
-		
-		// 执行所有注册的关闭回调
-		globalConnectionManager.mutex.RLock()
-		for _, callback := range globalConnectionManager.onShutdown {
+	// 关闭时执行所有注册的关闭回调
+	go func() {
+		<-ctx.Done()
+		cm.mutex.RLock()
+		for _, callback := range cm.onShutdown {
 			go callback()
 		}
-		globalConnectionManager.mutex.RUnlock()
-	}
+		cm.mutex.RUnlock()
+	}()
+
+	return cm
+}
+
+// NotifyShutdown 通知所有连接开始关闭（可重复调用）
+func (cm *ConnectionManager) NotifyShutdown() {
+	cm.cancel()
 }
 
 // IsShutdownRequested 检查是否请求关闭
-func IsShutdownRequested() bool {
-	return atomic.LoadInt32(&globalConnectionManager.shutdownRequested) == 1
+func (cm *ConnectionManager) IsShutdownRequested() bool {
+	return cm.ctx.Err() != nil
 }
 
 // RegisterShutdownCallback 注册关闭回调
-func RegisterShutdownCallback(callback func()) {
-	globalConnectionManager.mutex.Lock()
-	globalConnectionManager.onShutdown = append(globalConnectionManager.onShutdown, callback)
-	globalConnectionManager.mutex.Unlock()
+func (cm *ConnectionManager) RegisterShutdownCallback(callback func()) {
+	cm.mutex.Lock()
+	cm.onShutdown = append(cm.onShutdown, callback)
+	cm.mutex.Unlock()
 }
 
-// GetShutdownChannel 获取关闭信号通道
-func GetShutdownChannel() <-chan struct{} {
-	return globalConnectionManager.shutdownChan
+// Context 返回本次运行的 context，关闭时被取消
+func (cm *ConnectionManager) Context() context.Context {
+	return cm.ctx
+}
+
+// ShutdownChannel 获取关闭信号通道
+func (cm *ConnectionManager) ShutdownChannel() <-chan struct{} {
+	return cm.ctx.Done()
 }
 
 // IncrementConnections 增加活跃连接数
-func IncrementConnections() {
-	atomic.AddInt32(&globalConnectionManager.activeConnections, 1)
+func (cm *ConnectionManager) IncrementConnections() {
+	atomic.AddInt32(&cm.activeConnections, 1)
 }
 
 // DecrementConnections 减少活跃连接数
-func DecrementConnections() {
-	atomic.AddInt32(&globalConnectionManager.activeConnections, -1)
+func (cm *ConnectionManager) DecrementConnections() {
+	atomic.AddInt32(&cm.activeConnections, -1)
+}
+
+// ActiveConnections 获取活跃连接数
+func (cm *ConnectionManager) ActiveConnections() int32 {
+	return atomic.LoadInt32(&cm.activeConnections)
 }
 
-// GetActiveConnections 获取活跃连接数
-func GetActiveConnections() int32 {
-	return atomic.LoadInt32(&globalConnectionManager.activeConnections)
-}
\ No newline at end of file
+// scheduleShutdown 在指定时长后触发正常关闭流程（用于 -duration）
+func (cm *ConnectionManager) scheduleShutdown(duration time.Duration, logger *utils.Logger) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		logger.Infof("⏲️ Run duration of %v elapsed, shutting down...", duration)
+		cm.NotifyShutdown()
+	case <-cm.ctx.Done():
+		// 已经因其他原因（如 Ctrl+C）关闭
+	}
+}
@@ -0,0 +1,240 @@
+// network/crypto.go - -psk：用预共享口令派生的会话密钥对音频负载做 AES-GCM 加密与认证（包头保持明文）
+
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// PacketFlagEncrypted marks an audio packet whose payload is sealed with the session key
+// (AES-256-GCM, the header as additional data); the payload grows by packetCipherOverhead
+const PacketFlagEncrypted uint8 = 0x02
+
+// HandshakeFlagEncrypted asks for (client) or confirms (server) -psk payload encryption.
+// The handshake then carries PSKSalt, PSKCheck and PSKNonce after the session token field.
+const HandshakeFlagEncrypted uint8 = 0x04
+
+const (
+	pskSaltSize  = 16 // 客户端每次握手随机生成的盐，使每个会话的密钥不同
+	pskCheckSize = 16 // 证明己方知道口令的校验值，口令不同时在握手阶段即可发现
+	pskNonceSize = 16 // 服务端每次握手随机生成，与盐一同派生会话密钥，使录制的会话无法重放
+
+	// pskIterations PBKDF2-HMAC-SHA256 迭代次数，每次握手各算一次
+	pskIterations = 100000
+
+	// packetCipherOverhead AES-GCM 认证标签的长度
+	packetCipherOverhead = 16
+)
+
+// ErrPacketAuth is returned when an encrypted audio packet fails authentication
+var ErrPacketAuth = errors.New("audio packet failed authentication (wrong key or tampered data)")
+
+// ErrPacketReplay is returned for an authentic audio packet whose sequence number was
+// already accepted in this session or is too old for the replay window
+var ErrPacketReplay = errors.New("audio packet replayed")
+
+// packetCipher seals and opens audio payloads of one session. The nonce is the packet
+// sequence number, which the sender never reuses within a session; every handshake derives
+// a new key from the client's fresh salt and the server's fresh nonce, so nonces do not
+// repeat across sessions and a recorded session cannot be replayed into a new one.
+type packetCipher struct {
+	aead         cipher.AEAD
+	sessionNonce [pskNonceSize]byte
+	serverCheck  [pskCheckSize]byte
+}
+
+// pskKeys holds what both sides derive from the passphrase and the client salt before the
+// server nonce is known: the input key for the session key and the handshake check key
+type pskKeys struct {
+	salt        [pskSaltSize]byte
+	secret      []byte
+	checkKey    []byte
+	clientCheck [pskCheckSize]byte
+}
+
+// newPSKKeys runs PBKDF2 over the passphrase and salt and computes the client's check
+func newPSKKeys(passphrase string, salt [pskSaltSize]byte) *pskKeys {
+	keys := pbkdf2SHA256([]byte(passphrase), salt[:], pskIterations, 64)
+	k := &pskKeys{salt: salt, secret: keys[:32], checkKey: keys[32:]}
+	k.clientCheck = k.check("client", salt[:])
+	return k
+}
+
+// check 以口令派生的校验密钥对角色与握手随机值做 HMAC
+func (k *pskKeys) check(role string, values ...[]byte) (out [pskCheckSize]byte) {
+	mac := hmac.New(sha256.New, k.checkKey)
+	mac.Write([]byte("RemoteAudioCLI psk check " + role))
+	for _, v := range values {
+		mac.Write(v)
+	}
+	copy(out[:], mac.Sum(nil))
+	return out
+}
+
+// sessionCipher derives the session key with HKDF over the PBKDF2 output, salted with the
+// client salt and the server nonce, and the server's check over both values
+func (k *pskKeys) sessionCipher(nonce [pskNonceSize]byte) (*packetCipher, error) {
+	salt := append(append([]byte(nil), k.salt[:]...), nonce[:]...)
+	block, err := aes.NewCipher(hkdfSHA256(k.secret, salt, []byte("RemoteAudioCLI session key"), 32))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &packetCipher{aead: aead, sessionNonce: nonce, serverCheck: k.check("server", k.salt[:], nonce[:])}, nil
+}
+
+// newPSKNonce 生成服务端在握手回复中下发的随机值
+func newPSKNonce() ([pskNonceSize]byte, error) {
+	var nonce [pskNonceSize]byte
+	_, err := rand.Read(nonce[:])
+	return nonce, err
+}
+
+// newPSKSalt 生成握手用的随机盐
+func newPSKSalt() ([pskSaltSize]byte, error) {
+	var salt [pskSaltSize]byte
+	_, err := rand.Read(salt[:])
+	return salt, err
+}
+
+// checkEqual 以常数时间比较握手校验值
+func checkEqual(a, b [pskCheckSize]byte) bool {
+	return hmac.Equal(a[:], b[:])
+}
+
+// nonce 由包序列号构成；完整的包头另作为附加数据参与认证
+func (pc *packetCipher) nonce(sequence uint32) []byte {
+	nonce := make([]byte, pc.aead.NonceSize())
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], sequence)
+	return nonce
+}
+
+// seal encrypts payload into dst (appending) with header, already encoded with the final
+// PayloadSize and PacketFlagEncrypted, as additional data
+func (pc *packetCipher) seal(dst, header, payload []byte, sequence uint32) []byte {
+	return pc.aead.Seal(dst, pc.nonce(sequence), payload, header)
+}
+
+// open authenticates and decrypts an encrypted audio packet in place, restoring the
+// plaintext PayloadSize and clearing PacketFlagEncrypted
+func (pc *packetCipher) open(packet *Packet) error {
+	if packet.Header.Flags&PacketFlagEncrypted == 0 {
+		return fmt.Errorf("%w: unencrypted audio packet in an encrypted session", ErrPacketAuth)
+	}
+	header := make([]byte, HeaderSize)
+	encodeHeader(header, &packet.Header)
+	plain, err := pc.aead.Open(packet.Payload[:0], pc.nonce(packet.Header.Sequence), packet.Payload, header)
+	if err != nil {
+		return ErrPacketAuth
+	}
+	packet.Payload = plain
+	packet.Header.PayloadSize = uint32(len(plain))
+	packet.Header.Flags &^= PacketFlagEncrypted
+	return nil
+}
+
+// replayWindow remembers which of the last size sequence numbers up to the highest accepted
+// one have been seen, like the IPsec anti-replay window. Captured ciphertext re-sent within
+// the session therefore fails even though it authenticates.
+type replayWindow struct {
+	size    uint32
+	highest uint32
+	started bool
+	seen    []uint64 // 以 sequence % size 为下标的位图
+}
+
+// newReplayWindow creates a window of size sequence numbers (at least 1)
+func newReplayWindow(size int) *replayWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &replayWindow{size: uint32(size), seen: make([]uint64, (size+63)/64)}
+}
+
+func (w *replayWindow) bit(sequence uint32) (int, uint64) {
+	index := sequence % w.size
+	return int(index / 64), 1 << (index % 64)
+}
+
+// accept records sequence, or returns an ErrPacketReplay error if it must be dropped
+func (w *replayWindow) accept(sequence uint32) error {
+	switch {
+	case !w.started:
+		w.started = true
+		w.highest = sequence
+	case sequence > w.highest:
+		// 窗口前移：清除滑出窗口的序列号位置
+		if sequence-w.highest >= w.size {
+			for i := range w.seen {
+				w.seen[i] = 0
+			}
+		} else {
+			for s := w.highest + 1; s != sequence+1; s++ {
+				word, mask := w.bit(s)
+				w.seen[word] &^= mask
+			}
+		}
+		w.highest = sequence
+	case w.highest-sequence >= w.size:
+		return fmt.Errorf("%w: sequence is %d packets behind the newest, outside the %d-packet replay window",
+			ErrPacketReplay, w.highest-sequence, w.size)
+	}
+	word, mask := w.bit(sequence)
+	if w.seen[word]&mask != 0 {
+		return fmt.Errorf("%w: sequence already received", ErrPacketReplay)
+	}
+	w.seen[word] |= mask
+	return nil
+}
+
+// hkdfSHA256 implements HKDF (RFC 5869) with HMAC-SHA256
+func hkdfSHA256(secret, salt, info []byte, keyLen int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prf := hmac.New(sha256.New, extract.Sum(nil))
+	var key, t []byte
+	for counter := byte(1); len(key) < keyLen; counter++ {
+		prf.Reset()
+		prf.Write(t)
+		prf.Write(info)
+		prf.Write([]byte{counter})
+		t = prf.Sum(nil)
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// pbkdf2SHA256 implements PBKDF2 (RFC 8018) with HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	var counter [4]byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
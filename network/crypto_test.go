@@ -0,0 +1,173 @@
+package network
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// TestPBKDF2SHA256 RFC 7914 第 11 节的 PBKDF2-HMAC-SHA256 测试向量
+func TestPBKDF2SHA256(t *testing.T) {
+	tests := []struct {
+		iterations int
+		want       string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte("password"), []byte("salt"), tt.iterations, 32))
+		if got != tt.want {
+			t.Errorf("%d iterations: got %s, want %s", tt.iterations, got, tt.want)
+		}
+	}
+}
+
+// TestHKDFSHA256 RFC 5869 附录 A.1 的测试向量
+func TestHKDFSHA256(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	got := hex.EncodeToString(hkdfSHA256(secret, salt, info, 42))
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestSessionCipherDependsOnServerNonce(t *testing.T) {
+	salt := [pskSaltSize]byte{1, 2, 3}
+	client := newPSKKeys("correct horse", salt)
+	server := newPSKKeys("correct horse", salt)
+	if client.clientCheck != server.clientCheck {
+		t.Fatalf("client checks differ for the same passphrase and salt")
+	}
+	a, _ := client.sessionCipher([pskNonceSize]byte{7})
+	b, _ := server.sessionCipher([pskNonceSize]byte{7})
+	c, _ := server.sessionCipher([pskNonceSize]byte{8})
+	if a.serverCheck != b.serverCheck || a.serverCheck == c.serverCheck {
+		t.Fatalf("server check does not follow the nonce")
+	}
+	sealed := a.seal(nil, make([]byte, HeaderSize), []byte("audio"), 1)
+	if _, err := b.aead.Open(nil, b.nonce(1), sealed, make([]byte, HeaderSize)); err != nil {
+		t.Fatalf("same nonce: %v", err)
+	}
+	if _, err := c.aead.Open(nil, c.nonce(1), sealed, make([]byte, HeaderSize)); err == nil {
+		t.Fatalf("payload sealed for one server nonce opened with another")
+	}
+}
+
+func TestEncryptedAudioPacketRoundTrip(t *testing.T) {
+	salt := [pskSaltSize]byte{1, 2, 3}
+	pc, err := newPSKKeys("correct horse", salt).sessionCipher([pskNonceSize]byte{4, 5, 6})
+	if err != nil {
+		t.Fatalf("sessionCipher: %v", err)
+	}
+	payload := []byte("audio payload")
+	packet := NewAudioPacket(payload, 7)
+	packet.Header.Flags = PacketFlagSampleIndex
+
+	w := packetWriter{cipher: pc}
+	var buf bytes.Buffer
+	if err := w.WritePacket(&buf, packet); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	data := buf.Bytes()
+	if len(data) != HeaderSize+len(payload)+packetCipherOverhead || bytes.Contains(data, payload) {
+		t.Fatalf("encrypted packet has %d bytes or contains the plaintext", len(data))
+	}
+
+	// 包头保持明文，负载认证通过后还原
+	decoded, err := ReadPacket(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if decoded.Header.Sequence != 7 || decoded.Header.Flags != PacketFlagSampleIndex|PacketFlagEncrypted {
+		t.Fatalf("header = %+v", decoded.Header)
+	}
+	if err := pc.open(decoded); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if !bytes.Equal(decoded.Payload, payload) || decoded.Header != packet.Header {
+		t.Fatalf("decrypted %q %+v, want %q %+v", decoded.Payload, decoded.Header, payload, packet.Header)
+	}
+
+	// 篡改负载或包头、使用其他口令、或未加密的包都不能通过
+	tampered := func(offset int) *Packet {
+		corrupt := append([]byte(nil), data...)
+		corrupt[offset] ^= 0x01
+		p, err := ReadPacket(bytes.NewReader(corrupt))
+		if err != nil {
+			t.Fatalf("ReadPacket: %v", err)
+		}
+		return p
+	}
+	other, _ := newPSKKeys("wrong horse", salt).sessionCipher([pskNonceSize]byte{4, 5, 6})
+	intact, _ := ReadPacket(bytes.NewReader(data))
+	plain, _ := ReadPacket(bytes.NewReader(encodePacket(t, packet)))
+	cases := []struct {
+		name   string
+		cipher *packetCipher
+		packet *Packet
+	}{
+		{"payload", pc, tampered(HeaderSize)},
+		{"sequence", pc, tampered(11)},
+		{"timestamp", pc, tampered(23)},
+		{"other key", other, intact},
+		{"plaintext", pc, plain},
+	}
+	for _, c := range cases {
+		if err := c.cipher.open(c.packet); !errors.Is(err, ErrPacketAuth) {
+			t.Errorf("%s: got %v, want ErrPacketAuth", c.name, err)
+		}
+	}
+}
+
+func TestHandshakeConfigPSKRoundTrip(t *testing.T) {
+	for _, token := range [][SessionTokenSize]byte{{}, {9, 9}} {
+		original := HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4,
+			Flags: HandshakeFlagEncrypted | HandshakeFlagControlChannel, SessionToken: token,
+			PSKSalt: [pskSaltSize]byte{1, 2}, PSKCheck: [pskCheckSize]byte{3, 4}, PSKNonce: [pskNonceSize]byte{5, 6}}
+		var decoded HandshakeConfig
+		if err := decoded.FromBytes(original.ToBytes()); err != nil {
+			t.Fatalf("FromBytes: %v", err)
+		}
+		if decoded != original {
+			t.Fatalf("got %+v, want %+v", decoded, original)
+		}
+	}
+	truncated := (&HandshakeConfig{Flags: HandshakeFlagEncrypted}).ToBytes()
+	var decoded HandshakeConfig
+	if err := decoded.FromBytes(truncated[:len(truncated)-1]); err == nil {
+		t.Fatalf("truncated encrypted handshake accepted")
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	w := newReplayWindow(4)
+	steps := []struct {
+		sequence uint32
+		accept   bool
+	}{
+		{10, true},
+		{10, false}, // 重放
+		{12, true},
+		{11, true}, // 窗口内迟到的包
+		{11, false},
+		{9, true},
+		{8, false}, // 落在窗口之外
+		{20, true}, // 跳跃后旧位置全部清除
+		{17, true},
+		{16, false},
+		{20, false},
+		{21, true},
+		{17, false},
+	}
+	for i, step := range steps {
+		err := w.accept(step.sequence)
+		if (err == nil) != step.accept || (err != nil && !errors.Is(err, ErrPacketReplay)) {
+			t.Fatalf("step %d: accept(%d) = %v, want accepted %v", i, step.sequence, err, step.accept)
+		}
+	}
+}
@@ -0,0 +1,618 @@
+// network/flac.go - FLAC 无损压缩（每个音频包编码为一个独立的 FLAC 帧）
+
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// flacEncoder encodes interleaved little-endian PCM frames as FLAC frames.
+//
+// Each packet carries exactly one FLAC frame (no STREAMINFO; the stream format
+// comes from the handshake). Subframes use the best FIXED predictor (order 0-4)
+// with Rice-coded residuals, CONSTANT for digital silence, or VERBATIM when
+// prediction does not help. Channels are coded independently.
+type flacEncoder struct {
+	sampleRate  int
+	channels    int
+	bitDepth    int
+	frameNumber uint64
+
+	samples  [][]int64 // 按声道拆分的采样
+	residual []int64
+}
+
+// newFLACEncoder creates a FLAC frame encoder (bit depth 16 or 32)
+func newFLACEncoder(sampleRate, channels, bitDepth int) (*flacEncoder, error) {
+	if bitDepth != 16 && bitDepth != 32 {
+		return nil, fmt.Errorf("FLAC: unsupported bit depth: %d", bitDepth)
+	}
+	if channels < 1 || channels > 8 {
+		return nil, fmt.Errorf("FLAC: unsupported channel count: %d", channels)
+	}
+	return &flacEncoder{
+		sampleRate: sampleRate,
+		channels:   channels,
+		bitDepth:   bitDepth,
+		samples:    make([][]int64, channels),
+	}, nil
+}
+
+// Encode encodes one block of interleaved PCM into a FLAC frame
+func (e *flacEncoder) Encode(pcm []byte) ([]byte, error) {
+	bytesPerSample := e.bitDepth / 8
+	blockSize := len(pcm) / (bytesPerSample * e.channels)
+	if blockSize == 0 || blockSize > 65536 {
+		return nil, fmt.Errorf("FLAC: invalid block size: %d", blockSize)
+	}
+
+	// 解交织
+	for ch := 0; ch < e.channels; ch++ {
+		if cap(e.samples[ch]) < blockSize {
+			e.samples[ch] = make([]int64, blockSize)
+		}
+		e.samples[ch] = e.samples[ch][:blockSize]
+	}
+	for i := 0; i < blockSize; i++ {
+		for ch := 0; ch < e.channels; ch++ {
+			offset := (i*e.channels + ch) * bytesPerSample
+			if e.bitDepth == 16 {
+				e.samples[ch][i] = int64(int16(binary.LittleEndian.Uint16(pcm[offset:])))
+			} else {
+				e.samples[ch][i] = int64(int32(binary.LittleEndian.Uint32(pcm[offset:])))
+			}
+		}
+	}
+
+	w := &flacBitWriter{}
+	e.writeFrameHeader(w, blockSize)
+	for ch := 0; ch < e.channels; ch++ {
+		e.writeSubframe(w, e.samples[ch])
+	}
+	w.alignToByte()
+
+	crc := flacCRC16(w.buf)
+	w.writeBits(uint64(crc), 16)
+	e.frameNumber++
+
+	return w.buf, nil
+}
+
+// writeFrameHeader 写入帧头（固定块大小策略，帧号 UTF-8 编码，最后是 CRC-8）
+func (e *flacEncoder) writeFrameHeader(w *flacBitWriter, blockSize int) {
+	w.writeBits(0x3FFE, 14) // sync code
+	w.writeBits(0, 1)       // reserved
+	w.writeBits(0, 1)       // fixed blocksize
+	w.writeBits(0x7, 4)     // blocksize: 16 bit (blocksize-1) at end of header
+
+	rateCode, rateExtra, rateExtraBits := flacSampleRateCode(e.sampleRate)
+	w.writeBits(uint64(rateCode), 4)
+	w.writeBits(uint64(e.channels-1), 4) // independent channels
+	w.writeBits(uint64(flacSampleSizeCode(e.bitDepth)), 3)
+	w.writeBits(0, 1) // reserved
+
+	for _, b := range flacUTF8(e.frameNumber) {
+		w.writeBits(uint64(b), 8)
+	}
+	w.writeBits(uint64(blockSize-1), 16)
+	if rateExtraBits > 0 {
+		w.writeBits(uint64(rateExtra), rateExtraBits)
+	}
+
+	w.writeBits(uint64(flacCRC8(w.buf)), 8)
+}
+
+// writeSubframe 选择代价最小的子帧类型并写入
+func (e *flacEncoder) writeSubframe(w *flacBitWriter, samples []int64) {
+	// 数字静音或直流：CONSTANT
+	constant := true
+	for _, s := range samples[1:] {
+		if s != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		w.writeBits(0, 1)
+		w.writeBits(0x00, 6)
+		w.writeBits(0, 1)
+		w.writeSigned(samples[0], e.bitDepth)
+		return
+	}
+
+	// 选择残差绝对值之和最小的 FIXED 预测阶数
+	maxOrder := 4
+	if len(samples) <= maxOrder {
+		maxOrder = len(samples) - 1
+	}
+	bestOrder := 0
+	var bestSum uint64
+	for order := 0; order <= maxOrder; order++ {
+		var sum uint64
+		for i := order; i < len(samples); i++ {
+			r := flacFixedResidual(samples, i, order)
+			if r < 0 {
+				r = -r
+			}
+			sum += uint64(r)
+		}
+		if order == 0 || sum < bestSum {
+			bestOrder, bestSum = order, sum
+		}
+	}
+
+	if cap(e.residual) < len(samples) {
+		e.residual = make([]int64, len(samples))
+	}
+	residual := e.residual[:len(samples)-bestOrder]
+	for i := range residual {
+		residual[i] = flacFixedResidual(samples, i+bestOrder, bestOrder)
+	}
+	riceParam, riceBits := flacBestRiceParam(residual)
+
+	// 预测无收益时使用 VERBATIM
+	verbatimBits := uint64(len(samples) * e.bitDepth)
+	fixedBits := uint64(bestOrder*e.bitDepth) + 6 + 5 + riceBits
+	if fixedBits >= verbatimBits {
+		w.writeBits(0, 1)
+		w.writeBits(0x01, 6)
+		w.writeBits(0, 1)
+		for _, s := range samples {
+			w.writeSigned(s, e.bitDepth)
+		}
+		return
+	}
+
+	w.writeBits(0, 1)
+	w.writeBits(uint64(0x08|bestOrder), 6)
+	w.writeBits(0, 1)
+	for i := 0; i < bestOrder; i++ {
+		w.writeSigned(samples[i], e.bitDepth)
+	}
+
+	// 残差：RICE2（5 位参数），分区阶数 0
+	w.writeBits(0x1, 2)
+	w.writeBits(0, 4)
+	w.writeBits(uint64(riceParam), 5)
+	for _, r := range residual {
+		u := flacZigZag(r)
+		w.writeUnary(u >> riceParam)
+		if riceParam > 0 {
+			w.writeBits(u&(1<<riceParam-1), riceParam)
+		}
+	}
+}
+
+// flacDecoder decodes FLAC frames produced by flacEncoder back into interleaved little-endian PCM
+type flacDecoder struct {
+	channels int
+	bitDepth int
+	samples  [][]int64
+}
+
+// newFLACDecoder creates a FLAC frame decoder for the negotiated format
+func newFLACDecoder(channels, bitDepth int) (*flacDecoder, error) {
+	if bitDepth != 16 && bitDepth != 32 {
+		return nil, fmt.Errorf("FLAC: unsupported bit depth: %d", bitDepth)
+	}
+	return &flacDecoder{
+		channels: channels,
+		bitDepth: bitDepth,
+		samples:  make([][]int64, channels),
+	}, nil
+}
+
+// Decode decodes one FLAC frame
+func (d *flacDecoder) Decode(frame []byte) ([]byte, error) {
+	if len(frame) < 8 {
+		return nil, fmt.Errorf("FLAC: frame too short: %d bytes", len(frame))
+	}
+	if flacCRC16(frame[:len(frame)-2]) != binary.BigEndian.Uint16(frame[len(frame)-2:]) {
+		return nil, fmt.Errorf("FLAC: frame CRC mismatch")
+	}
+
+	r := &flacBitReader{buf: frame[:len(frame)-2]}
+	blockSize, err := d.readFrameHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for ch := 0; ch < d.channels; ch++ {
+		if cap(d.samples[ch]) < blockSize {
+			d.samples[ch] = make([]int64, blockSize)
+		}
+		d.samples[ch] = d.samples[ch][:blockSize]
+		if err := d.readSubframe(r, d.samples[ch]); err != nil {
+			return nil, fmt.Errorf("FLAC: channel %d: %w", ch, err)
+		}
+	}
+
+	// 交织为小端 PCM
+	bytesPerSample := d.bitDepth / 8
+	pcm := make([]byte, blockSize*d.channels*bytesPerSample)
+	for i := 0; i < blockSize; i++ {
+		for ch := 0; ch < d.channels; ch++ {
+			offset := (i*d.channels + ch) * bytesPerSample
+			if d.bitDepth == 16 {
+				binary.LittleEndian.PutUint16(pcm[offset:], uint16(int16(d.samples[ch][i])))
+			} else {
+				binary.LittleEndian.PutUint32(pcm[offset:], uint32(int32(d.samples[ch][i])))
+			}
+		}
+	}
+	return pcm, nil
+}
+
+// readFrameHeader 解析并校验帧头，返回块大小
+func (d *flacDecoder) readFrameHeader(r *flacBitReader) (int, error) {
+	if r.readBits(14) != 0x3FFE {
+		return 0, fmt.Errorf("FLAC: invalid frame sync code")
+	}
+	r.readBits(2) // reserved + blocking strategy
+	blockSizeCode := r.readBits(4)
+	rateCode := r.readBits(4)
+	channelCode := int(r.readBits(4))
+	sampleSizeCode := int(r.readBits(3))
+	r.readBits(1)
+
+	if channelCode+1 != d.channels {
+		return 0, fmt.Errorf("FLAC: unexpected channel assignment %d for %d channels", channelCode, d.channels)
+	}
+	if sampleSizeCode != flacSampleSizeCode(d.bitDepth) {
+		return 0, fmt.Errorf("FLAC: unexpected sample size code %d", sampleSizeCode)
+	}
+
+	// 帧号（UTF-8 编码）
+	first := r.readBits(8)
+	for mask := uint64(0x80); mask > 0x01 && first&mask != 0 && first&0x40 != 0; mask >>= 1 {
+		r.readBits(8)
+		first <<= 1
+	}
+
+	var blockSize int
+	switch blockSizeCode {
+	case 0x6:
+		blockSize = int(r.readBits(8)) + 1
+	case 0x7:
+		blockSize = int(r.readBits(16)) + 1
+	default:
+		return 0, fmt.Errorf("FLAC: unsupported block size code %d", blockSizeCode)
+	}
+
+	switch rateCode {
+	case 0xC:
+		r.readBits(8)
+	case 0xD, 0xE:
+		r.readBits(16)
+	}
+
+	headerLen := r.pos / 8
+	crc := uint8(r.readBits(8))
+	if r.err != nil {
+		return 0, fmt.Errorf("FLAC: truncated frame header")
+	}
+	if flacCRC8(r.buf[:headerLen]) != crc {
+		return 0, fmt.Errorf("FLAC: frame header CRC mismatch")
+	}
+	return blockSize, nil
+}
+
+// readSubframe 解码一个声道的子帧
+func (d *flacDecoder) readSubframe(r *flacBitReader, samples []int64) error {
+	r.readBits(1)
+	subframeType := r.readBits(6)
+	if r.readBits(1) != 0 {
+		return fmt.Errorf("wasted bits are not supported")
+	}
+
+	switch {
+	case subframeType == 0x00:
+		value := r.readSigned(d.bitDepth)
+		for i := range samples {
+			samples[i] = value
+		}
+	case subframeType == 0x01:
+		for i := range samples {
+			samples[i] = r.readSigned(d.bitDepth)
+		}
+	case subframeType >= 0x08 && subframeType <= 0x0C:
+		order := int(subframeType & 0x07)
+		if order > len(samples) {
+			return fmt.Errorf("predictor order %d exceeds block size", order)
+		}
+		for i := 0; i < order; i++ {
+			samples[i] = r.readSigned(d.bitDepth)
+		}
+		if err := d.readResidual(r, samples, order); err != nil {
+			return err
+		}
+		for i := order; i < len(samples); i++ {
+			samples[i] += flacFixedPrediction(samples, i, order)
+		}
+	default:
+		return fmt.Errorf("unsupported subframe type 0x%02x", subframeType)
+	}
+
+	if r.err != nil {
+		return fmt.Errorf("truncated subframe")
+	}
+	return nil
+}
+
+// readResidual 解码 Rice 残差（支持 RICE/RICE2 和分区），写入 samples[order:]
+func (d *flacDecoder) readResidual(r *flacBitReader, samples []int64, order int) error {
+	method := r.readBits(2)
+	if method > 1 {
+		return fmt.Errorf("reserved residual coding method %d", method)
+	}
+	paramBits := 4
+	if method == 1 {
+		paramBits = 5
+	}
+	escape := uint64(1)<<paramBits - 1
+
+	partitionOrder := r.readBits(4)
+	partitions := 1 << partitionOrder
+	if len(samples)%partitions != 0 || len(samples)/partitions < order {
+		return fmt.Errorf("invalid partition order %d", partitionOrder)
+	}
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		count := len(samples) / partitions
+		if p == 0 {
+			count -= order
+		}
+		param := r.readBits(paramBits)
+		if param == escape {
+			rawBits := int(r.readBits(5))
+			for n := 0; n < count; n++ {
+				samples[i] = r.readSigned(rawBits)
+				i++
+			}
+			continue
+		}
+		for n := 0; n < count; n++ {
+			u := r.readUnary()<<param | r.readBits(int(param))
+			samples[i] = flacUnZigZag(u)
+			i++
+			if r.err != nil {
+				return fmt.Errorf("truncated residual")
+			}
+		}
+	}
+	return nil
+}
+
+// flacFixedPrediction FIXED 预测器（阶数 0-4）
+func flacFixedPrediction(s []int64, i, order int) int64 {
+	switch order {
+	case 1:
+		return s[i-1]
+	case 2:
+		return 2*s[i-1] - s[i-2]
+	case 3:
+		return 3*s[i-1] - 3*s[i-2] + s[i-3]
+	case 4:
+		return 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+	default:
+		return 0
+	}
+}
+
+func flacFixedResidual(s []int64, i, order int) int64 {
+	return s[i] - flacFixedPrediction(s, i, order)
+}
+
+func flacZigZag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func flacUnZigZag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+// flacBestRiceParam 根据残差均值估计 Rice 参数，并在相邻参数中选择编码位数最少的
+func flacBestRiceParam(residual []int64) (int, uint64) {
+	var sum uint64
+	for _, r := range residual {
+		sum += flacZigZag(r)
+	}
+	estimate := 0
+	if n := uint64(len(residual)); n > 0 && sum > n {
+		estimate = bits.Len64(sum/n) - 1
+	}
+
+	bestParam, bestBits := 0, ^uint64(0)
+	for param := estimate - 1; param <= estimate+1; param++ {
+		if param < 0 || param > 30 {
+			continue
+		}
+		total := uint64(len(residual)) * uint64(param+1)
+		for _, r := range residual {
+			total += flacZigZag(r) >> param
+		}
+		if total < bestBits {
+			bestParam, bestBits = param, total
+		}
+	}
+	return bestParam, bestBits
+}
+
+// flacSampleRateCode 返回帧头中的采样率编码，非标准采样率写在帧头末尾
+func flacSampleRateCode(sampleRate int) (code int, extra int, extraBits int) {
+	switch sampleRate {
+	case 88200:
+		return 0x1, 0, 0
+	case 176400:
+		return 0x2, 0, 0
+	case 192000:
+		return 0x3, 0, 0
+	case 8000:
+		return 0x4, 0, 0
+	case 16000:
+		return 0x5, 0, 0
+	case 22050:
+		return 0x6, 0, 0
+	case 24000:
+		return 0x7, 0, 0
+	case 32000:
+		return 0x8, 0, 0
+	case 44100:
+		return 0x9, 0, 0
+	case 48000:
+		return 0xA, 0, 0
+	case 96000:
+		return 0xB, 0, 0
+	}
+	if sampleRate <= 0xFFFF {
+		return 0xD, sampleRate, 16
+	}
+	return 0xE, sampleRate / 10, 16
+}
+
+// flacSampleSizeCode 返回帧头中的采样位数编码
+func flacSampleSizeCode(bitDepth int) int {
+	switch bitDepth {
+	case 16:
+		return 0x4
+	case 24:
+		return 0x6
+	default:
+		return 0x7 // 32 bit
+	}
+}
+
+// flacUTF8 按 FLAC 的扩展 UTF-8 规则编码帧号
+func flacUTF8(v uint64) []byte {
+	if v < 0x80 {
+		return []byte{byte(v)}
+	}
+	n := 2
+	for v >= 1<<(5*n+1) && n < 7 {
+		n++
+	}
+	out := make([]byte, n)
+	for i := n - 1; i > 0; i-- {
+		out[i] = 0x80 | byte(v&0x3F)
+		v >>= 6
+	}
+	out[0] = byte(0xFF<<(8-n)) | byte(v)
+	return out
+}
+
+// flacCRC8 帧头校验（多项式 x^8 + x^2 + x + 1）
+func flacCRC8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// flacCRC16 整帧校验（多项式 x^16 + x^15 + x^2 + 1）
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// flacBitWriter MSB 优先的位写入器
+type flacBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits int
+}
+
+func (w *flacBitWriter) writeBits(v uint64, n int) {
+	for n > 0 {
+		take := n
+		if take > 32 {
+			take = 32
+		}
+		n -= take
+		chunk := (v >> n) & (1<<take - 1)
+		w.acc = w.acc<<take | chunk
+		w.nbits += take
+		for w.nbits >= 8 {
+			w.nbits -= 8
+			w.buf = append(w.buf, byte(w.acc>>w.nbits))
+		}
+		w.acc &= 1<<w.nbits - 1
+	}
+}
+
+func (w *flacBitWriter) writeSigned(v int64, n int) {
+	w.writeBits(uint64(v)&(1<<n-1), n)
+}
+
+func (w *flacBitWriter) writeUnary(q uint64) {
+	for ; q >= 32; q -= 32 {
+		w.writeBits(0, 32)
+	}
+	w.writeBits(1, int(q)+1)
+}
+
+func (w *flacBitWriter) alignToByte() {
+	if w.nbits > 0 {
+		w.writeBits(0, 8-w.nbits)
+	}
+}
+
+// flacBitReader MSB 优先的位读取器，越界后 err 非空并返回 0
+type flacBitReader struct {
+	buf []byte
+	pos int // bit position
+	err error
+}
+
+func (r *flacBitReader) readBits(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.buf)*8 {
+			r.err = fmt.Errorf("unexpected end of frame")
+			return 0
+		}
+		bit := r.buf[r.pos/8] >> (7 - uint(r.pos%8)) & 1
+		v = v<<1 | uint64(bit)
+		r.pos++
+	}
+	return v
+}
+
+func (r *flacBitReader) readSigned(n int) int64 {
+	if n == 0 {
+		return 0
+	}
+	v := r.readBits(n)
+	return int64(v<<(64-n)) >> (64 - n)
+}
+
+func (r *flacBitReader) readUnary() uint64 {
+	var q uint64
+	for r.readBits(1) == 0 {
+		if r.err != nil {
+			return 0
+		}
+		q++
+	}
+	return q
+}
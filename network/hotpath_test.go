@@ -0,0 +1,110 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+// discardConn 丢弃所有写入的 net.Conn，用于测量发送路径本身的开销
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (discardConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// newBenchmarkClient 创建一个已"连接"的客户端，音频格式与握手后一致
+func newBenchmarkClient(b *testing.B, codec utils.CodecType, channels int) *Client {
+	b.Helper()
+	config := utils.NewDefaultConfig()
+	config.Compression = codec
+	config.Channels = channels
+	config.SampleRate = 48000
+	config.BitDepth = 16
+
+	client := NewClient(config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	client.conn = discardConn{}
+	client.connected = 1
+	client.shutdown = NewConnectionManager(context.Background())
+	client.useOpus = codec == utils.CodecOpus
+	var err error
+	client.opusEncoder, client.flacEncoder, err = newEncoders(config)
+	if err != nil {
+		b.Fatalf("newEncoders: %v", err)
+	}
+	client.allocateAudioBuffers()
+	return client
+}
+
+// benchmarkFrame 返回一帧 16 位测试音频（每声道不同频率的锯齿波）
+func benchmarkFrame(config *utils.Config) []byte {
+	frame := make([]byte, config.FramesPerBuffer*config.GetFrameSize())
+	for i := 0; i < len(frame)/2; i++ {
+		sample := int16((i * 97 % 512) * 64)
+		frame[2*i] = byte(sample)
+		frame[2*i+1] = byte(sample >> 8)
+	}
+	return frame
+}
+
+// BenchmarkClientOnAudioData 测量采集回调的发送路径（编码、组包、写入），稳态下应为 0 allocs/op
+func BenchmarkClientOnAudioData(b *testing.B) {
+	for _, codec := range []utils.CodecType{utils.CodecPCM, utils.CodecOpus} {
+		for _, channels := range []int{2, 6} {
+			b.Run(fmt.Sprintf("%s/%dch", codec, channels), func(b *testing.B) {
+				client := newBenchmarkClient(b, codec, channels)
+				frame := benchmarkFrame(client.config)
+				client.onAudioData(frame) // 预热
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					client.onAudioData(frame)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkServerDecodeAudio 测量服务端 Opus 解码到 PCM 的路径，稳态下应为 0 allocs/op
+func BenchmarkServerDecodeAudio(b *testing.B) {
+	for _, channels := range []int{2, 6} {
+		b.Run(fmt.Sprintf("opus/%dch", channels), func(b *testing.B) {
+			client := newBenchmarkClient(b, utils.CodecOpus, channels)
+			frame := benchmarkFrame(client.config)
+			samples := len(frame) / 2
+			pcm16 := make([]int16, samples)
+			for i := range pcm16 {
+				pcm16[i] = int16(frame[2*i]) | int16(frame[2*i+1])<<8
+			}
+			encoded, err := client.opusEncoder.Encode(pcm16)
+			if err != nil {
+				b.Fatalf("Encode: %v", err)
+			}
+			payload := append([]byte(nil), encoded...)
+
+			server := NewServer(client.config, utils.NewLoggerWithLevel(utils.LogLevelError))
+			server.useOpus = true
+			server.opusDecoder, err = newOpusMultiDecoder(client.config.SampleRate, channels)
+			if err != nil {
+				b.Fatalf("newOpusMultiDecoder: %v", err)
+			}
+			server.pcm16 = make([]int16, samples)
+			server.pcmData = make([]byte, samples*2)
+			if _, ok := server.decodeAudioPayload(payload); !ok {
+				b.Fatal("decodeAudioPayload failed")
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				server.decodeAudioPayload(payload)
+			}
+		})
+	}
+}
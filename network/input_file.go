@@ -0,0 +1,115 @@
+// network/input_file.go - -input-file：把 Ogg Opus 文件中的包原样发送（不采集、不重新编码）
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"RemoteAudioCLI/audio"
+	"RemoteAudioCLI/utils"
+)
+
+// openInputFile opens the -input-file Ogg Opus file and sets the stream format the client
+// requests to match it: Opus at 48 kHz, the file's channel count, and one packet per buffer
+func (c *Client) openInputFile() error {
+	reader, err := audio.OpenOggOpusFile(c.config.InputFile)
+	if err != nil {
+		return err
+	}
+	packet, err := reader.ReadPacket()
+	if err == nil {
+		c.opusFileFrames, err = audio.OpusPacketFrames(packet)
+	}
+	if err != nil {
+		reader.Close()
+		if err == io.EOF {
+			return utils.NewAppError(utils.ErrAudioCapture, "input file contains no audio packets")
+		}
+		return utils.WrapError(err, utils.ErrAudioCapture, "failed to read the first Opus packet")
+	}
+	c.opusFile = reader
+	c.firstFilePacket = append([]byte(nil), packet...)
+
+	c.config.Compression = utils.CodecOpus
+	c.config.SampleRate = audio.OpusSampleRate
+	c.config.Channels = reader.Head.Channels
+	c.config.BitDepth = 16
+	c.config.SampleFormat = utils.SampleFormatInt
+	c.config.FramesPerBuffer = c.opusFileFrames
+
+	c.logger.Infof("📼 Sending pre-encoded Opus from %s - %d ch, %v packets (no capture, no re-encoding)",
+		c.config.InputFile, reader.Head.Channels, opusPacketDuration(c.opusFileFrames))
+	return nil
+}
+
+// checkInputFileFormat verifies that the negotiated stream can carry the file's packets as they
+// are: Opus with the file's channel count and a buffer as long as one packet. The server may
+// lower the sample rate (its decoder accepts any Opus packet) as long as the duration matches.
+func (c *Client) checkInputFileFormat() error {
+	channels := c.opusFile.Head.Channels
+	if c.config.Compression != utils.CodecOpus || c.config.Channels != channels ||
+		c.config.FramesPerBuffer*audio.OpusSampleRate != c.opusFileFrames*c.config.SampleRate {
+		return utils.NewAppError(utils.ErrProtocol, fmt.Sprintf(
+			"server negotiated %s, %d Hz, %d ch, %d frames per buffer; the input file needs Opus with %d ch and %v per buffer",
+			c.config.Compression, c.config.SampleRate, c.config.Channels, c.config.FramesPerBuffer,
+			channels, opusPacketDuration(c.opusFileFrames)))
+	}
+	return nil
+}
+
+// opusPacketDuration 返回 frames 个 48kHz 采样帧的时长
+func opusPacketDuration(frames int) time.Duration {
+	return time.Duration(frames) * time.Second / audio.OpusSampleRate
+}
+
+// fileStreamingLoop sends the file's packets paced at their playback duration. Packets
+// whose duration differs from the negotiated buffer are skipped, since the server decodes
+// into buffers of that size. The end of the file stops the client.
+func (c *Client) fileStreamingLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	// 采样序号按协商采样率计数
+	var sampleIndex uint64
+	next := time.Now()
+	packet := c.firstFilePacket
+	for ctx.Err() == nil {
+		frames, err := audio.OpusPacketFrames(packet)
+		if err != nil {
+			c.logger.WarnRateLimited("input-file-packet", fmt.Sprintf("Skipping invalid Opus packet in input file: %v", err))
+			frames = 0
+		} else if frames != c.opusFileFrames {
+			c.logger.WarnRateLimited("input-file-packet", fmt.Sprintf("Skipping Opus packet of %v: the stream uses %v packets",
+				opusPacketDuration(frames), opusPacketDuration(c.opusFileFrames)))
+		} else if atomic.LoadInt32(&c.connected) == 1 && !c.IsMuted() && c.IsTransmitting() {
+			c.sendAudioPayload(packet, sampleIndex)
+		}
+		sampleIndex += uint64(frames * c.config.SampleRate / audio.OpusSampleRate)
+
+		// 按包的播放时长节拍发送；落后超过一个包时重新对齐，不做追赶
+		next = next.Add(opusPacketDuration(frames))
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		} else if -wait > opusPacketDuration(c.opusFileFrames) {
+			next = time.Now()
+		}
+
+		packet, err = c.opusFile.ReadPacket()
+		if err != nil {
+			if err == io.EOF {
+				c.logger.Info("📄 Input file reached end of stream, stopping client")
+			} else {
+				c.logger.Error(fmt.Sprintf("Failed to read from input file: %v", err))
+			}
+			c.shutdown.NotifyShutdown()
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// network/negotiation.go - 服务端握手策略与参数协商
+
+package network
+
+import (
+	"fmt"
+
+	"RemoteAudioCLI/utils"
+)
+
+// opusSampleRates Opus 支持的采样率（从高到低）
+var opusSampleRates = []uint32{48000, 24000, 16000, 12000, 8000}
+
+// negotiateHandshake applies the server policy in config to the client's requested format.
+// Settings outside the policy are coerced where possible (lower sample rate, bit depth,
+// channel count, or a different allowed codec); an error is returned if no acceptable format exists.
+func negotiateHandshake(client HandshakeConfig, config *utils.Config) (HandshakeConfig, error) {
+	result := client
+
+	// 编解码器：不在允许列表中时改用列表中的第一个（列表顺序即偏好顺序）
+	if len(config.AllowedCodecs) > 0 && !codecAllowed(utils.CodecType(result.Compression), config.AllowedCodecs) {
+		result.Compression = uint8(config.AllowedCodecs[0])
+	}
+
+	// float32 只能以 32 位 PCM 传输：改用压缩编解码器或位深受限时退回整数采样
+	if utils.SampleFormat(result.SampleFormat) == utils.SampleFormatFloat32 &&
+		(utils.CodecType(result.Compression) != utils.CodecPCM || (config.MaxBitDepth > 0 && config.MaxBitDepth < 32)) {
+		result.SampleFormat = uint8(utils.SampleFormatInt)
+		if utils.CodecType(result.Compression) == utils.CodecOpus {
+			result.BitDepth = 16
+		}
+	}
+
+	if config.MaxChannels > 0 && int(result.Channels) > config.MaxChannels {
+		result.Channels = uint8(config.MaxChannels)
+	}
+
+	if config.MaxBitDepth > 0 && int(result.BitDepth) > config.MaxBitDepth {
+		switch {
+		case config.MaxBitDepth >= 24:
+			result.BitDepth = 24
+		case config.MaxBitDepth >= 16:
+			result.BitDepth = 16
+		default:
+			return result, fmt.Errorf("server policy allows no supported bit depth (max %d)", config.MaxBitDepth)
+		}
+	}
+
+	// 采样率：限制在 [MinSampleRate, MaxSampleRate] 内
+	sampleRate := result.SampleRate
+	if config.MaxSampleRate > 0 && sampleRate > uint32(config.MaxSampleRate) {
+		sampleRate = uint32(config.MaxSampleRate)
+	}
+	if config.MinSampleRate > 0 && sampleRate < uint32(config.MinSampleRate) {
+		sampleRate = uint32(config.MinSampleRate)
+	}
+	if utils.CodecType(result.Compression) == utils.CodecOpus && !isOpusSampleRate(sampleRate) {
+		// Opus 只支持固定采样率：取范围内最接近目标值的可用采样率（如 44100 → 48000），
+		// 距离相同时取较高者
+		coerced := uint32(0)
+		for _, rate := range opusSampleRates {
+			if (config.MinSampleRate > 0 && rate < uint32(config.MinSampleRate)) ||
+				(config.MaxSampleRate > 0 && rate > uint32(config.MaxSampleRate)) {
+				continue
+			}
+			if coerced == 0 || rateDistance(rate, sampleRate) < rateDistance(coerced, sampleRate) {
+				coerced = rate
+			}
+		}
+		if coerced == 0 {
+			return result, fmt.Errorf("no Opus sample rate within the server policy (%d-%d Hz)", config.MinSampleRate, config.MaxSampleRate)
+		}
+		sampleRate = coerced
+	}
+	if sampleRate != result.SampleRate {
+		// 保持每个缓冲区的时长不变（如 20ms）
+		frames := uint64(result.FramesPerBuffer) * uint64(sampleRate) / uint64(result.SampleRate)
+		if frames == 0 || frames > 65535 {
+			return result, fmt.Errorf("cannot adapt %d frames per buffer to %d Hz", result.FramesPerBuffer, sampleRate)
+		}
+		result.FramesPerBuffer = uint16(frames)
+		result.SampleRate = sampleRate
+	}
+
+	if err := result.Validate(); err != nil {
+		return result, fmt.Errorf("negotiated config is invalid: %w", err)
+	}
+
+	return result, nil
+}
+
+// rateDistance 两个采样率之差的绝对值
+func rateDistance(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// handshakeChanges 描述从请求的配置到协商结果之间的差异，用于双方日志
+func handshakeChanges(requested, negotiated HandshakeConfig) []string {
+	var changes []string
+	if requested.SampleRate != negotiated.SampleRate {
+		changes = append(changes, fmt.Sprintf("sample rate %dHz → %dHz", requested.SampleRate, negotiated.SampleRate))
+	}
+	if requested.Channels != negotiated.Channels {
+		changes = append(changes, fmt.Sprintf("channels %d → %d", requested.Channels, negotiated.Channels))
+	}
+	if requested.BitDepth != negotiated.BitDepth {
+		changes = append(changes, fmt.Sprintf("bit depth %d → %d", requested.BitDepth, negotiated.BitDepth))
+	}
+	if requested.FramesPerBuffer != negotiated.FramesPerBuffer {
+		changes = append(changes, fmt.Sprintf("frames per buffer %d → %d", requested.FramesPerBuffer, negotiated.FramesPerBuffer))
+	}
+	if requested.BufferCount != negotiated.BufferCount {
+		changes = append(changes, fmt.Sprintf("buffer count %d → %d", requested.BufferCount, negotiated.BufferCount))
+	}
+	if requested.SampleFormat != negotiated.SampleFormat {
+		changes = append(changes, fmt.Sprintf("sample format %s → %s", utils.SampleFormat(requested.SampleFormat), utils.SampleFormat(negotiated.SampleFormat)))
+	}
+	if requested.Compression != negotiated.Compression {
+		changes = append(changes, fmt.Sprintf("codec %s → %s", utils.CodecType(requested.Compression), utils.CodecType(negotiated.Compression)))
+	}
+	return changes
+}
+
+func codecAllowed(codec utils.CodecType, allowed []utils.CodecType) bool {
+	for _, c := range allowed {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+func isOpusSampleRate(rate uint32) bool {
+	for _, r := range opusSampleRates {
+		if r == rate {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package network
+
+import (
+	"errors"
+	"testing"
+
+	"RemoteAudioCLI/utils"
+)
+
+func TestNegotiateHandshake(t *testing.T) {
+	lossless := HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 24, FramesPerBuffer: 960, BufferCount: 4, Compression: uint8(utils.CodecFLAC)}
+
+	tests := []struct {
+		name    string
+		client  HandshakeConfig
+		policy  utils.Config
+		want    HandshakeConfig
+		wantErr bool
+	}{
+		{
+			name:   "no policy accepts client",
+			client: lossless,
+			want:   lossless,
+		},
+		{
+			name:   "high quality server coerces lossless to 16 bit",
+			client: lossless,
+			policy: utils.Config{MaxSampleRate: 48000, MaxChannels: 2, MaxBitDepth: 16},
+			want:   HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4, Compression: uint8(utils.CodecFLAC)},
+		},
+		{
+			name:   "sample rate lowered keeps buffer duration",
+			client: lossless,
+			policy: utils.Config{MaxSampleRate: 24000},
+			want:   HandshakeConfig{SampleRate: 24000, Channels: 2, BitDepth: 24, FramesPerBuffer: 480, BufferCount: 4, Compression: uint8(utils.CodecFLAC)},
+		},
+		{
+			name:   "disallowed codec falls back to first allowed",
+			client: lossless,
+			policy: utils.Config{AllowedCodecs: []utils.CodecType{utils.CodecPCM, utils.CodecOpus}},
+			want:   HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 24, FramesPerBuffer: 960, BufferCount: 4, Compression: uint8(utils.CodecPCM)},
+		},
+		{
+			name:   "opus rate snaps to supported rate",
+			client: HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4, Compression: uint8(utils.CodecOpus)},
+			policy: utils.Config{MaxSampleRate: 44100},
+			want:   HandshakeConfig{SampleRate: 24000, Channels: 2, BitDepth: 16, FramesPerBuffer: 480, BufferCount: 4, Compression: uint8(utils.CodecOpus)},
+		},
+		{
+			name:   "44.1 kHz opus goes up to the nearest supported rate",
+			client: HandshakeConfig{SampleRate: 44100, Channels: 2, BitDepth: 16, FramesPerBuffer: 882, BufferCount: 4, Compression: uint8(utils.CodecOpus)},
+			want:   HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4, Compression: uint8(utils.CodecOpus)},
+		},
+		{
+			name:   "opus rate snaps to the nearest rate within the policy",
+			client: HandshakeConfig{SampleRate: 22050, Channels: 1, BitDepth: 16, FramesPerBuffer: 441, BufferCount: 4, Compression: uint8(utils.CodecOpus)},
+			policy: utils.Config{MinSampleRate: 8000, MaxSampleRate: 48000},
+			want:   HandshakeConfig{SampleRate: 24000, Channels: 1, BitDepth: 16, FramesPerBuffer: 480, BufferCount: 4, Compression: uint8(utils.CodecOpus)},
+		},
+		{
+			name:   "float32 falls back to integer samples under a bit depth cap",
+			client: HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 32, FramesPerBuffer: 960, BufferCount: 4, SampleFormat: uint8(utils.SampleFormatFloat32)},
+			policy: utils.Config{MaxBitDepth: 24},
+			want:   HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 24, FramesPerBuffer: 960, BufferCount: 4},
+		},
+		{
+			name:   "float32 falls back to 16 bit when coerced to opus",
+			client: HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 32, FramesPerBuffer: 960, BufferCount: 4, SampleFormat: uint8(utils.SampleFormatFloat32)},
+			policy: utils.Config{AllowedCodecs: []utils.CodecType{utils.CodecOpus}},
+			want:   HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4, Compression: uint8(utils.CodecOpus)},
+		},
+		{
+			name:    "opus without a rate in range is rejected",
+			client:  HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4, Compression: uint8(utils.CodecOpus)},
+			policy:  utils.Config{MinSampleRate: 44100, MaxSampleRate: 44100},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := negotiateHandshake(tt.client, &tt.policy)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("%s: expected rejection, got %+v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: negotiateHandshake: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestNewEncodersOpusFailure Opus 编码器无法创建时返回 ErrOpusEncoder，调用方据此改用 PCM
+func TestNewEncodersOpusFailure(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.Compression = utils.CodecOpus
+	config.SampleRate = 44100
+	if _, _, err := newEncoders(config); !errors.Is(err, ErrOpusEncoder) {
+		t.Fatalf("newEncoders at 44100 Hz: got %v, want ErrOpusEncoder", err)
+	}
+
+	config.Compression = utils.CodecPCM
+	if _, _, err := newEncoders(config); err != nil {
+		t.Fatalf("newEncoders with PCM: %v", err)
+	}
+}
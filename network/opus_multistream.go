@@ -0,0 +1,185 @@
+// network/opus_multistream.go - 多声道 Opus 编解码（按声道对拆分为多个 Opus 流）
+
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hraban/opus"
+)
+
+// opusMaxPacketSize 单个 Opus 流的最大编码字节数
+const opusMaxPacketSize = 4000
+
+// libopus 接受的单流码率范围（bit/s）
+const (
+	opusMinBitrate = 6000
+	opusMaxBitrate = 510000
+)
+
+// opusStreamChannels 将声道按对分配给各个 Opus 流：1/2 声道为单流，其余每两个声道一个流（奇数时最后一个流为单声道）
+func opusStreamChannels(channels int) []int {
+	var streams []int
+	for remaining := channels; remaining > 0; remaining -= 2 {
+		if remaining >= 2 {
+			streams = append(streams, 2)
+		} else {
+			streams = append(streams, 1)
+		}
+	}
+	return streams
+}
+
+// opusMultiEncoder encodes interleaved PCM with one Opus encoder per channel pair.
+//
+// hraban/opus does not wrap libopus' multistream API, so for more than two
+// channels each stream's packet is sent with a 2-byte big-endian length prefix.
+// Mono and stereo use a single plain Opus packet, identical to older versions.
+type opusMultiEncoder struct {
+	channels  int
+	streams   []int
+	encoders  []*opus.Encoder
+	streamPCM [][]int16
+	// 复用的编码缓冲区：Encode 返回的切片在下一次调用前有效
+	packet  []byte
+	payload []byte
+}
+
+// newOpusMultiEncoder creates an encoder for the given sample rate and channel count.
+// bitrate is the total target in bits per second, split across streams by channel count (0 = libopus default)
+func newOpusMultiEncoder(sampleRate, channels, bitrate int) (*opusMultiEncoder, error) {
+	e := &opusMultiEncoder{
+		channels: channels,
+		streams:  opusStreamChannels(channels),
+		packet:   make([]byte, opusMaxPacketSize),
+	}
+	for _, streamChannels := range e.streams {
+		encoder, err := opus.NewEncoder(sampleRate, streamChannels, opus.AppAudio)
+		if err != nil {
+			return nil, err
+		}
+		if bitrate > 0 {
+			perStream := bitrate * streamChannels / channels
+			if perStream < opusMinBitrate {
+				perStream = opusMinBitrate
+			} else if perStream > opusMaxBitrate {
+				perStream = opusMaxBitrate
+			}
+			if err := encoder.SetBitrate(perStream); err != nil {
+				return nil, fmt.Errorf("set bitrate %d: %w", perStream, err)
+			}
+		}
+		e.encoders = append(e.encoders, encoder)
+		e.streamPCM = append(e.streamPCM, nil)
+	}
+	return e, nil
+}
+
+// Encode encodes one frame of interleaved 16-bit PCM.
+// The returned payload is only valid until the next call to Encode.
+func (e *opusMultiEncoder) Encode(pcm []int16) ([]byte, error) {
+	if len(e.encoders) == 1 {
+		n, err := e.encoders[0].Encode(pcm, e.packet)
+		if err != nil {
+			return nil, err
+		}
+		return e.packet[:n], nil
+	}
+
+	frames := len(pcm) / e.channels
+	payload := e.payload[:0]
+	firstChannel := 0
+	for s, streamChannels := range e.streams {
+		// 取出该流对应的声道
+		if cap(e.streamPCM[s]) < frames*streamChannels {
+			e.streamPCM[s] = make([]int16, frames*streamChannels)
+		}
+		streamPCM := e.streamPCM[s][:frames*streamChannels]
+		for f := 0; f < frames; f++ {
+			for ch := 0; ch < streamChannels; ch++ {
+				streamPCM[f*streamChannels+ch] = pcm[f*e.channels+firstChannel+ch]
+			}
+		}
+
+		n, err := e.encoders[s].Encode(streamPCM, e.packet)
+		if err != nil {
+			return nil, fmt.Errorf("stream %d: %w", s, err)
+		}
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(n))
+		payload = append(payload, length[:]...)
+		payload = append(payload, e.packet[:n]...)
+		firstChannel += streamChannels
+	}
+	e.payload = payload
+	return payload, nil
+}
+
+// opusMultiDecoder decodes payloads produced by opusMultiEncoder
+type opusMultiDecoder struct {
+	channels  int
+	streams   []int
+	decoders  []*opus.Decoder
+	streamPCM [][]int16
+}
+
+// newOpusMultiDecoder creates a decoder for the given sample rate and channel count
+func newOpusMultiDecoder(sampleRate, channels int) (*opusMultiDecoder, error) {
+	d := &opusMultiDecoder{channels: channels, streams: opusStreamChannels(channels)}
+	for _, streamChannels := range d.streams {
+		decoder, err := opus.NewDecoder(sampleRate, streamChannels)
+		if err != nil {
+			return nil, err
+		}
+		d.decoders = append(d.decoders, decoder)
+		d.streamPCM = append(d.streamPCM, nil)
+	}
+	return d, nil
+}
+
+// Decode decodes one payload into pcm (interleaved, at least maxFrames*channels samples) and returns the frame count
+func (d *opusMultiDecoder) Decode(payload []byte, pcm []int16, maxFrames int) (int, error) {
+	if len(d.decoders) == 1 {
+		return d.decoders[0].Decode(payload, pcm[:maxFrames*d.channels])
+	}
+
+	frames := -1
+	firstChannel := 0
+	for s, streamChannels := range d.streams {
+		if len(payload) < 2 {
+			return 0, fmt.Errorf("truncated multistream payload at stream %d", s)
+		}
+		length := int(binary.BigEndian.Uint16(payload[:2]))
+		payload = payload[2:]
+		if len(payload) < length {
+			return 0, fmt.Errorf("truncated multistream payload at stream %d", s)
+		}
+
+		if cap(d.streamPCM[s]) < maxFrames*streamChannels {
+			d.streamPCM[s] = make([]int16, maxFrames*streamChannels)
+		}
+		streamPCM := d.streamPCM[s][:maxFrames*streamChannels]
+		n, err := d.decoders[s].Decode(payload[:length], streamPCM)
+		if err != nil {
+			return 0, fmt.Errorf("stream %d: %w", s, err)
+		}
+		payload = payload[length:]
+		if n > maxFrames {
+			return 0, fmt.Errorf("stream %d decoded %d frames, more than the %d-frame buffer", s, n, maxFrames)
+		}
+		if frames != -1 && n != frames {
+			return 0, fmt.Errorf("stream %d decoded %d frames, stream 0 decoded %d", s, n, frames)
+		}
+		frames = n
+
+		// 写回交织的多声道缓冲区
+		for f := 0; f < n && f < maxFrames; f++ {
+			for ch := 0; ch < streamChannels; ch++ {
+				pcm[f*d.channels+firstChannel+ch] = streamPCM[f*streamChannels+ch]
+			}
+		}
+		firstChannel += streamChannels
+	}
+	return frames, nil
+}
@@ -2,17 +2,23 @@ package network
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"time"
+
+	"RemoteAudioCLI/utils"
 )
 
 // Protocol constants
 const (
-	ProtocolVersion = 1
+	ProtocolVersion = 2
 	MagicNumber     = 0x41554449 // "AUDI" in ASCII
-	HeaderSize      = 20         // Size of packet header in bytes
+	HeaderSize      = 24         // Size of packet header in bytes
 	MaxPayloadSize  = 65536      // Maximum payload size in bytes
+	MaxControlPayloadSize = 4096 // Maximum payload size for non-audio packets
+	opusMaxFrameBytes     = 1275 // libopus 单帧最大字节数
 )
 
 // PacketType represents different types of packets
@@ -24,6 +30,7 @@ const (
 	PacketTypeControl
 	PacketTypeHeartbeat
 	PacketTypeError
+	PacketTypeAttach // 在第二条连接上携带会话令牌，使其成为该会话的控制连接
 )
 
 // String returns the string representation of packet type
@@ -39,21 +46,25 @@ func (pt PacketType) String() string {
 		return "Heartbeat"
 	case PacketTypeError:
 		return "Error"
+	case PacketTypeAttach:
+		return "Attach"
 	default:
 		return "Unknown"
 	}
 }
 
-// PacketHeader represents the header of a network packet
+// PacketHeader represents the header of a network packet. Header and handshake fields are
+// big-endian; audio payloads of CodecPCM are interleaved little-endian samples (integer or
+// IEEE float32, see HandshakeConfig.SampleFormat), independent of the host and -pcm-bigendian
 type PacketHeader struct {
 	Magic       uint32    // Magic number for validation
 	Version     uint8     // Protocol version
 	Type        PacketType // Packet type
 	Flags       uint8     // Various flags
 	Reserved    uint8     // Reserved for future use
-	Sequence    uint32    // Sequence number
+	Sequence    uint32    // Sequence number（音频包按会话从 1 计数，每次握手后重新开始）
 	PayloadSize uint32    // Size of payload data
-	Timestamp   uint32    // Timestamp (Unix time in seconds)
+	Timestamp   uint64    // Timestamp (Unix time in milliseconds, since protocol v2)
 }
 
 // Packet represents a complete network packet
@@ -64,7 +75,13 @@ type Packet struct {
 
 // NewPacket creates a new packet with the specified type and payload
 func NewPacket(packetType PacketType, payload []byte) *Packet {
-	return &Packet{
+	packet := newPacket(packetType, payload)
+	return &packet
+}
+
+// newPacket 按值构造数据包，供音频热路径在栈上使用
+func newPacket(packetType PacketType, payload []byte) Packet {
+	return Packet{
 		Header: PacketHeader{
 			Magic:       MagicNumber,
 			Version:     ProtocolVersion,
@@ -73,12 +90,41 @@ func NewPacket(packetType PacketType, payload []byte) *Packet {
 			Reserved:    0,
 			Sequence:    0,
 			PayloadSize: uint32(len(payload)),
-			Timestamp:   uint32(time.Now().Unix()),
+			Timestamp:   NowTimestamp(),
 		},
 		Payload: payload,
 	}
 }
 
+// PacketFlagSampleIndex marks an audio packet whose payload starts with the big-endian
+// cumulative index of its first sample frame (SampleIndexSize bytes) before the audio data
+const PacketFlagSampleIndex uint8 = 0x01
+
+// SampleIndexSize is the size of the sample index prefix of PacketFlagSampleIndex packets
+const SampleIndexSize = 8
+
+// audioPayload splits an audio packet into its audio data and sample index;
+// indexed is false for packets without PacketFlagSampleIndex
+func audioPayload(packet *Packet) (audio []byte, sampleIndex uint64, indexed bool, err error) {
+	if packet.Header.Flags&PacketFlagSampleIndex == 0 {
+		return packet.Payload, 0, false, nil
+	}
+	if len(packet.Payload) < SampleIndexSize {
+		return nil, 0, false, fmt.Errorf("audio packet too short for its sample index: %d bytes", len(packet.Payload))
+	}
+	return packet.Payload[SampleIndexSize:], binary.BigEndian.Uint64(packet.Payload[:SampleIndexSize]), true, nil
+}
+
+// NowTimestamp returns the current wall-clock time as a packet timestamp
+func NowTimestamp() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+// TimestampToTime converts a packet timestamp back to time.Time
+func TimestampToTime(timestamp uint64) time.Time {
+	return time.UnixMilli(int64(timestamp))
+}
+
 // NewAudioPacket creates a new audio packet
 func NewAudioPacket(audioData []byte, sequence uint32) *Packet {
 	packet := NewPacket(PacketTypeAudio, audioData)
@@ -97,6 +143,21 @@ func NewHeartbeatPacket() *Packet {
 	return NewPacket(PacketTypeHeartbeat, nil)
 }
 
+// NewAttachPacket creates the packet that attaches a control connection to the session with token
+func NewAttachPacket(token [SessionTokenSize]byte) *Packet {
+	return NewPacket(PacketTypeAttach, token[:])
+}
+
+// 控制包负载的第一个字节是控制码，其后为可选的文本
+const (
+	ControlGoodbye byte = 0x01 // 服务端主动结束会话，文本为原因
+)
+
+// NewGoodbyePacket creates the control packet the server sends before ending a session on its own
+func NewGoodbyePacket(reason string) *Packet {
+	return NewPacket(PacketTypeControl, append([]byte{ControlGoodbye}, reason...))
+}
+
 // NewErrorPacket creates a new error packet
 func NewErrorPacket(errorMessage string) *Packet {
 	payload := []byte(errorMessage)
@@ -105,6 +166,21 @@ func NewErrorPacket(errorMessage string) *Packet {
 
 // WritePacket writes a packet to the provided writer
 func WritePacket(writer io.Writer, packet *Packet) error {
+	var w packetWriter
+	return w.WritePacket(writer, packet)
+}
+
+// packetWriter serializes packets into a reusable buffer, so a session can send
+// audio packets without allocating. Header and payload go out in a single Write,
+// which also keeps packets from concurrent writers on a net.Conn from interleaving.
+// With cipher set (-psk), audio payloads are sealed before they are written.
+type packetWriter struct {
+	buf    []byte
+	cipher *packetCipher
+}
+
+// WritePacket writes a packet to the provided writer
+func (w *packetWriter) WritePacket(writer io.Writer, packet *Packet) error {
 	// Validate packet
 	if packet.Header.Magic != MagicNumber {
 		return fmt.Errorf("invalid magic number: 0x%08X", packet.Header.Magic)
@@ -119,37 +195,189 @@ func WritePacket(writer io.Writer, packet *Packet) error {
 			packet.Header.PayloadSize, len(packet.Payload))
 	}
 
-	// Write header
-	headerBytes := make([]byte, HeaderSize)
-	binary.BigEndian.PutUint32(headerBytes[0:4], packet.Header.Magic)
-	headerBytes[4] = packet.Header.Version
-	headerBytes[5] = uint8(packet.Header.Type)
-	headerBytes[6] = packet.Header.Flags
-	headerBytes[7] = packet.Header.Reserved
-	binary.BigEndian.PutUint32(headerBytes[8:12], packet.Header.Sequence)
-	binary.BigEndian.PutUint32(headerBytes[12:16], packet.Header.PayloadSize)
-	binary.BigEndian.PutUint32(headerBytes[16:20], packet.Header.Timestamp)
-
-	if _, err := writer.Write(headerBytes); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
-	}
-
-	// Write payload if present
-	if packet.Header.PayloadSize > 0 {
-		if _, err := writer.Write(packet.Payload); err != nil {
-			return fmt.Errorf("failed to write payload: %w", err)
+	// 加密的音频包：负载加上认证标签，包头标记后作为附加数据参与认证
+	header := packet.Header
+	encrypt := w.cipher != nil && header.Type == PacketTypeAudio
+	if encrypt {
+		header.Flags |= PacketFlagEncrypted
+		header.PayloadSize += packetCipherOverhead
+		if header.PayloadSize > MaxPayloadSize {
+			return fmt.Errorf("payload too large: %d bytes", header.PayloadSize)
 		}
 	}
 
+	size := HeaderSize + int(header.PayloadSize)
+	if cap(w.buf) < size {
+		w.buf = make([]byte, size)
+	}
+	data := w.buf[:size]
+
+	encodeHeader(data, &header)
+	if encrypt {
+		w.cipher.seal(data[:HeaderSize], data[:HeaderSize], packet.Payload, header.Sequence)
+	} else {
+		copy(data[HeaderSize:], packet.Payload)
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write packet: %w", err)
+	}
+
 	return nil
 }
 
+// encodeHeader 按网络字节序（大端）写入 HeaderSize 字节的包头
+func encodeHeader(data []byte, header *PacketHeader) {
+	binary.BigEndian.PutUint32(data[0:4], header.Magic)
+	data[4] = header.Version
+	data[5] = uint8(header.Type)
+	data[6] = header.Flags
+	data[7] = header.Reserved
+	binary.BigEndian.PutUint32(data[8:12], header.Sequence)
+	binary.BigEndian.PutUint32(data[12:16], header.PayloadSize)
+	binary.BigEndian.PutUint64(data[16:24], header.Timestamp)
+}
+
+// ErrInvalidFrame is returned (wrapped) when a packet header violates the framing rules;
+// the stream can no longer be trusted and the connection should be closed
+var ErrInvalidFrame = errors.New("invalid packet framing")
+
+// ProtocolVersionError is returned when a packet carries a different protocol version.
+// It wraps ErrInvalidFrame; the handshake uses Remote to tell the user which side to upgrade.
+type ProtocolVersionError struct {
+	Remote uint8
+}
+
+func (e *ProtocolVersionError) Error() string {
+	return fmt.Sprintf("%v: unsupported protocol version: %d", ErrInvalidFrame, e.Remote)
+}
+
+func (e *ProtocolVersionError) Unwrap() error {
+	return ErrInvalidFrame
+}
+
+// protocolMismatchMessage 生成面向用户的版本不匹配说明，指出需要升级的一端
+func protocolMismatchMessage(serverVersion, clientVersion uint8) string {
+	older := "client"
+	if serverVersion < clientVersion {
+		older = "server"
+	}
+	return fmt.Sprintf("protocol version mismatch: server speaks v%d, client speaks v%d, please upgrade the %s",
+		serverVersion, clientVersion, older)
+}
+
+// HandshakeFailure classifies why a client could not connect or complete the handshake
+type HandshakeFailure int
+
+const (
+	HandshakeFailed           HandshakeFailure = iota // 其他原因（被服务端拒绝、配置无效等）
+	HandshakeRefused                                  // 连接被拒绝或不可达，服务端可能尚未监听
+	HandshakeTimeout                                  // 连接或握手应答超时
+	HandshakeProtocolMismatch                         // 双方协议版本不同
+)
+
+func (f HandshakeFailure) String() string {
+	switch f {
+	case HandshakeRefused:
+		return "connection refused"
+	case HandshakeTimeout:
+		return "timed out"
+	case HandshakeProtocolMismatch:
+		return "protocol mismatch"
+	default:
+		return "handshake failed"
+	}
+}
+
+// HandshakeError is returned (wrapped) by Client.Run when connecting or the handshake
+// fails for a reason that Kind tells apart; use errors.As to inspect it
+type HandshakeError struct {
+	Kind HandshakeFailure
+	Err  error
+}
+
+func (e *HandshakeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the server may simply not be ready yet
+func (e *HandshakeError) Retryable() bool {
+	return e.Kind == HandshakeRefused || e.Kind == HandshakeTimeout
+}
+
+// classifyDialError 区分拨号失败的原因；DNS 解析失败等配置错误不重试
+func classifyDialError(err error) HandshakeFailure {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return HandshakeTimeout
+	}
+	var dnsErr *net.DNSError
+	var addrErr *net.AddrError
+	if errors.As(err, &dnsErr) || errors.As(err, &addrErr) {
+		return HandshakeFailed
+	}
+	return HandshakeRefused
+}
+
 // ReadPacket reads a packet from the provided reader
 func ReadPacket(reader io.Reader) (*Packet, error) {
-	// Read header
+	header, err := readPacketHeader(reader, MaxPayloadSize)
+	if err != nil {
+		return nil, err
+	}
+	return readPacketPayload(reader, header)
+}
+
+// DeadlineReader is a reader with read deadlines, such as net.Conn
+type DeadlineReader interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
+
+// ReadPacketWithTimeout reads a packet, applying timeout separately to the header and the payload.
+//
+// The deadline is reset once the header has arrived, so a slow but alive link
+// that delivers a packet across the header deadline is not treated as dead.
+// The deadline is cleared before returning. maxAudioPayload limits audio
+// packets before the payload is allocated (0 means MaxPayloadSize).
+func ReadPacketWithTimeout(conn DeadlineReader, timeout time.Duration, maxAudioPayload uint32) (*Packet, error) {
+	defer conn.SetReadDeadline(time.Time{})
+
+	if maxAudioPayload == 0 || maxAudioPayload > MaxPayloadSize {
+		maxAudioPayload = MaxPayloadSize
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	header, err := readPacketHeader(conn, maxAudioPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	// 头部已到达，为负载重新计时
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	return readPacketPayload(conn, header)
+}
+
+// readPacketHeader 读取并校验包头；魔数和版本在读取完整包头之前先行校验
+func readPacketHeader(reader io.Reader, maxAudioPayload uint32) (PacketHeader, error) {
 	headerBytes := make([]byte, HeaderSize)
-	if _, err := io.ReadFull(reader, headerBytes); err != nil {
-		return nil, fmt.Errorf("failed to read header: %w", err)
+	if _, err := io.ReadFull(reader, headerBytes[:5]); err != nil {
+		return PacketHeader{}, fmt.Errorf("failed to read header: %w", err)
+	}
+	if magic := binary.BigEndian.Uint32(headerBytes[0:4]); magic != MagicNumber {
+		return PacketHeader{}, fmt.Errorf("%w: invalid magic number: 0x%08X", ErrInvalidFrame, magic)
+	}
+	if headerBytes[4] != ProtocolVersion {
+		return PacketHeader{}, &ProtocolVersionError{Remote: headerBytes[4]}
+	}
+
+	// Read the rest of the header
+	if _, err := io.ReadFull(reader, headerBytes[5:]); err != nil {
+		return PacketHeader{}, fmt.Errorf("failed to read header: %w", err)
 	}
 
 	// Parse header
@@ -161,22 +389,29 @@ func ReadPacket(reader io.Reader) (*Packet, error) {
 		Reserved:    headerBytes[7],
 		Sequence:    binary.BigEndian.Uint32(headerBytes[8:12]),
 		PayloadSize: binary.BigEndian.Uint32(headerBytes[12:16]),
-		Timestamp:   binary.BigEndian.Uint32(headerBytes[16:20]),
+		Timestamp:   binary.BigEndian.Uint64(headerBytes[16:24]),
 	}
 
-	// Validate header
-	if header.Magic != MagicNumber {
-		return nil, fmt.Errorf("invalid magic number: 0x%08X", header.Magic)
+	// Validate payload size before allocating
+	if header.PayloadSize > MaxPayloadSize {
+		return PacketHeader{}, fmt.Errorf("%w: payload too large: %d bytes", ErrInvalidFrame, header.PayloadSize)
 	}
 
-	if header.Version != ProtocolVersion {
-		return nil, fmt.Errorf("unsupported protocol version: %d", header.Version)
+	if header.Type == PacketTypeAudio {
+		if header.PayloadSize > maxAudioPayload {
+			return PacketHeader{}, fmt.Errorf("%w: audio payload of %d bytes exceeds limit of %d bytes",
+				ErrInvalidFrame, header.PayloadSize, maxAudioPayload)
+		}
+	} else if header.PayloadSize > MaxControlPayloadSize {
+		return PacketHeader{}, fmt.Errorf("%w: %s payload of %d bytes exceeds limit of %d bytes",
+			ErrInvalidFrame, header.Type, header.PayloadSize, MaxControlPayloadSize)
 	}
 
-	if header.PayloadSize > MaxPayloadSize {
-		return nil, fmt.Errorf("payload too large: %d bytes", header.PayloadSize)
-	}
+	return header, nil
+}
 
+// readPacketPayload 读取包头声明长度的负载
+func readPacketPayload(reader io.Reader, header PacketHeader) (*Packet, error) {
 	// Read payload
 	var payload []byte
 	if header.PayloadSize > 0 {
@@ -199,9 +434,29 @@ type HandshakeConfig struct {
 	BitDepth        uint8
 	FramesPerBuffer uint16
 	BufferCount     uint8
-	Compression     uint8
+	Compression     uint8 // utils.CodecType: 0=PCM, 1=Opus, 2=FLAC
+	SampleFormat    uint8 // utils.SampleFormat: 0=int, 1=float32（旧版本此字节为保留的 0）
+	Flags           uint8 // HandshakeFlag* 位（旧版本此字节为保留的 0）
+	// 服务端接受控制连接时回传的会话令牌（仅在非零时附加在 12 字节配置之后）
+	SessionToken [SessionTokenSize]byte
+	// HandshakeFlagEncrypted：客户端生成的盐与己方的口令校验值，附加在令牌字段（此时总是存在）之后；
+	// 服务端回复时附上它生成的随机值（客户端发送时为零）
+	PSKSalt  [pskSaltSize]byte
+	PSKCheck [pskCheckSize]byte
+	PSKNonce [pskNonceSize]byte
 }
 
+// HandshakeFlagControlChannel asks for (client) or grants (server) a separate control
+// connection that carries heartbeats, so they are not queued behind audio
+const HandshakeFlagControlChannel uint8 = 0x01
+
+// HandshakeFlagSampleIndex asks for (client) or grants (server) audio packets that carry
+// a cumulative sample index (see PacketFlagSampleIndex)
+const HandshakeFlagSampleIndex uint8 = 0x02
+
+// SessionTokenSize is the length of the token that associates a control connection with its session
+const SessionTokenSize = 16
+
 // ToBytes converts handshake config to byte array
 func (hc *HandshakeConfig) ToBytes() []byte {
 	data := make([]byte, 12)
@@ -211,7 +466,16 @@ func (hc *HandshakeConfig) ToBytes() []byte {
 	binary.BigEndian.PutUint16(data[6:8], hc.FramesPerBuffer)
 	data[8] = hc.BufferCount
 	data[9] = hc.Compression
-	// data[10:12] reserved for future use
+	data[10] = hc.SampleFormat
+	data[11] = hc.Flags
+	if hc.Flags&HandshakeFlagEncrypted != 0 {
+		data = append(data, hc.SessionToken[:]...)
+		data = append(data, hc.PSKSalt[:]...)
+		data = append(data, hc.PSKCheck[:]...)
+		data = append(data, hc.PSKNonce[:]...)
+	} else if hc.SessionToken != ([SessionTokenSize]byte{}) {
+		data = append(data, hc.SessionToken[:]...)
+	}
 	return data
 }
 
@@ -227,10 +491,41 @@ func (hc *HandshakeConfig) FromBytes(data []byte) error {
 	hc.FramesPerBuffer = binary.BigEndian.Uint16(data[6:8])
 	hc.BufferCount = data[8]
 	hc.Compression = data[9]
+	hc.SampleFormat = data[10]
+	hc.Flags = data[11]
+	hc.SessionToken = [SessionTokenSize]byte{}
+	if len(data) >= 12+SessionTokenSize {
+		copy(hc.SessionToken[:], data[12:12+SessionTokenSize])
+	}
+	hc.PSKSalt = [pskSaltSize]byte{}
+	hc.PSKCheck = [pskCheckSize]byte{}
+	hc.PSKNonce = [pskNonceSize]byte{}
+	if hc.Flags&HandshakeFlagEncrypted != 0 {
+		const offset = 12 + SessionTokenSize
+		if len(data) < offset+pskSaltSize+pskCheckSize+pskNonceSize {
+			return fmt.Errorf("encrypted handshake data too short: %d bytes", len(data))
+		}
+		copy(hc.PSKSalt[:], data[offset:])
+		copy(hc.PSKCheck[:], data[offset+pskSaltSize:])
+		copy(hc.PSKNonce[:], data[offset+pskSaltSize+pskCheckSize:])
+	}
 
 	return nil
 }
 
+// MaxAudioPayloadSize returns the largest plausible audio payload for this format:
+// one PCM frame plus codec overhead, or the worst-case Opus packet per stream
+func (hc *HandshakeConfig) MaxAudioPayloadSize() uint32 {
+	pcmBytes := uint32(hc.FramesPerBuffer) * uint32(hc.Channels) * uint32((hc.BitDepth+7)/8)
+	limit := pcmBytes + pcmBytes/8 + 256
+
+	opusBytes := uint32(len(opusStreamChannels(int(hc.Channels)))) * (opusMaxFrameBytes + 2)
+	if opusBytes > limit {
+		limit = opusBytes
+	}
+	return limit
+}
+
 // Validate checks if the handshake config is valid
 func (hc *HandshakeConfig) Validate() error {
 	if hc.SampleRate < 8000 || hc.SampleRate > 192000 {
@@ -253,5 +548,18 @@ func (hc *HandshakeConfig) Validate() error {
 		return fmt.Errorf("invalid buffer count: %d", hc.BufferCount)
 	}
 
+	if utils.CodecType(hc.Compression) > utils.MaxCodecType {
+		return fmt.Errorf("invalid compression codec: %d", hc.Compression)
+	}
+
+	if utils.SampleFormat(hc.SampleFormat) > utils.MaxSampleFormat {
+		return fmt.Errorf("invalid sample format: %d", hc.SampleFormat)
+	}
+
+	if utils.SampleFormat(hc.SampleFormat) == utils.SampleFormatFloat32 &&
+		(hc.BitDepth != 32 || utils.CodecType(hc.Compression) != utils.CodecPCM) {
+		return fmt.Errorf("float32 samples require bit depth 32 and PCM, got %d-bit %s", hc.BitDepth, utils.CodecType(hc.Compression))
+	}
+
 	return nil
 }
\ No newline at end of file
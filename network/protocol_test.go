@@ -0,0 +1,360 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+// errDeadlineExceeded 模拟 net.Conn 的读超时错误
+var errDeadlineExceeded = errors.New("i/o timeout")
+
+// throttledConn 每次 Read 只返回 chunk 字节并等待 delay，模拟慢速但存活的链路
+type throttledConn struct {
+	data     *bytes.Reader
+	chunk    int
+	delay    time.Duration
+	deadline time.Time
+}
+
+func (tc *throttledConn) Read(p []byte) (int, error) {
+	time.Sleep(tc.delay)
+	if !tc.deadline.IsZero() && time.Now().After(tc.deadline) {
+		return 0, errDeadlineExceeded
+	}
+	if len(p) > tc.chunk {
+		p = p[:tc.chunk]
+	}
+	return tc.data.Read(p)
+}
+
+func (tc *throttledConn) SetReadDeadline(t time.Time) error {
+	tc.deadline = t
+	return nil
+}
+
+func encodePacket(t *testing.T, packet *Packet) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WritePacket(&buf, packet); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadPacketWithTimeoutSlowLink(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xAB}, 32)
+	data := encodePacket(t, NewAudioPacket(payload, 7))
+
+	// 每 20ms 4 字节：头部约 120ms、负载约 160ms 到达，整包超过 200ms 超时，但每个阶段都在超时内
+	conn := &throttledConn{data: bytes.NewReader(data), chunk: 4, delay: 20 * time.Millisecond}
+	packet, err := ReadPacketWithTimeout(conn, 200*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("slow but alive link was treated as dead: %v", err)
+	}
+	if packet.Header.Sequence != 7 || !bytes.Equal(packet.Payload, payload) {
+		t.Fatalf("packet mismatch: seq=%d len=%d", packet.Header.Sequence, len(packet.Payload))
+	}
+	if !conn.deadline.IsZero() {
+		t.Fatalf("read deadline not cleared after packet")
+	}
+}
+
+func TestReadPacketWithTimeoutStalledHeader(t *testing.T) {
+	data := encodePacket(t, NewHeartbeatPacket())
+
+	conn := &throttledConn{data: bytes.NewReader(data), chunk: 1, delay: 10 * time.Millisecond}
+	if _, err := ReadPacketWithTimeout(conn, 30*time.Millisecond, 0); !errors.Is(err, errDeadlineExceeded) {
+		t.Fatalf("expected timeout for stalled header, got %v", err)
+	}
+}
+
+func TestReadPacketWithTimeoutRejectsOversizedAudio(t *testing.T) {
+	data := encodePacket(t, NewAudioPacket(make([]byte, 2048), 1))
+
+	conn := &throttledConn{data: bytes.NewReader(data), chunk: len(data)}
+	if _, err := ReadPacketWithTimeout(conn, time.Second, 1024); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expected ErrInvalidFrame for oversized audio payload, got %v", err)
+	}
+}
+
+func TestReadPacketRejectsBadMagic(t *testing.T) {
+	data := encodePacket(t, NewHeartbeatPacket())
+	data[0] ^= 0xFF
+
+	if _, err := ReadPacket(bytes.NewReader(data)); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expected ErrInvalidFrame for bad magic, got %v", err)
+	}
+}
+
+// 线格式（大端）：
+//
+//	0-3   Magic (0x41554449 "AUDI")
+//	4     Version
+//	5     Type
+//	6     Flags
+//	7     Reserved
+//	8-11  Sequence
+//	12-15 PayloadSize
+//	16-23 Timestamp (Unix 毫秒)
+//	24-   Payload
+func TestPacketRoundTrip(t *testing.T) {
+	handshake := &HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4, Compression: 1}
+
+	packets := []*Packet{
+		NewHandshakePacket(handshake),
+		NewAudioPacket([]byte{1, 2, 3, 4}, 42),
+		NewPacket(PacketTypeControl, []byte{0x01}),
+		NewHeartbeatPacket(),
+		NewErrorPacket("server busy"),
+	}
+
+	for _, original := range packets {
+		original.Header.Flags = 0x5A
+		original.Header.Reserved = 0xA5
+		data := encodePacket(t, original)
+		if len(data) != HeaderSize+len(original.Payload) {
+			t.Fatalf("%s: encoded %d bytes, want %d", original.Header.Type, len(data), HeaderSize+len(original.Payload))
+		}
+
+		decoded, err := ReadPacket(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: ReadPacket: %v", original.Header.Type, err)
+		}
+		if decoded.Header != original.Header {
+			t.Fatalf("%s: header mismatch: got %+v, want %+v", original.Header.Type, decoded.Header, original.Header)
+		}
+		if !bytes.Equal(decoded.Payload, original.Payload) {
+			t.Fatalf("%s: payload mismatch", original.Header.Type)
+		}
+	}
+}
+
+// TestAudioPacketByteOrder 头部字段与采样序号为大端，PCM 音频负载按小端采样原样传输
+func TestAudioPacketByteOrder(t *testing.T) {
+	pcm := make([]byte, 4)
+	binary.LittleEndian.PutUint16(pcm[0:], 0x1234)
+	binary.LittleEndian.PutUint16(pcm[2:], 0xABCD)
+	payload := make([]byte, SampleIndexSize, SampleIndexSize+len(pcm))
+	binary.BigEndian.PutUint64(payload, 0x0102030405060708)
+	packet := NewAudioPacket(append(payload, pcm...), 0x0A0B0C0D)
+	packet.Header.Flags = PacketFlagSampleIndex
+
+	data := encodePacket(t, packet)
+	if got := data[8:12]; !bytes.Equal(got, []byte{0x0A, 0x0B, 0x0C, 0x0D}) {
+		t.Fatalf("sequence bytes = %x, want big-endian 0a0b0c0d", got)
+	}
+	body := data[HeaderSize:]
+	if got := body[:SampleIndexSize]; !bytes.Equal(got, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Fatalf("sample index bytes = %x, want big-endian 0102030405060708", got)
+	}
+	if got := body[SampleIndexSize:]; !bytes.Equal(got, []byte{0x34, 0x12, 0xCD, 0xAB}) {
+		t.Fatalf("PCM bytes = %x, want little-endian 3412cdab", got)
+	}
+
+	decoded, err := ReadPacket(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	audio, index, _, err := audioPayload(decoded)
+	if err != nil || index != 0x0102030405060708 || !bytes.Equal(audio, pcm) {
+		t.Fatalf("audioPayload = %x, %#x, %v", audio, index, err)
+	}
+}
+
+func TestPacketPayloadBoundaries(t *testing.T) {
+	tests := []struct {
+		name      string
+		packet    *Packet
+		writeFail bool
+		readFail  bool
+	}{
+		{"empty audio", NewAudioPacket(nil, 1), false, false},
+		{"max audio", NewAudioPacket(make([]byte, MaxPayloadSize), 2), false, false},
+		{"audio too large", NewAudioPacket(make([]byte, MaxPayloadSize+1), 3), true, false},
+		{"max control", NewPacket(PacketTypeControl, make([]byte, MaxControlPayloadSize)), false, false},
+		{"control too large", NewPacket(PacketTypeControl, make([]byte, MaxControlPayloadSize+1)), false, true},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := WritePacket(&buf, tt.packet)
+		if tt.writeFail {
+			if err == nil {
+				t.Fatalf("%s: expected WritePacket to fail", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: WritePacket: %v", tt.name, err)
+		}
+
+		decoded, err := ReadPacket(&buf)
+		if tt.readFail {
+			if !errors.Is(err, ErrInvalidFrame) {
+				t.Fatalf("%s: expected ErrInvalidFrame, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: ReadPacket: %v", tt.name, err)
+		}
+		if len(decoded.Payload) != len(tt.packet.Payload) {
+			t.Fatalf("%s: payload length %d, want %d", tt.name, len(decoded.Payload), len(tt.packet.Payload))
+		}
+	}
+}
+
+func TestReadPacketTruncated(t *testing.T) {
+	data := encodePacket(t, NewAudioPacket([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 7))
+
+	for n := 0; n < len(data); n++ {
+		if _, err := ReadPacket(bytes.NewReader(data[:n])); err == nil {
+			t.Fatalf("expected error for packet truncated to %d bytes", n)
+		}
+	}
+}
+
+func TestReadPacketBadVersion(t *testing.T) {
+	data := encodePacket(t, NewHeartbeatPacket())
+	data[4] = ProtocolVersion + 1
+
+	_, err := ReadPacket(bytes.NewReader(data))
+	if !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expected ErrInvalidFrame for bad version, got %v", err)
+	}
+	var versionErr *ProtocolVersionError
+	if !errors.As(err, &versionErr) || versionErr.Remote != ProtocolVersion+1 {
+		t.Fatalf("expected ProtocolVersionError with remote version %d, got %v", ProtocolVersion+1, err)
+	}
+}
+
+func TestHandshakeConfigRoundTrip(t *testing.T) {
+	original := HandshakeConfig{SampleRate: 44100, Channels: 6, BitDepth: 24, FramesPerBuffer: 512, BufferCount: 8, Compression: 2}
+
+	data := original.ToBytes()
+	if len(data) != 12 {
+		t.Fatalf("handshake encoded to %d bytes, want 12", len(data))
+	}
+
+	var decoded HandshakeConfig
+	if err := decoded.FromBytes(data); err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+	if decoded != original {
+		t.Fatalf("handshake mismatch: got %+v, want %+v", decoded, original)
+	}
+	if err := decoded.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	if err := decoded.FromBytes(data[:11]); err == nil {
+		t.Fatalf("expected error for short handshake")
+	}
+
+	float32PCM := HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 32, FramesPerBuffer: 960, BufferCount: 4, SampleFormat: uint8(utils.SampleFormatFloat32)}
+	if err := decoded.FromBytes(float32PCM.ToBytes()); err != nil || decoded != float32PCM {
+		t.Fatalf("float32 handshake mismatch: got %+v (%v), want %+v", decoded, err, float32PCM)
+	}
+	if err := decoded.Validate(); err != nil {
+		t.Fatalf("Validate float32: %v", err)
+	}
+	float32PCM.Compression = uint8(utils.CodecFLAC)
+	if err := float32PCM.Validate(); err == nil {
+		t.Fatalf("expected float32 with FLAC to be invalid")
+	}
+
+	// 控制连接：标志位在第 12 字节，令牌非零时追加在配置之后
+	withToken := original
+	withToken.Flags = HandshakeFlagControlChannel
+	withToken.SessionToken[0], withToken.SessionToken[SessionTokenSize-1] = 0xAB, 0xCD
+	data = withToken.ToBytes()
+	if len(data) != 12+SessionTokenSize {
+		t.Fatalf("handshake with token encoded to %d bytes, want %d", len(data), 12+SessionTokenSize)
+	}
+	if err := decoded.FromBytes(data); err != nil || decoded != withToken {
+		t.Fatalf("token handshake mismatch: got %+v (%v), want %+v", decoded, err, withToken)
+	}
+	if err := decoded.FromBytes(original.ToBytes()); err != nil || decoded != original {
+		t.Fatalf("token not cleared when absent: got %+v (%v), want %+v", decoded, err, original)
+	}
+}
+
+// TestAudioPayloadSampleIndex 带 PacketFlagSampleIndex 的音频包负载以 8 字节采样序号开头
+func TestAudioPayloadSampleIndex(t *testing.T) {
+	audio := []byte{1, 2, 3, 4}
+	plain := NewAudioPacket(audio, 1)
+	if data, _, indexed, err := audioPayload(plain); err != nil || indexed || !bytes.Equal(data, audio) {
+		t.Fatalf("plain packet: got %v, indexed=%v, err=%v", data, indexed, err)
+	}
+
+	payload := make([]byte, SampleIndexSize, SampleIndexSize+len(audio))
+	binary.BigEndian.PutUint64(payload, 123456789)
+	indexedPacket := NewAudioPacket(append(payload, audio...), 2)
+	indexedPacket.Header.Flags = PacketFlagSampleIndex
+	decoded, err := ReadPacket(bytes.NewReader(encodePacket(t, indexedPacket)))
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	data, index, indexed, err := audioPayload(decoded)
+	if err != nil || !indexed || index != 123456789 || !bytes.Equal(data, audio) {
+		t.Fatalf("indexed packet: got %v, index=%d, indexed=%v, err=%v", data, index, indexed, err)
+	}
+
+	short := NewAudioPacket([]byte{1, 2, 3}, 3)
+	short.Header.Flags = PacketFlagSampleIndex
+	if _, _, _, err := audioPayload(short); err == nil {
+		t.Fatalf("expected error for a sample index packet shorter than the index")
+	}
+}
+
+// FuzzReadPacket 喂入任意字节：ReadPacket 不得 panic；解析成功时重新编码必须与输入前缀一致
+func FuzzReadPacket(f *testing.F) {
+	handshake := &HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4}
+	f.Add(encodePacketForFuzz(NewHandshakePacket(handshake)))
+	f.Add(encodePacketForFuzz(NewAudioPacket([]byte{0, 1, 2, 3}, 1)))
+	f.Add(encodePacketForFuzz(NewHeartbeatPacket()))
+	f.Add(encodePacketForFuzz(NewErrorPacket("error")))
+	f.Add([]byte{})
+	oversized := encodePacketForFuzz(NewAudioPacket(nil, 1))
+	binary.BigEndian.PutUint32(oversized[12:16], 0xFFFFFFFF)
+	f.Add(oversized)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := ReadPacket(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		if packet.Header.Magic != MagicNumber || packet.Header.Version != ProtocolVersion {
+			t.Fatalf("accepted packet with bad magic/version: %+v", packet.Header)
+		}
+		consumed := HeaderSize + int(packet.Header.PayloadSize)
+		if consumed > len(data) || len(packet.Payload) != int(packet.Header.PayloadSize) {
+			t.Fatalf("payload size %d inconsistent with %d input bytes", packet.Header.PayloadSize, len(data))
+		}
+
+		var buf bytes.Buffer
+		if err := WritePacket(&buf, packet); err != nil {
+			t.Fatalf("re-encoding accepted packet failed: %v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), data[:consumed]) {
+			t.Fatalf("re-encoded packet differs from input")
+		}
+
+		var hc HandshakeConfig
+		if packet.Header.Type == PacketTypeHandshake && hc.FromBytes(packet.Payload) == nil {
+			_ = hc.Validate()
+		}
+	})
+}
+
+func encodePacketForFuzz(packet *Packet) []byte {
+	var buf bytes.Buffer
+	WritePacket(&buf, packet)
+	return buf.Bytes()
+}
@@ -0,0 +1,57 @@
+// network/repacketizer.go - -device-buffer：把任意大小的采集缓冲重新切分为固定帧数的网络包
+
+package network
+
+// repacketizer collects captured audio in chunks of any size and hands it out again in
+// packets of exactly packetFrames sample frames, so the capture device buffer can differ
+// from the negotiated FramesPerBuffer (e.g. 1024-frame device buffers, 960-frame Opus packets)
+type repacketizer struct {
+	frameBytes   int // 每个采样帧的字节数
+	packetBytes  int
+	packetFrames int
+
+	buf   []byte // 尚未发出的采样，从 start 开始
+	start int
+	index uint64 // buf[start] 对应的累计采样序号
+}
+
+// newRepacketizer creates a repacketizer for packets of packetFrames frames of frameBytes bytes
+func newRepacketizer(packetFrames, frameBytes int) *repacketizer {
+	return &repacketizer{
+		frameBytes:   frameBytes,
+		packetBytes:  packetFrames * frameBytes,
+		packetFrames: packetFrames,
+	}
+}
+
+// Push appends a captured chunk whose first sample frame has the cumulative index
+// sampleIndex. If the chunk does not follow the pending audio (frames were dropped, or
+// nothing was pushed while muted) the pending partial packet is discarded.
+func (r *repacketizer) Push(chunk []byte, sampleIndex uint64) {
+	// 前移未发出的数据，复用同一块缓冲
+	pending := copy(r.buf, r.buf[r.start:])
+	r.buf = r.buf[:pending]
+	r.start = 0
+	if pending > 0 && r.index+uint64(pending/r.frameBytes) != sampleIndex {
+		r.buf = r.buf[:0]
+		pending = 0
+	}
+	if pending == 0 {
+		r.index = sampleIndex
+	}
+	r.buf = append(r.buf, chunk...)
+}
+
+// Next returns the next complete packet and the cumulative index of its first sample
+// frame; ok is false when less than one packet is pending. The packet is only valid
+// until the next Push.
+func (r *repacketizer) Next() (packet []byte, sampleIndex uint64, ok bool) {
+	if len(r.buf)-r.start < r.packetBytes {
+		return nil, 0, false
+	}
+	packet = r.buf[r.start : r.start+r.packetBytes]
+	sampleIndex = r.index
+	r.start += r.packetBytes
+	r.index += uint64(r.packetFrames)
+	return packet, sampleIndex, true
+}
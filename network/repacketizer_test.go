@@ -0,0 +1,51 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRepacketizer(t *testing.T) {
+	const frameBytes = 4 // 16 位立体声
+	r := newRepacketizer(960, frameBytes)
+
+	// 1024 帧的设备缓冲切分为 960 帧的包，内容与采样序号连续
+	var captured, sent []byte
+	next := uint64(0)
+	for chunk := 0; chunk < 15; chunk++ {
+		data := make([]byte, 1024*frameBytes)
+		for i := range data {
+			data[i] = byte(chunk*7 + i)
+		}
+		captured = append(captured, data...)
+		r.Push(data, uint64(chunk*1024))
+		for {
+			packet, sampleIndex, ok := r.Next()
+			if !ok {
+				break
+			}
+			if len(packet) != 960*frameBytes || sampleIndex != next {
+				t.Fatalf("packet of %d bytes at sample %d, want %d bytes at %d", len(packet), sampleIndex, 960*frameBytes, next)
+			}
+			sent = append(sent, packet...)
+			next += 960
+		}
+	}
+	if len(sent) != 16*960*frameBytes || !bytes.Equal(sent, captured[:len(sent)]) {
+		t.Fatalf("sent %d bytes that do not match the captured audio", len(sent))
+	}
+
+	// 15×1024 帧正好是 16 个包；采样序号不连续时丢弃未凑满的部分，从新位置重新开始
+	r.Push(make([]byte, 500*frameBytes), 15*1024)
+	if _, _, ok := r.Next(); ok {
+		t.Fatalf("packet emitted from 500 frames")
+	}
+	r.Push(bytes.Repeat([]byte{9}, 960*frameBytes), 20000)
+	packet, sampleIndex, ok := r.Next()
+	if !ok || sampleIndex != 20000 || !bytes.Equal(packet, bytes.Repeat([]byte{9}, 960*frameBytes)) {
+		t.Fatalf("after a gap: packet ok=%v at sample %d", ok, sampleIndex)
+	}
+	if _, _, ok := r.Next(); ok {
+		t.Fatalf("stale frames were kept after a gap")
+	}
+}
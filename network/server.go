@@ -3,15 +3,22 @@
 package network
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"RemoteAudioCLI/audio"
 	"RemoteAudioCLI/utils"
-	"github.com/hraban/opus"
 )
 
 // Server represents a network server for audio streaming
@@ -19,9 +26,20 @@ type Server struct {
 	config             *utils.Config
 	logger             *utils.Logger
 	listener           net.Listener
-	player             *audio.Player
+	player             AudioSink
 	notificationPlayer *audio.NotificationPlayer
 	
+	// 当前输出设备：播放器在设备丢失后切换到新设备时更新，后续会话使用新设备
+	outputDevice *audio.DeviceInfo
+	deviceMutex  sync.Mutex
+	
+	// 额外的输出设备（-output-device 指定多个时），与主设备同时播放
+	extraOutputDevices []*audio.DeviceInfo
+	// SetAudioSink：非 nil 时代替输出设备和管道，每个会话按协商的格式创建
+	newAudioSink func(config *utils.Config) AudioSink
+	// 本次会话的全部输出；player 指向其中第一个成功初始化的播放器（用于统计）
+	outputs []*outputSink
+	
 	// Connection state
 	running     int32 // atomic bool
 	clientConn  net.Conn
@@ -29,64 +47,208 @@ type Server struct {
 	
 	// Connection keepalive tracking
 	lastActivity time.Time
+	lastAudio    time.Time // 最后收到音频包的时间，用于 -idle-timeout（心跳不算）
 	activityMutex sync.RWMutex
 	
-	// Audio configuration (negotiated during handshake)
+	// Audio configuration (negotiated during handshake)，状态页读取（connectionMutex 保护）
 	audioConfig *HandshakeConfig
 	
-	// Statistics
-	stats *utils.NetworkStats
+	// Statistics: stats 为当前会话，断开时累加到 totalStats（已结束会话的累计）后清零
+	stats      *utils.NetworkStats
+	totalStats *utils.NetworkStats
+	
+	// 音频包序列号跟踪（仅在 packetProcessingLoop 中访问）
+	expectedSequence uint32
+	sequenceStarted  bool
+	
+	// -sample-index：期望的下一个采样序号（仅在 packetProcessingLoop 中访问）
+	expectedSampleIndex uint64
+	sampleIndexStarted  bool
 	
 	// Control channels for main server loop
-	stopChan   chan struct{}
 	errorChan  chan error
 	
-	// Control channels for client session - 使用指针以便重新创建
-	clientStopChan *chan struct{}
-	clientWg       sync.WaitGroup
+	// Client session lifecycle - 每个会话从服务端 context 派生新的 context
+	cancelSession context.CancelFunc
+	clientWg      sync.WaitGroup
+	
+	// -control-channel：握手时生成的会话令牌，携带该令牌的第二条连接成为控制连接（connectionMutex 保护）
+	sessionCtx   context.Context
+	sessionToken [SessionTokenSize]byte
+	controlConn  net.Conn
+	
+	// -psk：本会话的音频负载解密与重放窗口，未启用时为 nil（仅在 packetProcessingLoop 与握手中访问）
+	cipher *packetCipher
+	replay *replayWindow
 	
 	// Connection management
 	connectionMutex sync.Mutex
 	
-	opusDecoder *opus.Decoder
+	opusDecoder *opusMultiDecoder
 	useOpus     bool
+	flacDecoder *flacDecoder
+	
+	// Opus 解码的复用缓冲区（仅在 packetProcessingLoop 中访问），握手后按音频格式分配；
+	// 播放器入队时会复制数据，因此每个包都可以覆盖上一个包的内容
+	pcm16   []int16
+	pcmData []byte
+	
+	// 当前会话允许的最大音频负载（握手后根据音频格式计算）
+	maxAudioPayload uint32
+	
+	// 本次运行的生命周期（由 Serve 的 ctx、Stop 或 -duration 触发关闭），每次 Serve 重新创建
+	shutdown *ConnectionManager
+	
+	// 运行时命令设置的播放音量（百分比）与静音，跨会话保留（connectionMutex 保护）
+	volumePercent int
+	muted         bool
+	commandReader *utils.CommandReader
+	
+	// -preopen-output：会话之间保持打开的输出及其打开时的设备和格式（connectionMutex 保护）
+	preopened       []*outputSink
+	preopenedDevice *audio.DeviceInfo
+	preopenedConfig utils.Config
+	
+	// 白名单/黑名单中主机名条目解析出的地址（connectionMutex 保护），在加载、Reload 时及定期解析，
+	// 接受连接时不做 DNS 查询；clientHostsMutex 使各次解析按顺序完成，结果总是对应最新的列表
+	clientHosts      map[string][]net.IP
+	clientHostsMutex sync.Mutex
+}
+
+// outputSink 一个输出设备上的播放器；resampler 非 nil 时先把流重采样到设备支持的采样率
+type outputSink struct {
+	player    AudioSink
+	resampler *audio.Resampler
 }
 
-// NewServer creates a new network server
+// queue 将解码后的 PCM 交给该输出的播放器；重采样后的块与原始帧不再一一对应，不带采样序号
+func (o *outputSink) queue(pcmData []byte, capturedAt time.Time, endSample uint64) {
+	if o.resampler == nil {
+		o.player.QueueAudioIndexed(pcmData, capturedAt, endSample)
+		return
+	}
+	for _, chunk := range o.resampler.Process(pcmData) {
+		o.player.QueueAudioIndexed(chunk, capturedAt, 0)
+	}
+}
+
+// NewServer creates a new network server; a nil logger uses utils.NewLogger()
 func NewServer(config *utils.Config, logger *utils.Logger) *Server {
+	if logger == nil {
+		logger = utils.NewLogger()
+	}
 	return &Server{
 		config:    config,
 		logger:    logger,
-		stopChan:  make(chan struct{}),
 		errorChan: make(chan error, 10),
 		stats: &utils.NetworkStats{
 			BytesSent:     0,
 			BytesReceived: 0,
 			ErrorCount:    0,
 		},
+		totalStats: &utils.NetworkStats{},
+		volumePercent: config.Volume,
 	}
 }
 
-// Start initiates the server and begins listening for connections
+// Reload applies the hot-reloadable settings of a running server: the allowed and denied
+// client lists and the playback volume. A connected client that is no longer admitted is disconnected.
+func (s *Server) Reload(allowClients, denyClients []string, volumePercent int) {
+	s.connectionMutex.Lock()
+	s.config.AllowClients = allowClients
+	s.config.DenyClients = denyClients
+	s.volumePercent = volumePercent
+	s.connectionMutex.Unlock()
+	s.applyVolume()
+	s.resolveClientHosts()
+
+	s.connectionMutex.Lock()
+	var connectedIP string
+	if atomic.LoadInt32(&s.connected) == 1 && s.clientConn != nil {
+		connectedIP = remoteIPOf(s.clientConn)
+	}
+	hosts := s.clientHosts
+	s.connectionMutex.Unlock()
+	if connectedIP == "" {
+		return
+	}
+	if admitted, reason := admitClient(connectedIP, allowClients, denyClients, hosts); !admitted {
+		s.logger.Warnf("Disconnecting %s: %s", connectedIP, reason)
+		s.forceStopClientSession()
+	}
+}
+
+// AddOutputDevice adds a device that plays the received audio in addition to the one passed to Serve.
+// Each device gets its own player; one device failing does not stop playback on the others.
+// It must be called before Serve and is ignored when config.OutputPipe is set or SetAudioSink is used.
+func (s *Server) AddOutputDevice(device *audio.DeviceInfo) {
+	s.extraOutputDevices = append(s.extraOutputDevices, device)
+}
+
+// SetAudioSink replaces the output device or -output-pipe with a sink created by newSink for
+// each session, with the negotiated stream format. It must be called before Serve; the sink
+// gets no notification sounds and is not pre-opened.
+func (s *Server) SetAudioSink(newSink func(config *utils.Config) AudioSink) {
+	s.newAudioSink = newSink
+}
+
+// Start initiates the server and begins listening for connections until Stop is called
 func (s *Server) Start(outputDevice *audio.DeviceInfo) error {
-	s.logger.Info("🔊 Starting audio server...")
+	return s.Serve(context.Background(), outputDevice)
+}
+
+// Serve listens for clients and plays their audio until ctx is cancelled, Stop
+// is called or the configured duration elapses. A nil outputDevice uses the
+// default output device (ignored when config.OutputPipe is set or a sink was set with
+// SetAudioSink). It returns nil
+// after a requested shutdown; a stopped Server can be served again.
+func (s *Server) Serve(ctx context.Context, outputDevice *audio.DeviceInfo) error {
+	if err := s.config.ValidateKeepalive(); err != nil {
+		return err
+	}
+	if s.newAudioSink != nil {
+		outputDevice = nil
+	} else if outputDevice == nil && s.config.OutputPipe == "" {
+		device, err := audio.GetDefaultOutputDevice()
+		if err != nil {
+			return err
+		}
+		outputDevice = device
+	}
 	
-	// 注册关闭回调
-	RegisterShutdownCallback(func() {
-		s.Stop()
-	})
+	s.shutdown = NewConnectionManager(ctx)
+	// 接受循环在关闭信号后一秒内退出，返回前同步完成清理
+	defer s.Stop()
+	
+	s.logger.Info("🔊 Starting audio server...")
 
-	// 创建通知播放器
-	s.notificationPlayer = audio.NewNotificationPlayer(outputDevice, s.config, s.logger)
+	// 创建通知播放器（输出到管道时没有声卡，不播放提示音）
+	s.setOutputDevice(outputDevice)
+	if outputDevice != nil {
+		s.notificationPlayer = audio.NewNotificationPlayer(outputDevice, s.config, s.logger)
+	}
+	
+	s.resolveClientHosts()
+	go s.clientHostsRefreshLoop(s.shutdown.Context())
 	
 	// Start listening
 	if err := s.startListening(); err != nil {
 		return utils.WrapError(err, utils.ErrNetwork, "failed to start listening")
 	}
+	// 监听成功后才预先打开输出，端口被占用等失败不会占着音频设备
+	s.preopenOutputs(outputDevice)
 	
-	s.logger.Infof("📡 Server listening on %s", s.config.GetNetworkAddress())
+	// 打印实际绑定的地址（-port 0 时包含系统分配的端口）
+	s.logger.Infof("📡 Server listening on %s", s.listener.Addr())
 	s.logger.Info("💡 Press Ctrl+C to stop the server")
 	atomic.StoreInt32(&s.running, 1)
+	s.startRuntimeCommands()
+	
+	// 到达 -duration 指定的运行时间后自动关闭
+	if s.config.Duration > 0 {
+		s.logger.Infof("⏲️ Server will stop automatically after %v", s.config.Duration)
+		go s.shutdown.scheduleShutdown(s.config.Duration, s.logger)
+	}
 	
 	// 等待一小段时间让系统稳定
 	time.Sleep(200 * time.Millisecond)
@@ -101,7 +263,7 @@ func (s *Server) Start(outputDevice *audio.DeviceInfo) error {
 	}
 	
 	// Accept connections in a loop
-	for atomic.LoadInt32(&s.running) == 1 && !IsShutdownRequested() {
+	for atomic.LoadInt32(&s.running) == 1 && !s.shutdown.IsShutdownRequested() {
 		// 设置接受连接的超时，以便检查关闭信号
 		if tcpListener, ok := s.listener.(*net.TCPListener); ok {
 			tcpListener.SetDeadline(time.Now().Add(1 * time.Second))
@@ -109,7 +271,7 @@ func (s *Server) Start(outputDevice *audio.DeviceInfo) error {
 
 		conn, err := s.listener.Accept()
 		if err != nil {
-			if atomic.LoadInt32(&s.running) == 0 || IsShutdownRequested() {
+			if atomic.LoadInt32(&s.running) == 0 || s.shutdown.IsShutdownRequested() {
 				break // Server is shutting down
 			}
 			
@@ -118,42 +280,33 @@ func (s *Server) Start(outputDevice *audio.DeviceInfo) error {
 				continue // 超时，继续监听
 			}
 			
-			s.logger.Error(fmt.Sprintf("Failed to accept connection: %v", err))
+			s.logger.ErrorRateLimited("accept", fmt.Sprintf("Failed to accept connection: %v", err))
 			continue
 		}
 		
 		s.logger.Info("🔗 Client connected from: " + conn.RemoteAddr().String())
 		
-		// 在 Start 方法或主 accept 循环处加白名单校验
-		// 伪代码：
-		// for {
-		//   conn, err := listener.Accept()
-		//   if err != nil { ... }
-		//   if !isIPAllowed(conn.RemoteAddr().IP, s.config.AllowClients) {
-		//     日志，关闭conn，continue
-		//   }
-		//   go s.handleClient(conn, ...)
-		// }
-		//
-		// 新增 isIPAllowed 工具函数
-		remoteIP := ""
-		if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-			remoteIP = tcpAddr.IP.String()
-		} else {
-			remoteIP = conn.RemoteAddr().String()
-		}
-		if !isIPAllowed(remoteIP, s.config.AllowClients) {
-			s.logger.Warnf("Rejected connection from %s: not in allowed client list", remoteIP)
+		remoteIP := remoteIPOf(conn)
+		// 白名单与黑名单可通过 Reload 热更新（SIGHUP）
+		s.connectionMutex.Lock()
+		allowClients, denyClients, hosts := s.config.AllowClients, s.config.DenyClients, s.clientHosts
+		s.connectionMutex.Unlock()
+		admitted, reason := admitClient(remoteIP, allowClients, denyClients, hosts)
+		if !admitted {
+			s.logger.Warnf("Rejected connection from %s: %s", remoteIP, reason)
 			conn.Close()
 			continue
 		}
+		if len(allowClients) > 0 || len(denyClients) > 0 {
+			s.logger.Infof("🛂 Accepted connection from %s: %s", remoteIP, reason)
+		}
 		
 		// 使用互斥锁保护连接状态检查
 		s.connectionMutex.Lock()
 		if atomic.LoadInt32(&s.connected) == 1 {
-			s.logger.Warn("Another client is already connected, closing new connection")
-			conn.Close()
 			s.connectionMutex.Unlock()
+			// 可能是当前会话的控制连接，读到第一个包后再决定是否拒绝
+			go s.attachControlConnection(conn)
 			continue
 		}
 		
@@ -161,14 +314,17 @@ func (s *Server) Start(outputDevice *audio.DeviceInfo) error {
 		atomic.StoreInt32(&s.connected, 1)
 		s.connectionMutex.Unlock()
 		
-		// 播放连接提示音（延迟3秒，且连接还存活才播放）
+		// 播放连接提示音（延迟 -connect-sound-delay，且连接还存活才播放）；
+		// 淡入在 connectionSoundDone 关闭后才开始，即延迟加上提示音的时长
 		connectionSoundDone := make(chan struct{})
 		go func() {
-			time.Sleep(3 * time.Second)
-			if atomic.LoadInt32(&s.connected) == 1 && !IsShutdownRequested() {
+			time.Sleep(s.config.ConnectSoundDelay)
+			if atomic.LoadInt32(&s.connected) == 1 && !s.shutdown.IsShutdownRequested() {
 				s.logger.Info("🟢 Connection Healthy")
-				done := s.notificationPlayer.PlayConnectionSound()
-				<-done // 等待连接音效播放完成
+				if s.notificationPlayer != nil {
+					done := s.notificationPlayer.PlayConnectionSound()
+					<-done // 等待连接音效播放完成
+				}
 				close(connectionSoundDone)
 			} else {
 				close(connectionSoundDone)
@@ -177,7 +333,7 @@ func (s *Server) Start(outputDevice *audio.DeviceInfo) error {
 		
 		// Handle the client connection in a separate goroutine
 		// 关键修改：使用 goroutine 处理客户端连接，避免阻塞主循环
-		go s.handleClient(conn, outputDevice, connectionSoundDone)
+		go s.handleClient(conn, s.currentOutputDevice(), connectionSoundDone)
 	}
 	
 	s.logger.Info("✅ Server stopped")
@@ -186,22 +342,31 @@ func (s *Server) Start(outputDevice *audio.DeviceInfo) error {
 
 // Stop gracefully shuts down the server
 func (s *Server) Stop() {
-	s.logger.Info("🛑 Stopping server...")
+	// Signal stop to the accept loop and the client session
+	if s.shutdown != nil {
+		s.shutdown.NotifyShutdown()
+	}
 	
-	// Mark as not running
-	atomic.StoreInt32(&s.running, 0)
+	// Mark as not running (只执行一次清理)
+	if !atomic.CompareAndSwapInt32(&s.running, 1, 0) {
+		return
+	}
+	
+	s.logger.Info("🛑 Stopping server...")
+	s.commandReader.Stop()
 	
 	// Stop current client session
 	s.forceStopClientSession()
+	if s.config.DrainTimeout > 0 {
+		s.waitSessionCleanup(s.config.DrainTimeout + 2*time.Second)
+	}
+	s.releasePreopenedOutputs()
 	
 	// Close listener
 	if s.listener != nil {
 		s.listener.Close()
 	}
 	
-	// Signal stop to main server
-	close(s.stopChan)
-	
 	s.logger.Info("✅ Server stopped")
 }
 
@@ -216,16 +381,26 @@ func (s *Server) forceStopClientSession() {
 	
 	s.logger.Info("🔌 Force stopping client session...")
 	
-	// 强制关闭连接来中断阻塞的读取
+	// 取消会话 context，并强制关闭连接来中断阻塞的读取
+	if s.cancelSession != nil {
+		s.cancelSession()
+	}
 	if s.clientConn != nil {
 		s.clientConn.Close()
 	}
 	
 	// 等待 handleClient 完成清理
-	// 注意：不要在这里关闭 clientStopChan，让 handleClient 的 defer 处理
 	time.Sleep(100 * time.Millisecond)
 }
 
+// waitSessionCleanup 等待 cleanupClientSession 完成（例如排空播放缓冲），最多等待 timeout
+func (s *Server) waitSessionCleanup(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for s.shutdown.ActiveConnections() > 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
 // cleanupClientSession 清理客户端会话 (在 handleClient 中调用)
 func (s *Server) cleanupClientSession() {
 	s.logger.Info("🔌 Cleaning up client session...")
@@ -238,15 +413,43 @@ func (s *Server) cleanupClientSession() {
 	// 更新连接状态
 	s.connectionMutex.Lock()
 	atomic.StoreInt32(&s.connected, 0)
+	// 关闭音频连接：客户端据此知道会话已结束，可以立即重新连接
+	if s.clientConn != nil {
+		s.clientConn.Close()
+	}
 	s.clientConn = nil
-	s.clientStopChan = nil
+	s.cancelSession = nil
+	s.sessionCtx = nil
+	s.sessionToken = [SessionTokenSize]byte{}
+	if s.controlConn != nil {
+		s.controlConn.Close()
+		s.controlConn = nil
+	}
 	s.connectionMutex.Unlock()
 	
 	// 清理音频播放器
-	if s.player != nil {
-		s.player.Stop()
-		s.player.Terminate()
-		s.player = nil
+	s.connectionMutex.Lock()
+	outputs := s.outputs
+	s.outputs = nil
+	s.player = nil
+	s.connectionMutex.Unlock()
+	// 服务端正常关闭时先播完已缓冲的音频（-drain-timeout），否则立即渐出
+	drain := s.config.DrainTimeout > 0 && s.shutdown.IsShutdownRequested()
+	for _, output := range outputs {
+		if drain {
+			if !output.player.Drain(s.config.DrainTimeout) {
+				s.logger.Warnf("Buffered audio not fully played within -drain-timeout %v", s.config.DrainTimeout)
+			}
+			output.player.StopWithFadeOut(0)
+		} else {
+			output.player.StopWithFadeOut(s.config.FadeDuration)
+		}
+		output.player.Terminate()
+	}
+	
+	// 按本次会话的格式为下一个客户端重新预打开输出
+	if atomic.LoadInt32(&s.running) == 1 && !s.shutdown.IsShutdownRequested() {
+		s.preopenOutputs(s.currentOutputDevice())
 	}
 	
 	// 清理Opus解码器
@@ -254,16 +457,20 @@ func (s *Server) cleanupClientSession() {
 		s.opusDecoder = nil
 	}
 	s.useOpus = false
+	s.flacDecoder = nil
+	s.pcm16 = nil
+	s.pcmData = nil
 	
-	// 减少连接计数
-	DecrementConnections()
+	// 会话统计计入累计并清零
+	s.finishSessionStats()
 	
-	// 注意：不在这里关闭 clientStopChan，因为 handleClient 的 defer 函数会处理它
+	// 减少连接计数
+	s.shutdown.DecrementConnections()
 	
 	// 等待客户端 goroutine 结束（这个等待已在 handleClient 的 defer 中完成）
 	
 	// 如果不是服务端主动关闭，显示等待新连接的提示
-	if atomic.LoadInt32(&s.running) == 1 && !IsShutdownRequested() {
+	if atomic.LoadInt32(&s.running) == 1 && !s.shutdown.IsShutdownRequested() {
 		s.logger.Info("🔄 Client disconnected, waiting for new connections...")
 		s.logger.Info("📡 Server is ready to accept new client connections")
 	}
@@ -271,7 +478,10 @@ func (s *Server) cleanupClientSession() {
 
 // startListening creates and starts the TCP listener
 func (s *Server) startListening() error {
-	address := s.config.GetNetworkAddress()
+	address, err := ListenAddress(s.config)
+	if err != nil {
+		return err
+	}
 	
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
@@ -279,41 +489,137 @@ func (s *Server) startListening() error {
 	}
 	
 	s.listener = listener
+	
+	// -port 0 时由系统分配端口：回写到配置，使日志和端口文件反映实际端口
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && s.config.Port != tcpAddr.Port {
+		s.config.Port = tcpAddr.Port
+		s.logger.Infof("🎯 Ephemeral port assigned: %d", tcpAddr.Port)
+	}
+	
+	// 将实际端口写入文件，供脚本或同机客户端读取
+	if s.config.PortFile != "" {
+		if err := os.WriteFile(s.config.PortFile, []byte(strconv.Itoa(s.config.Port)+"\n"), 0644); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to write port file %s: %w", s.config.PortFile, err)
+		}
+	}
 	return nil
 }
 
+// ListenAddress returns the address the server binds to: config.Host, or the address of
+// config.Interface when an interface name is given (IPv4 preferred)
+func ListenAddress(config *utils.Config) (string, error) {
+	if config.Interface == "" {
+		return config.GetNetworkAddress(), nil
+	}
+	
+	iface, err := net.InterfaceByName(config.Interface)
+	if err != nil {
+		return "", fmt.Errorf("network interface %q not found: %w", config.Interface, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return "", fmt.Errorf("network interface %q is down", config.Interface)
+	}
+	
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get addresses of interface %q: %w", config.Interface, err)
+	}
+	
+	// 优先 IPv4，其次全局 IPv6，最后链路本地 IPv6（需要带上接口作为 zone）
+	var ipv6, linkLocal string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		switch {
+		case ip.To4() != nil:
+			return net.JoinHostPort(ip.String(), strconv.Itoa(config.Port)), nil
+		case ip.IsLinkLocalUnicast():
+			if linkLocal == "" {
+				linkLocal = ip.String() + "%" + iface.Name
+			}
+		default:
+			if ipv6 == "" {
+				ipv6 = ip.String()
+			}
+		}
+	}
+	if ipv6 == "" {
+		ipv6 = linkLocal
+	}
+	if ipv6 == "" {
+		return "", fmt.Errorf("network interface %q has no IP address", config.Interface)
+	}
+	return net.JoinHostPort(ipv6, strconv.Itoa(config.Port)), nil
+}
+
+// Addr returns the address the server is listening on (nil when not listening).
+// With Port 0 this reports the port chosen by the operating system.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// setOutputDevice 设置当前输出设备
+func (s *Server) setOutputDevice(device *audio.DeviceInfo) {
+	s.deviceMutex.Lock()
+	s.outputDevice = device
+	s.deviceMutex.Unlock()
+}
+
+// currentOutputDevice 返回当前输出设备
+func (s *Server) currentOutputDevice() *audio.DeviceInfo {
+	s.deviceMutex.Lock()
+	defer s.deviceMutex.Unlock()
+	return s.outputDevice
+}
+
+// onOutputDeviceChanged 播放器在原输出设备消失后切换到了新设备：记录日志并在新设备上提示
+func (s *Server) onOutputDeviceChanged(device *audio.DeviceInfo) {
+	s.setOutputDevice(device)
+	s.logger.Warnf("🎧 Output device changed, playback continues on: %s", device.Name)
+	if s.notificationPlayer != nil {
+		s.notificationPlayer.SetDevice(device)
+		s.notificationPlayer.PlayDeviceChangeBeep()
+	}
+}
+
 // handleClient handles a single client connection
 func (s *Server) handleClient(conn net.Conn, outputDevice *audio.DeviceInfo, connectionSoundDone chan struct{}) {
-	// 为这个客户端会话创建新的控制通道
-	clientStopChan := make(chan struct{})
-	s.clientStopChan = &clientStopChan
+	// 为这个客户端会话创建新的 context，服务端关闭时随之取消
+	sessionCtx, cancelSession := context.WithCancel(s.shutdown.Context())
+	// 播放循环不随会话 context 结束，由清理时的渐出（或 -drain-timeout 排空）停止
+	playbackCtx, stopPlayback := context.WithCancel(context.Background())
+	s.connectionMutex.Lock()
+	s.cancelSession = cancelSession
+	s.sessionCtx = sessionCtx
 	s.clientConn = conn
-	IncrementConnections()
+	s.connectionMutex.Unlock()
+	s.shutdown.IncrementConnections()
+	
+	// 新会话的序列号从头开始计数
+	s.expectedSequence = 0
+	s.sequenceStarted = false
+	s.expectedSampleIndex = 0
+	s.sampleIndexStarted = false
 	
 	// 初始化连接活跃时间
 	s.activityMutex.Lock()
 	s.lastActivity = time.Now()
+	s.lastAudio = s.lastActivity
 	s.activityMutex.Unlock()
 	
-	// 创建一个用于协调清理的context
-	sessionDone := make(chan struct{})
-	
-	// 用于防止多次关闭 channel
-	var stopChanClosed int32 // atomic bool
-	
-	// 安全关闭 clientStopChan 的函数
-	closeClientStopChan := func() {
-		if atomic.CompareAndSwapInt32(&stopChanClosed, 0, 1) {
-			close(clientStopChan)
-		}
-	}
-	
 	// 确保在函数结束时清理会话
 	defer func() {
 		s.logger.Info("🔌 Client session ended")
 		
-		// 安全关闭 clientStopChan 通知所有 goroutine 停止
-		closeClientStopChan()
+		// 取消会话 context 通知所有 goroutine 停止
+		cancelSession()
 		
 		// 等待所有 goroutine 结束，但设置超时
 		done := make(chan struct{})
@@ -331,7 +637,7 @@ func (s *Server) handleClient(conn net.Conn, outputDevice *audio.DeviceInfo, con
 		
 		// 执行清理
 		s.cleanupClientSession()
-		close(sessionDone)
+		stopPlayback()
 	}()
 	
 	// Perform handshake
@@ -342,14 +648,28 @@ func (s *Server) handleClient(conn net.Conn, outputDevice *audio.DeviceInfo, con
 	
 	s.logger.Info("🤝 Handshake completed with client")
 	
-	// Initialize audio player with negotiated configuration
-	s.player = audio.NewPlayer(outputDevice, s.config, s.logger)
-	if err := s.player.Initialize(); err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to initialize audio player: %v", err))
+	// Initialize audio players with negotiated configuration (reusing pre-opened ones when the format matches)
+	outputs := s.takePreopenedOutputs(outputDevice)
+	if outputs == nil {
+		outputs = s.initializeOutputs(outputDevice)
+	}
+	if len(outputs) == 0 {
 		return
 	}
+	s.connectionMutex.Lock()
+	s.outputs = outputs
+	s.player = outputs[0].player
+	s.connectionMutex.Unlock()
+	s.applyVolume()
 	
 	s.logger.Info("🔊 Audio player initialized")
+	var deviceLatency time.Duration
+	for _, output := range outputs {
+		if latency := output.player.DeviceLatency(); latency > deviceLatency {
+			deviceLatency = latency
+		}
+	}
+	s.logger.Infof("⏱️ Latency: %s", s.config.LatencySummary(deviceLatency))
 	
 	// 等待连接音效播放完成后再启动音频播放
 	go func() {
@@ -357,14 +677,21 @@ func (s *Server) handleClient(conn net.Conn, outputDevice *audio.DeviceInfo, con
 
 		// 防止 player 已被清理
 		s.connectionMutex.Lock()
-		player := s.player
+		outputs := s.outputs
 		s.connectionMutex.Unlock()
-		if player == nil {
+		if len(outputs) == 0 {
 			s.logger.Warn("Audio player was cleaned up before fade-in could start (client disconnected early)")
 			return
 		}
-		if err := player.StartWithFadeIn(500 * time.Millisecond); err != nil {
-			s.logger.Error(fmt.Sprintf("Failed to start audio player: %v", err))
+		started := 0
+		for _, output := range outputs {
+			if err := output.player.StartWithFadeIn(playbackCtx, s.config.FadeDuration); err != nil {
+				s.logger.Error(fmt.Sprintf("Failed to start audio player: %v", err))
+				continue
+			}
+			started++
+		}
+		if started == 0 {
 			return
 		}
 
@@ -374,33 +701,226 @@ func (s *Server) handleClient(conn net.Conn, outputDevice *audio.DeviceInfo, con
 	
 	// Start background routines for this client session
 	s.clientWg.Add(2)
-	go s.statisticsLoop(clientStopChan, sessionDone)
-	go s.connectionMonitorLoop(conn, clientStopChan, sessionDone)
+	go s.statisticsLoop(sessionCtx)
+	go s.connectionMonitorLoop(sessionCtx, conn)
 	
 	// 主要的数据处理循环 (阻塞)
-	s.packetProcessingLoop(conn, clientStopChan)
+	s.packetProcessingLoop(sessionCtx, conn)
 	
 	// 数据处理循环结束，意味着客户端断开连接
 	s.logger.Info("📤 Packet processing ended, client disconnected")
 }
 
+// startRuntimeCommands 启动运行时命令：q 停止服务端、s 统计、v 播放音量、m 切换静音（标准输入不是终端时跳过）
+func (s *Server) startRuntimeCommands() {
+	s.commandReader = startCommandPrompt(s.logger, runtimeCommands{
+		quit: func() {
+			s.logger.Info("👋 Quit requested")
+			s.shutdown.NotifyShutdown()
+		},
+		stats: func() {
+			stats := s.GetStats()
+			s.logger.LogNetworkStats(stats)
+			if stats.Total != nil {
+				s.logger.Infof("📈 Since start - Received: %d KB, Packets: %d, Lost: %d (%.1f%%)",
+					stats.Total.BytesReceived/1024, stats.Total.PacketsReceived, stats.Total.PacketsLost, stats.Total.LossPercent())
+			}
+			s.connectionMutex.Lock()
+			player := s.player
+			s.connectionMutex.Unlock()
+			if player != nil {
+				s.logger.LogAudioStats(player.GetStats())
+			}
+		},
+		volume: func(percent int) {
+			s.connectionMutex.Lock()
+			s.volumePercent = percent
+			s.connectionMutex.Unlock()
+			s.applyVolume()
+			s.logger.Infof("🎚️ Playback volume set to %d%%", percent)
+		},
+		mute: func() {
+			s.connectionMutex.Lock()
+			s.muted = !s.muted
+			muted := s.muted
+			s.connectionMutex.Unlock()
+			s.applyVolume()
+			if muted {
+				s.logger.Info("🔇 Playback muted")
+			} else {
+				s.logger.Info("🔊 Playback unmuted")
+			}
+		},
+	})
+}
+
+// applyVolume 把当前音量与静音状态应用到所有输出（管道输出不受影响）
+func (s *Server) applyVolume() {
+	s.connectionMutex.Lock()
+	defer s.connectionMutex.Unlock()
+	volume := float64(s.volumePercent) / 100
+	if s.muted {
+		volume = 0
+	}
+	for _, output := range s.outputs {
+		output.player.SetVolume(volume)
+	}
+}
+
+// preopenOutputs opens the output streams with the current config ahead of a session
+// (-preopen-output; pipe output opens instantly and is not pre-opened)
+func (s *Server) preopenOutputs(outputDevice *audio.DeviceInfo) {
+	if !s.config.PreopenOutput || s.config.OutputPipe != "" || outputDevice == nil {
+		return
+	}
+	
+	outputs := s.initializeOutputs(outputDevice)
+	if len(outputs) == 0 {
+		return
+	}
+	
+	s.connectionMutex.Lock()
+	if atomic.LoadInt32(&s.connected) == 1 || s.preopened != nil {
+		// 新会话已经自己打开了输出，不再保留这一组
+		s.connectionMutex.Unlock()
+		terminateOutputs(outputs)
+		return
+	}
+	s.preopened = outputs
+	s.preopenedDevice = outputDevice
+	s.preopenedConfig = *s.config
+	s.connectionMutex.Unlock()
+	
+	s.logger.Infof("🔈 Output pre-opened (%dHz, %dch, %d-bit), ready for the next client",
+		s.config.SampleRate, s.config.Channels, s.config.BitDepth)
+}
+
+// takePreopenedOutputs hands the pre-opened outputs to a session whose negotiated format and
+// output device match the ones they were opened with. Otherwise they are closed so the
+// session can reopen the device, and nil is returned.
+func (s *Server) takePreopenedOutputs(outputDevice *audio.DeviceInfo) []*outputSink {
+	s.connectionMutex.Lock()
+	outputs := s.preopened
+	opened := s.preopenedConfig
+	sameDevice := s.preopenedDevice == outputDevice
+	s.preopened = nil
+	s.preopenedDevice = nil
+	s.connectionMutex.Unlock()
+	
+	if outputs == nil {
+		return nil
+	}
+	if sameDevice && sameOutputFormat(&opened, s.config) {
+		s.logger.Info("🔈 Using the pre-opened output")
+		return outputs
+	}
+	
+	s.logger.Infof("🔁 Negotiated format (%dHz, %dch, %d-bit) differs from the pre-opened output (%dHz, %dch, %d-bit), reopening",
+		s.config.SampleRate, s.config.Channels, s.config.BitDepth, opened.SampleRate, opened.Channels, opened.BitDepth)
+	terminateOutputs(outputs)
+	return nil
+}
+
+// releasePreopenedOutputs closes outputs that are still waiting for a session
+func (s *Server) releasePreopenedOutputs() {
+	s.connectionMutex.Lock()
+	outputs := s.preopened
+	s.preopened = nil
+	s.preopenedDevice = nil
+	s.connectionMutex.Unlock()
+	terminateOutputs(outputs)
+}
+
+// sameOutputFormat 比较决定输出流与播放缓冲区的格式参数
+func sameOutputFormat(a, b *utils.Config) bool {
+	return a.SampleRate == b.SampleRate && a.Channels == b.Channels && a.BitDepth == b.BitDepth &&
+		a.SampleFormat == b.SampleFormat && a.FramesPerBuffer == b.FramesPerBuffer && a.BufferCount == b.BufferCount
+}
+
+// terminateOutputs 释放尚未开始播放的输出
+func terminateOutputs(outputs []*outputSink) {
+	for _, output := range outputs {
+		output.player.Terminate()
+	}
+}
+
+// initializeOutputs 为 SetAudioSink、管道或每个输出设备创建并初始化播放器；初始化失败的设备记录错误后跳过
+func (s *Server) initializeOutputs(outputDevice *audio.DeviceInfo) []*outputSink {
+	if s.newAudioSink != nil || s.config.OutputPipe != "" {
+		var player AudioSink
+		if s.newAudioSink != nil {
+			player = s.newAudioSink(s.config)
+		} else {
+			player = audio.NewPipePlayer(s.config.OutputPipe, s.config, s.logger)
+		}
+		if err := player.Initialize(); err != nil {
+			s.logger.Error(fmt.Sprintf("Failed to initialize audio player: %v", err))
+			return nil
+		}
+		return []*outputSink{{player: player}}
+	}
+	
+	var outputs []*outputSink
+	devices := append([]*audio.DeviceInfo{outputDevice}, s.extraOutputDevices...)
+	for i, device := range devices {
+		output, err := s.newOutputSink(device, i == 0)
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("Failed to initialize audio player on %s: %v", device.Name, err))
+			continue
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+// newOutputSink 在设备上创建播放器。设备不支持流的采样率时改用设备默认采样率并重采样；
+// 只有主设备在丢失后切换到默认输出设备，额外设备切过去会与主设备重复播放
+func (s *Server) newOutputSink(device *audio.DeviceInfo, primary bool) (*outputSink, error) {
+	config := *s.config
+	output := &outputSink{}
+	if !primary {
+		config.DeviceReopenAttempts = 0
+	}
+	
+	depth := audio.SampleDepth(s.config)
+	deviceRate := int(device.DefaultSampleRate)
+	deviceChannels := audio.OutputDeviceChannels(s.config) // -output-channel-map 时设备流的声道数多于流
+	if err := audio.CheckFormatSupported(device, false, s.config.SampleRate, deviceChannels, depth); err != nil &&
+		deviceRate > 0 && deviceRate != s.config.SampleRate &&
+		audio.CheckFormatSupported(device, false, deviceRate, deviceChannels, depth) == nil {
+		config.SampleRate = deviceRate
+		config.FramesPerBuffer = s.config.FramesPerBuffer * deviceRate / s.config.SampleRate
+		output.resampler = audio.NewResampler(s.config.SampleRate, deviceRate, s.config.Channels, depth, config.FramesPerBuffer)
+		s.logger.Infof("🔁 Resampling %dHz → %dHz for %s", s.config.SampleRate, deviceRate, device.Name)
+	}
+	
+	player := audio.NewPlayer(device, &config, s.logger)
+	if primary {
+		player.OnDeviceChange(s.onOutputDeviceChanged)
+	}
+	if err := player.Initialize(); err != nil {
+		return nil, err
+	}
+	output.player = player
+	return output, nil
+}
+
 // connectionMonitorLoop 监控连接状态
-func (s *Server) connectionMonitorLoop(conn net.Conn, stopChan chan struct{}, sessionDone chan struct{}) {
+func (s *Server) connectionMonitorLoop(ctx context.Context, conn net.Conn) {
 	defer s.clientWg.Done()
 	
-	ticker := time.NewTicker(5 * time.Second)
+	// 按心跳间隔检查，保证超时判定的精度与心跳节奏一致
+	ticker := time.NewTicker(s.config.HeartbeatInterval)
 	defer ticker.Stop()
+	sessionStart := time.Now()
 	
 	for {
 		select {
-		case <-stopChan:
-			s.logger.Debug("Connection monitor loop stopped by signal")
-			return
-		case <-sessionDone:
-			s.logger.Debug("Connection monitor loop stopped by session end")
-			return
-		case <-GetShutdownChannel():
-			s.logger.Info("🛑 Shutdown signal received, closing client connection")
+		case <-ctx.Done():
+			// 会话结束或服务端关闭：关闭连接以中断阻塞的读取
+			if s.shutdown.IsShutdownRequested() {
+				s.logger.Info("🛑 Shutdown signal received, closing client connection")
+			}
 			conn.Close()
 			return
 		case <-ticker.C:
@@ -412,8 +932,19 @@ func (s *Server) connectionMonitorLoop(conn net.Conn, stopChan chan struct{}, se
 			// 检查最后活跃时间
 			s.activityMutex.RLock()
 			lastActivity := s.lastActivity
+			lastAudio := s.lastAudio
 			s.activityMutex.RUnlock()
 			
+			// 共享机器上限制会话时长与空闲时间，到期后让出给下一个客户端
+			if s.config.MaxSession > 0 && time.Since(sessionStart) > s.config.MaxSession {
+				s.endSession(ctx, conn, fmt.Sprintf("maximum session duration of %v reached", s.config.MaxSession))
+				return
+			}
+			if s.config.IdleTimeout > 0 && time.Since(lastAudio) > s.config.IdleTimeout {
+				s.endSession(ctx, conn, fmt.Sprintf("no audio received for %v", s.config.IdleTimeout))
+				return
+			}
+			
 			// 如果超过保活超时时间没有活动，则断开连接
 			if time.Since(lastActivity) > s.config.KeepaliveTimeout {
 				s.logger.Warnf("🕐 Connection inactive for %v, closing connection", s.config.KeepaliveTimeout)
@@ -429,15 +960,83 @@ func (s *Server) connectionMonitorLoop(conn net.Conn, stopChan chan struct{}, se
 	}
 }
 
+// goodbyeGracePeriod 发送告别包后等待客户端自行断开的最长时间
+const goodbyeGracePeriod = time.Second
+
+// endSession sends the client a goodbye control packet and closes the connection, which ends
+// the session and frees the server for the next client. The write side is shut down first and
+// the connection is only closed once the client hangs up (or after goodbyeGracePeriod), so
+// unread audio in the receive buffer does not reset the connection before the goodbye arrives.
+// With -control-channel the client reads server packets only on the control connection, so
+// the goodbye goes there and both connections are closed.
+func (s *Server) endSession(ctx context.Context, conn net.Conn, reason string) {
+	s.logger.Infof("👋 Ending client session: %s", reason)
+	s.connectionMutex.Lock()
+	goodbyeConn := conn
+	if s.controlConn != nil {
+		goodbyeConn = s.controlConn
+	}
+	s.connectionMutex.Unlock()
+	
+	goodbyeConn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	if err := WritePacket(goodbyeConn, NewGoodbyePacket(reason)); err != nil {
+		s.logger.Warnf("Failed to send goodbye to client: %v", err)
+	} else if tcpConn, ok := goodbyeConn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+		select {
+		case <-ctx.Done():
+		case <-time.After(goodbyeGracePeriod):
+		}
+	}
+	if goodbyeConn != conn {
+		goodbyeConn.Close()
+	}
+	conn.Close()
+}
+
+// checkPSK sets up s.cipher for a client that asked for -psk encryption with the right key.
+// It returns why the client must be rejected, or "" when it may continue.
+func (s *Server) checkPSK(clientConfig *HandshakeConfig) string {
+	encrypted := clientConfig.Flags&HandshakeFlagEncrypted != 0
+	switch {
+	case s.config.PSK == "" && encrypted:
+		return "client uses -psk encryption but the server has no -psk"
+	case s.config.PSK != "" && !encrypted:
+		return "server requires -psk encryption"
+	case !encrypted:
+		return ""
+	}
+	keys := newPSKKeys(s.config.PSK, clientConfig.PSKSalt)
+	if !checkEqual(clientConfig.PSKCheck, keys.clientCheck) {
+		return "wrong pre-shared key (-psk)"
+	}
+	// 本端的随机值参与派生会话密钥：重放录制的握手得到的是另一个密钥，录下的音频包无法通过认证
+	nonce, err := newPSKNonce()
+	if err != nil {
+		return fmt.Sprintf("failed to generate the session nonce: %v", err)
+	}
+	cipher, err := keys.sessionCipher(nonce)
+	if err != nil {
+		return fmt.Sprintf("failed to derive the session key: %v", err)
+	}
+	s.cipher = cipher
+	s.replay = newReplayWindow(s.config.ReplayWindow)
+	return ""
+}
+
 // performHandshake handles the handshake protocol with the client
 func (s *Server) performHandshake(conn net.Conn) error {
-	// Set read timeout for handshake
-	conn.SetReadDeadline(time.Now().Add(s.config.ReadTimeout))
-	defer conn.SetReadDeadline(time.Time{})
-	
-	// Read handshake packet from client
-	handshakePacket, err := ReadPacket(conn)
+	// Read handshake packet from client (read timeout applies to header and payload separately)
+	handshakePacket, err := ReadPacketWithTimeout(conn, s.config.ReadTimeout, 0)
 	if err != nil {
+		// 协议版本不同：用错误包告知客户端哪一端需要升级（包头使用本端版本，客户端据此识别）
+		var versionErr *ProtocolVersionError
+		if errors.As(err, &versionErr) {
+			message := protocolMismatchMessage(ProtocolVersion, versionErr.Remote)
+			conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+			WritePacket(conn, NewErrorPacket(message))
+			return errors.New(message)
+		}
 		return fmt.Errorf("failed to read handshake packet: %w", err)
 	}
 	
@@ -456,16 +1055,71 @@ func (s *Server) performHandshake(conn net.Conn) error {
 		return fmt.Errorf("invalid client config: %w", err)
 	}
 	
-	s.logger.Infof("Client config - Sample Rate: %dHz, Channels: %d, Bit Depth: %d",
-		clientConfig.SampleRate, clientConfig.Channels, clientConfig.BitDepth)
+	// -psk：双方都要启用，并且客户端的校验值证明它知道同一口令
+	s.cipher, s.replay = nil, nil
+	if reason := s.checkPSK(&clientConfig); reason != "" {
+		conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+		WritePacket(conn, NewErrorPacket("handshake rejected: "+reason))
+		return fmt.Errorf("client rejected: %s", reason)
+	}
 	
-	// Create server response (accepting client's configuration for now)
-	serverConfig := clientConfig // Accept client's settings
-	s.audioConfig = &serverConfig
+	s.logger.Infof("Client config - Sample Rate: %dHz, Channels: %d, Bit Depth: %d, codec: %s",
+		clientConfig.SampleRate, clientConfig.Channels, clientConfig.BitDepth,
+		utils.CodecType(clientConfig.Compression))
 	
+	// 按服务端策略协商：能调整的调整后回传给客户端，无法满足时拒绝
+	serverConfig, err := negotiateHandshake(clientConfig, s.config)
+	if err != nil {
+		conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+		WritePacket(conn, NewErrorPacket(fmt.Sprintf("handshake rejected: %v", err)))
+		return fmt.Errorf("client config rejected by server policy: %w", err)
+	}
+	if changes := handshakeChanges(clientConfig, serverConfig); len(changes) > 0 {
+		s.logger.Warnf("⚖️ Adjusted client config to server policy: %s", strings.Join(changes, ", "))
+	}
+	s.logger.Infof("🤝 Negotiated config - Sample Rate: %dHz, Channels: %d, Bit Depth: %d, sample format: %s, codec: %s",
+		serverConfig.SampleRate, serverConfig.Channels, serverConfig.BitDepth,
+		utils.SampleFormat(serverConfig.SampleFormat), utils.CodecType(serverConfig.Compression))
 	// Update server configuration
 	s.updateConfigFromHandshake(&serverConfig)
 	
+	// 音频负载上限：取音频格式的合理上限与配置上限中较小者（采样序号前缀另计）
+	s.maxAudioPayload = serverConfig.MaxAudioPayloadSize()
+	if limit := uint32(s.config.MaxAudioPayloadSize); limit > 0 && limit < s.maxAudioPayload {
+		s.maxAudioPayload = limit
+	}
+	if clientConfig.Flags&HandshakeFlagSampleIndex != 0 {
+		s.maxAudioPayload += SampleIndexSize
+		s.logger.Info("🔢 Audio packets carry sample indexes")
+	}
+	if s.cipher != nil {
+		s.maxAudioPayload += packetCipherOverhead
+		s.logger.Info("🔐 Audio payloads are encrypted with the pre-shared key (AES-256-GCM)")
+	}
+	
+	// 客户端请求控制连接时生成会话令牌，须在回复之前记录，客户端收到回复后即可附加
+	// 采样序号总是支持，控制连接还需要生成令牌
+	serverConfig.Flags = clientConfig.Flags & HandshakeFlagSampleIndex
+	serverConfig.SessionToken = [SessionTokenSize]byte{}
+	if clientConfig.Flags&HandshakeFlagControlChannel != 0 {
+		if _, err := rand.Read(serverConfig.SessionToken[:]); err != nil {
+			s.logger.Warnf("Failed to generate session token, control connection disabled: %v", err)
+			serverConfig.SessionToken = [SessionTokenSize]byte{}
+		} else {
+			serverConfig.Flags |= HandshakeFlagControlChannel
+		}
+	}
+	if s.cipher != nil {
+		serverConfig.Flags |= HandshakeFlagEncrypted
+		serverConfig.PSKCheck = s.cipher.serverCheck
+		serverConfig.PSKNonce = s.cipher.sessionNonce
+	}
+	negotiated := serverConfig
+	s.connectionMutex.Lock()
+	s.sessionToken = serverConfig.SessionToken
+	s.audioConfig = &negotiated
+	s.connectionMutex.Unlock()
+	
 	// Send response
 	responsePacket := NewHandshakePacket(&serverConfig)
 	
@@ -474,15 +1128,29 @@ func (s *Server) performHandshake(conn net.Conn) error {
 		return fmt.Errorf("failed to send handshake response: %w", err)
 	}
 	
-	if clientConfig.Compression == 1 {
+	s.flacDecoder = nil
+	switch utils.CodecType(serverConfig.Compression) {
+	case utils.CodecOpus:
 		s.useOpus = true
 		var err error
-		s.opusDecoder, err = opus.NewDecoder(int(clientConfig.SampleRate), int(clientConfig.Channels))
+		s.opusDecoder, err = newOpusMultiDecoder(int(serverConfig.SampleRate), int(serverConfig.Channels))
 		if err != nil {
 			return fmt.Errorf("failed to initialize Opus decoder: %w", err)
 		}
+		samples := int(serverConfig.FramesPerBuffer) * int(serverConfig.Channels)
+		s.pcm16 = make([]int16, samples)
+		s.pcmData = make([]byte, samples*2)
 		s.logger.Info("🔊 Opus decoder initialized for compressed audio")
-	} else {
+	case utils.CodecFLAC:
+		s.useOpus = false
+		s.opusDecoder = nil
+		var err error
+		s.flacDecoder, err = newFLACDecoder(int(serverConfig.Channels), int(serverConfig.BitDepth))
+		if err != nil {
+			return fmt.Errorf("failed to initialize FLAC decoder: %w", err)
+		}
+		s.logger.Info("🔊 FLAC decoder initialized for lossless compressed audio")
+	default:
 		s.useOpus = false
 		s.opusDecoder = nil
 		s.logger.Info("🔊 Using PCM uncompressed audio")
@@ -496,29 +1164,48 @@ func (s *Server) updateConfigFromHandshake(handshakeConfig *HandshakeConfig) {
 	s.config.SampleRate = int(handshakeConfig.SampleRate)
 	s.config.Channels = int(handshakeConfig.Channels)
 	s.config.BitDepth = int(handshakeConfig.BitDepth)
+	s.config.SampleFormat = utils.SampleFormat(handshakeConfig.SampleFormat)
 	s.config.FramesPerBuffer = int(handshakeConfig.FramesPerBuffer)
 	s.config.BufferCount = int(handshakeConfig.BufferCount)
 }
 
 // packetProcessingLoop processes incoming packets from the client
-func (s *Server) packetProcessingLoop(conn net.Conn, stopChan chan struct{}) {
+func (s *Server) packetProcessingLoop(ctx context.Context, conn net.Conn) {
 	s.logger.Debug("Starting packet processing loop")
 	
 	for {
 		select {
-		case <-stopChan:
+		case <-ctx.Done():
 			s.logger.Debug("Packet processing loop stopped by signal")
 			return
 		default:
 			// Continue processing
 		}
 		
-		// Set read timeout
-		conn.SetReadDeadline(time.Now().Add(s.config.ReadTimeout))
-		
-		packet, err := ReadPacket(conn)
+		// 读超时分别作用于包头和负载
+		packet, err := ReadPacketWithTimeout(conn, s.config.PacketReadTimeout(), s.maxAudioPayload)
 		if err != nil {
-			s.logger.Error(fmt.Sprintf("Failed to read packet: %v", err))
+			if errors.Is(err, ErrInvalidFrame) {
+				// 帧格式错误，数据流已不可信，直接关闭连接
+				s.logger.Error(fmt.Sprintf("🚫 Protocol error from client, closing connection: %v", err))
+				atomic.AddInt64(&s.stats.ErrorCount, 1)
+				conn.Close()
+				return
+			}
+
+			// 客户端在包边界处正常关闭连接，不计为错误
+			if errors.Is(err, io.EOF) {
+				s.logger.Info("🔌 Client closed the connection")
+				return
+			}
+
+			// 心跳走控制连接时，静音期间音频连接上没有数据；是否断开由 connectionMonitorLoop 按心跳判断
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && s.hasControlConnection() {
+				continue
+			}
+
+			s.logger.ErrorRateLimited("read-packet", fmt.Sprintf("Failed to read packet: %v", err))
 			atomic.AddInt64(&s.stats.ErrorCount, 1)
 			
 			// 网络错误，客户端已断开连接
@@ -526,9 +1213,27 @@ func (s *Server) packetProcessingLoop(conn net.Conn, stopChan chan struct{}) {
 			return
 		}
 		
+		// -psk：音频负载先认证并解密，未通过的包丢弃
+		if s.cipher != nil && packet.Header.Type == PacketTypeAudio {
+			if err := s.cipher.open(packet); err != nil {
+				s.logger.WarnRateLimited("packet-auth", fmt.Sprintf("🔐 Dropping audio packet %d: %v", packet.Header.Sequence, err))
+				atomic.AddInt64(&s.stats.ErrorCount, 1)
+				continue
+			}
+			// 认证通过后才记入重放窗口，伪造的包无法推动窗口
+			if err := s.replay.accept(packet.Header.Sequence); err != nil {
+				s.logger.WarnRateLimited("packet-replay", fmt.Sprintf("🔐 Dropping audio packet %d: %v", packet.Header.Sequence, err))
+				atomic.AddInt64(&s.stats.ErrorCount, 1)
+				continue
+			}
+		}
+		
 		// 更新连接活跃时间 - 收到任何数据包都表示连接活跃
 		s.activityMutex.Lock()
 		s.lastActivity = time.Now()
+		if packet.Header.Type == PacketTypeAudio {
+			s.lastAudio = s.lastActivity
+		}
 		s.activityMutex.Unlock()
 		
 		// Update statistics
@@ -545,37 +1250,229 @@ func (s *Server) packetProcessingLoop(conn net.Conn, stopChan chan struct{}) {
 		case PacketTypeError:
 			s.handleErrorPacket(packet)
 			
+		case PacketTypeHandshake, PacketTypeAttach:
+			// 只能是连接上的第一个包；会话中途不支持重新协商，客户端状态已错乱或数据不可信
+			s.rejectProtocolViolation(conn, fmt.Sprintf("unexpected %s packet during an active session", packet.Header.Type))
+			return
+			
+		case PacketTypeControl:
+			s.logger.WarnRateLimited("client-control", "Ignoring control packet from client (control packets are only sent by the server)")
+			
 		default:
 			s.logger.Warnf("Unknown packet type received: %s", packet.Header.Type)
 		}
 	}
 }
 
+// rejectProtocolViolation tells the client why its connection is being closed with an error
+// packet, then closes it, which ends the session
+func (s *Server) rejectProtocolViolation(conn net.Conn, reason string) {
+	s.logger.Error(fmt.Sprintf("🚫 Protocol error from client, closing connection: %s", reason))
+	atomic.AddInt64(&s.stats.ErrorCount, 1)
+	conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	WritePacket(conn, NewErrorPacket("protocol violation: "+reason))
+	conn.Close()
+}
+
+// hasControlConnection reports whether the current session has a control connection attached
+func (s *Server) hasControlConnection() bool {
+	s.connectionMutex.Lock()
+	defer s.connectionMutex.Unlock()
+	return s.controlConn != nil
+}
+
+// attachControlConnection handles a connection that arrives while a session is active:
+// an Attach packet carrying the session's token makes it the control connection,
+// anything else is rejected as a second client
+func (s *Server) attachControlConnection(conn net.Conn) {
+	packet, err := ReadPacketWithTimeout(conn, s.config.ConnTimeout, 0)
+	
+	s.connectionMutex.Lock()
+	ctx := s.sessionCtx
+	accepted := err == nil && packet.Header.Type == PacketTypeAttach && ctx != nil && s.controlConn == nil &&
+		s.sessionToken != ([SessionTokenSize]byte{}) &&
+		subtle.ConstantTimeCompare(packet.Payload, s.sessionToken[:]) == 1
+	if accepted {
+		s.controlConn = conn
+	}
+	s.connectionMutex.Unlock()
+	
+	if !accepted {
+		s.logger.Warn("Another client is already connected, closing new connection")
+		conn.Close()
+		return
+	}
+	
+	// 原样回复 Attach 包作为确认
+	conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	if err := WritePacket(conn, packet); err != nil {
+		s.logger.Warnf("Failed to acknowledge control connection: %v", err)
+		conn.Close()
+		return
+	}
+	s.logger.Info("🎛️ Control connection attached, heartbeats use a separate connection")
+	s.controlConnectionLoop(ctx, conn)
+}
+
+// controlConnectionLoop processes heartbeats on the control connection; losing it ends the session
+func (s *Server) controlConnectionLoop(ctx context.Context, conn net.Conn) {
+	for {
+		packet, err := ReadPacketWithTimeout(conn, s.config.PacketReadTimeout(), 0)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Warnf("🔌 Control connection lost, ending session: %v", err)
+				s.connectionMutex.Lock()
+				if s.cancelSession != nil {
+					s.cancelSession()
+				}
+				s.connectionMutex.Unlock()
+			}
+			return
+		}
+		
+		s.activityMutex.Lock()
+		s.lastActivity = time.Now()
+		s.activityMutex.Unlock()
+		atomic.AddInt64(&s.stats.BytesReceived, int64(len(packet.Payload)+HeaderSize))
+		
+		switch packet.Header.Type {
+		case PacketTypeHeartbeat:
+			s.handleHeartbeatPacket(conn, packet)
+		case PacketTypeError:
+			s.handleErrorPacket(packet)
+		case PacketTypeHandshake, PacketTypeAttach:
+			s.rejectProtocolViolation(conn, fmt.Sprintf("unexpected %s packet on the control connection", packet.Header.Type))
+			s.connectionMutex.Lock()
+			if s.cancelSession != nil {
+				s.cancelSession()
+			}
+			s.connectionMutex.Unlock()
+			return
+		default:
+			s.logger.Warnf("Unexpected packet type on control connection: %s", packet.Header.Type)
+		}
+	}
+}
+
+// sequenceResyncWindow 序列号回退超过该包数时视为发送端重新计数，而不是迟到的包
+const sequenceResyncWindow = 1024
+
+// trackSequence 根据音频包序列号统计丢包与乱序。序列号按会话计数：客户端每次握手后从 1
+// 重新开始，服务端在新会话开始时重置期望值。差值按有符号数比较，uint32 回绕时仍然连续
+func (s *Server) trackSequence(sequence uint32) {
+	atomic.AddInt64(&s.stats.PacketsReceived, 1)
+	
+	if !s.sequenceStarted {
+		s.sequenceStarted = true
+		s.expectedSequence = sequence + 1
+		return
+	}
+	
+	delta := int32(sequence - s.expectedSequence)
+	switch {
+	case delta == 0:
+		s.expectedSequence++
+	case delta > 0:
+		// 序列号跳跃，中间的包视为丢失
+		atomic.AddInt64(&s.stats.PacketsLost, int64(delta))
+		s.expectedSequence = sequence + 1
+	case delta < -sequenceResyncWindow:
+		// 远早于任何可能迟到的包：发送端重新开始计数，从这里重新同步，不计为丢包或乱序
+		s.logger.Debugf("Sequence restarted at %d (expected %d), resynchronizing", sequence, s.expectedSequence)
+		s.expectedSequence = sequence + 1
+	default:
+		// 迟到的包：单独计为乱序，并从已记录的丢包中扣除
+		atomic.AddInt64(&s.stats.PacketsReordered, 1)
+		if atomic.LoadInt64(&s.stats.PacketsLost) > 0 {
+			atomic.AddInt64(&s.stats.PacketsLost, -1)
+		}
+	}
+}
+
+// trackSampleIndex 根据采样序号统计两包之间缺失的采样帧（丢包、静音暂停或采集端丢帧）
+func (s *Server) trackSampleIndex(sampleIndex uint64) {
+	if s.sampleIndexStarted && sampleIndex > s.expectedSampleIndex {
+		missing := sampleIndex - s.expectedSampleIndex
+		atomic.AddInt64(&s.stats.SamplesMissing, int64(missing))
+		s.logger.Debugf("Sample index gap: %d samples missing before sample %d", missing, sampleIndex)
+	}
+	// 迟到的包不回退期望值
+	if next := sampleIndex + uint64(s.config.FramesPerBuffer); !s.sampleIndexStarted || next > s.expectedSampleIndex {
+		s.expectedSampleIndex = next
+	}
+	s.sampleIndexStarted = true
+}
+
 // handleAudioPacket processes an audio packet
 func (s *Server) handleAudioPacket(packet *Packet) {
-	if s.player == nil {
+	s.trackSequence(packet.Header.Sequence)
+	
+	payload, sampleIndex, indexed, err := audioPayload(packet)
+	if err != nil {
+		s.logger.ErrorRateLimited("sample-index", fmt.Sprintf("Invalid audio packet: %v", err))
+		atomic.AddInt64(&s.stats.ErrorCount, 1)
 		return
 	}
-	var pcmData []byte
+	var endSample uint64
+	if indexed {
+		s.trackSampleIndex(sampleIndex)
+		endSample = sampleIndex + uint64(s.config.FramesPerBuffer)
+	}
+	
+	if len(s.outputs) == 0 {
+		return
+	}
+	pcmData, ok := s.decodeAudioPayload(payload)
+	if !ok {
+		return
+	}
+	capturedAt := TimestampToTime(packet.Header.Timestamp)
+	for _, output := range s.outputs {
+		// QueueAudioIndexed 复制数据，所有输出可以共享同一份解码结果
+		output.queue(pcmData, capturedAt, endSample)
+	}
+}
+
+// decodeAudioPayload decodes an audio packet payload to PCM. The returned slice may
+// point into the session's scratch buffers and is only valid until the next packet.
+func (s *Server) decodeAudioPayload(payload []byte) ([]byte, bool) {
 	if s.useOpus && s.opusDecoder != nil {
 		// Opus 解码
-		pcm16 := make([]int16, s.config.FramesPerBuffer*s.config.Channels)
-		lenOut, err := s.opusDecoder.Decode(packet.Payload, pcm16)
+		samples := s.config.FramesPerBuffer * s.config.Channels
+		if len(s.pcm16) < samples {
+			s.pcm16 = make([]int16, samples)
+			s.pcmData = make([]byte, samples*2)
+		}
+		pcm16 := s.pcm16[:samples]
+		lenOut, err := s.opusDecoder.Decode(payload, pcm16, s.config.FramesPerBuffer)
 		if err != nil {
-			s.logger.Error(fmt.Sprintf("Opus decode error: %v", err))
-			return
+			s.logger.ErrorRateLimited("opus-decode", fmt.Sprintf("Opus decode error: %v", err))
+			return nil, false
+		}
+		// 解码出的帧长必须等于协商的 FramesPerBuffer：更长的包已被解码器拒绝（缓冲区只有一帧），
+		// 更短的包交给播放器只会被当作长度错误的帧播放成静音
+		if lenOut != s.config.FramesPerBuffer {
+			s.logger.ErrorRateLimited("opus-frame-size", fmt.Sprintf("Dropping Opus packet of %d frames, expected %d", lenOut, s.config.FramesPerBuffer))
+			return nil, false
 		}
 		// 转回 []byte
-		pcmData = make([]byte, lenOut*2*s.config.Channels)
+		pcmData := s.pcmData[:lenOut*2*s.config.Channels]
 		for i := 0; i < lenOut*s.config.Channels; i++ {
 			pcmData[2*i] = byte(pcm16[i] & 0xFF)
 			pcmData[2*i+1] = byte((pcm16[i] >> 8) & 0xFF)
 		}
-	} else {
-		// PCM 直传
-		pcmData = packet.Payload
+		return pcmData, true
+	} else if s.flacDecoder != nil {
+		// FLAC 解码
+		decoded, err := s.flacDecoder.Decode(payload)
+		if err != nil {
+			s.logger.ErrorRateLimited("flac-decode", fmt.Sprintf("FLAC decode error: %v", err))
+			return nil, false
+		}
+		return decoded, true
 	}
-	s.player.QueueAudio(pcmData)
+	// PCM 直传
+	return payload, true
 }
 
 // handleHeartbeatPacket processes a heartbeat packet
@@ -590,7 +1487,7 @@ func (s *Server) handleHeartbeatPacket(conn net.Conn, packet *Packet) {
 	
 	conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
 	if err := WritePacket(conn, responsePacket); err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to send heartbeat response: %v", err))
+		s.logger.ErrorRateLimited("heartbeat-response", fmt.Sprintf("Failed to send heartbeat response: %v", err))
 		atomic.AddInt64(&s.stats.ErrorCount, 1)
 	} else {
 		atomic.AddInt64(&s.stats.BytesSent, int64(HeaderSize))
@@ -605,10 +1502,10 @@ func (s *Server) handleErrorPacket(packet *Packet) {
 }
 
 // statisticsLoop periodically logs server statistics
-func (s *Server) statisticsLoop(stopChan chan struct{}, sessionDone chan struct{}) {
+func (s *Server) statisticsLoop(ctx context.Context) {
 	defer s.clientWg.Done()
 	
-	// 每100ms刷新一次统计信息
+	// 每100ms采样一次统计信息，显示由 logger 按 -stats-interval 限频
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 	
@@ -616,37 +1513,37 @@ func (s *Server) statisticsLoop(stopChan chan struct{}, sessionDone chan struct{
 	
 	for {
 		select {
-		case <-stopChan:
-			s.logger.Debug("Statistics loop stopped by signal")
-			return
-		case <-sessionDone:
+		case <-ctx.Done():
 			s.logger.Debug("Statistics loop stopped by session end")
 			return
 		case <-ticker.C:
 			if atomic.LoadInt32(&s.connected) == 1 {
-				networkStats := s.GetStats()
-				
-				var audioStats *utils.AudioStats
-				if s.player != nil {
-					audioStats = s.player.GetStats()
-				} else {
-					// 创建默认的音频统计
-					audioStats = &utils.AudioStats{
-						FramesProcessed: 0,
-						DroppedFrames:   0,
-						Latency:         0,
-						BufferUsage:     0,
-						DecibelLevel:    -60.0,
-					}
-				}
-				
 				// 使用新的实时统计显示方法
-				s.logger.LogRealTimeStats(networkStats, audioStats)
+				s.logger.LogRealTimeStats(s.GetStats(), s.currentAudioStats())
 			}
 		}
 	}
 }
 
+// currentAudioStats 返回实时统计行与状态页共用的播放统计
+func (s *Server) currentAudioStats() *utils.AudioStats {
+	// 会话建立与结束时会替换 s.player，须在锁内取出引用
+	s.connectionMutex.Lock()
+	player := s.player
+	s.connectionMutex.Unlock()
+	if player != nil {
+		return player.GetStats()
+	}
+	// 创建默认的音频统计
+	return &utils.AudioStats{
+		FramesProcessed: 0,
+		DroppedFrames:   0,
+		Latency:         0,
+		BufferUsage:     0,
+		DecibelLevel:    -60.0,
+	}
+}
+
 // IsRunning returns whether the server is currently running
 func (s *Server) IsRunning() bool {
 	return atomic.LoadInt32(&s.running) == 1
@@ -657,25 +1554,160 @@ func (s *Server) IsConnected() bool {
 	return atomic.LoadInt32(&s.connected) == 1
 }
 
-// GetStats returns current network statistics
+// GetStats returns network statistics of the current session; Total holds the totals since server start
 func (s *Server) GetStats() *utils.NetworkStats {
-	return &utils.NetworkStats{
+	session := &utils.NetworkStats{
 		BytesSent:      atomic.LoadInt64(&s.stats.BytesSent),
 		BytesReceived:  atomic.LoadInt64(&s.stats.BytesReceived),
 		RoundTripTime:  s.stats.RoundTripTime,
 		ErrorCount:     atomic.LoadInt64(&s.stats.ErrorCount),
+		PacketsReceived:  atomic.LoadInt64(&s.stats.PacketsReceived),
+		PacketsLost:      atomic.LoadInt64(&s.stats.PacketsLost),
+		PacketsReordered: atomic.LoadInt64(&s.stats.PacketsReordered),
+		SamplesMissing:   atomic.LoadInt64(&s.stats.SamplesMissing),
 	}
+	
+	// 累计统计 = 已结束会话 + 当前会话
+	session.Total = &utils.NetworkStats{
+		BytesSent:        atomic.LoadInt64(&s.totalStats.BytesSent) + session.BytesSent,
+		BytesReceived:    atomic.LoadInt64(&s.totalStats.BytesReceived) + session.BytesReceived,
+		ErrorCount:       atomic.LoadInt64(&s.totalStats.ErrorCount) + session.ErrorCount,
+		PacketsReceived:  atomic.LoadInt64(&s.totalStats.PacketsReceived) + session.PacketsReceived,
+		PacketsLost:      atomic.LoadInt64(&s.totalStats.PacketsLost) + session.PacketsLost,
+		PacketsReordered: atomic.LoadInt64(&s.totalStats.PacketsReordered) + session.PacketsReordered,
+		SamplesMissing:   atomic.LoadInt64(&s.totalStats.SamplesMissing) + session.SamplesMissing,
+	}
+	return session
+}
+
+// finishSessionStats 输出本次会话与自启动以来的统计，然后把会话统计累加到总计并清零
+func (s *Server) finishSessionStats() {
+	stats := s.GetStats()
+	s.logger.Infof("📊 Session: ↓%.2fMB, %d packets, %d lost, %d errors | Since server start: ↓%.2fMB, %d packets, %d lost, %d errors",
+		float64(stats.BytesReceived)/(1024*1024), stats.PacketsReceived, stats.PacketsLost, stats.ErrorCount,
+		float64(stats.Total.BytesReceived)/(1024*1024), stats.Total.PacketsReceived, stats.Total.PacketsLost, stats.Total.ErrorCount)
+	
+	atomic.AddInt64(&s.totalStats.BytesSent, atomic.SwapInt64(&s.stats.BytesSent, 0))
+	atomic.AddInt64(&s.totalStats.BytesReceived, atomic.SwapInt64(&s.stats.BytesReceived, 0))
+	atomic.AddInt64(&s.totalStats.ErrorCount, atomic.SwapInt64(&s.stats.ErrorCount, 0))
+	atomic.AddInt64(&s.totalStats.PacketsReceived, atomic.SwapInt64(&s.stats.PacketsReceived, 0))
+	atomic.AddInt64(&s.totalStats.PacketsLost, atomic.SwapInt64(&s.stats.PacketsLost, 0))
+	atomic.AddInt64(&s.totalStats.PacketsReordered, atomic.SwapInt64(&s.stats.PacketsReordered, 0))
+	atomic.AddInt64(&s.totalStats.SamplesMissing, atomic.SwapInt64(&s.stats.SamplesMissing, 0))
 }
 
-// 新增 isIPAllowed 工具函数
-func isIPAllowed(ip string, allowList []string) bool {
+// remoteIPOf 返回连接对端的 IP（非 TCP 连接返回完整地址）
+func remoteIPOf(conn net.Conn) string {
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	return conn.RemoteAddr().String()
+}
+
+// admitClient decides whether a client at ip may connect. Deny rules take precedence over
+// allow rules and apply even when the allow list is empty. The reason names the deciding
+// rule for the audit log.
+func admitClient(ip string, allowList, denyList []string, hosts map[string][]net.IP) (bool, string) {
+	if rule := matchClient(ip, denyList, hosts); rule != "" {
+		return false, fmt.Sprintf("matches deny rule %s", rule)
+	}
 	if len(allowList) == 0 {
-		return true // 允许所有
+		return true, "no allow list, not denied"
+	}
+	if rule := matchClient(ip, allowList, hosts); rule != "" {
+		return true, fmt.Sprintf("matches allow rule %s", rule)
+	}
+	return false, "not in allowed client list"
+}
+
+// matchClient 返回 list 中第一个匹配 ip 的条目（IP、CIDR 范围或主机名），没有匹配时返回 ""。
+// 主机名条目与 hosts 中预先解析出的地址比较。
+func matchClient(ip string, list []string, hosts map[string][]net.IP) string {
+	addr := net.ParseIP(ip)
+	for _, entry := range list {
+		if ip == entry {
+			return entry
+		}
+		if addr == nil {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ipNet.Contains(addr) {
+				return entry
+			}
+			continue
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if entryIP.Equal(addr) {
+				return entry
+			}
+			continue
+		}
+		for _, resolvedIP := range hosts[entry] {
+			if resolvedIP.Equal(addr) {
+				return entry
+			}
+		}
 	}
-	for _, allowed := range allowList {
-		if ip == allowed {
-			return true
+	return ""
+}
+
+const (
+	// clientHostLookupTimeout 解析单个主机名条目的超时
+	clientHostLookupTimeout = 2 * time.Second
+	// clientHostRefreshInterval 定期重新解析主机名条目，地址变化后无需 SIGHUP 也会生效
+	clientHostRefreshInterval = 5 * time.Minute
+)
+
+// resolveClientHosts 解析白名单与黑名单中的主机名条目并替换 s.clientHosts，
+// 解析失败的主机名不匹配任何地址
+func (s *Server) resolveClientHosts() {
+	s.clientHostsMutex.Lock()
+	defer s.clientHostsMutex.Unlock()
+	s.connectionMutex.Lock()
+	lists := [][]string{s.config.AllowClients, s.config.DenyClients}
+	s.connectionMutex.Unlock()
+
+	hosts := make(map[string][]net.IP)
+	for _, list := range lists {
+		for _, entry := range list {
+			if _, done := hosts[entry]; done || net.ParseIP(entry) != nil {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(entry); err == nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), clientHostLookupTimeout)
+			resolved, err := net.DefaultResolver.LookupHost(ctx, entry)
+			cancel()
+			if err != nil {
+				s.logger.Warnf("Could not resolve client rule %s: %v", entry, err)
+			}
+			addrs := []net.IP{}
+			for _, host := range resolved {
+				if resolvedIP := net.ParseIP(host); resolvedIP != nil {
+					addrs = append(addrs, resolvedIP)
+				}
+			}
+			hosts[entry] = addrs
+		}
+	}
+
+	s.connectionMutex.Lock()
+	s.clientHosts = hosts
+	s.connectionMutex.Unlock()
+}
+
+// clientHostsRefreshLoop 每隔 clientHostRefreshInterval 重新解析主机名条目，直到 ctx 取消
+func (s *Server) clientHostsRefreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(clientHostRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.resolveClientHosts()
 		}
 	}
-	return false
 }
\ No newline at end of file
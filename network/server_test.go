@@ -0,0 +1,1462 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"RemoteAudioCLI/audio"
+	"RemoteAudioCLI/utils"
+)
+
+// TestServerServeRestart 确认同一进程内可以反复启动和停止服务端
+func TestServerServeRestart(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = 0
+	config.OutputPipe = "unused.pcm" // 不打开声卡；只有客户端连接时才会创建管道
+
+	logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+	server := NewServer(config, logger)
+
+	for run := 0; run < 2; run++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Serve(ctx, nil)
+		}()
+
+		time.Sleep(300 * time.Millisecond)
+		cancel()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("run %d: Serve returned %v", run, err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("run %d: Serve did not return after cancel", run)
+		}
+	}
+}
+
+// freePort 返回一个当前空闲的本地 TCP 端口
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// TestClientServerSession 通过管道输入/输出跑完整会话：连接、握手、推流、客户端结束后断开
+func TestClientServerSession(t *testing.T) {
+	const frames = 25
+
+	for _, codec := range []utils.CodecType{utils.CodecPCM, utils.CodecOpus, utils.CodecFLAC} {
+		t.Run(codec.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			port := freePort(t)
+
+			newConfig := func() *utils.Config {
+				config := utils.NewDefaultConfig()
+				config.Host = "127.0.0.1"
+				config.Port = port
+				config.SampleRate = 48000
+				config.FramesPerBuffer = 960
+				config.Compression = codec
+				return config
+			}
+
+			// 一段 440Hz 正弦波作为输入
+			serverConfig := newConfig()
+			serverConfig.OutputPipe = filepath.Join(dir, "out.pcm")
+			serverConfig.ConnectSoundDelay = 0 // 立即开始播放，缓冲区不会因等待而溢出丢帧
+			clientConfig := newConfig()
+			clientConfig.InputPipe = filepath.Join(dir, "in.pcm")
+			clientConfig.SampleIndex = true
+			input := make([]byte, frames*clientConfig.FramesPerBuffer*clientConfig.GetFrameSize())
+			for i := 0; i < len(input)/4; i++ {
+				sample := int16(8000 * math.Sin(2*math.Pi*440*float64(i)/48000))
+				binary.LittleEndian.PutUint16(input[4*i:], uint16(sample))
+				binary.LittleEndian.PutUint16(input[4*i+2:], uint16(sample))
+			}
+			if err := os.WriteFile(clientConfig.InputPipe, input, 0644); err != nil {
+				t.Fatalf("write input: %v", err)
+			}
+
+			logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+			server := NewServer(serverConfig, logger)
+			ctx, cancel := context.WithCancel(context.Background())
+			serveDone := make(chan error, 1)
+			go func() {
+				serveDone <- server.Serve(ctx, nil)
+			}()
+			defer func() {
+				cancel()
+				if err := <-serveDone; err != nil {
+					t.Errorf("Serve returned %v", err)
+				}
+			}()
+			time.Sleep(300 * time.Millisecond)
+
+			// 输入管道读完后客户端自行结束
+			client := NewClient(clientConfig, logger)
+			runDone := make(chan error, 1)
+			go func() {
+				runDone <- client.Run(context.Background(), nil)
+			}()
+			select {
+			case err := <-runDone:
+				if err != nil {
+					t.Fatalf("client Run returned %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("client did not finish streaming")
+			}
+
+			// 服务端应结束会话并把统计计入累计
+			deadline := time.Now().Add(5 * time.Second)
+			for atomic.LoadInt32(&server.connected) == 1 && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if atomic.LoadInt32(&server.connected) == 1 {
+				t.Fatalf("server still reports a connected client")
+			}
+			stats := server.GetStats()
+			if stats.Total.PacketsReceived < frames-1 || stats.Total.PacketsLost != 0 {
+				t.Fatalf("server received %d packets (%d lost), want about %d", stats.Total.PacketsReceived, stats.Total.PacketsLost, frames)
+			}
+			if stats.Total.SamplesMissing != 0 {
+				t.Fatalf("server saw %d samples missing in a gapless stream", stats.Total.SamplesMissing)
+			}
+			if stats.PacketsReceived != 0 {
+				t.Fatalf("session stats not reset after disconnect: %d packets", stats.PacketsReceived)
+			}
+
+			// 服务端播放器写出的应是解码后的正弦波：PCM/FLAC 逐字节一致，Opus 电平和频率一致。
+			// 客户端断开时仍在播放缓冲区中的音频不再播放，因此末尾最多少一个缓冲区的帧
+			output, err := os.ReadFile(serverConfig.OutputPipe)
+			if err != nil {
+				t.Fatalf("read output: %v", err)
+			}
+			frameBytes := clientConfig.FramesPerBuffer * clientConfig.GetFrameSize()
+			played := audibleFrames(output, frameBytes)
+			if len(played) < (frames-2*serverConfig.BufferCount)*frameBytes {
+				t.Fatalf("server played %d of %d frames", len(played)/frameBytes, frames)
+			}
+			if codec == utils.CodecOpus {
+				assertSine(t, played, rms(input), 440, 48000)
+			} else if !strings.Contains(string(input), string(played)) {
+				t.Fatalf("decoded %s audio differs from the input", codec)
+			}
+		})
+	}
+}
+
+// audibleFrames 去掉播放器在缓冲区欠载时写出的全零静音帧，返回其余帧
+func audibleFrames(output []byte, frameBytes int) []byte {
+	var audible []byte
+	for len(output) >= frameBytes {
+		frame := output[:frameBytes]
+		output = output[frameBytes:]
+		for _, b := range frame {
+			if b != 0 {
+				audible = append(audible, frame...)
+				break
+			}
+		}
+	}
+	return audible
+}
+
+// rms 计算交错 16 位立体声 PCM 的均方根电平
+func rms(pcm []byte) float64 {
+	var sum float64
+	for i := 0; i+1 < len(pcm); i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcm[i:])))
+		sum += sample * sample
+	}
+	return math.Sqrt(sum / float64(len(pcm)/2))
+}
+
+// assertSine 检查有损解码后的立体声 PCM 与原始正弦波电平相差不超过 2dB、
+// 左声道过零率对应的频率相差不超过 5%
+func assertSine(t *testing.T, pcm []byte, wantRMS, frequency float64, sampleRate int) {
+	t.Helper()
+	if ratio := 20 * math.Log10(rms(pcm)/wantRMS); math.Abs(ratio) > 2 {
+		t.Fatalf("decoded level differs from the input by %.1f dB", ratio)
+	}
+	crossings := 0
+	previous := int16(binary.LittleEndian.Uint16(pcm))
+	for i := 4; i+1 < len(pcm); i += 4 {
+		sample := int16(binary.LittleEndian.Uint16(pcm[i:]))
+		if (previous < 0) != (sample < 0) {
+			crossings++
+		}
+		previous = sample
+	}
+	got := float64(crossings) / 2 / (float64(len(pcm)/4) / float64(sampleRate))
+	if math.Abs(got-frequency) > frequency*0.05 {
+		t.Fatalf("decoded frequency %.0f Hz, want %.0f Hz", got, frequency)
+	}
+}
+
+// sineSource 不经过声卡，按帧间隔产生 440Hz 立体声正弦波
+type sineSource struct {
+	config *utils.Config
+	index  uint64
+	// Stop 可能由多个清理路径调用：只关闭一次，产生帧的协程使用自己持有的通道
+	stop     chan struct{}
+	stopOnce *sync.Once
+}
+
+func (s *sineSource) Initialize() error { return nil }
+
+func (s *sineSource) Start(ctx context.Context, callback audio.AudioDataCallback) error {
+	stop := make(chan struct{})
+	s.stop, s.stopOnce = stop, new(sync.Once)
+	go func() {
+		ticker := time.NewTicker(s.config.GetFrameDuration())
+		defer ticker.Stop()
+		frame := make([]byte, s.config.FramesPerBuffer*s.config.GetFrameSize())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			for i := 0; i < len(frame)/4; i++ {
+				t := float64(s.index+uint64(i)) / float64(s.config.SampleRate)
+				sample := int16(8000 * math.Sin(2*math.Pi*440*t))
+				binary.LittleEndian.PutUint16(frame[4*i:], uint16(sample))
+				binary.LittleEndian.PutUint16(frame[4*i+2:], uint16(sample))
+			}
+			callback(frame)
+			s.index += uint64(s.config.FramesPerBuffer)
+		}
+	}()
+	return nil
+}
+
+func (s *sineSource) Stop() {
+	if s.stopOnce != nil {
+		stop := s.stop
+		s.stopOnce.Do(func() { close(stop) })
+	}
+}
+
+func (s *sineSource) Terminate()                   {}
+func (s *sineSource) GetStats() *utils.AudioStats  { return &utils.AudioStats{} }
+func (s *sineSource) SampleIndex() uint64          { return s.index }
+func (s *sineSource) DeviceLatency() time.Duration { return 0 }
+
+// recordingSink 记录服务端交给它的全部音频
+type recordingSink struct {
+	mutex     sync.Mutex
+	received  []byte
+	endSample uint64
+}
+
+func (r *recordingSink) Initialize() error                                             { return nil }
+func (r *recordingSink) StartWithFadeIn(ctx context.Context, fade time.Duration) error { return nil }
+func (r *recordingSink) SetVolume(volume float64)                                      {}
+func (r *recordingSink) Drain(timeout time.Duration) bool                              { return true }
+func (r *recordingSink) StopWithFadeOut(fade time.Duration)                            {}
+func (r *recordingSink) Terminate()                                                    {}
+func (r *recordingSink) GetStats() *utils.AudioStats                                   { return &utils.AudioStats{} }
+func (r *recordingSink) DeviceLatency() time.Duration                                  { return 0 }
+
+func (r *recordingSink) QueueAudioIndexed(audioData []byte, capturedAt time.Time, endSample uint64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.received = append(r.received, audioData...)
+	r.endSample = endSample
+	return nil
+}
+
+func (r *recordingSink) recorded() ([]byte, uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]byte(nil), r.received...), r.endSample
+}
+
+// TestAudioSourceAndSink 通过 SetAudioSource / SetAudioSink 替换声卡跑完整会话
+func TestAudioSourceAndSink(t *testing.T) {
+	const frames = 10
+	port := freePort(t)
+	newConfig := func() *utils.Config {
+		config := utils.NewDefaultConfig()
+		config.Host = "127.0.0.1"
+		config.Port = port
+		config.SampleRate = 48000
+		config.FramesPerBuffer = 960
+		config.Compression = utils.CodecOpus
+		config.SampleIndex = true
+		return config
+	}
+	logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+
+	sink := &recordingSink{}
+	server := NewServer(newConfig(), logger)
+	server.SetAudioSink(func(config *utils.Config) AudioSink { return sink })
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- server.Serve(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		if err := <-serveDone; err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	client := NewClient(newConfig(), logger)
+	client.SetAudioSource(func(config *utils.Config) AudioSource { return &sineSource{config: config} })
+	clientCtx, stopClient := context.WithCancel(context.Background())
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- client.Run(clientCtx, nil)
+	}()
+
+	frameBytes := 960 * 4
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if received, _ := sink.recorded(); len(received) >= frames*frameBytes {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sink did not receive %d frames", frames)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	stopClient()
+	if err := <-runDone; err != nil {
+		t.Fatalf("client Run returned %v", err)
+	}
+
+	received, endSample := sink.recorded()
+	assertSine(t, received, 8000/math.Sqrt2, 440, 48000)
+	if endSample == 0 || endSample%960 != 0 {
+		t.Fatalf("sink got end sample %d, want a multiple of the 960-frame packets", endSample)
+	}
+}
+
+// pausedSource 一直不产生音频，相当于激励模式暂停了发送
+type pausedSource struct{ sineSource }
+
+func (p *pausedSource) Start(ctx context.Context, callback audio.AudioDataCallback) error { return nil }
+
+// TestHeartbeatOnlySession 没有音频、只有心跳的连接应保持到保活超时的数倍时长，
+// 即使读超时短于心跳间隔
+func TestHeartbeatOnlySession(t *testing.T) {
+	for _, controlChannel := range []bool{false, true} {
+		name := "shared"
+		if controlChannel {
+			name = "control-channel"
+		}
+		t.Run(name, func(t *testing.T) {
+			port := freePort(t)
+			newConfig := func() *utils.Config {
+				config := utils.NewDefaultConfig()
+				config.Host = "127.0.0.1"
+				config.Port = port
+				config.ControlChannel = controlChannel
+				config.HeartbeatInterval = 100 * time.Millisecond
+				config.HeartbeatTimeout = 300 * time.Millisecond
+				config.KeepaliveTimeout = 600 * time.Millisecond
+				config.ReadTimeout = 50 * time.Millisecond
+				return config
+			}
+			logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+
+			sink := &recordingSink{}
+			server := NewServer(newConfig(), logger)
+			server.SetAudioSink(func(config *utils.Config) AudioSink { return sink })
+			ctx, cancel := context.WithCancel(context.Background())
+			serveDone := make(chan error, 1)
+			go func() {
+				serveDone <- server.Serve(ctx, nil)
+			}()
+			defer func() {
+				cancel()
+				if err := <-serveDone; err != nil {
+					t.Errorf("Serve returned %v", err)
+				}
+			}()
+			time.Sleep(300 * time.Millisecond)
+
+			client := NewClient(newConfig(), logger)
+			client.SetAudioSource(func(config *utils.Config) AudioSource { return &pausedSource{} })
+			clientCtx, stopClient := context.WithCancel(context.Background())
+			runDone := make(chan error, 1)
+			go func() {
+				runDone <- client.Run(clientCtx, nil)
+			}()
+
+			select {
+			case err := <-runDone:
+				t.Fatalf("client stopped during the heartbeat-only session: %v", err)
+			case <-time.After(2 * time.Second):
+			}
+			if atomic.LoadInt32(&server.connected) != 1 {
+				t.Fatalf("server dropped the heartbeat-only connection")
+			}
+			if received, _ := sink.recorded(); len(received) != 0 {
+				t.Fatalf("sink received %d bytes from a paused source", len(received))
+			}
+			if rtt := client.GetStats().RoundTripTime; rtt <= 0 {
+				t.Fatalf("no heartbeat round trip measured")
+			}
+
+			stopClient()
+			if err := <-runDone; err != nil {
+				t.Fatalf("client Run returned %v", err)
+			}
+		})
+	}
+}
+
+// TestPSKSession 口令一致时加密推流被服务端正常解密；口令不同时握手即被拒绝
+func TestPSKSession(t *testing.T) {
+	const frames = 5
+	dir := t.TempDir()
+	port := freePort(t)
+	newConfig := func(psk string) *utils.Config {
+		config := utils.NewDefaultConfig()
+		config.Host = "127.0.0.1"
+		config.Port = port
+		config.SampleRate = 48000
+		config.FramesPerBuffer = 960
+		config.Compression = utils.CodecPCM
+		config.PSK = psk
+		return config
+	}
+
+	serverConfig := newConfig("shared secret")
+	serverConfig.OutputPipe = filepath.Join(dir, "out.pcm")
+	logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+	server := NewServer(serverConfig, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- server.Serve(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		<-serveDone
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	input := filepath.Join(dir, "in.pcm")
+	if err := os.WriteFile(input, make([]byte, frames*960*4), 0644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	run := func(psk string) error {
+		config := newConfig(psk)
+		config.InputPipe = input
+		config.ConnectRetries = 0
+		done := make(chan error, 1)
+		go func() {
+			done <- NewClient(config, logger).Run(context.Background(), nil)
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(10 * time.Second):
+			t.Fatalf("client with psk %q did not finish", psk)
+			return nil
+		}
+	}
+
+	if err := run("wrong secret"); err == nil || !strings.Contains(err.Error(), "wrong pre-shared key") {
+		t.Fatalf("wrong psk: client Run returned %v", err)
+	}
+	if err := run("shared secret"); err != nil {
+		t.Fatalf("matching psk: client Run returned %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&server.connected) == 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	stats := server.GetStats()
+	if stats.Total.PacketsReceived < frames-1 || stats.Total.ErrorCount != 0 {
+		t.Fatalf("server received %d packets with %d errors, want about %d without errors",
+			stats.Total.PacketsReceived, stats.Total.ErrorCount, frames)
+	}
+}
+
+// TestPSKSessionReplay 录下一次合法的加密会话（握手与音频包），原样重放到新连接：服务端的随机值
+// 使重放会话得到不同的密钥，录下的音频包全部无法通过认证
+func TestPSKSessionReplay(t *testing.T) {
+	const frames = 5
+	dir := t.TempDir()
+	port := freePort(t)
+	newConfig := func() *utils.Config {
+		config := utils.NewDefaultConfig()
+		config.Host = "127.0.0.1"
+		config.Port = port
+		config.SampleRate = 48000
+		config.FramesPerBuffer = 960
+		config.Compression = utils.CodecPCM
+		config.ControlChannel = false
+		config.PSK = "shared secret"
+		return config
+	}
+
+	serverConfig := newConfig()
+	serverConfig.OutputPipe = filepath.Join(dir, "out.pcm")
+	logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+	server := NewServer(serverConfig, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- server.Serve(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		<-serveDone
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	// 客户端经由转发端口连接，记录它发往服务端的全部字节
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer proxy.Close()
+	var recorded bytes.Buffer
+	proxyDone := make(chan struct{})
+	go func() {
+		defer close(proxyDone)
+		conn, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		upstream, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			return
+		}
+		replies := make(chan struct{})
+		go func() {
+			io.Copy(conn, upstream)
+			close(replies)
+		}()
+		io.Copy(upstream, io.TeeReader(conn, &recorded))
+		upstream.Close()
+		<-replies
+	}()
+
+	input := filepath.Join(dir, "in.pcm")
+	if err := os.WriteFile(input, make([]byte, frames*960*4), 0644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	clientConfig := newConfig()
+	clientConfig.Port = proxy.Addr().(*net.TCPAddr).Port
+	clientConfig.InputPipe = input
+	clientConfig.ConnectRetries = 0
+	if err := NewClient(clientConfig, logger).Run(context.Background(), nil); err != nil {
+		t.Fatalf("client Run returned %v", err)
+	}
+	<-proxyDone
+	waitDisconnected := func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(&server.connected) == 1 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	waitDisconnected()
+	before := server.GetStats().Total
+	if before.PacketsReceived < frames-1 {
+		t.Fatalf("recorded session delivered %d packets, want about %d", before.PacketsReceived, frames)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(recorded.Bytes()); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	reply, err := ReadPacketWithTimeout(conn, 5*time.Second, 0)
+	if err != nil {
+		t.Fatalf("read handshake reply: %v", err)
+	}
+	var replayed HandshakeConfig
+	if err := replayed.FromBytes(reply.Payload); err != nil {
+		t.Fatalf("parse handshake reply: %v", err)
+	}
+	conn.Close()
+	waitDisconnected()
+
+	after := server.GetStats().Total
+	if after.PacketsReceived != before.PacketsReceived || after.ErrorCount-before.ErrorCount < frames-1 {
+		t.Fatalf("replayed session: %d packets accepted, %d rejected, want none accepted and about %d rejected",
+			after.PacketsReceived-before.PacketsReceived, after.ErrorCount-before.ErrorCount, frames)
+	}
+}
+
+// writeOggOpus 写出每页一个包的 Ogg Opus 文件（OpusHead、OpusTags 之后为音频包）
+func writeOggOpus(t *testing.T, path string, channels int, packets [][]byte) {
+	t.Helper()
+	head := append([]byte("OpusHead"), 1, byte(channels), 0, 0, 0x80, 0xBB, 0, 0, 0, 0, 0)
+	tags := append([]byte("OpusTags"), 0, 0, 0, 0, 0, 0, 0, 0)
+	var file []byte
+	for seq, packet := range append([][]byte{head, tags}, packets...) {
+		page := make([]byte, 27, 28+len(packet))
+		copy(page, "OggS")
+		if seq == 0 {
+			page[5] = 0x02
+		}
+		binary.LittleEndian.PutUint32(page[18:], uint32(seq))
+		page[26] = 1
+		page = append(append(page, byte(len(packet))), packet...)
+		// Ogg CRC-32：多项式 0x04C11DB7，不反射
+		var crc uint32
+		for _, b := range page {
+			crc ^= uint32(b) << 24
+			for bit := 0; bit < 8; bit++ {
+				if crc&0x80000000 != 0 {
+					crc = crc<<1 ^ 0x04C11DB7
+				} else {
+					crc <<= 1
+				}
+			}
+		}
+		binary.LittleEndian.PutUint32(page[22:], crc)
+		file = append(file, page...)
+	}
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatalf("write input file: %v", err)
+	}
+}
+
+// TestInputFileSession 客户端把 Ogg Opus 文件中的包原样发送：按文件格式协商，时长不同的包被跳过
+func TestInputFileSession(t *testing.T) {
+	const frames = 10
+
+	dir := t.TempDir()
+	port := freePort(t)
+	newConfig := func() *utils.Config {
+		config := utils.NewDefaultConfig()
+		config.Host = "127.0.0.1"
+		config.Port = port
+		return config
+	}
+	serverConfig := newConfig()
+	serverConfig.OutputPipe = filepath.Join(dir, "out.pcm")
+	clientConfig := newConfig()
+	clientConfig.InputFile = filepath.Join(dir, "in.opus")
+	clientConfig.SampleIndex = true
+
+	// 20ms 立体声 CELT 包，中间夹一个 5ms 的包
+	var packets [][]byte
+	for i := 0; i < frames; i++ {
+		packets = append(packets, []byte{0xFC, byte(i), 0x55})
+		if i == frames/2 {
+			packets = append(packets, []byte{0xEC, 0xAA})
+		}
+	}
+	writeOggOpus(t, clientConfig.InputFile, 2, packets)
+
+	logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+	server := NewServer(serverConfig, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- server.Serve(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		if err := <-serveDone; err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	client := NewClient(clientConfig, logger)
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- client.Run(context.Background(), nil)
+	}()
+	select {
+	case err := <-runDone:
+		if err != nil {
+			t.Fatalf("client Run returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("client did not finish streaming")
+	}
+	if clientConfig.Compression != utils.CodecOpus || clientConfig.SampleRate != 48000 || clientConfig.FramesPerBuffer != 960 {
+		t.Fatalf("negotiated %s at %d Hz with %d frames, want Opus at 48000 Hz with 960",
+			clientConfig.Compression, clientConfig.SampleRate, clientConfig.FramesPerBuffer)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&server.connected) == 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	stats := server.GetStats()
+	if stats.Total.PacketsReceived != frames {
+		t.Fatalf("server received %d packets, want %d", stats.Total.PacketsReceived, frames)
+	}
+	// 跳过的 5ms 包在采样序号中留下 240 帧的间隙
+	if stats.Total.SamplesMissing != 240 {
+		t.Fatalf("SamplesMissing = %d, want 240", stats.Total.SamplesMissing)
+	}
+}
+
+// TestControlChannelSession 客户端请求控制连接：服务端附加携带正确令牌的第二条连接，拒绝其他连接
+func TestControlChannelSession(t *testing.T) {
+	const frames = 50
+
+	dir := t.TempDir()
+	port := freePort(t)
+	newConfig := func() *utils.Config {
+		config := utils.NewDefaultConfig()
+		config.Host = "127.0.0.1"
+		config.Port = port
+		config.SampleRate = 48000
+		config.FramesPerBuffer = 960
+		config.HeartbeatInterval = 100 * time.Millisecond
+		config.HeartbeatTimeout = 300 * time.Millisecond
+		config.KeepaliveTimeout = 2 * time.Second
+		return config
+	}
+	serverConfig := newConfig()
+	serverConfig.OutputPipe = filepath.Join(dir, "out.pcm")
+	clientConfig := newConfig()
+	clientConfig.ControlChannel = true
+	clientConfig.InputPipe = filepath.Join(dir, "in.pcm")
+	input := make([]byte, frames*clientConfig.FramesPerBuffer*clientConfig.GetFrameSize())
+	if err := os.WriteFile(clientConfig.InputPipe, input, 0644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+	server := NewServer(serverConfig, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- server.Serve(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		if err := <-serveDone; err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	client := NewClient(clientConfig, logger)
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- client.Run(context.Background(), nil)
+	}()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for !server.hasControlConnection() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !server.hasControlConnection() {
+		t.Fatalf("server did not attach a control connection")
+	}
+
+	// 令牌错误的 Attach 连接应被关闭
+	conn, err := net.Dial("tcp", clientConfig.GetNetworkAddress())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := WritePacket(conn, NewAttachPacket([SessionTokenSize]byte{1})); err != nil {
+		t.Fatalf("write attach: %v", err)
+	}
+	if _, err := ReadPacketWithTimeout(conn, 2*time.Second, 0); !errors.Is(err, io.EOF) {
+		t.Fatalf("attach with wrong token: got %v, want connection closed", err)
+	}
+
+	select {
+	case err := <-runDone:
+		if err != nil {
+			t.Fatalf("client Run returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("client did not finish streaming")
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&server.connected) == 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&server.connected) == 1 {
+		t.Fatalf("server still reports a connected client")
+	}
+	if server.hasControlConnection() {
+		t.Fatalf("control connection not cleared after the session")
+	}
+	if received := server.GetStats().Total.PacketsReceived; received < frames-1 {
+		t.Fatalf("server received %d packets, want about %d", received, frames)
+	}
+}
+
+// TestIdleTimeoutGoodbye 只发心跳的客户端在 -idle-timeout 后收到告别包，会话随即结束
+func TestIdleTimeoutGoodbye(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = freePort(t)
+	config.OutputPipe = filepath.Join(t.TempDir(), "out.pcm")
+	config.HeartbeatInterval = 100 * time.Millisecond
+	config.IdleTimeout = 300 * time.Millisecond
+
+	server := NewServer(config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- server.Serve(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		<-serveDone
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", config.GetNetworkAddress())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	handshake := &HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4}
+	if err := WritePacket(conn, NewHandshakePacket(handshake)); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+	if response, err := ReadPacketWithTimeout(conn, 3*time.Second, 0); err != nil || response.Header.Type != PacketTypeHandshake {
+		t.Fatalf("handshake response: %v (err %v)", response, err)
+	}
+
+	// 心跳不算活动：超时后应收到告别包
+	goodbye := make(chan *Packet, 1)
+	go func() {
+		for {
+			packet, err := ReadPacketWithTimeout(conn, 3*time.Second, 0)
+			if err != nil {
+				close(goodbye)
+				return
+			}
+			if packet.Header.Type == PacketTypeControl {
+				goodbye <- packet
+				return
+			}
+		}
+	}()
+	var packet *Packet
+	for packet == nil {
+		select {
+		case packet = <-goodbye:
+			if packet == nil {
+				t.Fatalf("connection closed without a goodbye packet")
+			}
+		case <-time.After(50 * time.Millisecond):
+			WritePacket(conn, NewHeartbeatPacket())
+		}
+	}
+	if packet.Payload[0] != ControlGoodbye {
+		t.Fatalf("control packet %#x, want goodbye", packet.Payload)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt32(&server.connected) == 1 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&server.connected) == 1 {
+		t.Fatalf("server still reports a connected client after the goodbye")
+	}
+}
+
+// stoppingSource 记录客户端是否停止了音频源
+type stoppingSource struct {
+	sineSource
+	stopped int32
+}
+
+func (s *stoppingSource) Stop() {
+	atomic.StoreInt32(&s.stopped, 1)
+	s.sineSource.Stop()
+}
+
+// TestClientStopsAfterGoodbye 服务端结束会话（-max-session）后客户端应完整清理：停止音频源、关闭连接并减少连接计数
+func TestClientStopsAfterGoodbye(t *testing.T) {
+	// 有控制连接时告别包走控制连接：客户端只在那里读取服务端的包
+	for _, controlChannel := range []bool{false, true} {
+		controlChannel := controlChannel
+		t.Run(fmt.Sprintf("control=%v", controlChannel), func(t *testing.T) {
+			port := freePort(t)
+			newConfig := func() *utils.Config {
+				config := utils.NewDefaultConfig()
+				config.Host = "127.0.0.1"
+				config.Port = port
+				config.HeartbeatInterval = 100 * time.Millisecond
+				config.ControlChannel = controlChannel
+				return config
+			}
+			logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+
+			serverConfig := newConfig()
+			serverConfig.MaxSession = 500 * time.Millisecond
+			server := NewServer(serverConfig, logger)
+			server.SetAudioSink(func(config *utils.Config) AudioSink { return &recordingSink{} })
+			ctx, cancel := context.WithCancel(context.Background())
+			serveDone := make(chan error, 1)
+			go func() {
+				serveDone <- server.Serve(ctx, nil)
+			}()
+			defer func() {
+				cancel()
+				<-serveDone
+			}()
+			time.Sleep(300 * time.Millisecond)
+
+			source := &stoppingSource{}
+			client := NewClient(newConfig(), logger)
+			client.SetAudioSource(func(config *utils.Config) AudioSource {
+				source.config = config
+				return source
+			})
+			runDone := make(chan error, 1)
+			go func() {
+				runDone <- client.Run(context.Background(), nil)
+			}()
+			select {
+			case err := <-runDone:
+				if err != nil {
+					t.Fatalf("client Run returned %v after the goodbye", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("client did not stop after the server ended the session")
+			}
+
+			if atomic.LoadInt32(&source.stopped) == 0 {
+				t.Errorf("audio source still running after the goodbye")
+			}
+			if _, err := client.conn.Write([]byte{0}); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("audio connection still open after the goodbye (write: %v)", err)
+			}
+			if controlChannel {
+				if client.controlConn == nil {
+					t.Fatalf("no control connection was attached")
+				}
+				if _, err := client.controlConn.Write([]byte{0}); !errors.Is(err, net.ErrClosed) {
+					t.Errorf("control connection still open after the goodbye (write: %v)", err)
+				}
+			}
+			if active := client.shutdown.ActiveConnections(); active != 0 {
+				t.Errorf("%d active connections after the goodbye, want 0", active)
+			}
+		})
+	}
+}
+
+// TestClientRetryAfterEncoderFailure 握手后编码器创建失败时客户端结束这次会话，
+// 立即以另一种编解码器重新 Run（main 回退到 PCM 的做法）应被服务端接受
+func TestClientRetryAfterEncoderFailure(t *testing.T) {
+	for _, controlChannel := range []bool{false, true} {
+		controlChannel := controlChannel
+		t.Run(fmt.Sprintf("control=%v", controlChannel), func(t *testing.T) {
+			port := freePort(t)
+			newConfig := func() *utils.Config {
+				config := utils.NewDefaultConfig()
+				config.Host = "127.0.0.1"
+				config.Port = port
+				config.SampleRate = 48000
+				config.FramesPerBuffer = 960
+				config.ControlChannel = controlChannel
+				return config
+			}
+			logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+
+			server := NewServer(newConfig(), logger)
+			sink := &recordingSink{}
+			server.SetAudioSink(func(config *utils.Config) AudioSink { return sink })
+			ctx, cancel := context.WithCancel(context.Background())
+			serveDone := make(chan error, 1)
+			go func() {
+				serveDone <- server.Serve(ctx, nil)
+			}()
+			defer func() {
+				cancel()
+				<-serveDone
+			}()
+			time.Sleep(300 * time.Millisecond)
+
+			sessionEncoders = func(config *utils.Config) (*opusMultiEncoder, *flacEncoder, error) {
+				return nil, nil, fmt.Errorf("%w: not available in this test", ErrOpusEncoder)
+			}
+			defer func() { sessionEncoders = newEncoders }()
+			clientConfig := newConfig()
+			clientConfig.Compression = utils.CodecOpus
+			source := &stoppingSource{}
+			client := NewClient(clientConfig, logger)
+			client.SetAudioSource(func(config *utils.Config) AudioSource {
+				source.config = config
+				return source
+			})
+			if err := client.Run(context.Background(), nil); !errors.Is(err, ErrOpusEncoder) {
+				t.Fatalf("first Run returned %v, want ErrOpusEncoder", err)
+			}
+			if _, err := client.conn.Write([]byte{0}); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("audio connection still open after the encoder failure (write: %v)", err)
+			}
+			if client.controlConn != nil {
+				if _, err := client.controlConn.Write([]byte{0}); !errors.Is(err, net.ErrClosed) {
+					t.Errorf("control connection still open after the encoder failure (write: %v)", err)
+				}
+			}
+
+			sessionEncoders = newEncoders
+			clientConfig.Compression = utils.CodecPCM
+			clientConfig.Duration = 500 * time.Millisecond
+			if err := client.Run(context.Background(), nil); err != nil {
+				t.Fatalf("retry with PCM returned %v", err)
+			}
+			if received, _ := sink.recorded(); len(received) == 0 {
+				t.Fatalf("server received no audio from the retried session")
+			}
+		})
+	}
+}
+
+// TestAdmitClientAllowList 白名单条目可以是 IP、CIDR 范围或已解析的主机名
+func TestAdmitClientAllowList(t *testing.T) {
+	allowList := []string{"192.168.1.100", "10.0.0.0/8", "fd00::/64", "::1", "studio.lan"}
+	hosts := map[string][]net.IP{"studio.lan": {net.ParseIP("192.168.1.50"), net.ParseIP("fd01::50")}}
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"192.168.1.100", true},
+		{"192.168.1.101", false},
+		{"10.20.30.40", true},
+		{"11.0.0.1", false},
+		{"fd00::1234", true},
+		{"0:0:0:0:0:0:0:1", true}, // 同一地址的不同写法
+		{"fd01::1", false},
+		{"192.168.1.50", true}, // studio.lan 解析出的地址
+		{"fd01::50", true},
+	}
+	for _, tt := range tests {
+		if got, reason := admitClient(tt.ip, allowList, nil, hosts); got != tt.allowed {
+			t.Errorf("admitClient(%s) = %v (%s), want %v", tt.ip, got, reason, tt.allowed)
+		}
+	}
+	if admitted, _ := admitClient("203.0.113.7", nil, nil, nil); !admitted {
+		t.Errorf("an empty list must allow every client")
+	}
+}
+
+// TestAdmitClientDenyWins 黑名单优先于白名单，且没有白名单时同样生效
+func TestAdmitClientDenyWins(t *testing.T) {
+	tests := []struct {
+		ip       string
+		allow    []string
+		deny     []string
+		admitted bool
+		rule     string
+	}{
+		{"10.0.0.5", []string{"10.0.0.0/8"}, []string{"10.0.0.5"}, false, "deny rule 10.0.0.5"},
+		{"10.0.0.6", []string{"10.0.0.0/8"}, []string{"10.0.0.5"}, true, "allow rule 10.0.0.0/8"},
+		{"203.0.113.7", nil, []string{"203.0.113.0/24"}, false, "deny rule 203.0.113.0/24"},
+		{"198.51.100.1", nil, []string{"203.0.113.0/24"}, true, "no allow list"},
+		{"198.51.100.1", []string{"10.0.0.0/8"}, nil, false, "not in allowed client list"},
+	}
+	for _, tt := range tests {
+		admitted, reason := admitClient(tt.ip, tt.allow, tt.deny, nil)
+		if admitted != tt.admitted || !strings.Contains(reason, tt.rule) {
+			t.Errorf("admitClient(%s, %v, %v) = %v, %q; want %v, %q", tt.ip, tt.allow, tt.deny, admitted, reason, tt.admitted, tt.rule)
+		}
+	}
+}
+
+// TestClientHostRules 主机名条目在加载时解析，接受连接时按解析出的地址比较
+func TestClientHostRules(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.AllowClients = []string{"localhost", "10.0.0.0/8"}
+	config.DenyClients = []string{"host.invalid"}
+	server := NewServer(config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	server.resolveClientHosts()
+
+	if _, ok := server.clientHosts["10.0.0.0/8"]; ok {
+		t.Errorf("CIDR entry was resolved as a host name")
+	}
+	if len(server.clientHosts["host.invalid"]) != 0 {
+		t.Errorf("unresolvable host matched %v", server.clientHosts["host.invalid"])
+	}
+	admitted, reason := admitClient("127.0.0.1", config.AllowClients, config.DenyClients, server.clientHosts)
+	if !admitted || !strings.Contains(reason, "allow rule localhost") {
+		t.Fatalf("127.0.0.1: got %v, %q; want admitted by localhost", admitted, reason)
+	}
+	if admitted, _ := admitClient("127.0.0.1", config.AllowClients, nil, nil); admitted {
+		t.Fatalf("host name matched without resolved addresses")
+	}
+}
+
+// TestDecodeOpusFrameSize 解码出的帧长与协商的 FramesPerBuffer 不同或多流负载被截断的 Opus 包被丢弃
+func TestDecodeOpusFrameSize(t *testing.T) {
+	for _, channels := range []int{2, 6} {
+		t.Run(fmt.Sprintf("%dch", channels), func(t *testing.T) {
+			config := utils.NewDefaultConfig()
+			config.Compression = utils.CodecOpus
+			config.SampleRate = 48000
+			config.Channels = channels
+			config.FramesPerBuffer = 960
+			encoder, _, err := newEncoders(config)
+			if err != nil {
+				t.Fatalf("newEncoders: %v", err)
+			}
+			pcm16 := make([]int16, config.FramesPerBuffer*channels)
+			for i := range pcm16 {
+				pcm16[i] = int16(4000 * math.Sin(float64(i)/10))
+			}
+			encoded, err := encoder.Encode(pcm16)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			valid := append([]byte(nil), encoded...)
+
+			server := NewServer(config, utils.NewLoggerWithLevel(utils.LogLevelError))
+			server.useOpus = true
+			if server.opusDecoder, err = newOpusMultiDecoder(config.SampleRate, channels); err != nil {
+				t.Fatalf("newOpusMultiDecoder: %v", err)
+			}
+			pcmData, ok := server.decodeAudioPayload(valid)
+			if !ok || len(pcmData) != config.FramesPerBuffer*config.GetFrameSize() {
+				t.Fatalf("valid packet: ok %v, %d bytes", ok, len(pcmData))
+			}
+
+			// 立体声为单流，TOC 0xF4 是一个 10ms CELT 帧，0xFF 0x03 是三个 20ms 帧（共 60ms）；
+			// 多声道负载由带长度前缀的多个流组成，截断后长度前缀越界
+			cases := map[string][]byte{"empty": {}}
+			if channels == 2 {
+				cases["10ms"] = []byte{0xF4}
+				cases["60ms"] = []byte{0xFF, 0x03}
+			} else {
+				cases["truncated"] = valid[:len(valid)/2]
+			}
+			for name, payload := range cases {
+				if pcmData, ok := server.decodeAudioPayload(payload); ok {
+					t.Errorf("%s: accepted %d bytes, want the packet dropped", name, len(pcmData))
+				}
+			}
+		})
+	}
+}
+
+// TestTrackSampleIndex 采样序号间隙计为缺失的采样帧，迟到的包不回退期望值
+func TestTrackSampleIndex(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.FramesPerBuffer = 480
+	server := NewServer(config, utils.NewLoggerWithLevel(utils.LogLevelError))
+
+	for _, index := range []uint64{960, 1440, 2880, 2400, 3360} {
+		server.trackSampleIndex(index)
+	}
+	// 1440 之后跳到 2880：缺失 1440..2880 共 960 帧；迟到的 2400 之后 3360 正好衔接
+	if missing := atomic.LoadInt64(&server.stats.SamplesMissing); missing != 960 {
+		t.Fatalf("SamplesMissing = %d, want 960", missing)
+	}
+	if server.expectedSampleIndex != 3840 {
+		t.Fatalf("expectedSampleIndex = %d, want 3840", server.expectedSampleIndex)
+	}
+}
+
+// TestTrackSequence 序列号跳跃计为丢包、迟到的包计为乱序；uint32 回绕不算丢包，大幅回退视为重新计数
+func TestTrackSequence(t *testing.T) {
+	track := func(sequences ...uint32) *Server {
+		server := NewServer(utils.NewDefaultConfig(), utils.NewLoggerWithLevel(utils.LogLevelError))
+		for _, sequence := range sequences {
+			server.trackSequence(sequence)
+		}
+		return server
+	}
+
+	// 回绕后 2 先于 1 到达：先计一个丢包，1 迟到后改计为乱序
+	server := track(math.MaxUint32-1, math.MaxUint32, 0, 2, 1, 3)
+	if lost, reordered := atomic.LoadInt64(&server.stats.PacketsLost), atomic.LoadInt64(&server.stats.PacketsReordered); lost != 0 || reordered != 1 {
+		t.Fatalf("across the wrap: %d lost, %d reordered, want 0 and 1", lost, reordered)
+	}
+
+	// 5001 之后从 1 重新计数：不计丢包或乱序，之后跳过的 3 照常计为丢包
+	server = track(5000, 5001, 1, 2, 4)
+	if lost, reordered := atomic.LoadInt64(&server.stats.PacketsLost), atomic.LoadInt64(&server.stats.PacketsReordered); lost != 1 || reordered != 0 {
+		t.Fatalf("after a restart: %d lost, %d reordered, want 1 and 0", lost, reordered)
+	}
+	if server.expectedSequence != 5 {
+		t.Fatalf("expectedSequence = %d, want 5", server.expectedSequence)
+	}
+}
+
+// TestTakePreopenedOutputs 预打开的输出只交给格式和设备都一致的会话，否则关闭后由会话重新打开
+func TestTakePreopenedOutputs(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+	device := &audio.DeviceInfo{Name: "test"}
+
+	preopen := func(server *Server) []*outputSink {
+		outputs := []*outputSink{{player: audio.NewPlayer(device, config, logger)}}
+		server.preopened = outputs
+		server.preopenedDevice = device
+		server.preopenedConfig = *server.config
+		return outputs
+	}
+
+	server := NewServer(config, logger)
+	outputs := preopen(server)
+	if got := server.takePreopenedOutputs(device); len(got) != 1 || got[0] != outputs[0] {
+		t.Fatalf("matching format: got %v, want the pre-opened outputs", got)
+	}
+	if server.preopened != nil {
+		t.Fatalf("pre-opened outputs not handed over")
+	}
+
+	preopen(server)
+	server.config.SampleRate = config.SampleRate / 2 // 握手协商出不同的采样率
+	if got := server.takePreopenedOutputs(device); got != nil {
+		t.Fatalf("different format: got %v, want nil", got)
+	}
+	if server.preopened != nil {
+		t.Fatalf("mismatched pre-opened outputs not released")
+	}
+
+	preopen(server)
+	if got := server.takePreopenedOutputs(&audio.DeviceInfo{Name: "other"}); got != nil {
+		t.Fatalf("different device: got %v, want nil", got)
+	}
+}
+
+// TestHandshakeProtocolMismatch 客户端使用其他协议版本时，服务端回复说明需要升级哪一端的错误包
+func TestHandshakeProtocolMismatch(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = freePort(t)
+	config.OutputPipe = "unused.pcm"
+
+	server := NewServer(config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- server.Serve(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		<-serveDone
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", config.GetNetworkAddress())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	handshake := &HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4}
+	data := encodePacket(t, NewHandshakePacket(handshake))
+	data[4] = ProtocolVersion + 1
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+
+	response, err := ReadPacketWithTimeout(conn, 3*time.Second, 0)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	want := protocolMismatchMessage(ProtocolVersion, ProtocolVersion+1)
+	if response.Header.Type != PacketTypeError || string(response.Payload) != want {
+		t.Fatalf("got %s packet %q, want error %q", response.Header.Type, response.Payload, want)
+	}
+}
+
+// TestHandshakeDuringSession 会话中途再收到握手包时，服务端回复错误包并断开
+func TestHandshakeDuringSession(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = freePort(t)
+	config.OutputPipe = filepath.Join(t.TempDir(), "out.pcm")
+
+	server := NewServer(config, utils.NewLoggerWithLevel(utils.LogLevelError))
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- server.Serve(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		<-serveDone
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", config.GetNetworkAddress())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	handshake := &HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, BufferCount: 4}
+	if err := WritePacket(conn, NewHandshakePacket(handshake)); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+	response, err := ReadPacketWithTimeout(conn, 3*time.Second, 0)
+	if err != nil || response.Header.Type != PacketTypeHandshake {
+		t.Fatalf("handshake response: %v, %v", response, err)
+	}
+
+	if err := WritePacket(conn, NewHandshakePacket(handshake)); err != nil {
+		t.Fatalf("write second handshake: %v", err)
+	}
+	response, err = ReadPacketWithTimeout(conn, 3*time.Second, 0)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if response.Header.Type != PacketTypeError || !strings.HasPrefix(string(response.Payload), "protocol violation: ") {
+		t.Fatalf("got %s packet %q, want a protocol violation error", response.Header.Type, response.Payload)
+	}
+	if _, err := ReadPacketWithTimeout(conn, 3*time.Second, 0); !errors.Is(err, io.EOF) {
+		t.Fatalf("connection still open after the protocol violation: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt32(&server.connected) == 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&server.connected) == 1 {
+		t.Fatalf("server still reports a connected client")
+	}
+}
+
+// TestClientHandshakeFailureKinds 连接被拒绝、握手超时和协议版本不匹配应能区分
+func TestClientHandshakeFailureKinds(t *testing.T) {
+	mismatch := encodePacket(t, NewErrorPacket("unsupported"))
+	mismatch[4] = ProtocolVersion + 1
+	tests := []struct {
+		name     string
+		serve    func(conn net.Conn) // nil 表示不监听
+		want     HandshakeFailure
+		exitCode int
+	}{
+		{"refused", nil, HandshakeRefused, utils.ExitCodeNetwork},
+		{"timeout", func(conn net.Conn) {
+			// 不回复，直到客户端超时断开
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			io.Copy(io.Discard, conn)
+		}, HandshakeTimeout, utils.ExitCodeNetwork},
+		{"mismatch", func(conn net.Conn) {
+			ReadPacket(conn)
+			conn.Write(mismatch)
+		}, HandshakeProtocolMismatch, utils.ExitCodeProtocol},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			config := utils.NewDefaultConfig()
+			config.Host = "127.0.0.1"
+			config.Port = freePort(t)
+			config.InputPipe = "unused.pcm" // 握手失败前不会打开
+			config.ConnTimeout = 200 * time.Millisecond
+			config.ConnectRetries = 0
+
+			if tt.serve != nil {
+				listener, err := net.Listen("tcp", config.GetNetworkAddress())
+				if err != nil {
+					t.Fatalf("listen: %v", err)
+				}
+				served := make(chan struct{})
+				defer func() {
+					listener.Close()
+					<-served
+				}()
+				go func() {
+					defer close(served)
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+					tt.serve(conn)
+				}()
+			}
+
+			client := NewClient(config, utils.NewLoggerWithLevel(utils.LogLevelError))
+			err := client.Run(context.Background(), nil)
+			var handshakeErr *HandshakeError
+			if !errors.As(err, &handshakeErr) {
+				t.Fatalf("Run returned %v, want a HandshakeError", err)
+			}
+			if handshakeErr.Kind != tt.want {
+				t.Fatalf("got %s, want %s (%v)", handshakeErr.Kind, tt.want, err)
+			}
+			if code := utils.ExitCodeForError(err); code != tt.exitCode {
+				t.Fatalf("exit code %d, want %d (%v)", code, tt.exitCode, err)
+			}
+		})
+	}
+}
+
+// TestClientConnectRetries 服务端晚于客户端启动时，客户端重试后应完成推流
+func TestClientConnectRetries(t *testing.T) {
+	dir := t.TempDir()
+	port := freePort(t)
+	newConfig := func() *utils.Config {
+		config := utils.NewDefaultConfig()
+		config.Host = "127.0.0.1"
+		config.Port = port
+		config.SampleRate = 48000
+		config.FramesPerBuffer = 960
+		return config
+	}
+
+	serverConfig := newConfig()
+	serverConfig.OutputPipe = filepath.Join(dir, "out.pcm")
+	clientConfig := newConfig()
+	clientConfig.InputPipe = filepath.Join(dir, "in.pcm")
+	clientConfig.ConnectRetries = 3
+	input := make([]byte, 2*clientConfig.FramesPerBuffer*clientConfig.GetFrameSize())
+	if err := os.WriteFile(clientConfig.InputPipe, input, 0644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	logger := utils.NewLoggerWithLevel(utils.LogLevelError)
+	server := NewServer(serverConfig, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		serveDone <- server.Serve(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		<-serveDone
+	}()
+
+	client := NewClient(clientConfig, logger)
+	if err := client.Run(context.Background(), nil); err != nil {
+		t.Fatalf("client Run returned %v", err)
+	}
+}
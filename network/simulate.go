@@ -0,0 +1,138 @@
+// network/simulate.go - -simulate-loss / -simulate-jitter / -simulate-reorder：在客户端的音频发送路径上模拟不稳定的网络
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+// netSimulator sits between the audio packet writer and the connection and drops, delays
+// and reorders whole packets (one Write call each), so that loss statistics, the jitter
+// buffer and gap concealment on the server can be tested on a perfect network. Heartbeats
+// and the handshake bypass it.
+type netSimulator struct {
+	w       io.Writer
+	loss    float64
+	reorder float64
+	jitter  time.Duration
+	rand    *rand.Rand // 只在写入方（采集回调或文件发送循环）中使用
+	held    []byte     // 乱序：暂扣的包，在下一个包之后发出
+
+	// 有抖动时由 run 按到期时间依次写出（TCP 保持顺序，晚到的包会推迟后面的包）
+	queue   chan delayedPacket
+	errMu   sync.Mutex
+	lastErr error
+}
+
+type delayedPacket struct {
+	due  time.Time
+	data []byte
+}
+
+// newNetSimulator returns nil when config enables none of the simulations
+func newNetSimulator(config *utils.Config, w io.Writer) *netSimulator {
+	if config.SimulateLoss <= 0 && config.SimulateJitter <= 0 && config.SimulateReorder <= 0 {
+		return nil
+	}
+	s := &netSimulator{
+		w:       w,
+		loss:    config.SimulateLoss,
+		reorder: config.SimulateReorder,
+		jitter:  config.SimulateJitter,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if s.jitter > 0 {
+		s.queue = make(chan delayedPacket, 256)
+	}
+	return s
+}
+
+// String describes the simulated conditions for the startup log
+func (s *netSimulator) String() string {
+	return fmt.Sprintf("%.1f%% loss, up to %v jitter, %.1f%% reordered", s.loss*100, s.jitter, s.reorder*100)
+}
+
+// Write takes one encoded packet. Dropped and held packets report success; once a delayed
+// write has failed, its error is returned for every later packet.
+func (s *netSimulator) Write(p []byte) (int, error) {
+	if err := s.writeError(); err != nil {
+		return 0, err
+	}
+	if s.loss > 0 && s.rand.Float64() < s.loss {
+		return len(p), nil
+	}
+	// 调用方会复用 p，暂扣或延迟的包需要复制
+	if s.held == nil && s.reorder > 0 && s.rand.Float64() < s.reorder {
+		s.held = append([]byte(nil), p...)
+		return len(p), nil
+	}
+	if err := s.send(p); err != nil {
+		return 0, err
+	}
+	if s.held != nil {
+		held := s.held
+		s.held = nil
+		if err := s.send(held); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// send 无抖动时直接写出，否则加上随机延迟后排队
+func (s *netSimulator) send(p []byte) error {
+	if s.queue == nil {
+		_, err := s.w.Write(p)
+		return err
+	}
+	delay := time.Duration(s.rand.Int63n(int64(s.jitter) + 1))
+	select {
+	case s.queue <- delayedPacket{due: time.Now().Add(delay), data: append([]byte(nil), p...)}:
+	default:
+		// 队列已满说明连接写不动了，与真实网络一样丢弃
+	}
+	return nil
+}
+
+// run writes the delayed packets until ctx is cancelled; it is only needed with jitter
+func (s *netSimulator) run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		var packet delayedPacket
+		select {
+		case <-ctx.Done():
+			return
+		case packet = <-s.queue:
+		}
+		if wait := time.Until(packet.due); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		}
+		if _, err := s.w.Write(packet.data); err != nil {
+			s.errMu.Lock()
+			s.lastErr = err
+			s.errMu.Unlock()
+			return
+		}
+	}
+}
+
+// writeError 返回延迟写出时遇到的错误；之后的包不再写出
+func (s *netSimulator) writeError() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	return s.lastErr
+}
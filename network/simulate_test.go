@@ -0,0 +1,105 @@
+package network
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+// packetLog 记录每次 Write 的数据（可被 run 的 goroutine 并发写入）
+type packetLog struct {
+	mu      sync.Mutex
+	packets []string
+}
+
+func (l *packetLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.packets = append(l.packets, string(p))
+	return len(p), nil
+}
+
+func (l *packetLog) written() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.packets...)
+}
+
+func TestNetSimulatorDisabledByDefault(t *testing.T) {
+	if s := newNetSimulator(utils.NewDefaultConfig(), &packetLog{}); s != nil {
+		t.Fatalf("simulator created without any -simulate flag")
+	}
+}
+
+// TestNetSimulatorLossAndReorder 全部丢弃时什么都不写；全部乱序时每个暂扣的包都跟在下一个包之后
+func TestNetSimulatorLossAndReorder(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.SimulateLoss = 1
+	log := &packetLog{}
+	s := newNetSimulator(config, log)
+	for _, packet := range []string{"a", "b", "c"} {
+		if n, err := s.Write([]byte(packet)); n != 1 || err != nil {
+			t.Fatalf("dropped packet reported %d, %v", n, err)
+		}
+	}
+	if got := log.written(); len(got) != 0 {
+		t.Fatalf("lost packets were written: %v", got)
+	}
+
+	config = utils.NewDefaultConfig()
+	config.SimulateReorder = 1
+	log = &packetLog{}
+	s = newNetSimulator(config, log)
+	buf := make([]byte, 1)
+	for _, packet := range []byte("abcde") {
+		buf[0] = packet // 调用方复用缓冲区
+		if _, err := s.Write(buf); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if got := strings.Join(log.written(), ""); got != "badc" {
+		t.Fatalf("written %q, want \"badc\" (e still held)", got)
+	}
+}
+
+// TestNetSimulatorJitter 延迟后的包仍按顺序全部写出
+func TestNetSimulatorJitter(t *testing.T) {
+	config := utils.NewDefaultConfig()
+	config.SimulateJitter = 20 * time.Millisecond
+	log := &packetLog{}
+	s := newNetSimulator(config, log)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+
+	buf := make([]byte, 1)
+	for i := 0; i < 20; i++ {
+		buf[0] = byte('a' + i)
+		if _, err := s.Write(buf); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(log.written()) < 20 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	got := log.written()
+	if len(got) != 20 {
+		t.Fatalf("%d of 20 delayed packets written", len(got))
+	}
+	for i, packet := range got {
+		if packet != string(rune('a'+i)) {
+			t.Fatalf("packet %d is %q: delayed packets out of order", i, packet)
+		}
+	}
+}
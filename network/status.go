@@ -0,0 +1,224 @@
+// network/status.go - -status-addr：自动刷新的 HTML 状态页，数据与实时统计行相同
+
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+// statusRefreshSeconds 状态页自动刷新间隔
+const statusRefreshSeconds = 2
+
+// Status is a snapshot of a server or client shown on the status page
+type Status struct {
+	Mode      string
+	Address   string // 服务端监听地址，或客户端连接的服务端地址
+	Connected bool
+	Clients   []string          // 服务端：已连接的客户端地址（目前最多一个）
+	Format    string            // 协商后的流格式，未连接时为空
+	Config    *NegotiatedConfig // 完整的协商配置（/config），未连接时为 nil
+	Network   *utils.NetworkStats
+	Audio     *utils.AudioStats
+}
+
+// NegotiatedConfig is the handshake outcome of the current session, served as JSON on /config
+type NegotiatedConfig struct {
+	SampleRate      int    `json:"sample_rate"`
+	Channels        int    `json:"channels"`
+	BitDepth        int    `json:"bit_depth"`
+	SampleFormat    string `json:"sample_format"`
+	FramesPerBuffer int    `json:"frames_per_buffer"`
+	BufferCount     int    `json:"buffer_count"`
+	Codec           string `json:"codec"`
+	// 本会话协商的可选功能
+	ControlChannel bool `json:"control_channel"`
+	SampleIndex    bool `json:"sample_index"`
+	Encrypted      bool `json:"encrypted"`
+}
+
+// StatusSource is implemented by Server and Client
+type StatusSource interface {
+	Status() *Status
+}
+
+// negotiatedConfig 从握手回复中取出协商结果；controlChannel 为控制连接是否真正建立
+func negotiatedConfig(hc *HandshakeConfig, controlChannel bool) *NegotiatedConfig {
+	return &NegotiatedConfig{
+		SampleRate:      int(hc.SampleRate),
+		Channels:        int(hc.Channels),
+		BitDepth:        int(hc.BitDepth),
+		SampleFormat:    utils.SampleFormat(hc.SampleFormat).String(),
+		FramesPerBuffer: int(hc.FramesPerBuffer),
+		BufferCount:     int(hc.BufferCount),
+		Codec:           utils.CodecType(hc.Compression).String(),
+		ControlChannel:  controlChannel,
+		SampleIndex:     hc.Flags&HandshakeFlagSampleIndex != 0,
+		Encrypted:       hc.Flags&HandshakeFlagEncrypted != 0,
+	}
+}
+
+// streamFormat 描述握手后的流格式
+func streamFormat(config *NegotiatedConfig) string {
+	return fmt.Sprintf("%dHz, %dch, %d-bit %s, %s",
+		config.SampleRate, config.Channels, config.BitDepth, config.SampleFormat, config.Codec)
+}
+
+// Status returns the connection state, negotiated format and live statistics
+func (s *Server) Status() *Status {
+	status := &Status{Mode: "server", Address: s.config.GetNetworkAddress(), Network: s.GetStats()}
+	if addr := s.Addr(); addr != nil {
+		status.Address = addr.String()
+	}
+	s.connectionMutex.Lock()
+	if atomic.LoadInt32(&s.connected) == 1 && s.clientConn != nil {
+		status.Connected = true
+		status.Clients = []string{s.clientConn.RemoteAddr().String()}
+		if s.audioConfig != nil {
+			status.Config = negotiatedConfig(s.audioConfig, s.controlConn != nil)
+			status.Format = streamFormat(status.Config)
+		}
+	}
+	s.connectionMutex.Unlock()
+	if status.Connected {
+		status.Audio = s.currentAudioStats()
+	}
+	return status
+}
+
+// Status returns the connection state, negotiated format and live statistics
+func (c *Client) Status() *Status {
+	status := &Status{Mode: "client", Address: c.config.GetNetworkAddress(), Network: c.GetStats()}
+	if c.IsConnected() {
+		status.Connected = true
+		status.Config = negotiatedConfig(&c.negotiated, c.controlConn != nil)
+		status.Format = streamFormat(status.Config)
+		status.Audio = c.currentAudioStats()
+	}
+	return status
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"mb": func(bytes int64) string { return fmt.Sprintf("%.2f MB", float64(bytes)/(1024*1024)) },
+	"ms": func(d time.Duration) string { return fmt.Sprintf("%.1f ms", d.Seconds()*1000) },
+	"db": func(level float64) string {
+		if level < -59.9 {
+			return "-- dB"
+		}
+		return fmt.Sprintf("%.1f dB", level)
+	},
+	"percent": func(ratio float64) string { return fmt.Sprintf("%.1f%%", ratio*100) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Remote Audio CLI - {{.Status.Mode}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { text-align: left; padding: 0.2em 1em 0.2em 0; }
+th { color: #555; font-weight: normal; }
+</style>
+</head>
+<body>
+{{with .Status}}
+<h1>🎵 Remote Audio CLI {{.Mode}}</h1>
+<table>
+<tr><th>Address</th><td>{{.Address}}</td></tr>
+<tr><th>State</th><td>{{if .Connected}}🟢 connected{{else}}⚪ waiting{{end}}</td></tr>
+{{if eq .Mode "server"}}<tr><th>Clients</th><td>{{range .Clients}}{{.}}<br>{{else}}none{{end}}</td></tr>{{end}}
+{{if .Format}}<tr><th>Format</th><td>{{.Format}}</td></tr>{{end}}
+{{with .Config}}<tr><th>Buffers</th><td>{{.FramesPerBuffer}} frames × {{.BufferCount}}</td></tr>
+<tr><th>Session</th><td>control channel {{if .ControlChannel}}on{{else}}off{{end}}, sample index {{if .SampleIndex}}on{{else}}off{{end}}, encryption {{if .Encrypted}}on{{else}}off{{end}} (<a href="/config">JSON</a>)</td></tr>{{end}}
+</table>
+{{with .Network}}
+<h2>🌐 Network</h2>
+<table>
+<tr><th>RTT</th><td>{{ms .RoundTripTime}}</td></tr>
+<tr><th>Sent / received</th><td>{{mb .BytesSent}} / {{mb .BytesReceived}}</td></tr>
+<tr><th>Errors</th><td>{{.ErrorCount}}</td></tr>
+{{if .PacketsReceived}}<tr><th>Packets</th><td>{{.PacketsReceived}} received, {{printf "%.1f%%" .LossPercent}} lost, {{.PacketsReordered}} reordered</td></tr>{{end}}
+{{with .Total}}<tr><th>Since start</th><td>{{mb .BytesReceived}} received, {{.PacketsReceived}} packets, {{.ErrorCount}} errors</td></tr>{{end}}
+</table>
+{{end}}
+{{with .Audio}}
+<h2>📊 Audio</h2>
+<table>
+<tr><th>Level</th><td>{{db .DecibelLevel}}{{if .Clipping}} ✂️ clipping{{end}}{{if .Limiting}} 🧱 limiting{{end}}{{if .Buffering}} ⏳ buffering{{end}}{{if .Muted}} 🔇 muted{{end}}{{if .PTT}}{{if .Transmitting}} 🎙️ transmitting{{else}} ⏸️ push-to-talk{{end}}{{end}}</td></tr>
+<tr><th>Frames</th><td>{{.FramesProcessed}} processed, {{.DroppedFrames}} dropped</td></tr>
+<tr><th>Latency</th><td>{{ms .Latency}}{{if .EndToEndLatency}}, {{ms .EndToEndLatency}} end-to-end{{end}}</td></tr>
+<tr><th>Buffer</th><td>{{percent .BufferUsage}}</td></tr>
+{{if .SNR}}<tr><th>SNR</th><td>{{printf "%.0f dB" .SNR}}</td></tr>{{end}}
+{{if .ClockDriftPPM}}<tr><th>Clock drift</th><td>{{printf "%+.0f ppm" .ClockDriftPPM}}</td></tr>{{end}}
+<tr><th>Underruns / overruns / write errors</th><td>{{.Underruns}} / {{.Overruns}} / {{.WriteErrors}}</td></tr>
+</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// configResponse 是 /config 返回的 JSON；未连接时 config 为 null
+type configResponse struct {
+	Mode      string            `json:"mode"`
+	Connected bool              `json:"connected"`
+	Clients   []string          `json:"clients,omitempty"`
+	Config    *NegotiatedConfig `json:"config"`
+}
+
+// statusHandler 渲染状态页（/）与协商配置（/config）；每次请求读取一次最新统计
+func statusHandler(source StatusSource, logger *utils.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/config" {
+			status := source.Status()
+			w.Header().Set("Content-Type", "application/json")
+			response := configResponse{Mode: status.Mode, Connected: status.Connected, Clients: status.Clients, Config: status.Config}
+			if err := json.NewEncoder(w).Encode(response); err != nil {
+				logger.WarnRateLimited("status-page", fmt.Sprintf("Failed to write /config: %v", err))
+			}
+			return
+		}
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		data := struct {
+			Refresh int
+			Status  *Status
+		}{statusRefreshSeconds, source.Status()}
+		if err := statusTemplate.Execute(w, data); err != nil {
+			logger.WarnRateLimited("status-page", fmt.Sprintf("Failed to render status page: %v", err))
+		}
+	})
+}
+
+// ServeStatusPage serves the status page on addr until ctx is cancelled. It returns once
+// the address is bound, so an unusable address fails at startup.
+func ServeStatusPage(ctx context.Context, addr string, source StatusSource, logger *utils.Logger) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return utils.WrapError(err, utils.ErrNetwork, "failed to listen on status address")
+	}
+	server := &http.Server{Handler: statusHandler(source, logger), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Warnf("Status page stopped: %v", err)
+		}
+	}()
+	logger.Infof("📋 Status page on http://%s/", listener.Addr())
+	return nil
+}
@@ -0,0 +1,72 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"RemoteAudioCLI/utils"
+)
+
+type fixedStatus Status
+
+func (f *fixedStatus) Status() *Status {
+	status := Status(*f)
+	return &status
+}
+
+func TestStatusPage(t *testing.T) {
+	source := &fixedStatus{
+		Mode:      "server",
+		Address:   "127.0.0.1:8080",
+		Connected: true,
+		Clients:   []string{"192.168.1.20:51234"},
+		Format:    "48000Hz, 2ch, 16-bit int, opus",
+		Config: negotiatedConfig(&HandshakeConfig{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960,
+			BufferCount: 4, Compression: uint8(utils.CodecOpus), Flags: HandshakeFlagSampleIndex | HandshakeFlagControlChannel}, false),
+		Network: &utils.NetworkStats{PacketsReceived: 99, PacketsLost: 1, Total: &utils.NetworkStats{}},
+		Audio:   &utils.AudioStats{DecibelLevel: -12.5, Limiting: true},
+	}
+	handler := statusHandler(source, utils.NewLoggerWithLevel(utils.LogLevelError))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != 200 {
+		t.Fatalf("status %d", recorder.Code)
+	}
+	page := recorder.Body.String()
+	for _, want := range []string{`http-equiv="refresh"`, "192.168.1.20:51234", "48000Hz, 2ch", "1.0% lost", "-12.5 dB", "limiting", "960 frames × 4", "control channel off, sample index on"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/config", nil))
+	if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("/config content type %q", got)
+	}
+	var response configResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("/config is not JSON: %v", err)
+	}
+	if config := response.Config; config == nil || config.SampleRate != 48000 || config.FramesPerBuffer != 960 || config.Codec != "Opus" ||
+		!config.SampleIndex || config.ControlChannel || len(response.Clients) != 1 {
+		t.Fatalf("/config returned %+v", response)
+	}
+
+	// 未连接时 config 为 null
+	handler = statusHandler(&fixedStatus{Mode: "client"}, utils.NewLoggerWithLevel(utils.LogLevelError))
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/config", nil))
+	if !strings.Contains(recorder.Body.String(), `"config":null`) {
+		t.Fatalf("/config while waiting: %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/other", nil))
+	if recorder.Code != 404 {
+		t.Fatalf("unknown path: status %d, want 404", recorder.Code)
+	}
+}
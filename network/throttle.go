@@ -0,0 +1,92 @@
+// network/throttle.go - 客户端发送带宽上限（令牌桶）
+
+package network
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+// throttleBurst 令牌桶容量对应的时长：允许短时突发，长期平均速率不超过上限
+const throttleBurst = 250 * time.Millisecond
+
+// tokenBucket limits the average send rate in bytes per second while allowing short bursts
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64 // bucket capacity in bytes
+	tokens float64 // 可为负：已发出但尚未"偿还"的字节
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket for the given rate in bits per second
+func newTokenBucket(bitsPerSecond int, now time.Time) *tokenBucket {
+	rate := float64(bitsPerSecond) / 8
+	burst := rate * throttleBurst.Seconds()
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// reserve takes n bytes from the bucket. It returns how long the caller should wait
+// before sending; if that would exceed maxDelay nothing is taken and ok is false
+func (b *tokenBucket) reserve(n int, now time.Time, maxDelay time.Duration) (wait time.Duration, ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < float64(n) {
+		wait = time.Duration((float64(n) - b.tokens) / b.rate * float64(time.Second))
+		if wait > maxDelay {
+			return wait, false
+		}
+	}
+	b.tokens -= float64(n)
+	return wait, true
+}
+
+// streamBitrate returns the bits per second PCM streaming needs for config, packet headers included
+func streamBitrate(config *utils.Config) int {
+	packetsPerSecond := float64(config.SampleRate) / float64(config.FramesPerBuffer)
+	payloadBits := config.SampleRate * config.Channels * config.BitDepth
+	return payloadBits + int(packetsPerSecond*HeaderSize*8)
+}
+
+// opusTargetBitrate returns the Opus encoder bitrate that keeps audio packets
+// (headers included) under config.MaxBitrate, or 0 when no cap is set
+func opusTargetBitrate(config *utils.Config) int {
+	if config.MaxBitrate <= 0 {
+		return 0
+	}
+	packetsPerSecond := float64(config.SampleRate) / float64(config.FramesPerBuffer)
+	return config.MaxBitrate - int(packetsPerSecond*HeaderSize*8)
+}
+
+// CheckBitrate verifies that the configured stream fits under config.MaxBitrate.
+// Only uncompressed PCM has a fixed rate; Opus is encoded at the cap and FLAC is smoothed by the send throttle
+func CheckBitrate(config *utils.Config) error {
+	if config.MaxBitrate <= 0 {
+		return nil
+	}
+	switch config.Compression {
+	case utils.CodecPCM:
+		if needed := streamBitrate(config); needed > config.MaxBitrate {
+			return utils.NewAppError(utils.ErrInvalidConfig, fmt.Sprintf("PCM at %dHz/%dch/%dbit needs %d kbit/s, above the %d kbit/s cap (use a lower -quality or -codec opus)",
+				config.SampleRate, config.Channels, config.BitDepth, needed/1000, config.MaxBitrate/1000))
+		}
+	case utils.CodecOpus:
+		if opusTargetBitrate(config) < opusMinBitrate*len(opusStreamChannels(config.Channels)) {
+			return utils.NewAppError(utils.ErrInvalidConfig, fmt.Sprintf("%d kbit/s is too low for Opus with %d channels at %d frames per buffer",
+				config.MaxBitrate/1000, config.Channels, config.FramesPerBuffer))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"RemoteAudioCLI/utils"
+)
+
+func TestTokenBucketKeepsAverageUnderRate(t *testing.T) {
+	start := time.Unix(0, 0)
+	bucket := newTokenBucket(64000, start) // 8000 bytes/s, 2000 byte burst
+
+	// 突发在容量内不需要等待
+	if wait, ok := bucket.reserve(2000, start, 20*time.Millisecond); !ok || wait != 0 {
+		t.Fatalf("burst within capacity: wait=%v ok=%v", wait, ok)
+	}
+
+	// 桶已空：800 字节需要等待 100ms，超过 maxDelay 时不消耗令牌
+	if _, ok := bucket.reserve(800, start, 20*time.Millisecond); ok {
+		t.Fatalf("expected packet to be dropped when the wait exceeds maxDelay")
+	}
+	wait, ok := bucket.reserve(800, start, time.Second)
+	if !ok || wait != 100*time.Millisecond {
+		t.Fatalf("expected 100ms wait, got wait=%v ok=%v", wait, ok)
+	}
+
+	// 1 秒内按 20ms 一包持续发送 200 字节（10000 bytes/s）时，被接受的量不应超过速率加容量
+	sent := 0
+	for i := 1; i <= 50; i++ {
+		now := start.Add(time.Duration(i) * 20 * time.Millisecond)
+		if _, ok := bucket.reserve(200, now, 20*time.Millisecond); ok {
+			sent += 200
+		}
+	}
+	if sent > 8000+2000 {
+		t.Fatalf("sent %d bytes in one second, cap is 8000 bytes/s", sent)
+	}
+}
+
+func TestCheckBitrate(t *testing.T) {
+	high := utils.Config{SampleRate: 48000, Channels: 2, BitDepth: 16, FramesPerBuffer: 960, Compression: utils.CodecPCM}
+
+	high.MaxBitrate = 1000000
+	if err := CheckBitrate(&high); err == nil {
+		t.Fatalf("expected 48kHz stereo PCM to exceed 1000 kbit/s")
+	}
+	high.MaxBitrate = 1600000
+	if err := CheckBitrate(&high); err != nil {
+		t.Fatalf("48kHz stereo PCM should fit 1600 kbit/s: %v", err)
+	}
+
+	high.Compression = utils.CodecOpus
+	high.MaxBitrate = 64000
+	if err := CheckBitrate(&high); err != nil {
+		t.Fatalf("Opus should fit 64 kbit/s: %v", err)
+	}
+	high.MaxBitrate = 10000
+	if err := CheckBitrate(&high); err == nil {
+		t.Fatalf("expected 10 kbit/s to be too low for Opus with 50 packets/s")
+	}
+}
@@ -0,0 +1,113 @@
+// utils/client_lists.go - 客户端白名单/黑名单条目（IP、CIDR 或主机名）的校验与 -allow-client-file / -deny-client-file 读取
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// ValidateClientEntry checks that an allow or deny list entry is an IP address, a CIDR range or a host name
+func ValidateClientEntry(entry string) error {
+	if net.ParseIP(entry) != nil {
+		return nil
+	}
+	if strings.Contains(entry, "/") {
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			return fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		return nil
+	}
+	if !isHostName(entry) {
+		return fmt.Errorf("%q is not an IP address, CIDR range or host name", entry)
+	}
+	return nil
+}
+
+// isHostName 检查主机名语法：以点分隔的字母、数字与连字符标签，标签不以连字符开头或结尾；
+// 最后一个标签不能全是数字，这样写错的 IP（如 300.1.1.1）不会被当成主机名
+func isHostName(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// LoadClientListFile reads a client list with one IP, CIDR range or host name per line.
+// Blank lines and text after # are ignored; errors name the offending line.
+func LoadClientListFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, WrapError(err, ErrInvalidConfig, "failed to open client list file")
+	}
+	defer file.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := scanner.Text()
+		if i := strings.IndexByte(entry, '#'); i >= 0 {
+			entry = entry[:i]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := ValidateClientEntry(entry); err != nil {
+			return nil, NewAppError(ErrInvalidConfig, fmt.Sprintf("%s line %d: %v", path, line, err))
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, WrapError(err, ErrInvalidConfig, fmt.Sprintf("failed to read %s", path))
+	}
+	return entries, nil
+}
+
+// AllowedClients returns AllowClients followed by the entries of AllowClientsFile, which is
+// read again on every call so edits take effect on the next reload. An allow file without
+// entries is an error, since an empty list would allow every client.
+func (c *Config) AllowedClients() ([]string, error) {
+	if c.AllowClientsFile == "" {
+		return c.AllowClients, nil
+	}
+	entries, err := LoadClientListFile(c.AllowClientsFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, NewAppError(ErrInvalidConfig, fmt.Sprintf("%s contains no allowed clients", c.AllowClientsFile))
+	}
+	return append(append([]string(nil), c.AllowClients...), entries...), nil
+}
+
+// DeniedClients returns DenyClients followed by the entries of DenyClientsFile, read again
+// on every call like AllowedClients; an empty deny file is fine
+func (c *Config) DeniedClients() ([]string, error) {
+	if c.DenyClientsFile == "" {
+		return c.DenyClients, nil
+	}
+	entries, err := LoadClientListFile(c.DenyClientsFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]string(nil), c.DenyClients...), entries...), nil
+}
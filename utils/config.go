@@ -4,9 +4,131 @@ package utils
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// CodecType identifies the audio codec; the value is sent as HandshakeConfig.Compression.
+// New codecs (e.g. AAC) get the next free value so older peers reject them in Validate.
+type CodecType uint8
+
+const (
+	CodecPCM  CodecType = 0
+	CodecOpus CodecType = 1
+	CodecFLAC CodecType = 2
+)
+
+// MaxCodecType is the highest codec value this version understands
+const MaxCodecType = CodecFLAC
+
+// String returns the display name of the codec
+func (c CodecType) String() string {
+	switch c {
+	case CodecPCM:
+		return "PCM"
+	case CodecOpus:
+		return "Opus"
+	case CodecFLAC:
+		return "FLAC"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(c))
+	}
+}
+
+// SampleFormat is the PCM sample encoding used on the wire and for the PortAudio streams
+type SampleFormat uint8
+
+const (
+	SampleFormatInt     SampleFormat = 0 // 有符号整数，宽度由 BitDepth 决定
+	SampleFormatFloat32 SampleFormat = 1 // 32 位 IEEE 浮点（-1.0 到 1.0），BitDepth 固定为 32
+)
+
+// MaxSampleFormat is the highest sample format value this version understands
+const MaxSampleFormat = SampleFormatFloat32
+
+// String returns the display name of the sample format
+func (f SampleFormat) String() string {
+	switch f {
+	case SampleFormatInt:
+		return "int"
+	case SampleFormatFloat32:
+		return "float32"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(f))
+	}
+}
+
+// ParseSampleFormat parses "int" or "float32"
+func ParseSampleFormat(format string) (SampleFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "int":
+		return SampleFormatInt, nil
+	case "float32", "float":
+		return SampleFormatFloat32, nil
+	default:
+		return SampleFormatInt, NewAppError(ErrInvalidConfig, fmt.Sprintf("invalid sample format %q (must be int or float32)", format))
+	}
+}
+
+// ConcealMode selects how the server fills playback gaps (buffer underruns)
+type ConcealMode uint8
+
+const (
+	ConcealSilence ConcealMode = iota // 数字静音
+	ConcealRepeat                     // 重复最后一帧完好的音频并渐弱（最多 ConcealFrames 帧），之后为静音
+	ConcealComfort                    // 与噪声底匹配的舒适噪声
+)
+
+// String returns the -conceal name of the mode
+func (m ConcealMode) String() string {
+	switch m {
+	case ConcealSilence:
+		return "silence"
+	case ConcealRepeat:
+		return "repeat"
+	case ConcealComfort:
+		return "comfort"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(m))
+	}
+}
+
+// ParseConcealMode parses "silence", "repeat" or "comfort"
+func ParseConcealMode(mode string) (ConcealMode, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "silence":
+		return ConcealSilence, nil
+	case "repeat":
+		return ConcealRepeat, nil
+	case "comfort":
+		return ConcealComfort, nil
+	default:
+		return ConcealSilence, NewAppError(ErrInvalidConfig, fmt.Sprintf("invalid conceal mode %q (must be repeat, silence or comfort)", mode))
+	}
+}
+
+// ParseChannelMap parses a comma-separated list of 1-based device channels such as "3,4"
+func ParseChannelMap(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var channelMap []int
+	seen := make(map[int]bool)
+	for _, field := range strings.Split(s, ",") {
+		ch, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || ch < 1 {
+			return nil, NewAppError(ErrInvalidConfig, fmt.Sprintf("invalid channel %q in channel map %q (must be 1 or more)", field, s))
+		}
+		if seen[ch] {
+			return nil, NewAppError(ErrInvalidConfig, fmt.Sprintf("channel %d appears twice in channel map %q", ch, s))
+		}
+		seen[ch] = true
+		channelMap = append(channelMap, ch)
+	}
+	return channelMap, nil
+}
+
 // Config holds the application configuration
 type Config struct {
 	// Operating mode: "server" or "client"
@@ -14,22 +136,38 @@ type Config struct {
 
 	// Network settings
 	Host string
+	// Server: bind to the address of this network interface instead of Host (e.g. "eth0")
+	Interface string
 	Port int
-	AllowClients []string // 允许的客户端IP白名单
+	// Server: file the actually bound port is written to after listening (useful with Port 0)
+	PortFile string
+	AllowClients []string // 允许的客户端白名单（IP、CIDR 或主机名）
+	AllowClientsFile string // 每行一个白名单条目的文件，启动和 SIGHUP 时读取，条目追加在 AllowClients 之后
+	DenyClients  []string // 拒绝的客户端黑名单，格式同 AllowClients，优先于白名单
+	DenyClientsFile string // 黑名单文件，格式与读取时机同 AllowClientsFile
+	Volume       int      // 服务端播放音量（百分比，运行时 v 命令可调）
+	LogLevel     string   // debug、info、warn 或 error
+	PSK          string `json:"-"` // 预共享口令，非空时加密音频负载；不写入 -resume 的设置文件
 
 	// Audio device settings (string identifiers)
 	InputDevice  string
 	OutputDevice string
 
+	// 1-based device channels the stream channels are played on (server) or captured from
+	// (client), in stream channel order; empty uses the first channels in order
+	OutputChannelMap []int
+	InputChannelMap  []int
+
 	// Audio device objects (使用 interface{} 避免循环导入)
-	SelectedInputDevice  interface{}
-	SelectedOutputDevice interface{}
+	SelectedInputDevice  interface{} `json:"-"`
+	SelectedOutputDevice interface{} `json:"-"`
 
 	// Audio parameters
 	SampleRate    int
 	FramesPerBuffer int
 	Channels      int
 	BitDepth      int
+	SampleFormat  SampleFormat
 
 	// Network buffer settings
 	BufferSize    int
@@ -38,13 +176,20 @@ type Config struct {
 	ReadTimeout   time.Duration
 	WriteTimeout  time.Duration
 
+	// Client: extra connection/handshake attempts while the server refuses or does not answer (0 = single attempt)
+	ConnectRetries int
+	// Client: ask for a second connection that carries heartbeats so they are not queued behind audio
+	ControlChannel bool
+	// Client: prefix audio packets with a cumulative sample index for sample-accurate timing on the server
+	SampleIndex bool
+
 	// Keepalive settings
 	HeartbeatInterval time.Duration
 	HeartbeatTimeout  time.Duration
 	KeepaliveTimeout  time.Duration
 
 	// Quality settings
-	Compression   bool
+	Compression   CodecType
 	NoiseReduction bool
 
 	// Stream quality: "low", "normal", "high", "lossless"
@@ -55,6 +200,130 @@ type Config struct {
 	ExcitationThreshold float64
 	// Excitation timeout in seconds (e.g. 10)
 	ExcitationTimeout int
+
+	// Clock drift compensation: minimum interval between frame drop/repeat corrections (0 = disabled)
+	DriftCorrectionInterval time.Duration
+	// Target playback buffer usage (0.0 - 1.0) the drift compensation steers towards
+	DriftTargetBuffer float64
+
+	// Run duration after which the stream stops gracefully (0 = run until interrupted)
+	Duration time.Duration
+
+	// Server: soft limiter on device output; LimiterRelease is its gain recovery time after a peak
+	Limiter        bool
+	LimiterRelease time.Duration
+
+	// Raw PCM pipe instead of an audio device ("-" = stdin/stdout, otherwise a named pipe path)
+	InputPipe  string
+	OutputPipe string
+	// Raw PCM pipes carry big-endian samples; the wire format stays little-endian
+	PipeBigEndian bool
+
+	// Client: Ogg Opus file whose packets are sent as they are, without capture or re-encoding
+	InputFile string
+
+	// Client: capture what the selected output device plays (WASAPI loopback, Windows only)
+	LoopbackCapture bool
+
+	// Client: downmix the captured channels to mono before encoding; the device keeps its channel count
+	SendMono bool
+
+	// Client: start with audio sending muted (toggle with 'm')
+	StartMuted bool
+
+	// Client: push-to-talk, audio is only sent after PTTKey is typed and until it is typed again
+	PTT    bool
+	PTTKey string
+
+	// Client: fixed input trim in dB applied before metering and encoding (0 = unchanged)
+	InputGainDB float64
+
+	// Client: high-pass / low-pass filter cutoffs in Hz applied to captured audio (0 = disabled)
+	HighPassHz float64
+	LowPassHz  float64
+
+	// Client: automatic gain control toward a target RMS level (dBFS)
+	EnableAGC   bool
+	AGCTargetDB float64
+
+	// Server: which notification sounds play; the device change beep is not affected
+	ConnectSound    bool
+	DisconnectSound bool
+	StartupSound    bool
+
+	// Server: how long a new client must stay connected before the connection sound plays;
+	// playback fades in only after this delay and the sound have finished
+	ConnectSoundDelay time.Duration
+
+	// Server: how buffer underruns are filled instead of digital silence; with ConcealRepeat the
+	// last good frame is repeated, fading out over ConcealFrames frames
+	Conceal       ConcealMode
+	ConcealFrames int
+
+	// Server: stereo balance from -1.0 (full left) to +1.0 (full right); ignored for other channel counts
+	Balance float64
+
+	// Server: stereo width via mid/side processing: 0 collapses to mono, 1 leaves the image unchanged,
+	// above 1 widens it; ignored for other channel counts
+	StereoWidth float64
+
+	// Server: attempts to reopen playback on the default output device after the current one disappears (0 disables)
+	DeviceReopenAttempts int
+
+	// Server: playback fade-in/fade-out duration when a session starts or ends (0 disables)
+	FadeDuration time.Duration
+
+	// Server: on a clean shutdown, keep playing the buffered audio for up to this long, fading out at its end, before closing the output (0 fades out immediately)
+	DrainTimeout time.Duration
+
+	// Server: ceiling on buffered playback audio; older frames are dropped to catch up (0 = unlimited)
+	MaxLatency time.Duration
+
+	// Server: buffered frames needed before playback starts, and again after an underrun (0 starts at once)
+	PrefillFrames int
+
+	// Server: pause writing to the output device after this much continuous silence, resuming with a fade-in (0 disables)
+	ServerSilenceSuppress time.Duration
+
+	// 非空时在此地址提供自动刷新的 HTML 状态页（-status-addr），两种模式都可用
+	StatusAddr string
+
+	// Server: end a client session after it has lasted this long, sending a goodbye first (0 disables)
+	MaxSession time.Duration
+
+	// Server: with -psk, how many recent audio packet sequence numbers are remembered to reject replayed packets
+	ReplayWindow int
+
+	// Server: end a client session when no audio packet (heartbeats do not count) arrives for this long (0 disables)
+	IdleTimeout time.Duration
+
+	// Server: open the output stream at startup with the configured format, reopening it only when a handshake negotiates a different one
+	PreopenOutput bool
+
+	// Server: handshake policy; clients asking for more are coerced down or rejected (0 / empty = no restriction)
+	MinSampleRate int
+	MaxSampleRate int
+	MaxChannels   int
+	MaxBitDepth   int
+	AllowedCodecs []CodecType
+
+	// Client: capture device buffer size in sample frames; captured audio is repacketized into FramesPerBuffer-frame packets (0 = same as FramesPerBuffer)
+	DeviceBuffer int
+
+	// Client: cap on the bandwidth used by audio packets in bits per second, headers included (0 = unlimited)
+	MaxBitrate int
+
+	// Client: network simulation on the audio send path for testing: the probability of dropping
+	// or reordering each audio packet (0.0 to 1.0) and the maximum random delay per packet
+	SimulateLoss    float64
+	SimulateJitter  time.Duration
+	SimulateReorder float64
+
+	// Server: upper bound for audio packet payloads in bytes (further limited by the negotiated format)
+	MaxAudioPayloadSize int
+
+	// Fraction of samples at full scale within one second that counts as clipping (e.g. 0.001 = 0.1%)
+	ClipFraction float64
 }
 
 // NewDefaultConfig creates a new configuration with default values
@@ -76,15 +345,33 @@ func NewDefaultConfig() *Config {
 		ConnTimeout:     10 * time.Second,
 		ReadTimeout:     15 * time.Second,  // 增加到15秒，给心跳包更多时间
 		WriteTimeout:    5 * time.Second,
+		ConnectRetries:  3,
+		ReplayWindow:    64,
 		HeartbeatInterval: 5 * time.Second,  // 心跳包发送间隔
 		HeartbeatTimeout:  10 * time.Second, // 心跳包超时时间
 		KeepaliveTimeout:  30 * time.Second, // 连接保活超时时间
-		Compression:     false,
+		Compression:     CodecPCM,
 		NoiseReduction:  false,
 		StreamQuality:   "normal",
 		EnableExcitation: false,
 		ExcitationThreshold: -45.0,
 		ExcitationTimeout: 10,
+		DriftCorrectionInterval: 10 * time.Second, // 最多每10秒补偿一帧，保证不易察觉
+		DriftTargetBuffer:       0.5,
+		ClipFraction:            0.001, // 每秒超过 0.1% 的采样满幅即视为削波
+		AGCTargetDB:             -20.0,
+		MaxAudioPayloadSize:     32768,
+		FadeDuration:            500 * time.Millisecond,
+		ConnectSound:            true,
+		DisconnectSound:         true,
+		StartupSound:            true,
+		ConnectSoundDelay:       500 * time.Millisecond,
+		LimiterRelease:          100 * time.Millisecond,
+		ConcealFrames:           3,
+		StereoWidth:             1.0,
+		Volume:                  100,
+		LogLevel:                "info",
+		DeviceReopenAttempts:    5,
 	}
 }
 
@@ -114,14 +401,64 @@ func (c *Config) Validate() error {
 		return NewAppError(ErrInvalidConfig, "bit depth must be 16, 24, or 32")
 	}
 
+	if c.SampleFormat == SampleFormatFloat32 && (c.BitDepth != 32 || c.Compression != CodecPCM) {
+		return NewAppError(ErrInvalidConfig, "float32 samples require a bit depth of 32 and the PCM codec")
+	}
+
+	return c.ValidateKeepalive()
+}
+
+// ValidateKeepalive checks that heartbeat interval < heartbeat timeout < keepalive timeout
+func (c *Config) ValidateKeepalive() error {
+	if c.HeartbeatInterval <= 0 {
+		return NewAppError(ErrInvalidConfig, "heartbeat interval must be positive")
+	}
+
+	if c.HeartbeatTimeout <= c.HeartbeatInterval {
+		return NewAppError(ErrInvalidConfig, "heartbeat timeout must be greater than heartbeat interval")
+	}
+
+	if c.KeepaliveTimeout <= c.HeartbeatTimeout {
+		return NewAppError(ErrInvalidConfig, "keepalive timeout must be greater than heartbeat timeout")
+	}
+
 	return nil
 }
 
+// PacketReadTimeout returns how long a session read waits for the next packet: ReadTimeout,
+// but never less than HeartbeatTimeout, so a connection that only carries heartbeats (audio
+// paused by excitation, mute or push-to-talk) does not time out between two heartbeats
+func (c *Config) PacketReadTimeout() time.Duration {
+	if c.ReadTimeout < c.HeartbeatTimeout {
+		return c.HeartbeatTimeout
+	}
+	return c.ReadTimeout
+}
+
 // GetFrameSize returns the size of one audio frame in bytes
 func (c *Config) GetFrameSize() int {
 	return c.Channels * (c.BitDepth / 8)
 }
 
+// GetFrameDuration returns the duration of one buffer of FramesPerBuffer sample frames
+func (c *Config) GetFrameDuration() time.Duration {
+	if c.SampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(c.FramesPerBuffer) * time.Second / time.Duration(c.SampleRate)
+}
+
+// LatencySummary describes the theoretical one-way latency added by this end of the stream:
+// BufferCount buffers of FramesPerBuffer frames plus the audio device's latency. Network
+// transit and the other end's device are not included.
+func (c *Config) LatencySummary(deviceLatency time.Duration) string {
+	frame := c.GetFrameDuration()
+	buffering := frame * time.Duration(c.BufferCount)
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return fmt.Sprintf("%.1fms frames (%d @ %dHz) × %d buffers = %.1fms + %.1fms device ≈ %.1fms one-way (this end only, excluding network)",
+		ms(frame), c.FramesPerBuffer, c.SampleRate, c.BufferCount, ms(buffering), ms(deviceLatency), ms(buffering+deviceLatency))
+}
+
 // GetBufferSizeInFrames returns the buffer size in audio frames
 func (c *Config) GetBufferSizeInFrames() int {
 	return c.BufferSize / c.GetFrameSize()
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -114,6 +115,50 @@ func GetErrorType(err error) ErrorType {
 	return ErrUnknown
 }
 
+// Process exit codes for the failure classes, so scripts and supervisors can react differently
+const (
+	ExitCodeError    = 1 // 其他错误
+	ExitCodeDevice   = 2 // 音频设备、采集或播放
+	ExitCodeNetwork  = 3 // 网络、连接或超时
+	ExitCodeConfig   = 4 // 无效的配置或参数
+	ExitCodeProtocol = 5 // 协议或握手
+)
+
+// ExitCode returns the process exit code for errors of this type
+func (e ErrorType) ExitCode() int {
+	switch e {
+	case ErrAudioDevice, ErrAudioCapture, ErrAudioPlayback:
+		return ExitCodeDevice
+	case ErrNetwork, ErrConnection, ErrTimeout:
+		return ExitCodeNetwork
+	case ErrInvalidConfig:
+		return ExitCodeConfig
+	case ErrProtocol:
+		return ExitCodeProtocol
+	default:
+		return ExitCodeError
+	}
+}
+
+// ExitCodeForError maps err to a process exit code: 0 for nil, otherwise the code of the
+// first AppError in its chain that has a type, or ExitCodeError when there is none
+func ExitCodeForError(err error) int {
+	if err == nil {
+		return 0
+	}
+	var appErr *AppError
+	for errors.As(err, &appErr) {
+		if appErr.Type != ErrUnknown {
+			return appErr.Type.ExitCode()
+		}
+		if appErr.Cause == nil {
+			break
+		}
+		err = appErr.Cause
+	}
+	return ExitCodeError
+}
+
 // Common error constructors for convenience
 
 // ErrInvalidConfigf creates a formatted invalid configuration error
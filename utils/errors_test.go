@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCodeForError(t *testing.T) {
+	device := NewAppError(ErrAudioDevice, "no input devices")
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, 0},
+		{"plain error", errors.New("boom"), ExitCodeError},
+		{"typed", NewAppError(ErrInvalidConfig, "bad flag"), ExitCodeConfig},
+		{"wrapped chain uses the outermost type", WrapError(WrapError(device, ErrTimeout, "connect"), ErrProtocol, "handshake"), ExitCodeProtocol},
+		{"untyped outer error falls through to the cause", NewAppErrorWithCause(ErrUnknown, "client failed", device), ExitCodeDevice},
+		{"behind fmt wrapping", fmt.Errorf("client failed: %w", WrapError(errors.New("refused"), ErrConnection, "dial")), ExitCodeNetwork},
+		{"untyped without a typed cause", NewAppErrorWithCause(ErrUnknown, "client failed", errors.New("boom")), ExitCodeError},
+	}
+	for _, tt := range tests {
+		if got := ExitCodeForError(tt.err); got != tt.want {
+			t.Errorf("%s: ExitCodeForError(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestErrorTypeExitCode(t *testing.T) {
+	tests := []struct {
+		errType ErrorType
+		want    int
+	}{
+		{ErrUnknown, ExitCodeError},
+		{ErrBuffer, ExitCodeError},
+		{ErrAudioDevice, ExitCodeDevice},
+		{ErrAudioCapture, ExitCodeDevice},
+		{ErrAudioPlayback, ExitCodeDevice},
+		{ErrNetwork, ExitCodeNetwork},
+		{ErrConnection, ExitCodeNetwork},
+		{ErrTimeout, ExitCodeNetwork},
+		{ErrInvalidConfig, ExitCodeConfig},
+		{ErrProtocol, ExitCodeProtocol},
+	}
+	for _, tt := range tests {
+		if got := tt.errType.ExitCode(); got != tt.want {
+			t.Errorf("%s.ExitCode() = %d, want %d", tt.errType, got, tt.want)
+		}
+	}
+}
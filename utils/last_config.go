@@ -0,0 +1,78 @@
+// utils/last_config.go - 保存/加载上次成功启动时的配置（-resume）
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LastConfig is the configuration saved after a successful start and reloaded with -resume.
+// Devices are stored by stable ID (host API and name) rather than index, because
+// indices change when devices are plugged in or removed.
+type LastConfig struct {
+	Config          *Config  `json:"config"`
+	InputDeviceID   string   `json:"input_device_id,omitempty"`
+	OutputDeviceIDs []string `json:"output_device_ids,omitempty"`
+
+	// 按设备 ID 记住的播放音量（百分比）与输入增益（dB），再次选中该设备时自动应用
+	OutputVolumes map[string]int     `json:"output_volumes,omitempty"`
+	InputGains    map[string]float64 `json:"input_gains,omitempty"`
+}
+
+// LastConfigPath returns ~/.config/remoteaudio/last.json
+func LastConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", WrapError(err, ErrInvalidConfig, "cannot determine home directory")
+	}
+	return filepath.Join(home, ".config", "remoteaudio", "last.json"), nil
+}
+
+// SaveLastConfig writes last to LastConfigPath, creating the directory if needed
+func SaveLastConfig(last *LastConfig) error {
+	path, err := LastConfigPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(last, "", "  ")
+	if err != nil {
+		return WrapError(err, ErrInvalidConfig, "failed to encode configuration")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return WrapError(err, ErrInvalidConfig, "failed to create configuration directory")
+	}
+	// 先写临时文件再重命名，避免中断时留下半个文件
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return WrapError(err, ErrInvalidConfig, "failed to write configuration")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return WrapError(err, ErrInvalidConfig, "failed to write configuration")
+	}
+	return nil
+}
+
+// LoadLastConfig reads the configuration saved by SaveLastConfig.
+// Fields missing from the file keep their NewDefaultConfig values.
+func LoadLastConfig() (*LastConfig, error) {
+	path, err := LastConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, WrapError(err, ErrInvalidConfig, fmt.Sprintf("no saved configuration at %s", path))
+	}
+	last := &LastConfig{Config: NewDefaultConfig()}
+	if err := json.Unmarshal(data, last); err != nil {
+		return nil, WrapError(err, ErrInvalidConfig, fmt.Sprintf("invalid saved configuration %s", path))
+	}
+	if last.Config == nil {
+		return nil, NewAppError(ErrInvalidConfig, fmt.Sprintf("saved configuration %s has no settings", path))
+	}
+	return last, nil
+}
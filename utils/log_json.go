@@ -0,0 +1,131 @@
+// utils/log_json.go - 结构化 JSON 日志输出（每行一个 JSON 对象，便于 Loki/ELK 采集）
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LogFormat selects how the logger renders messages and statistics
+type LogFormat int
+
+const (
+	LogFormatText LogFormat = iota // 彩色文本，统计信息一行刷新
+	LogFormatJSON                  // 每行一个 JSON 对象，统计信息为独立的 stats 事件
+)
+
+// ParseLogFormat parses "text" or "json"
+func ParseLogFormat(format string) (LogFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		return LogFormatText, nil
+	case "json":
+		return LogFormatJSON, nil
+	default:
+		return LogFormatText, NewAppError(ErrInvalidConfig, fmt.Sprintf("invalid log format %q (must be text or json)", format))
+	}
+}
+
+// logEntry 一条结构化日志；普通日志只有 time/level/msg，统计事件附带 network/audio
+type logEntry struct {
+	Time    string              `json:"time"`
+	Level   string              `json:"level"`
+	Msg     string              `json:"msg"`
+	Event   string              `json:"event,omitempty"`
+	Network *networkStatsFields `json:"network,omitempty"`
+	Audio   *audioStatsFields   `json:"audio,omitempty"`
+}
+
+// networkStatsFields NetworkStats 的 JSON 表示（时长统一为毫秒）
+type networkStatsFields struct {
+	BytesSent        int64               `json:"bytes_sent"`
+	BytesReceived    int64               `json:"bytes_received"`
+	RTTMs            float64             `json:"rtt_ms"`
+	Errors           int64               `json:"errors"`
+	PacketsReceived  int64               `json:"packets_received"`
+	PacketsLost      int64               `json:"packets_lost"`
+	PacketsReordered int64               `json:"packets_reordered"`
+	SamplesMissing   int64               `json:"samples_missing,omitempty"`
+	LossPercent      float64             `json:"loss_percent"`
+	Total            *networkStatsFields `json:"total,omitempty"`
+}
+
+// audioStatsFields AudioStats 的 JSON 表示（时长统一为毫秒）
+type audioStatsFields struct {
+	FramesProcessed   int64   `json:"frames_processed"`
+	DroppedFrames     int64   `json:"dropped_frames"`
+	Underruns         int64   `json:"underruns"`
+	Overruns          int64   `json:"overruns"`
+	WriteErrors       int64   `json:"write_errors"`
+	PlayoutSample     uint64  `json:"playout_sample,omitempty"`
+	LatencyMs         float64 `json:"latency_ms"`
+	EndToEndLatencyMs float64 `json:"e2e_latency_ms,omitempty"`
+	ClockDriftPPM     float64 `json:"clock_drift_ppm,omitempty"`
+	BufferUsage       float64 `json:"buffer_usage"`
+	DecibelLevel      float64 `json:"db"`
+	SNR               float64 `json:"snr_db,omitempty"`
+	Muted             bool    `json:"muted,omitempty"`
+	Clipping          bool    `json:"clipping,omitempty"`
+	Buffering         bool    `json:"buffering,omitempty"`
+}
+
+func newNetworkStatsFields(stats *NetworkStats) *networkStatsFields {
+	if stats == nil {
+		return nil
+	}
+	return &networkStatsFields{
+		BytesSent:        stats.BytesSent,
+		BytesReceived:    stats.BytesReceived,
+		RTTMs:            stats.RoundTripTime.Seconds() * 1000,
+		Errors:           stats.ErrorCount,
+		PacketsReceived:  stats.PacketsReceived,
+		PacketsLost:      stats.PacketsLost,
+		PacketsReordered: stats.PacketsReordered,
+		SamplesMissing:   stats.SamplesMissing,
+		LossPercent:      stats.LossPercent(),
+		Total:            newNetworkStatsFields(stats.Total),
+	}
+}
+
+func newAudioStatsFields(stats *AudioStats) *audioStatsFields {
+	if stats == nil {
+		return nil
+	}
+	return &audioStatsFields{
+		FramesProcessed:   stats.FramesProcessed,
+		DroppedFrames:     stats.DroppedFrames,
+		Underruns:         stats.Underruns,
+		Overruns:          stats.Overruns,
+		WriteErrors:       stats.WriteErrors,
+		PlayoutSample:     stats.PlayoutSample,
+		LatencyMs:         stats.Latency.Seconds() * 1000,
+		EndToEndLatencyMs: stats.EndToEndLatency.Seconds() * 1000,
+		ClockDriftPPM:     stats.ClockDriftPPM,
+		BufferUsage:       stats.BufferUsage,
+		DecibelLevel:      stats.DecibelLevel,
+		SNR:               stats.SNR,
+		Muted:             stats.Muted,
+		Clipping:          stats.Clipping,
+		Buffering:         stats.Buffering,
+	}
+}
+
+// writeJSON 将一条日志编码为单行 JSON（json.Encoder 每条记录只调用一次 Write，并发输出不会交错）
+func (l *Logger) writeJSON(level LogLevel, message string, networkStats *NetworkStats, audioStats *AudioStats) {
+	entry := logEntry{
+		Time:    time.Now().Format(time.RFC3339Nano),
+		Level:   level.String(),
+		Msg:     message,
+		Network: newNetworkStatsFields(networkStats),
+		Audio:   newAudioStatsFields(audioStats),
+	}
+	if networkStats != nil || audioStats != nil {
+		entry.Event = "stats"
+	}
+
+	if err := json.NewEncoder(l.out).Encode(entry); err != nil {
+		fmt.Fprintf(l.out, "{\"level\":\"ERROR\",\"msg\":%q}\n", fmt.Sprintf("failed to encode log entry: %v", err))
+	}
+}
@@ -3,8 +3,12 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -34,18 +38,63 @@ func (l LogLevel) String() string {
 	}
 }
 
+// ParseLogLevel parses "debug", "info", "warn" or "error" (case-insensitive)
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "", "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	default:
+		return LogLevelInfo, NewAppError(ErrInvalidConfig, fmt.Sprintf("invalid log level %q (must be debug, info, warn or error)", level))
+	}
+}
+
 // Logger provides structured logging functionality
 type Logger struct {
 	level           LogLevel
+	out             io.Writer
 	logger          *log.Logger
 	lastStatsOutput time.Time
 	statsMode       bool // 是否处于统计显示模式
+	noColor         bool // 禁用 ANSI 颜色输出
+	format          LogFormat
+	statsInterval   time.Duration // 统计行刷新 / JSON stats 事件的最小间隔（0 使用各模式的默认值）
+
+	// -waveform：统计行中用滚动的 ASCII 波形代替电平表（仅在 LogRealTimeStats 中访问）
+	waveform        bool
+	waveformPeak    float64   // 本次刷新间隔内见到的最大峰值
+	waveformHistory []float64 // 最近 waveformWidth 次刷新的峰值，最新的在末尾
+
+	// 限频日志：同一 key 在窗口内只输出一次，其余计数后附在下一次输出中
+	rateMutex   sync.Mutex
+	rateLimited map[string]*rateLimitState
 }
 
+// rateLimitState 记录某个 key 上次输出的时间和之后被抑制的次数
+type rateLimitState struct {
+	lastLogged time.Time
+	suppressed int
+}
+
+// RateLimitWindow is the window within which messages with the same key are collapsed
+const RateLimitWindow = 5 * time.Second
+
+// defaultJSONStatsInterval JSON 模式下默认的 stats 事件间隔
+const defaultJSONStatsInterval = 10 * time.Second
+
+// defaultTextStatsInterval 文本模式下统计行默认的刷新间隔（统计循环本身更频繁地采样）
+const defaultTextStatsInterval = 500 * time.Millisecond
+
 // NewLogger creates a new logger with INFO level
 func NewLogger() *Logger {
 	return &Logger{
 		level:  LogLevelInfo,
+		out:    os.Stdout,
 		logger: log.New(os.Stdout, "", 0),
 	}
 }
@@ -54,6 +103,7 @@ func NewLogger() *Logger {
 func NewLoggerWithLevel(level LogLevel) *Logger {
 	return &Logger{
 		level:  level,
+		out:    os.Stdout,
 		logger: log.New(os.Stdout, "", 0),
 	}
 }
@@ -63,6 +113,28 @@ func (l *Logger) SetLevel(level LogLevel) {
 	l.level = level
 }
 
+// SetOutput redirects all log and statistics output (e.g. to stderr when stdout carries audio)
+func (l *Logger) SetOutput(w io.Writer) {
+	l.out = w
+	l.logger.SetOutput(w)
+}
+
+// SetNoColor disables ANSI color codes in log and statistics output
+func (l *Logger) SetNoColor(noColor bool) {
+	l.noColor = noColor
+}
+
+// SetFormat selects text (default) or JSON output
+func (l *Logger) SetFormat(format LogFormat) {
+	l.format = format
+}
+
+// SetStatsInterval sets the minimum interval between refreshes of the live stats line, or
+// between stats events in JSON mode (0 uses the default of the format: 500ms or 10s)
+func (l *Logger) SetStatsInterval(interval time.Duration) {
+	l.statsInterval = interval
+}
+
 // GetLevel returns the current log level
 func (l *Logger) GetLevel() LogLevel {
 	return l.level
@@ -74,9 +146,14 @@ func (l *Logger) log(level LogLevel, message string) {
 		return
 	}
 
+	if l.format == LogFormatJSON {
+		l.writeJSON(level, message, nil, nil)
+		return
+	}
+
 	// 如果处于统计模式，需要换行再输出普通日志
 	if l.statsMode {
-		fmt.Print("\n")
+		fmt.Fprint(l.out, "\n")
 		l.statsMode = false
 	}
 
@@ -96,6 +173,9 @@ func (l *Logger) log(level LogLevel, message string) {
 		colorCode = "\033[31m" // Red
 	}
 	resetCode := "\033[0m"
+	if l.noColor {
+		colorCode, resetCode = "", ""
+	}
 
 	formattedMessage := fmt.Sprintf("%s[%s] %s%s %s",
 		colorCode, timestamp, levelStr, resetCode, message)
@@ -123,6 +203,49 @@ func (l *Logger) Error(message string) {
 	l.log(LogLevelError, message)
 }
 
+// ErrorRateLimited logs an error at most once per RateLimitWindow for the given key;
+// messages in between are counted and reported as "(repeated N times)" with the next one
+func (l *Logger) ErrorRateLimited(key string, message string) {
+	l.logRateLimited(LogLevelError, key, message)
+}
+
+// WarnRateLimited is the warning-level variant of ErrorRateLimited
+func (l *Logger) WarnRateLimited(key string, message string) {
+	l.logRateLimited(LogLevelWarn, key, message)
+}
+
+// logRateLimited 按 key 限频输出日志
+func (l *Logger) logRateLimited(level LogLevel, key string, message string) {
+	if level < l.level {
+		return
+	}
+
+	l.rateMutex.Lock()
+	if l.rateLimited == nil {
+		l.rateLimited = make(map[string]*rateLimitState)
+	}
+	state, ok := l.rateLimited[key]
+	if !ok {
+		state = &rateLimitState{}
+		l.rateLimited[key] = state
+	}
+	now := time.Now()
+	if !state.lastLogged.IsZero() && now.Sub(state.lastLogged) < RateLimitWindow {
+		state.suppressed++
+		l.rateMutex.Unlock()
+		return
+	}
+	suppressed := state.suppressed
+	state.lastLogged = now
+	state.suppressed = 0
+	l.rateMutex.Unlock()
+
+	if suppressed > 0 {
+		message = fmt.Sprintf("%s (repeated %d times)", message, suppressed)
+	}
+	l.log(level, message)
+}
+
 // Debugf logs a formatted debug message
 func (l *Logger) Debugf(format string, args ...interface{}) {
 	l.Debug(fmt.Sprintf(format, args...))
@@ -154,12 +277,75 @@ func (l *Logger) getLatencyIndicator(latencyMs float64) string {
 	}
 }
 
+// 电平表宽度及颜色阈值 (dB)
+const (
+	levelMeterWidth    = 10
+	levelMeterYellowDB = -12.0
+	levelMeterRedDB    = -3.0
+)
+
+// renderLevelMeter 将 -60dB..0dB 的电平渲染为固定宽度的电平表，如 [####------]
+func (l *Logger) renderLevelMeter(decibelLevel float64) string {
+	filled := int((decibelLevel+60.0)/60.0*levelMeterWidth + 0.5)
+	if filled < 0 {
+		filled = 0
+	} else if filled > levelMeterWidth {
+		filled = levelMeterWidth
+	}
+
+	bar := make([]byte, levelMeterWidth)
+	for i := range bar {
+		if i < filled {
+			bar[i] = '#'
+		} else {
+			bar[i] = '-'
+		}
+	}
+
+	if l.noColor || filled == 0 {
+		return "[" + string(bar) + "]"
+	}
+
+	return "[" + levelColor(decibelLevel) + string(bar[:filled]) + "\033[0m" + string(bar[filled:]) + "]"
+}
+
+// levelColor 电平对应的颜色：绿色正常，黄色偏响，红色接近削波
+func levelColor(decibelLevel float64) string {
+	if decibelLevel >= levelMeterRedDB {
+		return "\033[31m"
+	} else if decibelLevel >= levelMeterYellowDB {
+		return "\033[33m"
+	}
+	return "\033[32m"
+}
+
 // LogRealTimeStats 实时显示网络和音频统计信息（一行刷新）
 func (l *Logger) LogRealTimeStats(networkStats *NetworkStats, audioStats *AudioStats) {
 	if l.level > LogLevelInfo {
 		return
 	}
 
+	interval := l.statsInterval
+	if interval <= 0 {
+		interval = defaultTextStatsInterval
+		if l.format == LogFormatJSON {
+			interval = defaultJSONStatsInterval
+		}
+	}
+	if l.waveform {
+		l.waveformPeak = math.Max(l.waveformPeak, audioStats.PeakLevel)
+	}
+	if time.Since(l.lastStatsOutput) < interval {
+		return
+	}
+
+	// JSON 模式：不做一行刷新，按间隔输出独立的 stats 事件
+	if l.format == LogFormatJSON {
+		l.writeJSON(LogLevelInfo, "stats", networkStats, audioStats)
+		l.lastStatsOutput = time.Now()
+		return
+	}
+
 	// 计算延迟毫秒数
 	latencyMs := networkStats.RoundTripTime.Seconds() * 1000
 	latencyIndicator := l.getLatencyIndicator(latencyMs)
@@ -176,6 +362,13 @@ func (l *Logger) LogRealTimeStats(networkStats *NetworkStats, audioStats *AudioS
 		float64(networkStats.BytesReceived)/(1024*1024),
 		networkStats.ErrorCount)
 	
+	// 丢包统计 - 只有接收方（服务端）才有序列号信息
+	if networkStats.PacketsReceived > 0 {
+		networkInfo += fmt.Sprintf(" | 📉%.1f%% 🔀%d",
+			networkStats.LossPercent(),
+			networkStats.PacketsReordered)
+	}
+	
 	// 音频统计 - 如果分贝低于-59.9dB则显示为--dB
 	var decibelDisplay string
 	if audioStats.DecibelLevel < -59.9 {
@@ -184,12 +377,68 @@ func (l *Logger) LogRealTimeStats(networkStats *NetworkStats, audioStats *AudioS
 		decibelDisplay = fmt.Sprintf("%.1fdB", audioStats.DecibelLevel)
 	}
 	
-	audioInfo := fmt.Sprintf("📊 %s | 🎵%dk | ⚡%.1fms | ⏳%.1f%%",
+	meter := l.renderLevelMeter(audioStats.DecibelLevel)
+	if l.waveform {
+		// 输出不是终端（重定向到文件或管道）时保持电平表
+		if l.writesToTerminal() {
+			meter = l.renderWaveform(l.waveformPeak)
+		}
+		l.waveformPeak = 0
+	}
+	audioInfo := fmt.Sprintf("📊 %s %6s | 🎵%dk | ⚡%.1fms | ⏳%.1f%%",
+		meter,
 		decibelDisplay,
 		audioStats.FramesProcessed/1000,
 		audioStats.Latency.Seconds()*1000,
 		audioStats.BufferUsage*100)
 	
+	// 端到端延迟 - 只有收到带时间戳的音频时才有值
+	if audioStats.EndToEndLatency != 0 {
+		audioInfo += fmt.Sprintf(" | ⏱️%.0fms E2E", audioStats.EndToEndLatency.Seconds()*1000)
+	}
+	
+	// 时钟漂移估计
+	if audioStats.ClockDriftPPM != 0 {
+		audioInfo += fmt.Sprintf(" | 🕰️%+.0fppm", audioStats.ClockDriftPPM)
+	}
+	
+	// 欠载/溢出/写入错误分开显示，便于区分网络问题与设备问题
+	if audioStats.Underruns != 0 {
+		audioInfo += fmt.Sprintf(" | 🕳️%d under", audioStats.Underruns)
+	}
+	if audioStats.Overruns != 0 {
+		audioInfo += fmt.Sprintf(" | 🌊%d over", audioStats.Overruns)
+	}
+	if audioStats.WriteErrors != 0 {
+		audioInfo += fmt.Sprintf(" | 💥%d werr", audioStats.WriteErrors)
+	}
+	
+	// 信噪比估计，用于在开始传输前检查麦克风位置与增益
+	if audioStats.SNR != 0 {
+		audioInfo += fmt.Sprintf(" | 📶SNR %.0fdB", audioStats.SNR)
+	}
+	
+	if audioStats.Clipping {
+		audioInfo += " | ✂️CLIP"
+	}
+	if audioStats.Limiting {
+		audioInfo += " | 🧱LIMIT"
+	}
+	if audioStats.Buffering {
+		audioInfo += " | ⏳BUFFERING"
+	}
+	
+	if audioStats.Muted {
+		audioInfo += " | 🔇MUTED"
+	}
+	if audioStats.PTT {
+		if audioStats.Transmitting {
+			audioInfo += " | 🎙️TX"
+		} else {
+			audioInfo += " | ⏸️PTT"
+		}
+	}
+	
 	// 使用 \r 实现一行刷新
 	statsLine := fmt.Sprintf("\r[%s] %s | %s", timestamp, networkInfo, audioInfo)
 	
@@ -203,7 +452,7 @@ func (l *Logger) LogRealTimeStats(networkStats *NetworkStats, audioStats *AudioS
 		statsLine += string(padding)
 	}
 	
-	fmt.Print(statsLine)
+	fmt.Fprint(l.out, statsLine)
 	l.statsMode = true
 	l.lastStatsOutput = time.Now()
 }
@@ -214,18 +463,29 @@ func (l *Logger) LogAudioStats(stats *AudioStats) {
 		return
 	}
 	
+	if l.format == LogFormatJSON {
+		l.writeJSON(LogLevelInfo, "audio stats", nil, stats)
+		return
+	}
+	
 	// 如果处于统计模式，需要换行
 	if l.statsMode {
-		fmt.Print("\n")
+		fmt.Fprint(l.out, "\n")
 		l.statsMode = false
 	}
 	
-	l.Infof("📊 Audio Stats - Frames: %d, Dropped: %d, Latency: %.2fms, Buffer: %.1f%%, Volume: %.1fdB",
+	l.Infof("📊 Audio Stats - Frames: %d, Dropped: %d (underruns: %d, overruns: %d, write errors: %d), Latency: %.2fms, Buffer: %.1f%%, Volume: %.1fdB",
 		stats.FramesProcessed,
 		stats.DroppedFrames,
+		stats.Underruns,
+		stats.Overruns,
+		stats.WriteErrors,
 		stats.Latency.Seconds()*1000,
 		stats.BufferUsage*100,
 		stats.DecibelLevel)
+	if stats.PlayoutSample != 0 {
+		l.Infof("🔢 Playout position: sample %d", stats.PlayoutSample)
+	}
 }
 
 // LogNetworkStats logs network statistics (保留原有方法以兼容性)
@@ -234,30 +494,55 @@ func (l *Logger) LogNetworkStats(stats *NetworkStats) {
 		return
 	}
 	
+	if l.format == LogFormatJSON {
+		l.writeJSON(LogLevelInfo, "network stats", stats, nil)
+		return
+	}
+	
 	// 如果处于统计模式，需要换行
 	if l.statsMode {
-		fmt.Print("\n")
+		fmt.Fprint(l.out, "\n")
 		l.statsMode = false
 	}
 	
 	latencyMs := stats.RoundTripTime.Seconds() * 1000
 	latencyIndicator := l.getLatencyIndicator(latencyMs)
 	
-	l.Infof("🌐 Network Stats %s - Sent: %d KB, Received: %d KB, RTT: %.2fms, Errors: %d",
+	l.Infof("🌐 Network Stats %s - Sent: %d KB, Received: %d KB, RTT: %.2fms, Errors: %d, Lost: %d (%.1f%%), Reordered: %d",
 		latencyIndicator,
 		stats.BytesSent/1024,
 		stats.BytesReceived/1024,
 		latencyMs,
-		stats.ErrorCount)
+		stats.ErrorCount,
+		stats.PacketsLost,
+		stats.LossPercent(),
+		stats.PacketsReordered)
+	if stats.SamplesMissing != 0 {
+		l.Infof("🔢 Samples missing from sample-index gaps: %d", stats.SamplesMissing)
+	}
 }
 
 // AudioStats represents audio processing statistics
 type AudioStats struct {
 	FramesProcessed int64
-	DroppedFrames   int64
-	Latency         time.Duration
+	DroppedFrames   int64         // 所有原因丢弃或以静音代替的帧数合计
+	Underruns       int64         // 播放端到点没有数据、只能播放静音的次数
+	Overruns        int64         // 采集端输入溢出或播放端队列已满而丢弃的次数
+	WriteErrors     int64         // 写入音频流或输出管道失败的次数
+	PlayoutSample   uint64        // 服务端：已写入输出的发送端累计采样序号（-sample-index；0 表示未知）
+	Latency         time.Duration // 处理延迟；服务端收到带时间戳的音频时为交付与计划播放时间的偏差
+	EndToEndLatency time.Duration // 发送端采集到本地播放的延迟（依赖两端时钟同步）
+	ClockDriftPPM   float64       // 估计的两端采样时钟漂移 (ppm)
 	BufferUsage     float64
 	DecibelLevel    float64 // 新增：当前分贝级别
+	PeakLevel       float64 // 最近的峰值幅度 0.0 到 1.0（带衰减的峰值保持，用于 -waveform）
+	SNR             float64 // 客户端：有效语音 RMS 与噪声底 RMS 之比 (dB)，尚无有效音频时为 0
+	Muted           bool    // 客户端是否处于静音状态
+	PTT             bool    // 客户端以 -ptt 按键通话模式运行
+	Transmitting    bool    // -ptt：当前是否在发送
+	Clipping        bool    // 最近的统计窗口内输入是否削波
+	Limiting        bool    // 服务端 -limiter 最近是否压缩了峰值
+	Buffering       bool    // 服务端 -prefill-frames：正在缓冲，尚未（重新）开始播放
 }
 
 // NetworkStats represents network transmission statistics
@@ -266,4 +551,22 @@ type NetworkStats struct {
 	BytesReceived  int64
 	RoundTripTime  time.Duration
 	ErrorCount     int64
+
+	// 音频包序列号统计（仅服务端接收方向有效）
+	PacketsReceived  int64 // 收到的音频包数量
+	PacketsLost      int64 // 根据序列号间隙推算出的丢包数量
+	PacketsReordered int64 // 序列号小于期望值的乱序包数量
+	SamplesMissing   int64 // -sample-index：采样序号间隙中缺失的采样帧数
+
+	// 服务端：自启动以来的累计统计（含当前会话）；其余字段为当前会话。客户端为 nil
+	Total *NetworkStats
+}
+
+// LossPercent returns the audio packet loss percentage derived from sequence gaps
+func (ns *NetworkStats) LossPercent() float64 {
+	total := ns.PacketsReceived + ns.PacketsLost
+	if total <= 0 {
+		return 0.0
+	}
+	return float64(ns.PacketsLost) / float64(total) * 100
 }
\ No newline at end of file
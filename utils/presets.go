@@ -0,0 +1,89 @@
+// utils/presets.go - 用户自定义的 -quality 预设（~/.config/remoteaudio/presets.json 或 -presets-file）
+
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// QualityPreset is a user-defined stream quality, selected by name with -quality
+type QualityPreset struct {
+	SampleRate      int    `json:"sample_rate"`
+	Channels        int    `json:"channels"`
+	BitDepth        int    `json:"bit_depth"`
+	FramesPerBuffer int    `json:"frames_per_buffer"`
+	Codec           string `json:"codec,omitempty"`   // pcm、opus 或 flac；为空时沿用 -codec
+	Bitrate         int    `json:"bitrate,omitempty"` // kbit/s，与 -max-bitrate 相同；0 表示不限
+}
+
+// Validate checks the preset's audio parameters
+func (p *QualityPreset) Validate() error {
+	switch {
+	case p.SampleRate < 8000 || p.SampleRate > 192000:
+		return fmt.Errorf("sample_rate %d must be between 8000 and 192000", p.SampleRate)
+	case p.Channels < 1 || p.Channels > 8:
+		return fmt.Errorf("channels %d must be between 1 and 8", p.Channels)
+	case p.BitDepth != 16 && p.BitDepth != 24 && p.BitDepth != 32:
+		return fmt.Errorf("bit_depth %d must be 16, 24 or 32", p.BitDepth)
+	case p.FramesPerBuffer < 1 || p.FramesPerBuffer > 8192:
+		return fmt.Errorf("frames_per_buffer %d must be between 1 and 8192", p.FramesPerBuffer)
+	case p.Bitrate < 0:
+		return fmt.Errorf("bitrate %d must not be negative", p.Bitrate)
+	}
+	switch strings.ToLower(p.Codec) {
+	case "", "pcm", "opus", "flac":
+		return nil
+	default:
+		return fmt.Errorf("codec %q must be pcm, opus or flac", p.Codec)
+	}
+}
+
+// PresetsPath returns ~/.config/remoteaudio/presets.json
+func PresetsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", WrapError(err, ErrInvalidConfig, "cannot determine home directory")
+	}
+	return filepath.Join(home, ".config", "remoteaudio", "presets.json"), nil
+}
+
+// LoadQualityPresets reads a JSON object mapping preset names to presets. Names are
+// lower-cased; "custom" is reserved for the wizard. With path "" the default PresetsPath
+// is read and a missing file yields no presets.
+func LoadQualityPresets(path string) (map[string]QualityPreset, error) {
+	optional := path == ""
+	if optional {
+		var err error
+		if path, err = PresetsPath(); err != nil {
+			return nil, err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, WrapError(err, ErrInvalidConfig, fmt.Sprintf("cannot read presets file %s", path))
+	}
+	var raw map[string]QualityPreset
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, WrapError(err, ErrInvalidConfig, fmt.Sprintf("invalid presets file %s", path))
+	}
+	presets := make(map[string]QualityPreset, len(raw))
+	for name, preset := range raw {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if key == "" || key == "custom" {
+			return nil, NewAppError(ErrInvalidConfig, fmt.Sprintf("%s: invalid preset name %q", path, name))
+		}
+		if err := preset.Validate(); err != nil {
+			return nil, NewAppError(ErrInvalidConfig, fmt.Sprintf("%s: preset %q: %v", path, name, err))
+		}
+		presets[key] = preset
+	}
+	return presets, nil
+}
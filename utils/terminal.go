@@ -0,0 +1,50 @@
+// utils/terminal.go - 终端命令读取（用于运行时命令）
+
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// CommandReader reads line commands (e.g. "v 80") typed on stdin while a session runs
+type CommandReader struct {
+	stopped int32 // atomic bool
+}
+
+// StartCommandReader calls handler with the lower-cased first word and the remaining
+// words of every non-empty line typed on stdin. It returns an error if stdin is not a
+// terminal; call Stop to ignore further input.
+func StartCommandReader(handler func(command string, args []string)) (*CommandReader, error) {
+	if !isTerminal(os.Stdin) {
+		return nil, errors.New("stdin is not a terminal")
+	}
+
+	cr := &CommandReader{}
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 0 && !cr.isStopped() {
+				handler(strings.ToLower(fields[0]), fields[1:])
+			}
+		}
+	}()
+
+	return cr, nil
+}
+
+// Stop makes the reader ignore further input (the pending read of stdin cannot be interrupted)
+func (cr *CommandReader) Stop() {
+	if cr == nil {
+		return
+	}
+	atomic.StoreInt32(&cr.stopped, 1)
+}
+
+func (cr *CommandReader) isStopped() bool {
+	return atomic.LoadInt32(&cr.stopped) == 1
+}
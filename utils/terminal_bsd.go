@@ -0,0 +1,7 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package utils
+
+import "syscall"
+
+const ioctlGetTermios = syscall.TIOCGETA
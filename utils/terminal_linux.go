@@ -0,0 +1,5 @@
+package utils
+
+import "syscall"
+
+const ioctlGetTermios = syscall.TCGETS
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !windows
+
+package utils
+
+import "os"
+
+// isTerminal 其他平台无法判断，按非终端处理
+func isTerminal(f *os.File) bool {
+	return false
+}
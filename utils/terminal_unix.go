@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package utils
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// isTerminal 能读取 termios 即为终端
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlGetTermios, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
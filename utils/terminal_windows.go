@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+)
+
+// isTerminal 能读取控制台模式即为控制台（重定向的文件或管道会失败）
+func isTerminal(f *os.File) bool {
+	var mode uint32
+	r, _, _ := procGetConsoleMode.Call(f.Fd(), uintptr(unsafe.Pointer(&mode)))
+	return r != 0
+}
@@ -0,0 +1,72 @@
+// utils/waveform.go - -waveform：统计行中滚动显示每次刷新的峰值，最新的在右侧
+
+package utils
+
+import (
+	"math"
+	"os"
+	"strings"
+)
+
+// waveformWidth 波形显示的刷新次数（列数）
+const waveformWidth = 24
+
+// waveformRamp 由低到高的 ASCII 字符，-60dB 以下为空格
+const waveformRamp = " .:-=+*#%@"
+
+// SetWaveform replaces the level meter of the live stats line with a scrolling ASCII
+// waveform of the peak level per refresh. It only takes effect while the output is a terminal.
+func (l *Logger) SetWaveform(enabled bool) {
+	l.waveform = enabled
+	l.waveformHistory = nil
+}
+
+// writesToTerminal 日志与统计输出是否为终端
+func (l *Logger) writesToTerminal() bool {
+	f, ok := l.out.(*os.File)
+	return ok && isTerminal(f)
+}
+
+// renderWaveform 记录本次刷新的峰值（0.0 到 1.0）并渲染最近 waveformWidth 次的波形，如 [  .:=#*-.  ]
+func (l *Logger) renderWaveform(peak float64) string {
+	l.waveformHistory = append(l.waveformHistory, peak)
+	if len(l.waveformHistory) > waveformWidth {
+		l.waveformHistory = l.waveformHistory[len(l.waveformHistory)-waveformWidth:]
+	}
+
+	var b strings.Builder
+	b.WriteString("[")
+	b.WriteString(strings.Repeat(" ", waveformWidth-len(l.waveformHistory)))
+	color := ""
+	for _, peak := range l.waveformHistory {
+		db := -60.0
+		if peak > 0 {
+			db = math.Max(20*math.Log10(peak), -60.0)
+		}
+		level := int((db+60.0)/60.0*float64(len(waveformRamp)-1) + 0.5)
+		if level > len(waveformRamp)-1 {
+			level = len(waveformRamp) - 1
+		}
+
+		// 相邻同色的列共用一个颜色码，静音列不着色
+		if !l.noColor {
+			want := ""
+			if level > 0 {
+				want = levelColor(db)
+			}
+			if want != color {
+				if color != "" {
+					b.WriteString("\033[0m")
+				}
+				b.WriteString(want)
+				color = want
+			}
+		}
+		b.WriteByte(waveformRamp[level])
+	}
+	if color != "" {
+		b.WriteString("\033[0m")
+	}
+	b.WriteString("]")
+	return b.String()
+}
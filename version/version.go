@@ -0,0 +1,27 @@
+// version/version.go - 构建信息（通过 -ldflags -X 注入）
+
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// 构建时注入，例如：
+//
+//	go build -ldflags "-X RemoteAudioCLI/version.Version=1.2.0 -X RemoteAudioCLI/version.GitCommit=$(git rev-parse --short HEAD) -X RemoteAudioCLI/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" -o RemoteAudioCli.exe main.go
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+// GoVersion returns the Go version the binary was built with
+func GoVersion() string {
+	return runtime.Version()
+}
+
+// String returns a one-line summary of the build, e.g. for startup logs
+func String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s)", Version, GitCommit, BuildDate, GoVersion())
+}